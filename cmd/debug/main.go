@@ -13,47 +13,88 @@ func main() {
 }
 
 func DebugCLI() {
-	var dbPath, command, zoneID, mode string
+	var dbPath, command, zoneID, groupID, mode, reason, backupPath, backupDir string
 	var setpoint float64
+	var offIfUnsupported bool
 	flag.StringVar(&dbPath, "db", "data/hvac.db", "Path to the SQLite database file")
-	flag.StringVar(&command, "cmd", "", "Command to run: set-system-mode, set-zone-mode, set-zone-setpoint, reset-air-handler-timestamps")
+	flag.StringVar(&command, "cmd", "", "Command to run: set-system-mode, set-zone-mode, set-zone-setpoint, set-group-mode, set-group-setpoint, zone-capabilities, reset-air-handler-timestamps, migration-status, backup, restore")
 	flag.StringVar(&zoneID, "zone", "", "Zone ID for zone commands")
+	flag.StringVar(&groupID, "group", "", "Zone group ID for group commands, or 'all' for every zone")
+	flag.BoolVar(&offIfUnsupported, "off-if-unsupported", false, "Turn off group zones that don't support the mode instead of failing")
 	flag.StringVar(&mode, "mode", "", "Mode for system or zone")
 	flag.Float64Var(&setpoint, "setpoint", 0, "Setpoint value for zone")
+	flag.StringVar(&reason, "reason", "", "Reason recorded in the audit log for mode and setpoint changes")
+	flag.StringVar(&backupPath, "backup", "", "Backup file to restore from")
+	flag.StringVar(&backupDir, "backup-dir", "data/backups", "Directory to write backups to")
 	help := flag.Bool("help", false, "Show help")
 	flag.Parse()
 
 	if *help || command == "" {
 		fmt.Println("\nUsage of hvac-debug:")
 		fmt.Println("  -db string\tPath to the SQLite database file (default 'hvac.db')")
-		fmt.Println("  -cmd string\tCommand to run: set-system-mode, set-zone-mode, set-zone-setpoint, reset-air-handler-timestamps")
+		fmt.Println("  -cmd string\tCommand to run: set-system-mode, set-zone-mode, set-zone-setpoint, set-group-mode, set-group-setpoint, zone-capabilities, reset-air-handler-timestamps, migration-status, backup, restore")
 		fmt.Println("  -zone string\tZone ID for zone commands")
+		fmt.Println("  -group string\tZone group ID for group commands, or 'all' for every zone")
+		fmt.Println("  -off-if-unsupported\tTurn off group zones that don't support the mode instead of failing")
 		fmt.Println("  -mode string\tMode for system or zone")
 		fmt.Println("  -setpoint float\tSetpoint value for zone")
+		fmt.Println("  -reason string\tReason recorded in the audit log for mode and setpoint changes")
+		fmt.Println("  -backup string\tBackup file to restore from")
+		fmt.Println("  -backup-dir string\tDirectory to write backups to (default 'data/backups')")
 		fmt.Println("  -help\tShow this help message")
 		fmt.Println("\nCommands:")
+		fmt.Println("  set-group-mode\tSet -mode on every zone in -group in one change, checking each zone's capabilities")
+		fmt.Println("  set-group-setpoint\tSet -setpoint on every zone in -group in one change")
+		fmt.Println("  zone-capabilities\tShow how -zone's capabilities, or every zone's, derive from its config and attached devices")
 		fmt.Println("  reset-air-handler-timestamps\tReset basement and main_floor air handler timestamps to 13+ hours ago (triggers recirculation)")
+		fmt.Println("  migration-status\tList schema migrations and whether each has been applied")
+		fmt.Println("  backup\tWrite a backup of the database to -backup-dir")
+		fmt.Println("  restore\tVerify -backup and swap it in for the database (stop the controller first)")
 		os.Exit(0)
 	}
 
 	var err error
 	switch command {
 	case "set-system-mode":
-		err = db.SetSystemModeCLI(dbPath, mode)
+		err = db.SetSystemModeCLI(dbPath, mode, reason)
 	case "set-zone-mode":
 		if zoneID == "" {
 			fmt.Println("Error: zone ID is required")
 			os.Exit(1)
 		}
-		err = db.SetZoneModeCLI(dbPath, zoneID, mode)
+		err = db.SetZoneModeCLI(dbPath, zoneID, mode, reason)
 	case "set-zone-setpoint":
 		if zoneID == "" {
 			fmt.Println("Error: zone ID is required")
 			os.Exit(1)
 		}
-		err = db.SetZoneSetpointCLI(dbPath, zoneID, setpoint)
+		err = db.SetZoneSetpointCLI(dbPath, zoneID, setpoint, reason)
+	case "set-group-mode":
+		if groupID == "" {
+			fmt.Println("Error: group ID is required")
+			os.Exit(1)
+		}
+		err = db.SetGroupModeCLI(dbPath, groupID, mode, reason, offIfUnsupported)
+	case "set-group-setpoint":
+		if groupID == "" {
+			fmt.Println("Error: group ID is required")
+			os.Exit(1)
+		}
+		err = db.SetGroupSetpointCLI(dbPath, groupID, setpoint, reason)
+	case "zone-capabilities":
+		err = db.ZoneCapabilitiesCLI(dbPath, zoneID)
 	case "reset-air-handler-timestamps":
 		err = db.ResetAirHandlerTimestampsCLI(dbPath)
+	case "migration-status":
+		err = db.MigrationStatusCLI(dbPath)
+	case "backup":
+		err = db.BackupCLI(dbPath, backupDir)
+	case "restore":
+		if backupPath == "" {
+			fmt.Println("Error: backup file is required")
+			os.Exit(1)
+		}
+		err = db.RestoreCLI(dbPath, backupPath)
 	default:
 		fmt.Println("Invalid command")
 		os.Exit(1)
@@ -1,7 +1,8 @@
 package main
 
 import (
-	"database/sql"
+	"flag"
+	"fmt"
 	"os"
 	"os/signal"
 	"syscall"
@@ -13,12 +14,15 @@ import (
 	"github.com/thatsimonsguy/hvac-controller/internal/api"
 	"github.com/thatsimonsguy/hvac-controller/internal/config"
 	"github.com/thatsimonsguy/hvac-controller/internal/controllers/buffercontroller"
+	"github.com/thatsimonsguy/hvac-controller/internal/controllers/exercisecontroller"
 	"github.com/thatsimonsguy/hvac-controller/internal/controllers/failsafecontroller"
 	"github.com/thatsimonsguy/hvac-controller/internal/controllers/recirculationcontroller"
 	"github.com/thatsimonsguy/hvac-controller/internal/controllers/zonecontroller"
 	"github.com/thatsimonsguy/hvac-controller/internal/datadog"
+	"github.com/thatsimonsguy/hvac-controller/internal/device"
 	"github.com/thatsimonsguy/hvac-controller/internal/env"
 	"github.com/thatsimonsguy/hvac-controller/internal/notifications"
+	"github.com/thatsimonsguy/hvac-controller/internal/reload"
 	"github.com/thatsimonsguy/hvac-controller/internal/temperature"
 	"github.com/thatsimonsguy/hvac-controller/internal/gpio"
 	"github.com/thatsimonsguy/hvac-controller/internal/logging"
@@ -27,37 +31,51 @@ import (
 )
 
 func main() {
-	env.Cfg = config.Load()
-	logging.Init(env.Cfg.LogLevel)
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "validate-config":
+			os.Exit(validateConfig(os.Args[2:]))
+		case "reconcile-config":
+			os.Exit(reconcileConfig(os.Args[2:]))
+		}
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	env.SetCfg(cfg)
+	logging.Init(env.Cfg().LogLevel)
 
-	if env.Cfg.EnableDatadog {
+	if env.Cfg().EnableDatadog {
 		datadog.InitMetrics()
 	}
 
 	// Initialize notifications
 	notifications.Init()
 
+	// Open the single DB connection pool shared by every component. The repository implements each
+	// component's Store interface.
+	repo, err := db.Open(env.Cfg().DBPath)
+	if err != nil {
+		shutdown.ShutdownWithError(err, "Failed to connect to database")
+	}
+	defer repo.Close()
+
 	// Initialize the DB
-	db.InitConfig(env.Cfg)
-	firstRun, err := db.InitializeIfMissing()
+	firstRun, err := repo.InitializeIfMissing(env.Cfg())
 	if err != nil {
 		shutdown.ShutdownWithError(err, "Failed to initialize database")
 	}
-	if err := db.ValidateDatabase(); err != nil {
+	if err := repo.ValidateDatabase(); err != nil {
 		shutdown.ShutdownWithError(err, "Failed to validate database")
 	}
 
-	// Create DB connection
-	dbConn, err := sql.Open("sqlite3", env.Cfg.DBPath)
-	if err != nil {
-		shutdown.ShutdownWithError(err, "Failed to connect to database")
-	}
-	defer dbConn.Close()
-
 	log.Info().Msg("Starting HVAC controller")
 
 	// Ensure services are properly installed and enabled on every run
-	if err := startup.EnsureServicesReady(dbConn); err != nil {
+	if err := startup.EnsureServicesReady(repo); err != nil {
 		shutdown.ShutdownWithError(err, "Failed to ensure services are ready")
 	}
 
@@ -69,47 +87,73 @@ func main() {
 		}
 	}
 
-	if err := gpio.ValidateInitialPinStates(dbConn); err != nil {
+	if err := gpio.ValidateInitialPinStates(repo); err != nil {
 		shutdown.ShutdownWithError(err, "Failed to initialize pin states")
 	}
 
-	mainPowerPin, err := db.GetMainPowerPin(dbConn)
+	mainPowerPin, err := repo.GetMainPowerPin()
 	if err != nil {
 		shutdown.ShutdownWithError(err, "could not retrieve main power pin from db")
 	}
 	gpio.Activate(mainPowerPin) // Turn on the relay board
 
 	// Start centralized temperature reading service
-	tempService := temperature.NewService(dbConn, env.Cfg.PollIntervalSeconds)
+	tempService := temperature.NewService(repo, env.Cfg().PollIntervalSeconds)
 	tempService.Start()
 
-	zones, err := db.GetAllZones(dbConn)
+	// Flow verification reads supply and return probes with the same drivers
+	device.SetSensorReader(tempService.Reader())
+
+	zones, err := repo.GetAllZones()
 	if err != nil {
 		shutdown.ShutdownWithError(err, "could not get zones from db")
 	}
 
 	for _, zone := range zones {
-		zonecontroller.RunZoneController(&zone, dbConn, tempService)
+		zonecontroller.RunZoneController(&zone, repo, tempService)
 	}
 	
 	// Stagger controller startups to avoid CPU spikes and race conditions
 	time.Sleep(3 * time.Second)
-	buffercontroller.RunBufferController(dbConn, tempService)
+	buffercontroller.RunBufferController(repo, tempService)
 	
 	time.Sleep(3 * time.Second)
-	recirculationcontroller.RunRecirculationController(dbConn)
+	recirculationcontroller.RunRecirculationController(repo)
 	
 	time.Sleep(3 * time.Second)
-	failsafecontroller.RunFailsafeController(dbConn, tempService)
+	failsafecontroller.RunFailsafeController(repo, tempService)
+
+	time.Sleep(3 * time.Second)
+	exercisecontroller.RunExerciseController(repo)
+
+	// Periodic online backups of the DB, rotated in backup_dir
+	repo.RunBackups()
+
+	// Config hot reload: controllers read env.Cfg() every cycle, cached values are refreshed via listeners
+	reloader := reload.NewReloader(repo)
+	reloader.OnReload(tempService.ApplyConfig)
 
 	// Start REST API server
-	apiServer := api.NewServer(dbConn, tempService, env.Cfg)
+	apiServer := api.NewServer(repo, tempService, env.Cfg())
+	apiServer.SetReloader(reloader)
+	reloader.OnReload(apiServer.UpdateConfig)
 	go func() {
 		if err := apiServer.Start(8080); err != nil {
 			log.Error().Err(err).Msg("REST API server failed to start")
 		}
 	}()
 
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			log.Info().Msg("SIGHUP received — reloading config")
+			if err := reloader.Reload(); err != nil {
+				log.Error().Err(err).Msg("Config reload failed - keeping running config")
+			}
+		}
+	}()
+
 	sig := make(chan os.Signal, 1)
 	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
 
@@ -117,3 +161,65 @@ func main() {
 	log.Info().Msg("Shutdown signal received — exiting")
 	shutdown.Shutdown()
 }
+
+// validateConfig implements `hvac-controller validate-config [-config-file path]` and returns the exit code.
+func validateConfig(args []string) int {
+	fs := flag.NewFlagSet("validate-config", flag.ExitOnError)
+	path := fs.String("config-file", "config.json", "Path to controller config file")
+	fs.Parse(args)
+
+	cfg, err := config.LoadFile(*path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	fmt.Printf("%s is valid\n", *path)
+	return 0
+}
+
+// reconcileConfig implements `hvac-controller reconcile-config [-config-file path] [-dry-run]`, which
+// prints the differences between the config and an existing database and applies them unless -dry-run is set.
+func reconcileConfig(args []string) int {
+	fs := flag.NewFlagSet("reconcile-config", flag.ExitOnError)
+	path := fs.String("config-file", "config.json", "Path to controller config file")
+	dryRun := fs.Bool("dry-run", false, "Print the diff without changing the database")
+	fs.Parse(args)
+
+	cfg, err := config.LoadFile(*path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if _, err := os.Stat(cfg.DBPath); err != nil {
+		fmt.Fprintf(os.Stderr, "Database %s not found: %v\n", cfg.DBPath, err)
+		return 1
+	}
+
+	repo, err := db.Open(cfg.DBPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer repo.Close()
+
+	diff, err := repo.ReconcileConfig(cfg, *dryRun)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	fmt.Println(diff)
+	if *dryRun && len(diff) > 0 {
+		fmt.Println("Dry run - no changes applied")
+	}
+	return 0
+}
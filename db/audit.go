@@ -0,0 +1,104 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Actors for changes that don't come from an API client.
+const (
+	ActorCLI = "cli"
+)
+
+// Audit actions, one per kind of control change.
+const (
+	AuditSystemMode      = "system_mode"
+	AuditZoneMode        = "zone_mode"
+	AuditZoneSetpoint    = "zone_setpoint"
+	AuditZoneFailsafe    = "zone_failsafe"
+	AuditZoneCirculate   = "zone_circulate"
+	AuditOverrideSet     = "system_override_set"
+	AuditOverrideClear   = "system_override_clear"
+	AuditPrimaryHeatPump = "primary_heat_pump"
+	AuditDeviceOnline    = "device_online"
+
+	AuditRecirculationStart  = "recirculation_start"
+	AuditRecirculationCancel = "recirculation_cancel"
+)
+
+// Audit says who is making a control change and why. It is written to audit_log in the same
+// transaction as the change itself.
+type Audit struct {
+	Actor  string
+	Reason string
+}
+
+// AuditEntry is one recorded control change.
+type AuditEntry struct {
+	ID        int64
+	CreatedAt time.Time
+	Actor     string
+	Action    string
+	Target    string
+	OldValue  string
+	NewValue  string
+	Reason    string
+}
+
+// AuditFilter narrows GetAuditLog. Zero values match everything.
+type AuditFilter struct {
+	Actor  string
+	Action string
+	Target string
+}
+
+// recordAudit appends a change to audit_log as part of tx.
+func recordAudit(tx *repoTx, audit Audit, action, target, oldValue, newValue string) error {
+	_, err := tx.Exec(`INSERT INTO audit_log (created_at, actor, action, target, old_value, new_value, reason) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		time.Now().Format(time.RFC3339), audit.Actor, action, nullIfEmpty(target), oldValue, newValue, nullIfEmpty(audit.Reason))
+	if err != nil {
+		return fmt.Errorf("record audit entry: %w", err)
+	}
+	return nil
+}
+
+// GetAuditLog returns a page of audit entries matching filter, newest first, and the total
+// number of matching entries.
+func (r *Repository) GetAuditLog(filter AuditFilter, limit, offset int) ([]AuditEntry, int, error) {
+	where := `WHERE (? = '' OR actor = ?) AND (? = '' OR action = ?) AND (? = '' OR target = ?)`
+	args := []interface{}{filter.Actor, filter.Actor, filter.Action, filter.Action, filter.Target, filter.Target}
+
+	var total int
+	if err := r.queryRow(`SELECT COUNT(*) FROM audit_log `+where, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count audit log: %w", err)
+	}
+
+	rows, err := r.query(`SELECT id, created_at, actor, action, target, old_value, new_value, reason FROM audit_log `+where+` ORDER BY id DESC LIMIT ? OFFSET ?`,
+		append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to query audit log: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []AuditEntry
+	for rows.Next() {
+		var e AuditEntry
+		var createdAt string
+		var target, oldValue, newValue, reason sql.NullString
+		if err := rows.Scan(&e.ID, &createdAt, &e.Actor, &e.Action, &target, &oldValue, &newValue, &reason); err != nil {
+			return nil, 0, fmt.Errorf("failed to scan audit entry: %w", err)
+		}
+		e.CreatedAt, _ = time.Parse(time.RFC3339, createdAt)
+		e.Target, e.OldValue, e.NewValue, e.Reason = target.String, oldValue.String, newValue.String, reason.String
+		entries = append(entries, e)
+	}
+	return entries, total, rows.Err()
+}
+
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
@@ -0,0 +1,78 @@
+package db
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/thatsimonsguy/hvac-controller/internal/model"
+)
+
+func newAuditTestRepo(t *testing.T) *Repository {
+	conn, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	conn.SetMaxOpenConns(1)
+	t.Cleanup(func() { conn.Close() })
+
+	_, err = Migrate(conn)
+	require.NoError(t, err)
+	_, err = conn.Exec(`
+		INSERT INTO system (id, system_mode) VALUES (1, 'heating');
+		INSERT INTO devices (name, device_type, online, is_primary, last_rotated) VALUES
+			('hp_a', 'heat_pump', 1, 1, '2024-01-01T00:00:00Z'),
+			('hp_b', 'heat_pump', 1, 0, '2024-01-02T00:00:00Z');
+	`)
+	require.NoError(t, err)
+	return New(conn)
+}
+
+func TestAuditLog_RecordsControlChanges(t *testing.T) {
+	repo := newAuditTestRepo(t)
+
+	failsafe := Audit{Actor: "failsafecontroller", Reason: "zone garage at 38.0°F"}
+	require.NoError(t, repo.SetSystemOverride(model.ModeHeating, failsafe))
+	// Switching the override keeps the mode to restore
+	require.NoError(t, repo.SetSystemOverride(model.ModeCooling, Audit{Actor: "failsafecontroller", Reason: "zone attic at 90.0°F"}))
+	require.NoError(t, repo.ClearSystemOverride(Audit{Actor: "failsafecontroller"}))
+	require.NoError(t, repo.SwapPrimaryHeatPump(Audit{Actor: "buffercontroller"}))
+	require.NoError(t, repo.UpdateDeviceOnlineStatusByName("hp_b", false, Audit{Actor: "flow-verification"}))
+	require.NoError(t, repo.UpdateSystemMode(model.ModeCooling, false, Audit{Actor: ActorCLI}))
+
+	entries, total, err := repo.GetAuditLog(AuditFilter{}, 10, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 6, total)
+
+	type change struct{ actor, action, target, oldValue, newValue, reason string }
+	var got []change
+	for _, e := range entries {
+		assert.False(t, e.CreatedAt.IsZero())
+		got = append(got, change{e.Actor, e.Action, e.Target, e.OldValue, e.NewValue, e.Reason})
+	}
+	assert.Equal(t, []change{
+		{ActorCLI, AuditSystemMode, "", "heating", "cooling", ""},
+		{"flow-verification", AuditDeviceOnline, "hp_b", "true", "false", ""},
+		{"buffercontroller", AuditPrimaryHeatPump, "", "hp_a", "hp_b", ""},
+		{"failsafecontroller", AuditOverrideClear, "", "cooling", "heating", ""},
+		{"failsafecontroller", AuditOverrideSet, "", "heating", "cooling", "zone attic at 90.0°F"},
+		{"failsafecontroller", AuditOverrideSet, "", "heating", "heating", "zone garage at 38.0°F"},
+	}, got)
+
+	// Filters and pagination
+	entries, total, err = repo.GetAuditLog(AuditFilter{Actor: "failsafecontroller"}, 1, 2)
+	require.NoError(t, err)
+	assert.Equal(t, 3, total)
+	require.Len(t, entries, 1)
+	assert.Equal(t, AuditOverrideSet, entries[0].Action)
+}
+
+func TestAuditLog_FailedChangeIsNotRecorded(t *testing.T) {
+	repo := newAuditTestRepo(t)
+
+	assert.Error(t, repo.UpdateZoneMode("missing", model.ModeHeating, false, Audit{Actor: ActorCLI}))
+
+	_, total, err := repo.GetAuditLog(AuditFilter{}, 10, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 0, total)
+}
@@ -0,0 +1,240 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/thatsimonsguy/hvac-controller/internal/env"
+)
+
+const backupTimeFormat = "20060102-150405"
+
+// backups are named hvac-<timestamp>.db so lexical order is chronological
+const backupGlob = "hvac-*.db"
+
+// Backup writes a consistent online copy of the database into dir. See BackupDatabase.
+func (r *Repository) Backup(dir string) (string, error) {
+	return BackupDatabase(r.conn, dir)
+}
+
+// BackupDatabase writes a consistent online copy of db into dir with VACUUM INTO, checks the copy's
+// integrity and returns its path. The controller keeps running while the copy is taken.
+func BackupDatabase(db *sql.DB, dir string) (string, error) {
+	if err := os.MkdirAll(dir, 0770); err != nil {
+		return "", fmt.Errorf("failed to create backup directory: %w", err)
+	}
+
+	backupPath := filepath.Join(dir, fmt.Sprintf("hvac-%s.db", time.Now().Format(backupTimeFormat)))
+	if _, err := db.Exec(`VACUUM INTO ?`, backupPath); err != nil {
+		return "", fmt.Errorf("failed to back up database: %w", err)
+	}
+
+	if err := VerifyBackup(backupPath); err != nil {
+		os.Remove(backupPath)
+		return "", err
+	}
+	return backupPath, nil
+}
+
+// RotateBackups deletes all but the newest keep backups in dir and returns the removed paths.
+func RotateBackups(dir string, keep int) ([]string, error) {
+	backups, err := ListBackups(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(backups) <= keep {
+		return nil, nil
+	}
+
+	var removed []string
+	for _, path := range backups[:len(backups)-keep] {
+		if err := os.Remove(path); err != nil {
+			return removed, fmt.Errorf("failed to remove old backup %s: %w", path, err)
+		}
+		removed = append(removed, path)
+	}
+	return removed, nil
+}
+
+// ListBackups returns the backups in dir, oldest first.
+func ListBackups(dir string) ([]string, error) {
+	backups, err := filepath.Glob(filepath.Join(dir, backupGlob))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list backups: %w", err)
+	}
+	sort.Strings(backups)
+	return backups, nil
+}
+
+// VerifyBackup opens a backup read-only and checks it passes PRAGMA integrity_check and has the
+// tables the controller needs.
+func VerifyBackup(path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return fmt.Errorf("backup not found: %w", err)
+	}
+
+	db, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return fmt.Errorf("failed to open backup: %w", err)
+	}
+	defer db.Close()
+
+	if err := CheckIntegrity(db); err != nil {
+		return fmt.Errorf("backup %s: %w", path, err)
+	}
+	if err := validateTables(db); err != nil {
+		return fmt.Errorf("backup %s: %w", path, err)
+	}
+	return nil
+}
+
+// RestoreDatabase replaces the database at dbPath with a verified backup. The current database,
+// including changes still in its WAL, is kept alongside as a pre-restore snapshot. It refuses to
+// restore while the database is open elsewhere, e.g. by the running controller.
+func RestoreDatabase(backupPath, dbPath string) (string, error) {
+	if err := VerifyBackup(backupPath); err != nil {
+		return "", err
+	}
+
+	var snapshot string
+	if _, err := os.Stat(dbPath); err == nil {
+		if snapshot, err = snapshotForRestore(dbPath); err != nil {
+			return "", err
+		}
+	}
+
+	// Copy next to the target and rename so a failed copy never leaves a half-written database
+	tmpPath := dbPath + ".restoring"
+	if err := copyFile(backupPath, tmpPath); err != nil {
+		os.Remove(tmpPath)
+		return snapshot, err
+	}
+	if err := os.Rename(tmpPath, dbPath); err != nil {
+		os.Remove(tmpPath)
+		return snapshot, fmt.Errorf("failed to swap in backup: %w", err)
+	}
+
+	// Stale journal files belong to the old database and must not be replayed onto the restored one
+	for _, suffix := range []string{"-wal", "-shm", "-journal"} {
+		os.Remove(dbPath + suffix)
+	}
+	return snapshot, nil
+}
+
+// RunBackups takes a backup every backup_interval_hours and rotates old ones, picking up from the
+// newest existing backup so restarts don't reset the schedule. Settings are re-read from the active
+// config each cycle, so a reload takes effect at the next one.
+func (r *Repository) RunBackups() {
+	go func() {
+		for {
+			cfg := env.Cfg()
+			interval := time.Duration(cfg.BackupIntervalHours) * time.Hour
+			if interval <= 0 {
+				// Disabled, check again later in case a config reload enables it
+				time.Sleep(time.Hour)
+				continue
+			}
+
+			dir := cfg.BackupDir
+			if wait := time.Until(latestBackupTime(dir).Add(interval)); wait > 0 {
+				time.Sleep(min(wait, interval))
+				continue
+			}
+
+			backupPath, err := r.Backup(dir)
+			if err != nil {
+				log.Error().Err(err).Msg("Periodic database backup failed")
+				time.Sleep(time.Hour)
+				continue
+			}
+			log.Info().Str("path", backupPath).Msg("Database backed up")
+
+			removed, err := RotateBackups(dir, cfg.BackupRetention)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to rotate database backups")
+			}
+			for _, path := range removed {
+				log.Debug().Str("path", path).Msg("Removed old database backup")
+			}
+		}
+	}()
+}
+
+// latestBackupTime returns when the newest backup in dir was taken, or the zero time if there is none.
+func latestBackupTime(dir string) time.Time {
+	backups, err := ListBackups(dir)
+	if err != nil || len(backups) == 0 {
+		return time.Time{}
+	}
+
+	newest := filepath.Base(backups[len(backups)-1])
+	stamp := strings.TrimSuffix(strings.TrimPrefix(newest, "hvac-"), ".db")
+	t, err := time.ParseInLocation(backupTimeFormat, stamp, time.Local)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// snapshotDatabase writes a VACUUM INTO copy of the open database next to its file as
+// <path>.<label>-<timestamp>. Unlike a file copy it includes changes still in the WAL.
+func snapshotDatabase(db *sql.DB, dbPath, label string) (string, error) {
+	snapshotPath := fmt.Sprintf("%s.%s-%s", dbPath, label, time.Now().Format(backupTimeFormat))
+	if _, err := db.Exec(`VACUUM INTO ?`, snapshotPath); err != nil {
+		return "", fmt.Errorf("failed to snapshot database: %w", err)
+	}
+	return snapshotPath, nil
+}
+
+// snapshotForRestore takes the pre-restore snapshot under an exclusive lock, so nothing can write
+// after it is taken. Closing the last connection checkpoints the WAL into the database file, leaving
+// no journal behind that the restore would discard.
+func snapshotForRestore(dbPath string) (string, error) {
+	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?_busy_timeout=0&_locking_mode=EXCLUSIVE&_txlock=exclusive", dbPath))
+	if err != nil {
+		return "", fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	// In exclusive locking mode the lock is kept after the transaction ends
+	tx, err := db.Begin()
+	if err != nil {
+		return "", fmt.Errorf("database %s is in use, stop the controller before restoring: %w", dbPath, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return "", fmt.Errorf("failed to lock database: %w", err)
+	}
+	return snapshotDatabase(db, dbPath, "pre-restore")
+}
+
+func copyFile(srcPath, dstPath string) error {
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", srcPath, err)
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(dstPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0660)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", dstPath, err)
+	}
+
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return fmt.Errorf("failed to copy %s to %s: %w", srcPath, dstPath, err)
+	}
+	if err := dst.Sync(); err != nil {
+		dst.Close()
+		return fmt.Errorf("failed to sync %s: %w", dstPath, err)
+	}
+	return dst.Close()
+}
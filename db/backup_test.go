@@ -0,0 +1,116 @@
+package db
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newFileTestDB(t *testing.T, path string) *sql.DB {
+	db, err := sql.Open("sqlite3", path)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	_, err = Migrate(db)
+	require.NoError(t, err)
+	_, err = db.Exec(`INSERT INTO system (id, system_mode) VALUES (1, 'heating')`)
+	require.NoError(t, err)
+	return db
+}
+
+func TestBackupDatabaseAndRotate(t *testing.T) {
+	dir := t.TempDir()
+	db := newFileTestDB(t, filepath.Join(dir, "hvac.db"))
+	backupDir := filepath.Join(dir, "backups")
+
+	backupPath, err := BackupDatabase(db, backupDir)
+	require.NoError(t, err)
+	assert.NoError(t, VerifyBackup(backupPath))
+
+	backup, err := sql.Open("sqlite3", backupPath)
+	require.NoError(t, err)
+	defer backup.Close()
+	var mode string
+	require.NoError(t, backup.QueryRow(`SELECT system_mode FROM system WHERE id = 1`).Scan(&mode))
+	assert.Equal(t, "heating", mode)
+
+	// Older backups sort first by name, so rotation keeps the newest
+	for _, name := range []string{"hvac-20240101-000000.db", "hvac-20240102-000000.db"} {
+		require.NoError(t, copyFile(backupPath, filepath.Join(backupDir, name)))
+	}
+	removed, err := RotateBackups(backupDir, 2)
+	require.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(backupDir, "hvac-20240101-000000.db")}, removed)
+
+	backups, err := ListBackups(backupDir)
+	require.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(backupDir, "hvac-20240102-000000.db"), backupPath}, backups)
+	assert.False(t, latestBackupTime(backupDir).IsZero())
+}
+
+func TestVerifyBackup_RejectsBadFiles(t *testing.T) {
+	dir := t.TempDir()
+
+	assert.Error(t, VerifyBackup(filepath.Join(dir, "missing.db")))
+
+	garbage := filepath.Join(dir, "garbage.db")
+	require.NoError(t, os.WriteFile(garbage, []byte("not a database"), 0600))
+	assert.Error(t, VerifyBackup(garbage))
+
+	// A valid SQLite file without the controller's tables is not a usable backup
+	empty := filepath.Join(dir, "empty.db")
+	db, err := sql.Open("sqlite3", empty)
+	require.NoError(t, err)
+	_, err = db.Exec(`CREATE TABLE other (id INTEGER)`)
+	require.NoError(t, err)
+	db.Close()
+	assert.Error(t, VerifyBackup(empty))
+}
+
+func TestRestoreDatabase(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "hvac.db")
+	db := newFileTestDB(t, dbPath)
+	_, err := db.Exec(`PRAGMA journal_mode = WAL`) // as the controller opens it
+	require.NoError(t, err)
+
+	backupPath, err := BackupDatabase(db, filepath.Join(dir, "backups"))
+	require.NoError(t, err)
+
+	_, err = db.Exec(`UPDATE system SET system_mode = 'cooling' WHERE id = 1`)
+	require.NoError(t, err)
+
+	// The controller still has the database open
+	_, err = RestoreDatabase(backupPath, dbPath)
+	assert.ErrorContains(t, err, "in use")
+	require.NoError(t, db.Close())
+
+	snapshot, err := RestoreDatabase(backupPath, dbPath)
+	require.NoError(t, err)
+
+	// The snapshot keeps the change made after the backup
+	saved, err := sql.Open("sqlite3", snapshot)
+	require.NoError(t, err)
+	defer saved.Close()
+	var savedMode string
+	require.NoError(t, saved.QueryRow(`SELECT system_mode FROM system WHERE id = 1`).Scan(&savedMode))
+	assert.Equal(t, "cooling", savedMode)
+
+	restored, err := sql.Open("sqlite3", dbPath)
+	require.NoError(t, err)
+	defer restored.Close()
+	var mode string
+	require.NoError(t, restored.QueryRow(`SELECT system_mode FROM system WHERE id = 1`).Scan(&mode))
+	assert.Equal(t, "heating", mode)
+
+	// A bad backup leaves the database alone
+	garbage := filepath.Join(dir, "garbage.db")
+	require.NoError(t, os.WriteFile(garbage, []byte("not a database"), 0600))
+	_, err = RestoreDatabase(garbage, dbPath)
+	assert.Error(t, err)
+	assert.NoError(t, CheckIntegrity(restored))
+}
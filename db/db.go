@@ -4,7 +4,7 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
-	"os"
+	"strings"
 	"time"
 
 	"github.com/rs/zerolog/log"
@@ -14,72 +14,60 @@ import (
 	"github.com/thatsimonsguy/hvac-controller/internal/model"
 )
 
-var cfg *config.Config
-var schemaPath = "db/schema.sql" // not putting this is config, since the schema is actively part of the codebase and not an artifact of it
-
-func InitConfig(c *config.Config) {
-	cfg = c
-}
-
-func InitializeIfMissing() (bool, error) {
-	firstRun := false
-
-	if _, err := os.Stat(cfg.DBPath); os.IsNotExist(err) {
-		firstRun = true
-		// Touch the file and set permissions
-		f, err := os.Create(cfg.DBPath)
-		if err != nil {
-			return firstRun, fmt.Errorf("failed to create database file: %w", err)
-		}
-		f.Close()
-		os.Chmod(cfg.DBPath, 0660) // Optional: Set desired permissions
+// InitializeIfMissing creates and seeds the schema on a new database, or migrates and reconciles an
+// existing one against cfg. It reports whether this was the first run.
+func (r *Repository) InitializeIfMissing(cfg *config.Config) (bool, error) {
+	exists, err := tableExists(r.conn, "system")
+	if err != nil {
+		return false, err
+	}
 
+	if !exists {
 		// Apply the schema
-		if err := ApplySchema(); err != nil {
-			return firstRun, err
+		if err := r.ApplySchema(); err != nil {
+			return true, err
 		}
 		// Seed the database
-		return firstRun, SeedDatabase()
+		return true, r.SeedDatabase(cfg)
 	}
-	
-	// DB file exists, check for migrations
-	if err := ApplyMigrations(); err != nil {
-		return firstRun, err
+
+	// DB exists, check for migrations
+	if err := r.ApplyMigrations(cfg.DBPath); err != nil {
+		return false, err
 	}
-	
-	return firstRun, nil
-}
 
-func ApplySchema() error {
-	db, err := sql.Open("sqlite3", cfg.DBPath)
-	if err != nil {
-		return fmt.Errorf("failed to open database: %w", err)
+	// Pick up config edits made since the DB was seeded
+	if _, err := r.ReconcileConfig(cfg, false); err != nil {
+		return false, err
 	}
-	defer db.Close()
 
-	schemaBytes, err := os.ReadFile(schemaPath)
+	return false, nil
+}
+
+// ReconcileConfig brings the database in line with c and returns the changes made, or only reports
+// them when dryRun is set.
+func (r *Repository) ReconcileConfig(c *config.Config, dryRun bool) (ConfigDiff, error) {
+	diff, err := ReconcileConfig(r.conn, c, dryRun)
 	if err != nil {
-		return fmt.Errorf("failed to read schema file: %w", err)
+		return nil, fmt.Errorf("failed to reconcile config: %w", err)
 	}
-	schema := string(schemaBytes)
+	return diff, nil
+}
 
-	_, err = db.Exec(schema)
+// ApplySchema creates the schema on a new database by applying every migration.
+func (r *Repository) ApplySchema() error {
+	applied, err := Migrate(r.conn)
 	if err != nil {
-		return fmt.Errorf("failed to apply schema: %w", err)
+		return err
 	}
 
-	log.Info().Str("path", schemaPath).Msg("Schema successfully applied")
+	log.Info().Int("migrations", len(applied)).Msg("Schema successfully applied")
 	return nil
 }
 
-func SeedDatabase() error {
-	db, err := sql.Open("sqlite3", cfg.DBPath)
-	if err != nil {
-		return fmt.Errorf("failed to open database: %w", err)
-	}
-	defer db.Close()
-
-	tx, err := db.Begin()
+// SeedDatabase fills a freshly created schema from cfg.
+func (r *Repository) SeedDatabase(cfg *config.Config) error {
+	tx, err := r.conn.Begin()
 	if err != nil {
 		return fmt.Errorf("failed to begin transaction: %w", err)
 	}
@@ -94,7 +82,7 @@ func SeedDatabase() error {
 
 	// Insert system sensors
 	for _, s := range cfg.SystemSensors {
-		_, err = tx.Exec(`INSERT OR REPLACE INTO sensors (id, bus) VALUES (?, ?)`, s.ID, s.Bus)
+		_, err = tx.Exec(`INSERT OR REPLACE INTO sensors (id, type, bus, poll_interval_seconds) VALUES (?, ?, ?, ?)`, s.ID, s.Driver(), s.Bus, s.PollIntervalSeconds)
 		if err != nil {
 			return fmt.Errorf("failed to insert system sensor %s: %w", s.ID, err)
 		}
@@ -102,20 +90,40 @@ func SeedDatabase() error {
 
 	// Insert zone sensors
 	for _, z := range cfg.Zones {
-		sensor := z.Sensor
-		_, err = tx.Exec(`INSERT OR REPLACE INTO sensors (id, bus) VALUES (?, ?)`, sensor.ID, sensor.Bus)
-		if err != nil {
-			return fmt.Errorf("failed to insert zone sensor %s: %w", sensor.ID, err)
+		for _, sensor := range zoneConfigSensors(z) {
+			_, err = tx.Exec(`INSERT OR REPLACE INTO sensors (id, type, bus, poll_interval_seconds) VALUES (?, ?, ?, ?)`, sensor.ID, sensor.Driver(), sensor.Bus, sensor.PollIntervalSeconds)
+			if err != nil {
+				return fmt.Errorf("failed to insert zone sensor %s: %w", sensor.ID, err)
+			}
 		}
 	}
 
 	// Insert zones
+	txExec := func(query string, args ...interface{}) error {
+		_, err := tx.Exec(query, args...)
+		return err
+	}
 	for _, z := range cfg.Zones {
-		_, err = tx.Exec(`INSERT OR REPLACE INTO zones (id, label, setpoint, mode, capabilities, sensor_id) VALUES (?, ?, ?, ?, ?, ?)`,
-			z.ID, z.Label, z.Setpoint, model.ModeOff, marshalJSON(z.Capabilities), z.Sensor.ID)
+		humiditySensorID, humidityTarget := zoneHumidity(z)
+		circulate := z.CirculateSettings()
+		_, err = tx.Exec(`INSERT OR REPLACE INTO zones (id, label, setpoint, mode, capabilities, sensor_id, failsafe_enabled, failsafe_min_temp, failsafe_max_temp, aggregation, humidity_sensor_id, humidity_target, circulate_minutes_per_hour, circulate_between_calls, priority) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			z.ID, z.Label, z.Setpoint, model.ModeOff, marshalJSON(z.Capabilities), z.Sensor.ID, z.FailsafeActive(), z.FailsafeMinTemp, z.FailsafeMaxTemp, z.AggregationMethod(), humiditySensorID, humidityTarget, circulate.MinutesPerHour, circulate.BetweenCalls, z.PriorityWeight())
 		if err != nil {
 			return fmt.Errorf("failed to insert zone %s: %w", z.ID, err)
 		}
+		if err := writeZoneSensors(txExec, z); err != nil {
+			return fmt.Errorf("failed to insert sensors for zone %s: %w", z.ID, err)
+		}
+	}
+
+	// Insert zone groups
+	for _, g := range cfg.ZoneGroups {
+		if _, err = tx.Exec(`INSERT INTO zone_groups (id, label) VALUES (?, ?)`, g.ID, g.Label); err != nil {
+			return fmt.Errorf("failed to insert zone group %s: %w", g.ID, err)
+		}
+		if err := writeZoneGroupMembers(txExec, g); err != nil {
+			return fmt.Errorf("failed to insert zones for group %s: %w", g.ID, err)
+		}
 	}
 
 	// Insert devices from config with role assignment
@@ -135,15 +143,23 @@ func SeedDatabase() error {
 		}
 	}
 	for _, d := range cfg.DeviceConfig.AirHandlers.Devices {
-		_, err = tx.Exec(`INSERT INTO devices (name, pin_number, pin_active_high, min_on, min_off, online, last_changed, active_modes, device_type, role, zone_id, circ_pump_pin_number, circ_pump_pin_active_high) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
-			d.Name, d.Pin, cfg.RelayBoardActiveHigh, int(cfg.DeviceConfig.AirHandlers.DeviceProfile.MinTimeOn*60), int(cfg.DeviceConfig.AirHandlers.DeviceProfile.MinTimeOff*60), true, time.Now().Format(time.RFC3339), marshalJSON(cfg.DeviceConfig.AirHandlers.DeviceProfile.ActiveModes), "air_handler", "distributor", d.Zone, d.CircPumpPin, cfg.RelayBoardActiveHigh)
+		supplyID, returnID, err := insertFlowSensors(tx, d.SupplySensor, d.ReturnSensor)
+		if err != nil {
+			return fmt.Errorf("failed to insert flow sensors for air handler %s: %w", d.Name, err)
+		}
+		_, err = tx.Exec(`INSERT INTO devices (name, pin_number, pin_active_high, min_on, min_off, online, last_changed, active_modes, device_type, role, zone_id, circ_pump_pin_number, circ_pump_pin_active_high, supply_sensor_id, return_sensor_id, recirculation_policy) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			d.Name, d.Pin, cfg.RelayBoardActiveHigh, int(cfg.DeviceConfig.AirHandlers.DeviceProfile.MinTimeOn*60), int(cfg.DeviceConfig.AirHandlers.DeviceProfile.MinTimeOff*60), true, time.Now().Format(time.RFC3339), marshalJSON(cfg.DeviceConfig.AirHandlers.DeviceProfile.ActiveModes), "air_handler", "distributor", d.Zone, d.CircPumpPin, cfg.RelayBoardActiveHigh, supplyID, returnID, marshalJSON(d.Recirculation))
 		if err != nil {
 			return fmt.Errorf("failed to insert air handler %s: %w", d.Name, err)
 		}
 	}
 	for _, d := range cfg.DeviceConfig.RadiantFloorLoops.Devices {
-		_, err = tx.Exec(`INSERT INTO devices (name, pin_number, pin_active_high, min_on, min_off, online, last_changed, active_modes, device_type, role, zone_id) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
-			d.Name, d.Pin, cfg.RelayBoardActiveHigh, int(cfg.DeviceConfig.RadiantFloorLoops.DeviceProfile.MinTimeOn*60), int(cfg.DeviceConfig.RadiantFloorLoops.DeviceProfile.MinTimeOff*60), true, time.Now().Format(time.RFC3339), marshalJSON(cfg.DeviceConfig.RadiantFloorLoops.DeviceProfile.ActiveModes), "radiant_floor", "distributor", d.Zone)
+		supplyID, returnID, err := insertFlowSensors(tx, d.SupplySensor, d.ReturnSensor)
+		if err != nil {
+			return fmt.Errorf("failed to insert flow sensors for radiant loop %s: %w", d.Name, err)
+		}
+		_, err = tx.Exec(`INSERT INTO devices (name, pin_number, pin_active_high, min_on, min_off, online, last_changed, active_modes, device_type, role, zone_id, supply_sensor_id, return_sensor_id) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			d.Name, d.Pin, cfg.RelayBoardActiveHigh, int(cfg.DeviceConfig.RadiantFloorLoops.DeviceProfile.MinTimeOn*60), int(cfg.DeviceConfig.RadiantFloorLoops.DeviceProfile.MinTimeOff*60), true, time.Now().Format(time.RFC3339), marshalJSON(cfg.DeviceConfig.RadiantFloorLoops.DeviceProfile.ActiveModes), "radiant_floor", "distributor", d.Zone, supplyID, returnID)
 		if err != nil {
 			return fmt.Errorf("failed to insert radiant loop %s: %w", d.Name, err)
 		}
@@ -158,106 +174,130 @@ func SeedDatabase() error {
 	return nil
 }
 
+// insertFlowSensors stores the optional supply/return sensors of a distribution device
+// and returns their IDs, or nil when the device has none configured.
+func insertFlowSensors(tx *sql.Tx, supply, ret *model.Sensor) (supplyID, returnID *string, err error) {
+	for _, s := range []*model.Sensor{supply, ret} {
+		if s == nil {
+			continue
+		}
+		if _, err := tx.Exec(`INSERT INTO sensors (id, type, bus, poll_interval_seconds) VALUES (?, ?, ?, ?) ON CONFLICT(id) DO UPDATE SET type = excluded.type, bus = excluded.bus, poll_interval_seconds = excluded.poll_interval_seconds`,
+			s.ID, s.Driver(), s.Bus, s.PollIntervalSeconds); err != nil {
+			return nil, nil, err
+		}
+	}
+	if supply != nil {
+		supplyID = &supply.ID
+	}
+	if ret != nil {
+		returnID = &ret.ID
+	}
+	return supplyID, returnID, nil
+}
+
 func marshalJSON(v interface{}) string {
 	b, _ := json.Marshal(v)
 	return string(b)
 }
 
-func ValidateDatabase() error {
-	db, err := sql.Open("sqlite3", cfg.DBPath)
-	if err != nil {
-		return fmt.Errorf("failed to open database: %w", err)
+// ValidateDatabase checks the expected tables are readable and that SQLite reports no corruption.
+func (r *Repository) ValidateDatabase() error {
+	if err := validateTables(r.conn); err != nil {
+		return err
 	}
-	defer db.Close()
+	if err := CheckIntegrity(r.conn); err != nil {
+		return err
+	}
+
+	log.Info().Msg("Database validated")
+	return nil
+}
 
-	// Check for expected tables and count of key entries
+// validateTables checks for expected tables and logs the count of key entries.
+func validateTables(q queryer) error {
 	tables := []string{"system", "zones", "devices", "sensors"}
 	for _, table := range tables {
 		var count int
-		err := db.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s", table)).Scan(&count)
+		err := q.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s", table)).Scan(&count)
 		if err != nil {
 			return fmt.Errorf("failed to query table %s: %w", table, err)
 		}
 		log.Debug().Str("table", table).Int("records", count).Msg("Table validated")
 	}
+	return nil
+}
 
-	log.Info().Msg("Database validated")
+// CheckIntegrity runs PRAGMA integrity_check and returns every problem it reports.
+func CheckIntegrity(q queryer) error {
+	rows, err := q.Query("PRAGMA integrity_check")
+	if err != nil {
+		return fmt.Errorf("failed to run integrity check: %w", err)
+	}
+	defer rows.Close()
+
+	var problems []string
+	for rows.Next() {
+		var result string
+		if err := rows.Scan(&result); err != nil {
+			return fmt.Errorf("failed to scan integrity check: %w", err)
+		}
+		if result != "ok" {
+			problems = append(problems, result)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to run integrity check: %w", err)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("integrity check failed: %s", strings.Join(problems, "; "))
+	}
 	return nil
 }
 
-func ApplyMigrations() error {
-	db, err := sql.Open("sqlite3", cfg.DBPath)
+// ApplyMigrations applies any pending migrations to the existing database at dbPath, snapshotting
+// it first.
+func (r *Repository) ApplyMigrations(dbPath string) error {
+	pending, err := PendingMigrations(r.conn)
+	if err != nil {
+		return err
+	}
+	if len(pending) == 0 {
+		log.Debug().Msg("Database schema is up to date")
+		return nil
+	}
+
+	backupPath, err := snapshotDatabase(r.conn, dbPath, "pre-migration")
 	if err != nil {
-		return fmt.Errorf("failed to open database: %w", err)
+		return err
 	}
-	defer db.Close()
+	log.Info().Str("backup", backupPath).Int("pending", len(pending)).Msg("Backed up database before migrating")
+
+	if _, err := Migrate(r.conn); err != nil {
+		return fmt.Errorf("%w (backup at %s)", err, backupPath)
+	}
+	return nil
+}
 
-	// Check if override columns exist
-	var overrideActiveExists, priorSystemModeExists, recirculationActiveExists, recirculationStartedAtExists bool
-	
-	rows, err := db.Query("PRAGMA table_info(system)")
+// tableColumns returns the set of column names present on a table.
+func tableColumns(q queryer, table string) (map[string]bool, error) {
+	rows, err := q.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
 	if err != nil {
-		return fmt.Errorf("failed to get table info: %w", err)
+		return nil, fmt.Errorf("failed to get table info for %s: %w", table, err)
 	}
 	defer rows.Close()
-	
+
+	columns := make(map[string]bool)
 	for rows.Next() {
 		var cid int
 		var name, dataType string
 		var notNull bool
 		var defaultValue *string
 		var pk int
-		
+
 		if err := rows.Scan(&cid, &name, &dataType, &notNull, &defaultValue, &pk); err != nil {
-			return fmt.Errorf("failed to scan column info: %w", err)
-		}
-		
-		if name == "override_active" {
-			overrideActiveExists = true
-		}
-		if name == "prior_system_mode" {
-			priorSystemModeExists = true
-		}
-		if name == "recirculation_active" {
-			recirculationActiveExists = true
-		}
-		if name == "recirculation_started_at" {
-			recirculationStartedAtExists = true
-		}
-	}
-	
-	// Add missing columns
-	if !overrideActiveExists {
-		_, err = db.Exec("ALTER TABLE system ADD COLUMN override_active BOOLEAN DEFAULT FALSE")
-		if err != nil {
-			return fmt.Errorf("failed to add override_active column: %w", err)
+			return nil, fmt.Errorf("failed to scan column info: %w", err)
 		}
-		log.Info().Msg("Added override_active column to system table")
+		columns[name] = true
 	}
-	
-	if !priorSystemModeExists {
-		_, err = db.Exec("ALTER TABLE system ADD COLUMN prior_system_mode TEXT DEFAULT NULL")
-		if err != nil {
-			return fmt.Errorf("failed to add prior_system_mode column: %w", err)
-		}
-		log.Info().Msg("Added prior_system_mode column to system table")
-	}
-	
-	if !recirculationActiveExists {
-		_, err = db.Exec("ALTER TABLE system ADD COLUMN recirculation_active BOOLEAN DEFAULT FALSE")
-		if err != nil {
-			return fmt.Errorf("failed to add recirculation_active column: %w", err)
-		}
-		log.Info().Msg("Added recirculation_active column to system table")
-	}
-	
-	if !recirculationStartedAtExists {
-		_, err = db.Exec("ALTER TABLE system ADD COLUMN recirculation_started_at TEXT")
-		if err != nil {
-			return fmt.Errorf("failed to add recirculation_started_at column: %w", err)
-		}
-		log.Info().Msg("Added recirculation_started_at column to system table")
-	}
-	
-	return nil
+	return columns, rows.Err()
 }
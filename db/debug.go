@@ -1,34 +1,157 @@
 package db
 
 import (
-	"database/sql"
+	"fmt"
+	"strings"
 
 	"github.com/thatsimonsguy/hvac-controller/internal/model"
 )
 
-func SetSystemModeCLI(dbPath, mode string) error {
-	dbConn, err := sql.Open("sqlite3", dbPath)
+func SetSystemModeCLI(dbPath, mode, reason string) error {
+	r, err := Open(dbPath)
 	if err != nil {
 		return err
 	}
-	defer dbConn.Close()
-	return UpdateSystemMode(dbConn, model.SystemMode(mode))
+	defer r.Close()
+	return r.UpdateSystemMode(model.SystemMode(mode), false, Audit{Actor: ActorCLI, Reason: reason})
 }
 
-func SetZoneModeCLI(dbPath, zoneID, mode string) error {
-	db, err := sql.Open("sqlite3", dbPath)
+// SetZoneModeCLI sets a zone's mode, refusing modes the zone's capabilities don't allow.
+func SetZoneModeCLI(dbPath, zoneID, mode, reason string) error {
+	r, err := Open(dbPath)
 	if err != nil {
 		return err
 	}
-	defer db.Close()
-	return UpdateZoneMode(db, zoneID, model.SystemMode(mode))
+	defer r.Close()
+	return r.UpdateZoneMode(zoneID, model.SystemMode(mode), false, Audit{Actor: ActorCLI, Reason: reason})
 }
 
-func SetZoneSetpointCLI(dbPath, zoneID string, setpoint float64) error {
-	db, err := sql.Open("sqlite3", dbPath)
+// ZoneCapabilitiesCLI prints how each capability of a zone, or of every zone when zoneID is empty,
+// is derived and the modes the zone can be put in.
+func ZoneCapabilitiesCLI(dbPath, zoneID string) error {
+	r, err := Open(dbPath)
 	if err != nil {
 		return err
 	}
-	defer db.Close()
-	return UpdateZoneSetpoint(db, zoneID, setpoint)
+	defer r.Close()
+
+	ids := []string{zoneID}
+	if zoneID == "" {
+		all, err := r.GetZoneGroup(model.AllZonesGroup)
+		if err != nil {
+			return err
+		}
+		ids = all.Zones
+	}
+	for _, id := range ids {
+		report, err := r.GetZoneCapabilities(id)
+		if err != nil {
+			return err
+		}
+		fmt.Println(id)
+		for _, c := range report.Capabilities {
+			listed := "not listed"
+			if c.Listed {
+				listed = "listed"
+			}
+			devices := "no attached device"
+			if len(c.Devices) > 0 {
+				devices = strings.Join(c.Devices, ", ")
+			}
+			fmt.Printf("  %-12s %-11s %s\n", c.Capability, listed, devices)
+		}
+		modes := make([]string, 0, 4)
+		for _, m := range report.SupportedModes() {
+			modes = append(modes, string(m))
+		}
+		fmt.Printf("  modes: %s\n", strings.Join(modes, ", "))
+	}
+	return nil
+}
+
+func SetZoneSetpointCLI(dbPath, zoneID string, setpoint float64, reason string) error {
+	r, err := Open(dbPath)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return r.UpdateZoneSetpoint(zoneID, setpoint, Audit{Actor: ActorCLI, Reason: reason})
+}
+
+// SetGroupModeCLI sets every zone in a group, or "all", to mode in one change and prints the mode
+// each zone ended up in.
+func SetGroupModeCLI(dbPath, groupID, mode, reason string, offIfUnsupported bool) error {
+	r, err := Open(dbPath)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	modes, err := r.UpdateZoneGroupMode(groupID, model.SystemMode(mode), offIfUnsupported, false, Audit{Actor: ActorCLI, Reason: reason})
+	if err != nil {
+		return err
+	}
+	for _, id := range sortedKeys(modes) {
+		fmt.Printf("%-20s %s\n", id, modes[id])
+	}
+	return nil
+}
+
+func SetGroupSetpointCLI(dbPath, groupID string, setpoint float64, reason string) error {
+	r, err := Open(dbPath)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return r.UpdateZoneGroupSetpoint(groupID, setpoint, Audit{Actor: ActorCLI, Reason: reason})
+}
+
+func MigrationStatusCLI(dbPath string) error {
+	r, err := Open(dbPath)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	statuses, err := MigrationStatuses(r.conn)
+	if err != nil {
+		return err
+	}
+	for _, s := range statuses {
+		state := "pending"
+		if s.Applied {
+			state = "applied " + s.AppliedAt
+		}
+		fmt.Printf("%04d  %-30s %s\n", s.Version, s.Name, state)
+	}
+	return nil
+}
+
+func BackupCLI(dbPath, dir string) error {
+	r, err := Open(dbPath)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	backupPath, err := r.Backup(dir)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Backed up %s to %s\n", dbPath, backupPath)
+	return nil
+}
+
+// RestoreCLI swaps a verified backup in for the database. It fails while the controller service
+// still has the database open.
+func RestoreCLI(dbPath, backupPath string) error {
+	snapshot, err := RestoreDatabase(backupPath, dbPath)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Restored %s from %s\n", dbPath, backupPath)
+	if snapshot != "" {
+		fmt.Printf("Previous database saved as %s\n", snapshot)
+	}
+	return nil
 }
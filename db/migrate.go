@@ -0,0 +1,235 @@
+package db
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Migrations are numbered NNNN_description.sql files applied in order. Never edit one that has
+// shipped; add a new file instead.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// MigrationStatus pairs a known migration with whether and when it was applied.
+type MigrationStatus struct {
+	Migration
+	Applied   bool
+	AppliedAt string
+}
+
+const createMigrationsTable = `CREATE TABLE IF NOT EXISTS schema_migrations (
+    version INTEGER PRIMARY KEY,
+    name TEXT NOT NULL,
+    applied_at TEXT NOT NULL  -- ISO8601 datetime string
+)`
+
+// Columns added by hand before versioned migrations existed. Databases from that era are adopted
+// at version 1 by adding whichever of these they are missing.
+var legacyColumns = []struct {
+	table, column, definition string
+}{
+	{"system", "override_active", "BOOLEAN DEFAULT FALSE"},
+	{"system", "prior_system_mode", "TEXT DEFAULT NULL"},
+	{"system", "recirculation_active", "BOOLEAN DEFAULT FALSE"},
+	{"system", "recirculation_started_at", "TEXT"},
+	{"devices", "supply_sensor_id", "TEXT REFERENCES sensors(id) ON DELETE SET NULL"},
+	{"devices", "return_sensor_id", "TEXT REFERENCES sensors(id) ON DELETE SET NULL"},
+}
+
+func loadMigrations() ([]Migration, error) {
+	entries, err := migrationFiles.ReadDir("migrations")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %w", err)
+	}
+
+	var migrations []Migration
+	seen := make(map[int]string)
+	for _, e := range entries {
+		name := strings.TrimSuffix(e.Name(), ".sql")
+		prefix, desc, ok := strings.Cut(name, "_")
+		version, err := strconv.Atoi(prefix)
+		if !ok || err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration %s must be named NNNN_description.sql", e.Name())
+		}
+		if other, dup := seen[version]; dup {
+			return nil, fmt.Errorf("migrations %s and %s share version %d", other, e.Name(), version)
+		}
+		seen[version] = e.Name()
+
+		body, err := migrationFiles.ReadFile(path.Join("migrations", e.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %s: %w", e.Name(), err)
+		}
+		migrations = append(migrations, Migration{Version: version, Name: desc, SQL: string(body)})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// MigrationStatuses lists every embedded migration and whether it has been applied to db.
+func MigrationStatuses(db *sql.DB) ([]MigrationStatus, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, len(migrations))
+	for i, m := range migrations {
+		appliedAt, ok := applied[m.Version]
+		statuses[i] = MigrationStatus{Migration: m, Applied: ok, AppliedAt: appliedAt}
+	}
+	return statuses, nil
+}
+
+// PendingMigrations returns the migrations that have not been applied to db yet, in order.
+func PendingMigrations(db *sql.DB) ([]Migration, error) {
+	statuses, err := MigrationStatuses(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []Migration
+	for _, s := range statuses {
+		if !s.Applied {
+			pending = append(pending, s.Migration)
+		}
+	}
+	return pending, nil
+}
+
+// Migrate applies every pending migration in order, each in its own transaction, and returns the
+// ones it applied. It stops at the first failure, leaving that migration unapplied.
+func Migrate(db *sql.DB) ([]Migration, error) {
+	if _, err := db.Exec(createMigrationsTable); err != nil {
+		return nil, fmt.Errorf("failed to create schema_migrations table: %w", err)
+	}
+
+	pending, err := PendingMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var ran []Migration
+	for _, m := range pending {
+		if err := applyMigration(db, m); err != nil {
+			return ran, fmt.Errorf("failed to apply migration %04d_%s: %w", m.Version, m.Name, err)
+		}
+		log.Info().Int("version", m.Version).Str("name", m.Name).Msg("Applied migration")
+		ran = append(ran, m)
+	}
+	return ran, nil
+}
+
+func applyMigration(db *sql.DB, m Migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	legacy := false
+	if m.Version == 1 {
+		if legacy, err = tableExists(tx, "system"); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec(m.SQL); err != nil {
+		return err
+	}
+	if legacy {
+		if err := adoptLegacySchema(tx); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(`INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)`,
+		m.Version, m.Name, time.Now().Format(time.RFC3339))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// adoptLegacySchema brings a database created before versioned migrations up to the baseline schema.
+func adoptLegacySchema(tx *sql.Tx) error {
+	columns := make(map[string]map[string]bool)
+	for _, c := range legacyColumns {
+		if columns[c.table] == nil {
+			existing, err := tableColumns(tx, c.table)
+			if err != nil {
+				return err
+			}
+			columns[c.table] = existing
+		}
+		if columns[c.table][c.column] {
+			continue
+		}
+
+		if _, err := tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", c.table, c.column, c.definition)); err != nil {
+			return fmt.Errorf("failed to add %s.%s column: %w", c.table, c.column, err)
+		}
+		log.Info().Str("table", c.table).Str("column", c.column).Msg("Added legacy column while adopting existing database")
+	}
+	return nil
+}
+
+func appliedMigrations(db *sql.DB) (map[int]string, error) {
+	applied := make(map[int]string)
+
+	exists, err := tableExists(db, "schema_migrations")
+	if err != nil || !exists {
+		return applied, err
+	}
+
+	rows, err := db.Query(`SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema_migrations: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var version int
+		var appliedAt string
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan schema_migrations: %w", err)
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+// queryer is satisfied by both *sql.DB and *sql.Tx.
+type queryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+func tableExists(q queryer, table string) (bool, error) {
+	var count int
+	err := q.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?`, table).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("failed to check for table %s: %w", table, err)
+	}
+	return count > 0, nil
+}
@@ -0,0 +1,135 @@
+package db
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	migrations, err := loadMigrations()
+	require.NoError(t, err)
+	require.NotEmpty(t, migrations)
+
+	assert.Equal(t, 1, migrations[0].Version)
+	assert.Equal(t, "initial_schema", migrations[0].Name)
+	for i := 1; i < len(migrations); i++ {
+		assert.Greater(t, migrations[i].Version, migrations[i-1].Version)
+	}
+}
+
+func TestMigrate_FreshDatabase(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	pending, err := PendingMigrations(db)
+	require.NoError(t, err)
+	all, err := loadMigrations()
+	require.NoError(t, err)
+	assert.Len(t, pending, len(all))
+
+	applied, err := Migrate(db)
+	require.NoError(t, err)
+	assert.Len(t, applied, len(all))
+
+	statuses, err := MigrationStatuses(db)
+	require.NoError(t, err)
+	for _, s := range statuses {
+		assert.True(t, s.Applied, "migration %d should be applied", s.Version)
+		assert.NotEmpty(t, s.AppliedAt)
+	}
+
+	// Running again is a no-op
+	applied, err = Migrate(db)
+	require.NoError(t, err)
+	assert.Empty(t, applied)
+}
+
+func TestMigrate_AdoptsLegacyDatabase(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	// A database from before override, recirculation and flow sensor columns existed
+	_, err = db.Exec(`
+		CREATE TABLE system (id INTEGER PRIMARY KEY CHECK(id=1), system_mode TEXT NOT NULL, main_power_pin_number INTEGER,
+			main_power_pin_active_high BOOLEAN, temp_sensor_bus_pin INTEGER, override_active BOOLEAN DEFAULT FALSE);
+		CREATE TABLE sensors (id TEXT PRIMARY KEY, bus TEXT);
+		CREATE TABLE zones (id TEXT PRIMARY KEY, label TEXT, setpoint REAL, mode TEXT, capabilities TEXT, sensor_id TEXT);
+		CREATE TABLE devices (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT, device_type TEXT);
+		INSERT INTO system (id, system_mode, override_active) VALUES (1, 'heating', 1);
+		INSERT INTO devices (name, device_type) VALUES ('boiler', 'boiler');
+	`)
+	require.NoError(t, err)
+
+	_, err = Migrate(db)
+	require.NoError(t, err)
+
+	systemColumns, err := tableColumns(db, "system")
+	require.NoError(t, err)
+	for _, column := range []string{"override_active", "prior_system_mode", "recirculation_active", "recirculation_started_at"} {
+		assert.True(t, systemColumns[column], "system.%s should exist", column)
+	}
+	deviceColumns, err := tableColumns(db, "devices")
+	require.NoError(t, err)
+	assert.True(t, deviceColumns["supply_sensor_id"])
+	assert.True(t, deviceColumns["return_sensor_id"])
+
+	// Existing rows survive adoption
+	var mode string
+	var override bool
+	require.NoError(t, db.QueryRow(`SELECT system_mode, override_active FROM system WHERE id = 1`).Scan(&mode, &override))
+	assert.Equal(t, "heating", mode)
+	assert.True(t, override)
+
+	pending, err := PendingMigrations(db)
+	require.NoError(t, err)
+	assert.Empty(t, pending)
+}
+
+func TestApplyMigrations_BacksUpBeforeMigrating(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "hvac.db")
+
+	db, err := sql.Open("sqlite3", dbPath)
+	require.NoError(t, err)
+	_, err = db.Exec(`CREATE TABLE system (id INTEGER PRIMARY KEY CHECK(id=1), system_mode TEXT NOT NULL);
+		CREATE TABLE devices (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT);
+		INSERT INTO system (id, system_mode) VALUES (1, 'off');`)
+	require.NoError(t, err)
+	db.Close()
+
+	repo, err := Open(dbPath)
+	require.NoError(t, err)
+	defer repo.Close()
+
+	require.NoError(t, repo.ApplyMigrations(dbPath))
+
+	backups, err := filepath.Glob(dbPath + ".pre-migration-*")
+	require.NoError(t, err)
+	require.Len(t, backups, 1)
+
+	// The backup is the untouched pre-migration database
+	backup, err := sql.Open("sqlite3", backups[0])
+	require.NoError(t, err)
+	defer backup.Close()
+	exists, err := tableExists(backup, "schema_migrations")
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	// Nothing pending, so no second backup
+	require.NoError(t, repo.ApplyMigrations(dbPath))
+	backups, err = filepath.Glob(dbPath + ".pre-migration-*")
+	require.NoError(t, err)
+	assert.Len(t, backups, 1)
+
+	_, err = os.Stat(dbPath)
+	assert.NoError(t, err)
+}
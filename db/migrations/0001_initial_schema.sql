@@ -1,4 +1,5 @@
--- schema.sql
+-- 0001_initial_schema.sql
+-- Baseline schema. Databases created before versioned migrations are adopted at this version.
 
 -- 🏠 System table (singleton)
 CREATE TABLE IF NOT EXISTS system (
@@ -43,7 +44,9 @@ CREATE TABLE IF NOT EXISTS devices (
     mode_pin_number INTEGER,  -- For heat pumps only
     mode_pin_active_high BOOLEAN,
     is_primary BOOLEAN,  -- For heat pumps only
-    last_rotated TEXT  -- For heat pumps only
+    last_rotated TEXT,  -- For heat pumps only
+    supply_sensor_id TEXT REFERENCES sensors(id) ON DELETE SET NULL,  -- Optional, distributors only
+    return_sensor_id TEXT REFERENCES sensors(id) ON DELETE SET NULL   -- Optional, distributors only
 );
 
 -- 🌡️ Sensors table (from model.Sensor)
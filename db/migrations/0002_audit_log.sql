@@ -0,0 +1,14 @@
+-- 0002_audit_log.sql
+-- Who changed what and why, for every user-initiated and automatic control change.
+
+-- 📝 Audit log (append only)
+CREATE TABLE IF NOT EXISTS audit_log (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    created_at TEXT NOT NULL,  -- ISO8601 datetime string
+    actor TEXT NOT NULL,  -- api:<client address>, cli, or the controller that made the change
+    action TEXT NOT NULL,  -- e.g. zone_mode, system_override_set
+    target TEXT,  -- Zone ID or device name, NULL for system-wide changes
+    old_value TEXT,
+    new_value TEXT,
+    reason TEXT
+);
@@ -0,0 +1,6 @@
+-- 0003_zone_failsafe.sql
+-- Per-zone failsafe settings. NULL limits fall back to system_override_min_temp/max_temp.
+
+ALTER TABLE zones ADD COLUMN failsafe_enabled BOOLEAN NOT NULL DEFAULT TRUE;
+ALTER TABLE zones ADD COLUMN failsafe_min_temp REAL;  -- Freeze protection limit
+ALTER TABLE zones ADD COLUMN failsafe_max_temp REAL;  -- Overheat limit
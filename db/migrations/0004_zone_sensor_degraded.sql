@@ -0,0 +1,4 @@
+-- 0004_zone_sensor_degraded.sql
+-- Zones whose sensor has gone without a valid reading for longer than failsafe_stale_minutes.
+
+ALTER TABLE zones ADD COLUMN sensor_degraded_since TEXT;  -- ISO8601 time of the last valid reading, NULL when healthy
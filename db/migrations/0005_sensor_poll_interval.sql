@@ -0,0 +1,4 @@
+-- 0005_sensor_poll_interval.sql
+-- Per-sensor poll intervals, so slow-moving sensors can be read less often than zone sensors.
+
+ALTER TABLE sensors ADD COLUMN poll_interval_seconds INTEGER NOT NULL DEFAULT 0;  -- 0 uses the system poll interval
@@ -0,0 +1,4 @@
+-- 0006_sensor_type.sql
+-- Sensors other than DS18B20s on the 1-Wire bus, see the model.Sensor* types.
+
+ALTER TABLE sensors ADD COLUMN type TEXT NOT NULL DEFAULT 'w1';  -- w1, w1_temperature, iio, hwmon, mqtt or http
@@ -0,0 +1,16 @@
+-- 0007_zone_sensors.sql
+-- Zones that combine several sensors into one temperature, see model.Zone.Sensors.
+-- zones.sensor_id stays the primary sensor.
+
+CREATE TABLE IF NOT EXISTS zone_sensors (
+    zone_id TEXT NOT NULL REFERENCES zones(id) ON DELETE CASCADE,
+    sensor_id TEXT NOT NULL REFERENCES sensors(id) ON DELETE CASCADE,
+    weight REAL NOT NULL DEFAULT 1,  -- Used by the weighted_mean aggregation
+    position INTEGER NOT NULL DEFAULT 0,  -- Order the sensors are listed in the config
+    PRIMARY KEY (zone_id, sensor_id)
+);
+
+ALTER TABLE zones ADD COLUMN aggregation TEXT NOT NULL DEFAULT 'mean';  -- mean, weighted_mean, min, max or median
+
+-- Every existing zone reads just its primary sensor
+INSERT INTO zone_sensors (zone_id, sensor_id) SELECT id, sensor_id FROM zones WHERE sensor_id IS NOT NULL;
@@ -0,0 +1,5 @@
+-- 0008_zone_humidity.sql
+-- Optional humidity sensing per zone, used by zones with the dehumidify capability.
+
+ALTER TABLE zones ADD COLUMN humidity_sensor_id TEXT REFERENCES sensors(id) ON DELETE SET NULL;
+ALTER TABLE zones ADD COLUMN humidity_target REAL;  -- Relative humidity percent, NULL when not set
@@ -0,0 +1,6 @@
+-- 0009_pump_exercise.sql
+-- Exercise runs for idle circulator and radiant loop pumps, so pumps don't seize over a season of
+-- no use and controllers can leave a pump alone while it is being exercised.
+
+ALTER TABLE devices ADD COLUMN pump_exercise_started_at TEXT;  -- Set while an exercise run is in progress
+ALTER TABLE devices ADD COLUMN pump_last_run_at TEXT;          -- When the pump last ran, for demand or exercise
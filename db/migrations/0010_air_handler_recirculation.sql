@@ -0,0 +1,8 @@
+-- 0010_air_handler_recirculation.sql
+-- Recirculation settings and run state per air handler, replacing the single system-wide
+-- recirculation flag so one handler's run no longer holds every blower on.
+
+ALTER TABLE devices ADD COLUMN recirculation_policy TEXT;             -- JSON RecirculationPolicy from the air handler config
+ALTER TABLE devices ADD COLUMN recirculation_started_at TEXT;         -- Set while a recirculation run is in progress
+ALTER TABLE devices ADD COLUMN recirculation_ends_at TEXT;            -- When the run in progress should stop
+ALTER TABLE devices ADD COLUMN recirculation_manual BOOLEAN NOT NULL DEFAULT FALSE;  -- The run was started through the API
@@ -0,0 +1,5 @@
+-- 0011_zone_circulation.sql
+-- Blower duty cycle for circulate mode, and circulation between heating and cooling calls.
+
+ALTER TABLE zones ADD COLUMN circulate_minutes_per_hour INTEGER NOT NULL DEFAULT 0;   -- 0 runs the blower continuously
+ALTER TABLE zones ADD COLUMN circulate_between_calls BOOLEAN NOT NULL DEFAULT FALSE;  -- Circulate while heating/cooling is idle
@@ -0,0 +1,14 @@
+-- 0012_zone_groups.sql
+-- Named groups of zones from the config, so several zones can be switched in one change.
+
+CREATE TABLE IF NOT EXISTS zone_groups (
+    id TEXT PRIMARY KEY,
+    label TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS zone_group_members (
+    group_id TEXT NOT NULL REFERENCES zone_groups(id) ON DELETE CASCADE,
+    zone_id TEXT NOT NULL REFERENCES zones(id) ON DELETE CASCADE,
+    position INTEGER NOT NULL DEFAULT 0,  -- Order the zones are listed in the config
+    PRIMARY KEY (group_id, zone_id)
+);
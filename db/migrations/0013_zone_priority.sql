@@ -0,0 +1,5 @@
+-- 0013_zone_priority.sql
+-- Zone demand weighting, and load shedding of low-priority zones while heating capacity is limited.
+
+ALTER TABLE zones ADD COLUMN priority REAL NOT NULL DEFAULT 1;  -- Weight of the zone's heating demand
+ALTER TABLE zones ADD COLUMN shed_since TEXT;                   -- ISO8601 time the zone was shed, NULL when not shed
@@ -6,56 +6,260 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/thatsimonsguy/hvac-controller/internal/config"
 	"github.com/thatsimonsguy/hvac-controller/internal/model"
 )
 
 // GetSystemMode retrieves the current system mode.
-func GetSystemMode(db *sql.DB) (model.SystemMode, error) {
+func (r *Repository) GetSystemMode() (model.SystemMode, error) {
 	var mode string
-	err := db.QueryRow(`SELECT system_mode FROM system WHERE id = 1`).Scan(&mode)
+	err := r.queryRow(`SELECT system_mode FROM system WHERE id = 1`).Scan(&mode)
 	if err != nil {
 		return model.ModeOff, fmt.Errorf("failed to get system mode: %w", err)
 	}
 	return model.SystemMode(mode), nil
 }
 
+// zoneColumns is the column list scanZone expects.
+const zoneColumns = `id, label, setpoint, mode, capabilities, sensor_id, failsafe_enabled, failsafe_min_temp, failsafe_max_temp, sensor_degraded_since, aggregation,
+	humidity_sensor_id, humidity_target, circulate_minutes_per_hour, circulate_between_calls, priority, shed_since`
+
+// scanZone reads a row selected with zoneColumns.
+func scanZone(scan func(dest ...interface{}) error) (model.Zone, error) {
+	var z model.Zone
+	var capabilities string
+	var enabled bool
+	var minTemp, maxTemp sql.NullFloat64
+	var degradedSince, humiditySensorID, shedSince sql.NullString
+	var humidityTarget sql.NullFloat64
+	var circulate model.Circulation
+	if err := scan(&z.ID, &z.Label, &z.Setpoint, &z.Mode, &capabilities, &z.Sensor.ID, &enabled, &minTemp, &maxTemp, &degradedSince, &z.Aggregation,
+		&humiditySensorID, &humidityTarget, &circulate.MinutesPerHour, &circulate.BetweenCalls, &z.Priority, &shedSince); err != nil {
+		return z, err
+	}
+	z.Circulate = &circulate
+	if humiditySensorID.Valid {
+		z.HumiditySensor = &model.Sensor{ID: humiditySensorID.String}
+	}
+	z.HumidityTarget = humidityTarget.Float64
+	json.Unmarshal([]byte(capabilities), &z.Capabilities)
+	z.FailsafeEnabled = &enabled
+	z.FailsafeMinTemp, z.FailsafeMaxTemp = nullFloatPtr(minTemp), nullFloatPtr(maxTemp)
+	if degradedSince.Valid {
+		if since, err := time.Parse(time.RFC3339, degradedSince.String); err == nil {
+			z.SensorDegradedSince = &since
+		}
+	}
+	if shedSince.Valid {
+		if since, err := time.Parse(time.RFC3339, shedSince.String); err == nil {
+			z.ShedSince = &since
+		}
+	}
+	return z, nil
+}
+
+// zoneSensors returns the sensors of every zone, or of just zoneID when it is set, keyed by zone
+// ID in their configured order.
+func (r *Repository) zoneSensors(zoneID string) (map[string][]model.ZoneSensor, error) {
+	rows, err := r.query(`SELECT zs.zone_id, s.id, s.type, s.bus, s.poll_interval_seconds, zs.weight FROM zone_sensors zs
+		JOIN sensors s ON s.id = zs.sensor_id
+		WHERE ? = '' OR zs.zone_id = ?
+		ORDER BY zs.zone_id, zs.position`, zoneID, zoneID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query zone sensors: %w", err)
+	}
+	defer rows.Close()
+
+	sensors := make(map[string][]model.ZoneSensor)
+	for rows.Next() {
+		var id string
+		var s model.ZoneSensor
+		if err := rows.Scan(&id, &s.ID, &s.Type, &s.Bus, &s.PollIntervalSeconds, &s.Weight); err != nil {
+			return nil, fmt.Errorf("failed to scan zone sensor: %w", err)
+		}
+		sensors[id] = append(sensors[id], s)
+	}
+	return sensors, rows.Err()
+}
+
 // GetAllZones retrieves all zones from the database.
-func GetAllZones(db *sql.DB) ([]model.Zone, error) {
-	rows, err := db.Query(`SELECT id, label, setpoint, mode, capabilities, sensor_id FROM zones`)
+func (r *Repository) GetAllZones() ([]model.Zone, error) {
+	rows, err := r.query(`SELECT ` + zoneColumns + ` FROM zones`)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query zones: %w", err)
 	}
-	defer rows.Close()
 
 	var zones []model.Zone
 	for rows.Next() {
-		var z model.Zone
-		var capabilities string
-		err = rows.Scan(&z.ID, &z.Label, &z.Setpoint, &z.Mode, &capabilities, &z.Sensor.ID)
+		z, err := scanZone(rows.Scan)
 		if err != nil {
+			rows.Close()
 			return nil, fmt.Errorf("failed to scan zone: %w", err)
 		}
-		json.Unmarshal([]byte(capabilities), &z.Capabilities)
 		zones = append(zones, z)
 	}
+	rows.Close()
+
+	sensors, err := r.zoneSensors("")
+	if err != nil {
+		return nil, err
+	}
+	for i := range zones {
+		zones[i].Sensors = sensors[zones[i].ID]
+	}
 	return zones, nil
 }
 
 // GetZoneByID retrieves a specific zone by its ID.
-func GetZoneByID(db *sql.DB, id string) (*model.Zone, error) {
-	var z model.Zone
-	var capabilities string
-	err := db.QueryRow(`SELECT id, label, setpoint, mode, capabilities, sensor_id FROM zones WHERE id = ?`, id).Scan(&z.ID, &z.Label, &z.Setpoint, &z.Mode, &capabilities, &z.Sensor.ID)
+func (r *Repository) GetZoneByID(id string) (*model.Zone, error) {
+	z, err := scanZone(r.queryRow(`SELECT `+zoneColumns+` FROM zones WHERE id = ?`, id).Scan)
 	if err != nil {
 		return &z, fmt.Errorf("failed to get zone %s: %w", id, err)
 	}
-	json.Unmarshal([]byte(capabilities), &z.Capabilities)
+	sensors, err := r.zoneSensors(id)
+	if err != nil {
+		return &z, err
+	}
+	z.Sensors = sensors[id]
 	return &z, nil
 }
 
+// zoneCapabilities is every capability a zone can list, in report order.
+var zoneCapabilities = []string{config.CapabilityHeating, config.CapabilityCooling, config.CapabilityFan, config.CapabilityDehumidify}
+
+// GetZoneCapabilities derives what a zone can do from the capabilities it lists and the active
+// modes of the distribution devices attached to it. Only an air handler that cools can dehumidify.
+func (r *Repository) GetZoneCapabilities(zoneID string) (*model.CapabilityReport, error) {
+	zone, err := r.GetZoneByID(zoneID)
+	if err != nil {
+		return nil, err
+	}
+	return zoneCapabilityReport(r.query, *zone)
+}
+
+// zoneCapabilityReport builds a zone's capability report, see GetZoneCapabilities, reading its
+// devices with query so it can run inside a transaction.
+func zoneCapabilityReport(query func(string, ...interface{}) (*sql.Rows, error), zone model.Zone) (*model.CapabilityReport, error) {
+	rows, err := query(`SELECT name, device_type, active_modes FROM devices WHERE zone_id = ? AND role = 'distributor' ORDER BY name`, zone.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query zone devices: %w", err)
+	}
+	defer rows.Close()
+
+	provided := make(map[string][]string) // capability -> device names
+	for rows.Next() {
+		var name, deviceType, activeModes string
+		if err := rows.Scan(&name, &deviceType, &activeModes); err != nil {
+			return nil, fmt.Errorf("failed to scan zone device: %w", err)
+		}
+		var modes []string
+		json.Unmarshal([]byte(activeModes), &modes)
+		for _, m := range modes {
+			provided[m] = append(provided[m], name)
+			if m == config.CapabilityCooling && deviceType == "air_handler" {
+				provided[config.CapabilityDehumidify] = append(provided[config.CapabilityDehumidify], name)
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query zone devices: %w", err)
+	}
+
+	report := &model.CapabilityReport{Zone: zone.ID}
+	for _, c := range zoneCapabilities {
+		devices := provided[c]
+		if devices == nil {
+			devices = []string{}
+		}
+		report.Capabilities = append(report.Capabilities, model.ZoneCapability{Capability: c, Listed: zone.HasCapability(c), Devices: devices})
+	}
+	return report, nil
+}
+
+// GetZoneGroups retrieves the configured zone groups, without the implicit all-zones group.
+func (r *Repository) GetZoneGroups() ([]model.ZoneGroup, error) {
+	rows, err := r.query(`SELECT id, label FROM zone_groups ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query zone groups: %w", err)
+	}
+	var groups []model.ZoneGroup
+	for rows.Next() {
+		var g model.ZoneGroup
+		if err := rows.Scan(&g.ID, &g.Label); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan zone group: %w", err)
+		}
+		groups = append(groups, g)
+	}
+	rows.Close()
+
+	members, err := zoneGroupMembers(r.query)
+	if err != nil {
+		return nil, err
+	}
+	for i := range groups {
+		groups[i].Zones = members[groups[i].ID]
+	}
+	return groups, nil
+}
+
+// GetZoneGroup retrieves a zone group by ID. model.AllZonesGroup returns every zone.
+func (r *Repository) GetZoneGroup(id string) (*model.ZoneGroup, error) {
+	if id == model.AllZonesGroup {
+		return r.allZonesGroup()
+	}
+	var g model.ZoneGroup
+	if err := r.queryRow(`SELECT id, label FROM zone_groups WHERE id = ?`, id).Scan(&g.ID, &g.Label); err != nil {
+		return nil, fmt.Errorf("failed to get zone group %s: %w", id, err)
+	}
+	members, err := zoneGroupMembers(r.query)
+	if err != nil {
+		return nil, err
+	}
+	g.Zones = members[id]
+	return &g, nil
+}
+
+func (r *Repository) allZonesGroup() (*model.ZoneGroup, error) {
+	rows, err := r.query(`SELECT id FROM zones ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query zones: %w", err)
+	}
+	defer rows.Close()
+
+	g := model.ZoneGroup{ID: model.AllZonesGroup, Label: "All zones"}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan zone: %w", err)
+		}
+		g.Zones = append(g.Zones, id)
+	}
+	return &g, rows.Err()
+}
+
+// zoneGroupMembers returns the zone IDs of every group, keyed by group ID in their configured order.
+func zoneGroupMembers(query func(string, ...interface{}) (*sql.Rows, error)) (map[string][]string, error) {
+	rows, err := query(`SELECT group_id, zone_id FROM zone_group_members ORDER BY group_id, position`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query zone group members: %w", err)
+	}
+	defer rows.Close()
+
+	members := make(map[string][]string)
+	for rows.Next() {
+		var groupID, zoneID string
+		if err := rows.Scan(&groupID, &zoneID); err != nil {
+			return nil, fmt.Errorf("failed to scan zone group member: %w", err)
+		}
+		members[groupID] = append(members[groupID], zoneID)
+	}
+	return members, rows.Err()
+}
+
 // Sensor queries
-func GetAllSensors(db *sql.DB) ([]model.Sensor, error) {
-	rows, err := db.Query(`SELECT id, bus FROM sensors`)
+func (r *Repository) GetAllSensors() ([]model.Sensor, error) {
+	rows, err := r.query(`SELECT id, type, bus, poll_interval_seconds FROM sensors`)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query sensors: %w", err)
 	}
@@ -64,7 +268,7 @@ func GetAllSensors(db *sql.DB) ([]model.Sensor, error) {
 	var sensors []model.Sensor
 	for rows.Next() {
 		var s model.Sensor
-		err = rows.Scan(&s.ID, &s.Bus)
+		err = rows.Scan(&s.ID, &s.Type, &s.Bus, &s.PollIntervalSeconds)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan sensor: %w", err)
 		}
@@ -73,9 +277,9 @@ func GetAllSensors(db *sql.DB) ([]model.Sensor, error) {
 	return sensors, nil
 }
 
-func GetSensorByID(db *sql.DB, id string) (*model.Sensor, error) {
+func (r *Repository) GetSensorByID(id string) (*model.Sensor, error) {
 	var s model.Sensor
-	err := db.QueryRow(`SELECT id, bus FROM sensors WHERE id = ?`, id).Scan(&s.ID, &s.Bus)
+	err := r.queryRow(`SELECT id, type, bus, poll_interval_seconds FROM sensors WHERE id = ?`, id).Scan(&s.ID, &s.Type, &s.Bus, &s.PollIntervalSeconds)
 	if err != nil {
 		return &s, fmt.Errorf("failed to get sensor %s: %w", id, err)
 	}
@@ -83,8 +287,8 @@ func GetSensorByID(db *sql.DB, id string) (*model.Sensor, error) {
 }
 
 // GetHeatPumps retrieves all heat pumps from the database.
-func GetHeatPumps(db *sql.DB) ([]model.HeatPump, error) {
-	rows, err := db.Query(`SELECT name, pin_number, pin_active_high, min_on, min_off, online, last_changed, active_modes, mode_pin_number, mode_pin_active_high, is_primary, last_rotated FROM devices WHERE device_type = 'heat_pump'`)
+func (r *Repository) GetHeatPumps() ([]model.HeatPump, error) {
+	rows, err := r.query(`SELECT name, pin_number, pin_active_high, min_on, min_off, online, last_changed, active_modes, mode_pin_number, mode_pin_active_high, is_primary, last_rotated FROM devices WHERE device_type = 'heat_pump'`)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query heat pumps: %w", err)
 	}
@@ -121,8 +325,8 @@ func GetHeatPumps(db *sql.DB) ([]model.HeatPump, error) {
 }
 
 // GetBoilers retrieves all boilers from the database.
-func GetBoilers(db *sql.DB) ([]model.Boiler, error) {
-	rows, err := db.Query(`SELECT name, pin_number, pin_active_high, min_on, min_off, online, last_changed, active_modes FROM devices WHERE device_type = 'boiler'`)
+func (r *Repository) GetBoilers() ([]model.Boiler, error) {
+	rows, err := r.query(`SELECT name, pin_number, pin_active_high, min_on, min_off, online, last_changed, active_modes FROM devices WHERE device_type = 'boiler'`)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query boilers: %w", err)
 	}
@@ -147,9 +351,43 @@ func GetBoilers(db *sql.DB) ([]model.Boiler, error) {
 	return boilers, nil
 }
 
+// airHandlerColumns is the column list scanAirHandler expects.
+const airHandlerColumns = `name, pin_number, pin_active_high, min_on, min_off, online, last_changed, active_modes, zone_id, circ_pump_pin_number, circ_pump_pin_active_high,
+	recirculation_policy, recirculation_started_at, recirculation_ends_at, recirculation_manual`
+
+// scanAirHandler reads a row selected with airHandlerColumns.
+func scanAirHandler(scan func(dest ...interface{}) error) (model.AirHandler, error) {
+	var ah model.AirHandler
+	var d model.Device
+	var activeModes string
+	var lastChanged, policy, startedAt, endsAt sql.NullString
+	var zoneID string
+	err := scan(&d.Name, &d.Pin.Number, &d.Pin.ActiveHigh, &d.MinOn, &d.MinOff, &d.Online, &lastChanged, &activeModes, &zoneID, &ah.CircPumpPin.Number, &ah.CircPumpPin.ActiveHigh,
+		&policy, &startedAt, &endsAt, &ah.RecirculationRun.Manual)
+	if err != nil {
+		return ah, err
+	}
+	json.Unmarshal([]byte(activeModes), &d.ActiveModes)
+	if lastChanged.Valid {
+		d.LastChanged, _ = time.Parse(time.RFC3339, lastChanged.String)
+	}
+	if policy.Valid {
+		json.Unmarshal([]byte(policy.String), &ah.Recirculation)
+	}
+	if startedAt.Valid {
+		ah.RecirculationRun.StartedAt, _ = time.Parse(time.RFC3339, startedAt.String)
+	}
+	if endsAt.Valid {
+		ah.RecirculationRun.EndsAt, _ = time.Parse(time.RFC3339, endsAt.String)
+	}
+	ah.Device = d
+	ah.Zone = &model.Zone{ID: zoneID}
+	return ah, nil
+}
+
 // GetAirHandlers retrieves all air handlers from the database.
-func GetAirHandlers(db *sql.DB) ([]model.AirHandler, error) {
-	rows, err := db.Query(`SELECT name, pin_number, pin_active_high, min_on, min_off, online, last_changed, active_modes, zone_id, circ_pump_pin_number, circ_pump_pin_active_high FROM devices WHERE device_type = 'air_handler'`)
+func (r *Repository) GetAirHandlers() ([]model.AirHandler, error) {
+	rows, err := r.query(`SELECT ` + airHandlerColumns + ` FROM devices WHERE device_type = 'air_handler'`)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query air handlers: %w", err)
 	}
@@ -157,34 +395,18 @@ func GetAirHandlers(db *sql.DB) ([]model.AirHandler, error) {
 
 	var airHandlers []model.AirHandler
 	for rows.Next() {
-		var d model.Device
-		var activeModes string
-		var lastChanged sql.NullString
-		var zoneID string
-		var circPumpPinNumber int
-		var circPumpPinActiveHigh bool
-
-		err = rows.Scan(&d.Name, &d.Pin.Number, &d.Pin.ActiveHigh, &d.MinOn, &d.MinOff, &d.Online, &lastChanged, &activeModes, &zoneID, &circPumpPinNumber, &circPumpPinActiveHigh)
+		ah, err := scanAirHandler(rows.Scan)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan air handler: %w", err)
 		}
-		json.Unmarshal([]byte(activeModes), &d.ActiveModes)
-		if lastChanged.Valid {
-			d.LastChanged, _ = time.Parse(time.RFC3339, lastChanged.String)
-		}
-		ah := model.AirHandler{
-			Device:      d,
-			Zone:        &model.Zone{ID: zoneID},
-			CircPumpPin: model.GPIOPin{Number: circPumpPinNumber, ActiveHigh: circPumpPinActiveHigh},
-		}
 		airHandlers = append(airHandlers, ah)
 	}
 	return airHandlers, nil
 }
 
 // GetRadiantLoops retrieves all radiant loops from the database.
-func GetRadiantLoops(db *sql.DB) ([]model.RadiantFloorLoop, error) {
-	rows, err := db.Query(`SELECT name, pin_number, pin_active_high, min_on, min_off, online, last_changed, active_modes, zone_id FROM devices WHERE device_type = 'radiant_floor'`)
+func (r *Repository) GetRadiantLoops() ([]model.RadiantFloorLoop, error) {
+	rows, err := r.query(`SELECT name, pin_number, pin_active_high, min_on, min_off, online, last_changed, active_modes, zone_id FROM devices WHERE device_type = 'radiant_floor'`)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query radiant loops: %w", err)
 	}
@@ -215,8 +437,8 @@ func GetRadiantLoops(db *sql.DB) ([]model.RadiantFloorLoop, error) {
 }
 
 // GetAirHandlerByID retrieves a single air handler by device name (ID).
-func GetAirHandlerByID(db *sql.DB, id string) (*model.AirHandler, error) {
-	rows, err := db.Query(`SELECT name, pin_number, pin_active_high, min_on, min_off, online, last_changed, active_modes, zone_id, circ_pump_pin_number, circ_pump_pin_active_high FROM devices WHERE device_type = 'air_handler' AND zone_id = ?`, id)
+func (r *Repository) GetAirHandlerByID(id string) (*model.AirHandler, error) {
+	rows, err := r.query(`SELECT `+airHandlerColumns+` FROM devices WHERE device_type = 'air_handler' AND zone_id = ?`, id)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query air handler %s: %w", id, err)
 	}
@@ -224,26 +446,10 @@ func GetAirHandlerByID(db *sql.DB, id string) (*model.AirHandler, error) {
 
 	var airHandlers []model.AirHandler
 	for rows.Next() {
-		var d model.Device
-		var activeModes string
-		var lastChanged sql.NullString
-		var zoneID string
-		var circPumpPinNumber int
-		var circPumpPinActiveHigh bool
-
-		err = rows.Scan(&d.Name, &d.Pin.Number, &d.Pin.ActiveHigh, &d.MinOn, &d.MinOff, &d.Online, &lastChanged, &activeModes, &zoneID, &circPumpPinNumber, &circPumpPinActiveHigh)
+		ah, err := scanAirHandler(rows.Scan)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan air handler: %w", err)
 		}
-		json.Unmarshal([]byte(activeModes), &d.ActiveModes)
-		if lastChanged.Valid {
-			d.LastChanged, _ = time.Parse(time.RFC3339, lastChanged.String)
-		}
-		ah := model.AirHandler{
-			Device:      d,
-			Zone:        &model.Zone{ID: zoneID},
-			CircPumpPin: model.GPIOPin{Number: circPumpPinNumber, ActiveHigh: circPumpPinActiveHigh},
-		}
 		airHandlers = append(airHandlers, ah)
 	}
 
@@ -257,8 +463,8 @@ func GetAirHandlerByID(db *sql.DB, id string) (*model.AirHandler, error) {
 }
 
 // GetRadiantLoopByID retrieves a single radiant floor loop by device name (ID).
-func GetRadiantLoopByID(db *sql.DB, id string) (*model.RadiantFloorLoop, error) {
-	rows, err := db.Query(`SELECT name, pin_number, pin_active_high, min_on, min_off, online, last_changed, active_modes, zone_id FROM devices WHERE device_type = 'radiant_floor' AND zone_id = ?`, id)
+func (r *Repository) GetRadiantLoopByID(id string) (*model.RadiantFloorLoop, error) {
+	rows, err := r.query(`SELECT name, pin_number, pin_active_high, min_on, min_off, online, last_changed, active_modes, zone_id FROM devices WHERE device_type = 'radiant_floor' AND zone_id = ?`, id)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query radiant loop %s: %w", id, err)
 	}
@@ -296,10 +502,10 @@ func GetRadiantLoopByID(db *sql.DB, id string) (*model.RadiantFloorLoop, error)
 }
 
 // GetMainPowerPin retrieves the main power pin configuration as a GPIOPin.
-func GetMainPowerPin(db *sql.DB) (model.GPIOPin, error) {
+func (r *Repository) GetMainPowerPin() (model.GPIOPin, error) {
 	var pinNumber int
 	var activeHigh bool
-	err := db.QueryRow(`SELECT main_power_pin_number, main_power_pin_active_high FROM system WHERE id = 1`).Scan(&pinNumber, &activeHigh)
+	err := r.queryRow(`SELECT main_power_pin_number, main_power_pin_active_high FROM system WHERE id = 1`).Scan(&pinNumber, &activeHigh)
 	if err != nil {
 		return model.GPIOPin{}, fmt.Errorf("failed to get MainPowerPin: %w", err)
 	}
@@ -310,12 +516,32 @@ func GetMainPowerPin(db *sql.DB) (model.GPIOPin, error) {
 }
 
 // GetSystemOverride retrieves the current override state.
-func GetSystemOverride(db *sql.DB) (bool, error) {
+func (r *Repository) GetSystemOverride() (bool, error) {
 	var overrideActive bool
-	err := db.QueryRow(`SELECT override_active FROM system WHERE id = 1`).Scan(&overrideActive)
+	err := r.queryRow(`SELECT override_active FROM system WHERE id = 1`).Scan(&overrideActive)
 	if err != nil {
 		return false, fmt.Errorf("failed to get system override state: %w", err)
 	}
 	return overrideActive, nil
 }
 
+
+// GetDeviceFlowSensors retrieves the optional supply and return sensors attached to a distribution device.
+// Either sensor is nil when it is not configured.
+func (r *Repository) GetDeviceFlowSensors(deviceName string) (supply *model.Sensor, ret *model.Sensor, err error) {
+	var supplyID, supplyType, supplyBus, returnID, returnType, returnBus sql.NullString
+	err = r.queryRow(`SELECT s.id, s.type, s.bus, r.id, r.type, r.bus FROM devices d
+		LEFT JOIN sensors s ON s.id = d.supply_sensor_id
+		LEFT JOIN sensors r ON r.id = d.return_sensor_id
+		WHERE d.name = ?`, deviceName).Scan(&supplyID, &supplyType, &supplyBus, &returnID, &returnType, &returnBus)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get flow sensors for %s: %w", deviceName, err)
+	}
+	if supplyID.Valid {
+		supply = &model.Sensor{ID: supplyID.String, Type: supplyType.String, Bus: supplyBus.String}
+	}
+	if returnID.Valid {
+		ret = &model.Sensor{ID: returnID.String, Type: returnType.String, Bus: returnBus.String}
+	}
+	return supply, ret, nil
+}
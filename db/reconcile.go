@@ -0,0 +1,721 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/thatsimonsguy/hvac-controller/internal/config"
+	"github.com/thatsimonsguy/hvac-controller/internal/model"
+)
+
+// ConfigChange is a single difference between the config and the database.
+type ConfigChange struct {
+	Action string // add, update or remove
+	Kind   string // system, sensor, zone, group or device
+	Name   string
+	Detail string
+}
+
+func (c ConfigChange) String() string {
+	s := fmt.Sprintf("%-6s %-6s %s", c.Action, c.Kind, c.Name)
+	if c.Detail != "" {
+		s += " (" + c.Detail + ")"
+	}
+	return s
+}
+
+// ConfigDiff lists every change needed to bring the database in line with the config.
+type ConfigDiff []ConfigChange
+
+func (d ConfigDiff) String() string {
+	if len(d) == 0 {
+		return "database matches config"
+	}
+	lines := make([]string, len(d))
+	for i, c := range d {
+		lines[i] = c.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// ReconcileConfig diffs c against the sensors, zones, zone groups, devices and system pins in the database
+// and, unless dryRun is set, applies the additions, updates and removals in one transaction.
+// Only config-owned columns are written: runtime state such as setpoints, modes, online,
+// last_changed, is_primary and last_rotated is preserved for rows that already exist.
+func ReconcileConfig(db *sql.DB, c *config.Config, dryRun bool) (ConfigDiff, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var diff ConfigDiff
+	record := func(action, kind, name, detail string) {
+		diff = append(diff, ConfigChange{Action: action, Kind: kind, Name: name, Detail: detail})
+	}
+	// exec is a no-op on dry runs so the same code path produces the diff and the changes
+	exec := func(query string, args ...interface{}) error {
+		if dryRun {
+			return nil
+		}
+		_, err := tx.Exec(query, args...)
+		return err
+	}
+
+	if err := reconcileSystem(tx, c, record, exec); err != nil {
+		return nil, err
+	}
+
+	sensors, err := reconcileSensors(tx, c, record, exec)
+	if err != nil {
+		return nil, err
+	}
+	zones, err := reconcileZones(tx, c, record, exec)
+	if err != nil {
+		return nil, err
+	}
+	if err := reconcileZoneGroups(tx, c, record, exec); err != nil {
+		return nil, err
+	}
+	if err := reconcileDevices(tx, c, record, exec); err != nil {
+		return nil, err
+	}
+
+	// Removals run last and in dependency order so nothing still in use is dropped
+	for _, id := range zones {
+		if err := exec(`DELETE FROM zones WHERE id = ?`, id); err != nil {
+			return nil, fmt.Errorf("remove zone %s: %w", id, err)
+		}
+	}
+	for _, id := range sensors {
+		if err := exec(`DELETE FROM sensors WHERE id = ?`, id); err != nil {
+			return nil, fmt.Errorf("remove sensor %s: %w", id, err)
+		}
+	}
+
+	if dryRun {
+		return diff, nil
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit config changes: %w", err)
+	}
+
+	for _, change := range diff {
+		log.Info().
+			Str("action", change.Action).
+			Str("kind", change.Kind).
+			Str("name", change.Name).
+			Str("detail", change.Detail).
+			Msg("Reconciled config change")
+	}
+	return diff, nil
+}
+
+type recordFunc func(action, kind, name, detail string)
+type execFunc func(query string, args ...interface{}) error
+
+func reconcileSystem(tx *sql.Tx, c *config.Config, record recordFunc, exec execFunc) error {
+	var mainPin, busPin sql.NullInt64
+	var activeHigh sql.NullBool
+	err := tx.QueryRow(`SELECT main_power_pin_number, main_power_pin_active_high, temp_sensor_bus_pin FROM system WHERE id = 1`).
+		Scan(&mainPin, &activeHigh, &busPin)
+	if err != nil {
+		return fmt.Errorf("read system row: %w", err)
+	}
+	current := columnSet{
+		{"main_power_pin_number", mainPin},
+		{"main_power_pin_active_high", activeHigh},
+		{"temp_sensor_bus_pin", busPin},
+	}
+	desired := columnSet{
+		{"main_power_pin_number", nullInt(c.MainPowerGPIO)},
+		{"main_power_pin_active_high", nullBool(c.MainPowerActiveHigh)},
+		{"temp_sensor_bus_pin", nullInt(c.TempSensorBusGPIO)},
+	}
+
+	if changes := current.changes(desired); len(changes) > 0 {
+		record("update", "system", "system", strings.Join(changes, ", "))
+		if err := exec(`UPDATE system SET main_power_pin_number = ?, main_power_pin_active_high = ?, temp_sensor_bus_pin = ? WHERE id = 1`,
+			c.MainPowerGPIO, c.MainPowerActiveHigh, c.TempSensorBusGPIO); err != nil {
+			return fmt.Errorf("update system pins: %w", err)
+		}
+	}
+	return nil
+}
+
+// reconcileSensors adds and updates sensors, and returns the IDs to remove once nothing references them.
+func reconcileSensors(tx *sql.Tx, c *config.Config, record recordFunc, exec execFunc) ([]string, error) {
+	desired := make(map[string]model.Sensor)
+	for _, s := range configSensors(c) {
+		desired[s.ID] = s
+	}
+
+	rows, err := tx.Query(`SELECT id, type, bus, poll_interval_seconds FROM sensors`)
+	if err != nil {
+		return nil, fmt.Errorf("read sensors: %w", err)
+	}
+	current := make(map[string]columnSet)
+	for rows.Next() {
+		var id string
+		var sensorType, bus sql.NullString
+		var interval sql.NullInt64
+		if err := rows.Scan(&id, &sensorType, &bus, &interval); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scan sensor: %w", err)
+		}
+		current[id] = columnSet{{"type", sensorType}, {"bus", bus}, {"poll_interval_seconds", interval}}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("read sensors: %w", err)
+	}
+
+	for _, id := range sortedKeys(desired) {
+		s := desired[id]
+		existing, exists := current[id]
+		if !exists {
+			record("add", "sensor", id, "bus "+s.Bus)
+			if err := exec(`INSERT INTO sensors (id, type, bus, poll_interval_seconds) VALUES (?, ?, ?, ?)`, id, s.Driver(), s.Bus, s.PollIntervalSeconds); err != nil {
+				return nil, fmt.Errorf("add sensor %s: %w", id, err)
+			}
+			continue
+		}
+
+		want := columnSet{{"type", nullString(s.Driver())}, {"bus", nullString(s.Bus)}, {"poll_interval_seconds", nullInt(s.PollIntervalSeconds)}}
+		if changes := existing.changes(want); len(changes) > 0 {
+			record("update", "sensor", id, strings.Join(changes, ", "))
+			if err := exec(`UPDATE sensors SET type = ?, bus = ?, poll_interval_seconds = ? WHERE id = ?`, append(want.values(), id)...); err != nil {
+				return nil, fmt.Errorf("update sensor %s: %w", id, err)
+			}
+		}
+	}
+
+	var removed []string
+	for _, id := range sortedKeys(current) {
+		if _, ok := desired[id]; !ok {
+			record("remove", "sensor", id, "")
+			removed = append(removed, id)
+		}
+	}
+	return removed, nil
+}
+
+// reconcileZones adds and updates zones, and returns the IDs to remove once their devices are gone.
+// New zones start off at their configured setpoint; existing zones keep their setpoint and mode.
+// Failsafe settings are only reconciled when the config sets them, so changes made through the API
+// survive a restart unless the config says otherwise.
+func reconcileZones(tx *sql.Tx, c *config.Config, record recordFunc, exec execFunc) ([]string, error) {
+	rows, err := tx.Query(`SELECT id, label, capabilities, sensor_id, failsafe_enabled, failsafe_min_temp, failsafe_max_temp, aggregation,
+		humidity_sensor_id, humidity_target, circulate_minutes_per_hour, circulate_between_calls, priority FROM zones`)
+	if err != nil {
+		return nil, fmt.Errorf("read zones: %w", err)
+	}
+	current := make(map[string]columnSet)
+	for rows.Next() {
+		var id string
+		var label, capabilities, sensorID, aggregation, humiditySensorID sql.NullString
+		var failsafeEnabled, circulateBetween sql.NullBool
+		var failsafeMin, failsafeMax, humidityTarget, priority sql.NullFloat64
+		var circulateMinutes sql.NullInt64
+		if err := rows.Scan(&id, &label, &capabilities, &sensorID, &failsafeEnabled, &failsafeMin, &failsafeMax, &aggregation,
+			&humiditySensorID, &humidityTarget, &circulateMinutes, &circulateBetween, &priority); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scan zone: %w", err)
+		}
+		current[id] = columnSet{{"label", label}, {"capabilities", capabilities}, {"sensor_id", sensorID},
+			{"failsafe_enabled", failsafeEnabled}, {"failsafe_min_temp", failsafeMin}, {"failsafe_max_temp", failsafeMax},
+			{"aggregation", aggregation}, {"humidity_sensor_id", humiditySensorID}, {"humidity_target", humidityTarget},
+			{"circulate_minutes_per_hour", circulateMinutes}, {"circulate_between_calls", circulateBetween}, {"priority", priority}}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("read zones: %w", err)
+	}
+
+	// Each zone's sensor list, compared as a whole, e.g. "main_floor_sensor, sunroom_sensor*0.5"
+	rows, err = tx.Query(`SELECT zone_id, sensor_id, weight FROM zone_sensors ORDER BY zone_id, position`)
+	if err != nil {
+		return nil, fmt.Errorf("read zone sensors: %w", err)
+	}
+	currentSensors := make(map[string][]model.ZoneSensor)
+	for rows.Next() {
+		var zoneID string
+		var s model.ZoneSensor
+		if err := rows.Scan(&zoneID, &s.ID, &s.Weight); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scan zone sensor: %w", err)
+		}
+		currentSensors[zoneID] = append(currentSensors[zoneID], s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("read zone sensors: %w", err)
+	}
+
+	desired := make(map[string]bool)
+	for _, z := range c.Zones {
+		desired[z.ID] = true
+		capabilities := marshalJSON(z.Capabilities)
+
+		humiditySensorID, humidityTarget := zoneHumidity(z)
+
+		existing, ok := current[z.ID]
+		if !ok {
+			record("add", "zone", z.ID, "")
+			circulate := z.CirculateSettings()
+			if err := exec(`INSERT INTO zones (id, label, setpoint, mode, capabilities, sensor_id, failsafe_enabled, failsafe_min_temp, failsafe_max_temp, aggregation, humidity_sensor_id, humidity_target,
+				circulate_minutes_per_hour, circulate_between_calls, priority) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+				z.ID, z.Label, z.Setpoint, model.ModeOff, capabilities, z.Sensor.ID, z.FailsafeActive(), z.FailsafeMinTemp, z.FailsafeMaxTemp, z.AggregationMethod(), humiditySensorID, humidityTarget,
+				circulate.MinutesPerHour, circulate.BetweenCalls, z.PriorityWeight()); err != nil {
+				return nil, fmt.Errorf("add zone %s: %w", z.ID, err)
+			}
+			if err := writeZoneSensors(exec, z); err != nil {
+				return nil, fmt.Errorf("add sensors for zone %s: %w", z.ID, err)
+			}
+			continue
+		}
+
+		// Settings the config leaves unset keep their current values
+		failsafeEnabled, failsafeMin, failsafeMax := existing.value("failsafe_enabled"), existing.value("failsafe_min_temp"), existing.value("failsafe_max_temp")
+		if z.FailsafeEnabled != nil {
+			failsafeEnabled = nullBool(*z.FailsafeEnabled)
+		}
+		if z.FailsafeMinTemp != nil {
+			failsafeMin = nullFloat(*z.FailsafeMinTemp)
+		}
+		if z.FailsafeMaxTemp != nil {
+			failsafeMax = nullFloat(*z.FailsafeMaxTemp)
+		}
+		circulateMinutes, circulateBetween := existing.value("circulate_minutes_per_hour"), existing.value("circulate_between_calls")
+		if z.Circulate != nil {
+			circulateMinutes = nullInt(z.Circulate.MinutesPerHour)
+			circulateBetween = nullBool(z.Circulate.BetweenCalls)
+		}
+
+		want := columnSet{{"label", nullString(z.Label)}, {"capabilities", nullString(capabilities)}, {"sensor_id", nullString(z.Sensor.ID)},
+			{"failsafe_enabled", failsafeEnabled}, {"failsafe_min_temp", failsafeMin}, {"failsafe_max_temp", failsafeMax},
+			{"aggregation", nullString(z.AggregationMethod())}, {"humidity_sensor_id", humiditySensorID}, {"humidity_target", humidityTarget},
+			{"circulate_minutes_per_hour", circulateMinutes}, {"circulate_between_calls", circulateBetween}, {"priority", nullFloat(z.PriorityWeight())}}
+		changes := existing.changes(want)
+		from, to := formatZoneSensors(currentSensors[z.ID]), formatZoneSensors(z.ZoneSensors())
+		if from != to {
+			changes = append(changes, fmt.Sprintf("sensors: %s -> %s", from, to))
+		}
+		if len(changes) > 0 {
+			record("update", "zone", z.ID, strings.Join(changes, ", "))
+			if err := exec(`UPDATE zones SET label = ?, capabilities = ?, sensor_id = ?, failsafe_enabled = ?, failsafe_min_temp = ?, failsafe_max_temp = ?, aggregation = ?,
+				humidity_sensor_id = ?, humidity_target = ?, circulate_minutes_per_hour = ?, circulate_between_calls = ?, priority = ? WHERE id = ?`,
+				append(want.values(), z.ID)...); err != nil {
+				return nil, fmt.Errorf("update zone %s: %w", z.ID, err)
+			}
+		}
+		if from != to {
+			if err := writeZoneSensors(exec, z); err != nil {
+				return nil, fmt.Errorf("update sensors for zone %s: %w", z.ID, err)
+			}
+		}
+	}
+
+	var removed []string
+	for _, id := range sortedKeys(current) {
+		if !desired[id] {
+			record("remove", "zone", id, "")
+			removed = append(removed, id)
+		}
+	}
+	return removed, nil
+}
+
+// reconcileZoneGroups adds, updates and removes zone groups, replacing a group's member list
+// whenever it differs from the config.
+func reconcileZoneGroups(tx *sql.Tx, c *config.Config, record recordFunc, exec execFunc) error {
+	rows, err := tx.Query(`SELECT id, label FROM zone_groups`)
+	if err != nil {
+		return fmt.Errorf("read zone groups: %w", err)
+	}
+	current := make(map[string]columnSet)
+	for rows.Next() {
+		var id string
+		var label sql.NullString
+		if err := rows.Scan(&id, &label); err != nil {
+			rows.Close()
+			return fmt.Errorf("scan zone group: %w", err)
+		}
+		current[id] = columnSet{{"label", label}}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("read zone groups: %w", err)
+	}
+
+	members, err := zoneGroupMembers(tx.Query)
+	if err != nil {
+		return err
+	}
+
+	desired := make(map[string]bool)
+	for _, g := range c.ZoneGroups {
+		desired[g.ID] = true
+		existing, ok := current[g.ID]
+		if !ok {
+			record("add", "group", g.ID, strings.Join(g.Zones, ", "))
+			if err := exec(`INSERT INTO zone_groups (id, label) VALUES (?, ?)`, g.ID, g.Label); err != nil {
+				return fmt.Errorf("add zone group %s: %w", g.ID, err)
+			}
+			if err := writeZoneGroupMembers(exec, g); err != nil {
+				return fmt.Errorf("add zones for group %s: %w", g.ID, err)
+			}
+			continue
+		}
+
+		want := columnSet{{"label", nullString(g.Label)}}
+		changes := existing.changes(want)
+		from, to := strings.Join(members[g.ID], ", "), strings.Join(g.Zones, ", ")
+		if from != to {
+			changes = append(changes, fmt.Sprintf("zones: %s -> %s", from, to))
+		}
+		if len(changes) == 0 {
+			continue
+		}
+		record("update", "group", g.ID, strings.Join(changes, ", "))
+		if err := exec(`UPDATE zone_groups SET label = ? WHERE id = ?`, append(want.values(), g.ID)...); err != nil {
+			return fmt.Errorf("update zone group %s: %w", g.ID, err)
+		}
+		if from != to {
+			if err := writeZoneGroupMembers(exec, g); err != nil {
+				return fmt.Errorf("update zones for group %s: %w", g.ID, err)
+			}
+		}
+	}
+
+	for _, id := range sortedKeys(current) {
+		if desired[id] {
+			continue
+		}
+		record("remove", "group", id, "")
+		if err := exec(`DELETE FROM zone_groups WHERE id = ?`, id); err != nil {
+			return fmt.Errorf("remove zone group %s: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// writeZoneGroupMembers replaces a group's member list with the configured one.
+func writeZoneGroupMembers(exec execFunc, g model.ZoneGroup) error {
+	if err := exec(`DELETE FROM zone_group_members WHERE group_id = ?`, g.ID); err != nil {
+		return err
+	}
+	for i, id := range g.Zones {
+		if err := exec(`INSERT INTO zone_group_members (group_id, zone_id, position) VALUES (?, ?, ?)`, g.ID, id, i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// zoneHumidity returns a zone's humidity sensor ID and target, NULL where the config leaves them unset.
+func zoneHumidity(z model.Zone) (sensorID sql.NullString, target sql.NullFloat64) {
+	if z.HumiditySensor != nil {
+		sensorID = nullString(z.HumiditySensor.ID)
+	}
+	if z.HumidityTarget > 0 {
+		target = nullFloat(z.HumidityTarget)
+	}
+	return sensorID, target
+}
+
+// zoneConfigSensors lists every sensor a zone config defines: its primary, the sensors it
+// aggregates and its humidity sensor.
+func zoneConfigSensors(z model.Zone) []model.Sensor {
+	sensors := []model.Sensor{z.Sensor}
+	for _, s := range z.Sensors {
+		sensors = append(sensors, s.Sensor)
+	}
+	if z.HumiditySensor != nil {
+		sensors = append(sensors, *z.HumiditySensor)
+	}
+	return sensors
+}
+
+// writeZoneSensors replaces a zone's sensor list with the configured one.
+func writeZoneSensors(exec execFunc, z model.Zone) error {
+	if err := exec(`DELETE FROM zone_sensors WHERE zone_id = ?`, z.ID); err != nil {
+		return err
+	}
+	for i, s := range z.ZoneSensors() {
+		if err := exec(`INSERT INTO zone_sensors (zone_id, sensor_id, weight, position) VALUES (?, ?, ?, ?)`,
+			z.ID, s.ID, s.EffectiveWeight(), i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// formatZoneSensors lists sensor IDs in order, with their weight where it isn't 1.
+func formatZoneSensors(sensors []model.ZoneSensor) string {
+	if len(sensors) == 0 {
+		return "NULL"
+	}
+	parts := make([]string, len(sensors))
+	for i, s := range sensors {
+		parts[i] = s.ID
+		if w := s.EffectiveWeight(); w != 1 {
+			parts[i] += fmt.Sprintf("*%v", w)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// deviceColumnNames are the config-owned device columns, in the order configDevice fills them.
+var deviceColumnNames = []string{
+	"device_type", "role", "pin_number", "pin_active_high", "min_on", "min_off", "active_modes", "zone_id",
+	"circ_pump_pin_number", "circ_pump_pin_active_high", "mode_pin_number", "mode_pin_active_high",
+	"supply_sensor_id", "return_sensor_id", "recirculation_policy",
+}
+
+type configDevice struct {
+	name       string
+	deviceType string
+	columns    columnSet
+}
+
+func reconcileDevices(tx *sql.Tx, c *config.Config, record recordFunc, exec execFunc) error {
+	rows, err := tx.Query(`SELECT name, ` + strings.Join(deviceColumnNames, ", ") + ` FROM devices`)
+	if err != nil {
+		return fmt.Errorf("read devices: %w", err)
+	}
+	current := make(map[string]columnSet)
+	for rows.Next() {
+		var name string
+		var deviceType, role, activeModes, zoneID, supplyID, returnID, recirculation sql.NullString
+		var pin, minOn, minOff, circPin, modePin sql.NullInt64
+		var pinHigh, circHigh, modeHigh sql.NullBool
+		if err := rows.Scan(&name, &deviceType, &role, &pin, &pinHigh, &minOn, &minOff, &activeModes, &zoneID,
+			&circPin, &circHigh, &modePin, &modeHigh, &supplyID, &returnID, &recirculation); err != nil {
+			rows.Close()
+			return fmt.Errorf("scan device: %w", err)
+		}
+		current[name] = deviceColumns(deviceType, role, pin, pinHigh, minOn, minOff, activeModes, zoneID,
+			circPin, circHigh, modePin, modeHigh, supplyID, returnID, recirculation)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("read devices: %w", err)
+	}
+
+	hasPrimary := false
+	if err := tx.QueryRow(`SELECT COUNT(*) > 0 FROM devices WHERE device_type = 'heat_pump' AND is_primary = 1`).Scan(&hasPrimary); err != nil {
+		return fmt.Errorf("read primary heat pump: %w", err)
+	}
+
+	desired := make(map[string]bool)
+	for _, d := range configDevices(c) {
+		desired[d.name] = true
+		values := d.columns.values()
+
+		existing, ok := current[d.name]
+		if !ok {
+			record("add", "device", d.name, d.deviceType)
+
+			// New heat pumps only become primary when there is none yet, so an existing rotation is kept
+			now := time.Now().Format(time.RFC3339)
+			var primary, lastRotated interface{}
+			if d.deviceType == "heat_pump" {
+				primary = !hasPrimary
+				hasPrimary = true
+				lastRotated = now
+			}
+			args := append([]interface{}{d.name}, values...)
+			args = append(args, true, now, primary, lastRotated)
+			if err := exec(`INSERT INTO devices (name, `+strings.Join(deviceColumnNames, ", ")+`, online, last_changed, is_primary, last_rotated) VALUES (?`+
+				strings.Repeat(", ?", len(deviceColumnNames)+4)+`)`, args...); err != nil {
+				return fmt.Errorf("add device %s: %w", d.name, err)
+			}
+			continue
+		}
+
+		if changes := existing.changes(d.columns); len(changes) > 0 {
+			record("update", "device", d.name, strings.Join(changes, ", "))
+			args := append(values, d.name)
+			if err := exec(`UPDATE devices SET `+strings.Join(deviceColumnNames, " = ?, ")+` = ? WHERE name = ?`, args...); err != nil {
+				return fmt.Errorf("update device %s: %w", d.name, err)
+			}
+		}
+	}
+
+	for _, name := range sortedKeys(current) {
+		if desired[name] {
+			continue
+		}
+		record("remove", "device", name, "")
+		if err := exec(`DELETE FROM devices WHERE name = ?`, name); err != nil {
+			return fmt.Errorf("remove device %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// configDevices flattens the device groups into the rows the devices table should hold.
+func configDevices(c *config.Config) []configDevice {
+	dc := c.DeviceConfig
+	high := c.RelayBoardActiveHigh
+	var none sql.NullString
+	var noPin sql.NullInt64
+	var noHigh sql.NullBool
+
+	profile := func(p config.DeviceProfile) (sql.NullInt64, sql.NullInt64, sql.NullString) {
+		return nullInt(p.MinTimeOn * 60), nullInt(p.MinTimeOff * 60), nullString(marshalJSON(p.ActiveModes))
+	}
+	sensorID := func(s *model.Sensor) sql.NullString {
+		if s == nil {
+			return none
+		}
+		return nullString(s.ID)
+	}
+
+	var devices []configDevice
+	for _, d := range dc.HeatPumps.Devices {
+		minOn, minOff, modes := profile(dc.HeatPumps.DeviceProfile)
+		devices = append(devices, configDevice{d.Name, "heat_pump", deviceColumns(
+			nullString("heat_pump"), nullString("source"), nullInt(d.Pin), nullBool(high), minOn, minOff, modes, none,
+			noPin, noHigh, nullInt(d.ModePin), nullBool(high), none, none, none)})
+	}
+	for _, d := range dc.Boilers.Devices {
+		minOn, minOff, modes := profile(dc.Boilers.DeviceProfile)
+		devices = append(devices, configDevice{d.Name, "boiler", deviceColumns(
+			nullString("boiler"), nullString("source"), nullInt(d.Pin), nullBool(high), minOn, minOff, modes, none,
+			noPin, noHigh, noPin, noHigh, none, none, none)})
+	}
+	for _, d := range dc.AirHandlers.Devices {
+		minOn, minOff, modes := profile(dc.AirHandlers.DeviceProfile)
+		devices = append(devices, configDevice{d.Name, "air_handler", deviceColumns(
+			nullString("air_handler"), nullString("distributor"), nullInt(d.Pin), nullBool(high), minOn, minOff, modes, nullString(d.Zone),
+			nullInt(d.CircPumpPin), nullBool(high), noPin, noHigh, sensorID(d.SupplySensor), sensorID(d.ReturnSensor),
+			nullString(marshalJSON(d.Recirculation)))})
+	}
+	for _, d := range dc.RadiantFloorLoops.Devices {
+		minOn, minOff, modes := profile(dc.RadiantFloorLoops.DeviceProfile)
+		devices = append(devices, configDevice{d.Name, "radiant_floor", deviceColumns(
+			nullString("radiant_floor"), nullString("distributor"), nullInt(d.Pin), nullBool(high), minOn, minOff, modes, nullString(d.Zone),
+			noPin, noHigh, noPin, noHigh, sensorID(d.SupplySensor), sensorID(d.ReturnSensor), none)})
+	}
+	return devices
+}
+
+func deviceColumns(deviceType, role sql.NullString, pin sql.NullInt64, pinHigh sql.NullBool, minOn, minOff sql.NullInt64,
+	activeModes, zoneID sql.NullString, circPin sql.NullInt64, circHigh sql.NullBool, modePin sql.NullInt64, modeHigh sql.NullBool,
+	supplyID, returnID, recirculation sql.NullString) columnSet {
+	values := []interface{}{deviceType, role, pin, pinHigh, minOn, minOff, activeModes, zoneID, circPin, circHigh, modePin, modeHigh, supplyID, returnID, recirculation}
+	set := make(columnSet, len(values))
+	for i, v := range values {
+		set[i] = column{deviceColumnNames[i], v}
+	}
+	return set
+}
+
+// configSensors lists every sensor the config defines: system, zone and flow sensors. Sensors
+// shared between zones are listed once per use.
+func configSensors(c *config.Config) []model.Sensor {
+	var sensors []model.Sensor
+	for _, name := range sortedKeys(c.SystemSensors) {
+		sensors = append(sensors, c.SystemSensors[name])
+	}
+	for _, z := range c.Zones {
+		sensors = append(sensors, zoneConfigSensors(z)...)
+	}
+	for _, d := range c.DeviceConfig.AirHandlers.Devices {
+		for _, s := range []*model.Sensor{d.SupplySensor, d.ReturnSensor} {
+			if s != nil {
+				sensors = append(sensors, *s)
+			}
+		}
+	}
+	for _, d := range c.DeviceConfig.RadiantFloorLoops.Devices {
+		for _, s := range []*model.Sensor{d.SupplySensor, d.ReturnSensor} {
+			if s != nil {
+				sensors = append(sensors, *s)
+			}
+		}
+	}
+	return sensors
+}
+
+// column is a named, nullable column value as read from or written to the database.
+type column struct {
+	name  string
+	value interface{} // sql.NullString, sql.NullInt64, sql.NullFloat64 or sql.NullBool
+}
+
+type columnSet []column
+
+// changes describes the columns that differ between s and want, e.g. "min_on: 600 -> 900".
+func (s columnSet) changes(want columnSet) []string {
+	var changes []string
+	for _, col := range s {
+		from, to := formatNull(col.value), formatNull(want.value(col.name))
+		if from != to {
+			changes = append(changes, fmt.Sprintf("%s: %s -> %s", col.name, from, to))
+		}
+	}
+	return changes
+}
+
+// value returns the value of the named column, or nil if s has no such column.
+func (s columnSet) value(name string) interface{} {
+	for _, col := range s {
+		if col.name == name {
+			return col.value
+		}
+	}
+	return nil
+}
+
+// values returns the column values as query arguments.
+func (s columnSet) values() []interface{} {
+	values := make([]interface{}, len(s))
+	for i, col := range s {
+		values[i] = col.value
+	}
+	return values
+}
+
+func formatNull(v interface{}) string {
+	switch n := v.(type) {
+	case sql.NullString:
+		if n.Valid {
+			return n.String
+		}
+	case sql.NullInt64:
+		if n.Valid {
+			return fmt.Sprint(n.Int64)
+		}
+	case sql.NullBool:
+		if n.Valid {
+			return fmt.Sprint(n.Bool)
+		}
+	case sql.NullFloat64:
+		if n.Valid {
+			return fmt.Sprint(n.Float64)
+		}
+	}
+	return "NULL"
+}
+
+func nullString(s string) sql.NullString  { return sql.NullString{String: s, Valid: true} }
+func nullInt(i int) sql.NullInt64         { return sql.NullInt64{Int64: int64(i), Valid: true} }
+func nullBool(b bool) sql.NullBool        { return sql.NullBool{Bool: b, Valid: true} }
+func nullFloat(f float64) sql.NullFloat64 { return sql.NullFloat64{Float64: f, Valid: true} }
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,372 @@
+package db
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/thatsimonsguy/hvac-controller/internal/config"
+	"github.com/thatsimonsguy/hvac-controller/internal/model"
+)
+
+func reconcileTestConfig(dbPath string) *config.Config {
+	return &config.Config{
+		DBPath:            dbPath,
+		TempSensorBusGPIO: 4,
+		MainPowerGPIO:     25,
+		Zones: []model.Zone{
+			{ID: "main_floor", Label: "Main Floor", Setpoint: 70, Capabilities: []string{"heating"}, Sensor: model.Sensor{ID: "main_floor_sensor", Bus: "28-000000000001"}},
+			{ID: "garage", Label: "Garage", Setpoint: 55, Capabilities: []string{"heating"}, Sensor: model.Sensor{ID: "garage_sensor", Bus: "28-000000000002"}},
+		},
+		DeviceConfig: config.DeviceConfig{
+			HeatPumps: config.HeatPumpGroup{
+				DeviceProfile: config.DeviceProfile{MinTimeOn: 10, MinTimeOff: 5, ActiveModes: []string{"heating", "cooling"}},
+				Devices:       []config.HeatPumpConfig{{Name: "heat_pump_A", Pin: 23, ModePin: 18}},
+			},
+			Boilers: config.BoilerGroup{
+				DeviceProfile: config.DeviceProfile{MinTimeOn: 2, MinTimeOff: 5, ActiveModes: []string{"heating"}},
+				Devices:       []config.BoilerConfig{{Name: "boiler", Pin: 22}},
+			},
+			RadiantFloorLoops: config.RadiantLoopGroup{
+				DeviceProfile: config.DeviceProfile{MinTimeOn: 5, MinTimeOff: 3, ActiveModes: []string{"heating"}},
+				Devices: []config.RadiantLoopConfig{
+					{Name: "main_radiant_loop", Pin: 16, Zone: "main_floor"},
+					{Name: "garage_radiant_loop", Pin: 17, Zone: "garage"},
+				},
+			},
+		},
+		SystemSensors: map[string]model.Sensor{
+			"buffer_tank": {ID: "buffer_tank", Bus: "28-000000000003"},
+		},
+	}
+}
+
+// seedReconcileTestDB creates and seeds a database file from c, the way a first run would.
+func seedReconcileTestDB(t *testing.T, c *config.Config) *sql.DB {
+	repo, err := Open(c.DBPath)
+	require.NoError(t, err)
+	t.Cleanup(func() { repo.Close() })
+
+	firstRun, err := repo.InitializeIfMissing(c)
+	require.NoError(t, err)
+	require.True(t, firstRun)
+	return repo.conn
+}
+
+func TestReconcileConfig_SeededDatabaseMatches(t *testing.T) {
+	c := reconcileTestConfig(filepath.Join(t.TempDir(), "hvac.db"))
+	dbConn := seedReconcileTestDB(t, c)
+
+	diff, err := ReconcileConfig(dbConn, c, true)
+	require.NoError(t, err)
+	assert.Empty(t, diff, diff.String())
+}
+
+func TestReconcileConfig_AppliesChangesAndKeepsRuntimeState(t *testing.T) {
+	c := reconcileTestConfig(filepath.Join(t.TempDir(), "hvac.db"))
+	dbConn := seedReconcileTestDB(t, c)
+
+	// Runtime state the reconcile must not touch
+	_, err := dbConn.Exec(`UPDATE zones SET mode = 'heating', setpoint = 68 WHERE id = 'main_floor'`)
+	require.NoError(t, err)
+	_, err = dbConn.Exec(`UPDATE devices SET last_changed = '2024-01-01T00:00:00Z', online = 0 WHERE name = 'heat_pump_A'`)
+	require.NoError(t, err)
+
+	c.Zones[0].Sensor.Bus = "28-000000000009"
+	c.SystemSensors["buffer_tank"] = model.Sensor{ID: "buffer_tank", Bus: "28-000000000003", PollIntervalSeconds: 60}
+	c.Zones = append(c.Zones[:1], model.Zone{
+		ID: "office", Label: "Office", Setpoint: 68, Capabilities: []string{"heating"},
+		Sensor: model.Sensor{ID: "office_sensor", Bus: "28-000000000004"},
+	})
+	c.DeviceConfig.HeatPumps.DeviceProfile.MinTimeOn = 15
+	c.DeviceConfig.HeatPumps.Devices = append(c.DeviceConfig.HeatPumps.Devices, config.HeatPumpConfig{Name: "heat_pump_B", Pin: 24, ModePin: 19})
+	c.DeviceConfig.RadiantFloorLoops.Devices = []config.RadiantLoopConfig{
+		{Name: "main_radiant_loop", Pin: 16, Zone: "main_floor"},
+		{Name: "office_radiant_loop", Pin: 17, Zone: "office"},
+	}
+
+	expected := []string{
+		"update sensor main_floor_sensor (bus: 28-000000000001 -> 28-000000000009)",
+		"update sensor buffer_tank (poll_interval_seconds: 0 -> 60)",
+		"add    sensor office_sensor (bus 28-000000000004)",
+		"remove sensor garage_sensor",
+		"add    zone   office",
+		"remove zone   garage",
+		"update device heat_pump_A (min_on: 600 -> 900)",
+		"add    device heat_pump_B (heat_pump)",
+		"add    device office_radiant_loop (radiant_floor)",
+		"remove device garage_radiant_loop",
+	}
+
+	// Dry run reports the changes without applying them
+	diff, err := ReconcileConfig(dbConn, c, true)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, expected, diffLines(diff))
+
+	var count int
+	require.NoError(t, dbConn.QueryRow(`SELECT COUNT(*) FROM zones WHERE id = 'office'`).Scan(&count))
+	assert.Equal(t, 0, count)
+
+	diff, err = ReconcileConfig(dbConn, c, false)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, expected, diffLines(diff))
+
+	// Applying again is a no-op
+	diff, err = ReconcileConfig(dbConn, c, false)
+	require.NoError(t, err)
+	assert.Empty(t, diff)
+
+	var mode string
+	var setpoint float64
+	require.NoError(t, dbConn.QueryRow(`SELECT mode, setpoint FROM zones WHERE id = 'main_floor'`).Scan(&mode, &setpoint))
+	assert.Equal(t, "heating", mode)
+	assert.Equal(t, 68.0, setpoint)
+
+	var minOn int
+	var lastChanged string
+	var online, primary bool
+	require.NoError(t, dbConn.QueryRow(`SELECT min_on, last_changed, online, is_primary FROM devices WHERE name = 'heat_pump_A'`).
+		Scan(&minOn, &lastChanged, &online, &primary))
+	assert.Equal(t, 900, minOn)
+	assert.Equal(t, "2024-01-01T00:00:00Z", lastChanged)
+	assert.False(t, online)
+	assert.True(t, primary)
+
+	require.NoError(t, dbConn.QueryRow(`SELECT online, is_primary FROM devices WHERE name = 'heat_pump_B'`).Scan(&online, &primary))
+	assert.True(t, online)
+	assert.False(t, primary, "an existing primary heat pump must be kept")
+
+	require.NoError(t, dbConn.QueryRow(`SELECT COUNT(*) FROM zones WHERE id = 'garage'`).Scan(&count))
+	assert.Equal(t, 0, count)
+	require.NoError(t, dbConn.QueryRow(`SELECT COUNT(*) FROM devices WHERE name = 'garage_radiant_loop'`).Scan(&count))
+	assert.Equal(t, 0, count)
+}
+
+func TestReconcileConfig_ZoneFailsafeOnlyWhenConfigured(t *testing.T) {
+	c := reconcileTestConfig(filepath.Join(t.TempDir(), "hvac.db"))
+	dbConn := seedReconcileTestDB(t, c)
+	repo := New(dbConn)
+
+	// A limit set through the API survives while the config leaves it unset
+	maxTemp := 95.0
+	require.NoError(t, repo.UpdateZoneFailsafe("main_floor", true, nil, &maxTemp, Audit{Actor: ActorCLI}))
+
+	disabled, minTemp := false, 35.0
+	c.Zones[1].FailsafeEnabled = &disabled
+	c.Zones[1].FailsafeMinTemp = &minTemp
+
+	diff, err := ReconcileConfig(dbConn, c, false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"update zone   garage (failsafe_enabled: true -> false, failsafe_min_temp: NULL -> 35)"}, diffLines(diff))
+
+	zones, err := repo.GetAllZones()
+	require.NoError(t, err)
+	require.Len(t, zones, 2)
+	for _, z := range zones {
+		switch z.ID {
+		case "main_floor":
+			assert.True(t, z.FailsafeActive())
+			assert.Nil(t, z.FailsafeMinTemp)
+			require.NotNil(t, z.FailsafeMaxTemp)
+			assert.Equal(t, 95.0, *z.FailsafeMaxTemp)
+		case "garage":
+			assert.False(t, z.FailsafeActive())
+			require.NotNil(t, z.FailsafeMinTemp)
+			assert.Equal(t, 35.0, *z.FailsafeMinTemp)
+			assert.Nil(t, z.FailsafeMaxTemp)
+		}
+	}
+}
+
+func TestReconcileConfig_ZoneCirculationOnlyWhenConfigured(t *testing.T) {
+	c := reconcileTestConfig(filepath.Join(t.TempDir(), "hvac.db"))
+	dbConn := seedReconcileTestDB(t, c)
+	repo := New(dbConn)
+
+	// A duty set through the API survives while the config leaves it unset
+	require.NoError(t, repo.UpdateZoneCirculate("main_floor", model.Circulation{MinutesPerHour: 20, BetweenCalls: true}, Audit{Actor: ActorCLI}))
+	c.Zones[1].Circulate = &model.Circulation{MinutesPerHour: 10}
+
+	diff, err := ReconcileConfig(dbConn, c, false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"update zone   garage (circulate_minutes_per_hour: 0 -> 10)"}, diffLines(diff))
+
+	zones, err := repo.GetAllZones()
+	require.NoError(t, err)
+	circulation := make(map[string]model.Circulation)
+	for _, z := range zones {
+		circulation[z.ID] = z.CirculateSettings()
+	}
+	assert.Equal(t, map[string]model.Circulation{
+		"main_floor": {MinutesPerHour: 20, BetweenCalls: true},
+		"garage":     {MinutesPerHour: 10},
+	}, circulation)
+
+	entries, _, err := repo.GetAuditLog(AuditFilter{Action: AuditZoneCirculate}, 10, 0)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "continuous between_calls=false", entries[0].OldValue)
+	assert.Equal(t, "20 min/h between_calls=true", entries[0].NewValue)
+}
+
+func TestReconcileConfig_ZoneGroups(t *testing.T) {
+	c := reconcileTestConfig(filepath.Join(t.TempDir(), "hvac.db"))
+	c.ZoneGroups = []model.ZoneGroup{{ID: "house", Label: "House", Zones: []string{"main_floor", "garage"}}}
+	dbConn := seedReconcileTestDB(t, c)
+	repo := New(dbConn)
+
+	diff, err := ReconcileConfig(dbConn, c, true)
+	require.NoError(t, err)
+	assert.Empty(t, diff, diff.String())
+
+	group, err := repo.GetZoneGroup("house")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"main_floor", "garage"}, group.Zones)
+
+	c.ZoneGroups = []model.ZoneGroup{
+		{ID: "house", Label: "Whole house", Zones: []string{"main_floor"}},
+		{ID: "heated", Label: "Heated", Zones: []string{"garage", "main_floor"}},
+	}
+	diff, err = ReconcileConfig(dbConn, c, false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"update group  house (label: House -> Whole house, zones: main_floor, garage -> main_floor)",
+		"add    group  heated (garage, main_floor)",
+	}, diffLines(diff))
+
+	groups, err := repo.GetZoneGroups()
+	require.NoError(t, err)
+	assert.Equal(t, c.ZoneGroups[1], groups[0])
+	assert.Equal(t, c.ZoneGroups[0], groups[1])
+
+	// Removing a zone drops it from its groups, and removed groups are gone
+	c.Zones = c.Zones[:1]
+	c.DeviceConfig.RadiantFloorLoops.Devices = c.DeviceConfig.RadiantFloorLoops.Devices[:1]
+	c.ZoneGroups = c.ZoneGroups[1:]
+	c.ZoneGroups[0].Zones = []string{"main_floor"}
+	_, err = ReconcileConfig(dbConn, c, false)
+	require.NoError(t, err)
+
+	groups, err = repo.GetZoneGroups()
+	require.NoError(t, err)
+	assert.Equal(t, []model.ZoneGroup{{ID: "heated", Label: "Heated", Zones: []string{"main_floor"}}}, groups)
+
+	_, err = repo.GetZoneGroup("house")
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	all, err := repo.GetZoneGroup(model.AllZonesGroup)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"main_floor"}, all.Zones)
+}
+
+func TestReconcileConfig_ZoneSensors(t *testing.T) {
+	c := reconcileTestConfig(filepath.Join(t.TempDir(), "hvac.db"))
+	dbConn := seedReconcileTestDB(t, c)
+	repo := New(dbConn)
+
+	sunroom := model.Sensor{ID: "sunroom_sensor", Type: model.SensorMQTT, Bus: "home/sunroom/temperature"}
+	c.Zones[0].Sensors = []model.ZoneSensor{{Sensor: c.Zones[0].Sensor}, {Sensor: sunroom, Weight: 0.5}}
+	c.Zones[0].Aggregation = model.AggregateWeightedMean
+
+	diff, err := ReconcileConfig(dbConn, c, false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"add    sensor sunroom_sensor (bus home/sunroom/temperature)",
+		"update zone   main_floor (aggregation: mean -> weighted_mean, sensors: main_floor_sensor -> main_floor_sensor, sunroom_sensor*0.5)",
+	}, diffLines(diff))
+
+	zone, err := repo.GetZoneByID("main_floor")
+	require.NoError(t, err)
+	assert.Equal(t, "main_floor_sensor", zone.Sensor.ID)
+	assert.Equal(t, model.AggregateWeightedMean, zone.Aggregation)
+	assert.Equal(t, []model.ZoneSensor{
+		{Sensor: model.Sensor{ID: "main_floor_sensor", Type: model.SensorW1, Bus: "28-000000000001"}, Weight: 1},
+		{Sensor: sunroom, Weight: 0.5},
+	}, zone.Sensors)
+
+	// Going back to a single sensor drops the extra one
+	c.Zones[0].Sensors = nil
+	c.Zones[0].Aggregation = ""
+	diff, err = ReconcileConfig(dbConn, c, false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"remove sensor sunroom_sensor",
+		"update zone   main_floor (aggregation: weighted_mean -> mean, sensors: main_floor_sensor, sunroom_sensor*0.5 -> main_floor_sensor)",
+	}, diffLines(diff))
+
+	zones, err := repo.GetAllZones()
+	require.NoError(t, err)
+	for _, z := range zones {
+		require.Len(t, z.Sensors, 1, z.ID)
+		assert.Equal(t, z.Sensor.ID, z.Sensors[0].ID)
+	}
+}
+
+func TestReconcileConfig_ZoneHumidity(t *testing.T) {
+	c := reconcileTestConfig(filepath.Join(t.TempDir(), "hvac.db"))
+	dbConn := seedReconcileTestDB(t, c)
+	repo := New(dbConn)
+
+	c.Zones[0].HumiditySensor = &model.Sensor{ID: "main_floor_humidity", Type: model.SensorIIO, Bus: "iio:device0"}
+	c.Zones[0].HumidityTarget = 50
+	diff, err := ReconcileConfig(dbConn, c, false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"add    sensor main_floor_humidity (bus iio:device0)",
+		"update zone   main_floor (humidity_sensor_id: NULL -> main_floor_humidity, humidity_target: NULL -> 50)",
+	}, diffLines(diff))
+
+	zone, err := repo.GetZoneByID("main_floor")
+	require.NoError(t, err)
+	require.NotNil(t, zone.HumiditySensor)
+	assert.Equal(t, "main_floor_humidity", zone.HumiditySensor.ID)
+	assert.Equal(t, 50.0, zone.HumidityTarget)
+
+	zone, err = repo.GetZoneByID("garage")
+	require.NoError(t, err)
+	assert.Nil(t, zone.HumiditySensor)
+	assert.Zero(t, zone.HumidityTarget)
+}
+
+func diffLines(diff ConfigDiff) []string {
+	lines := make([]string, len(diff))
+	for i, c := range diff {
+		lines[i] = c.String()
+	}
+	return lines
+}
+
+func TestReconcileConfig_ZonePriorityKeepsShedState(t *testing.T) {
+	c := reconcileTestConfig(filepath.Join(t.TempDir(), "hvac.db"))
+	c.Zones[1].Priority = 0.5
+	dbConn := seedReconcileTestDB(t, c)
+	repo := New(dbConn)
+
+	since := time.Now().Truncate(time.Second)
+	require.NoError(t, repo.SetZoneShed("garage", &since))
+
+	c.Zones[1].Priority = 0.25
+	diff, err := ReconcileConfig(dbConn, c, false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"update zone   garage (priority: 0.5 -> 0.25)"}, diffLines(diff))
+
+	garage, err := repo.GetZoneByID("garage")
+	require.NoError(t, err)
+	assert.Equal(t, 0.25, garage.Priority)
+	require.NotNil(t, garage.ShedSince)
+	assert.True(t, since.Equal(*garage.ShedSince))
+
+	mainFloor, err := repo.GetZoneByID("main_floor")
+	require.NoError(t, err)
+	assert.Equal(t, 1.0, mainFloor.Priority)
+	assert.Nil(t, mainFloor.ShedSince)
+
+	require.NoError(t, repo.SetZoneShed("garage", nil))
+	garage, err = repo.GetZoneByID("garage")
+	require.NoError(t, err)
+	assert.Nil(t, garage.ShedSince)
+}
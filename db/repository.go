@@ -0,0 +1,137 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+)
+
+// busyTimeoutMillis is how long a connection waits on a locked database before SQLITE_BUSY.
+const busyTimeoutMillis = 5000
+
+// Repository owns the process-wide connection pool. Every query, transaction, migration and backup
+// goes through it so the controllers, the API and startup share one set of connections and settings.
+type Repository struct {
+	conn  *sql.DB
+	mutex sync.Mutex
+	stmts map[string]*sql.Stmt
+}
+
+// Open opens the database at path with WAL journaling, a busy timeout and foreign keys enforced.
+// Transactions take the write lock up front so concurrent writers wait instead of failing mid-transaction.
+func Open(path string) (*Repository, error) {
+	_, statErr := os.Stat(path)
+
+	dsn := fmt.Sprintf("file:%s?_journal_mode=WAL&_busy_timeout=%d&_foreign_keys=on&_txlock=immediate", path, busyTimeoutMillis)
+	conn, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	if err := conn.Ping(); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to open database %s: %w", path, err)
+	}
+
+	if os.IsNotExist(statErr) {
+		os.Chmod(path, 0660)
+		log.Info().Str("path", path).Msg("Created database file")
+	}
+	return New(conn), nil
+}
+
+// New wraps an already open connection pool, e.g. an in-memory database in tests.
+func New(conn *sql.DB) *Repository {
+	return &Repository{conn: conn, stmts: make(map[string]*sql.Stmt)}
+}
+
+// Close releases the prepared statements and the connection pool.
+func (r *Repository) Close() error {
+	r.mutex.Lock()
+	for query, s := range r.stmts {
+		s.Close()
+		delete(r.stmts, query)
+	}
+	r.mutex.Unlock()
+	return r.conn.Close()
+}
+
+// stmt returns the prepared statement for query, preparing and caching it on first use.
+func (r *Repository) stmt(query string) (*sql.Stmt, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if s, ok := r.stmts[query]; ok {
+		return s, nil
+	}
+	s, err := r.conn.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	r.stmts[query] = s
+	return s, nil
+}
+
+func (r *Repository) exec(query string, args ...interface{}) (sql.Result, error) {
+	s, err := r.stmt(query)
+	if err != nil {
+		return nil, err
+	}
+	return s.Exec(args...)
+}
+
+func (r *Repository) query(query string, args ...interface{}) (*sql.Rows, error) {
+	s, err := r.stmt(query)
+	if err != nil {
+		return nil, err
+	}
+	return s.Query(args...)
+}
+
+func (r *Repository) queryRow(query string, args ...interface{}) *sql.Row {
+	s, err := r.stmt(query)
+	if err != nil {
+		// sql.Row can't be built with an error, so let QueryRow report the prepare failure on Scan
+		return r.conn.QueryRow(query, args...)
+	}
+	return s.QueryRow(args...)
+}
+
+// repoTx is a transaction that reuses the repository's prepared statements on its connection.
+// Statements not yet prepared run directly on the transaction: preparing on the pool while the
+// transaction holds a connection could wait forever on a pool limited to one connection.
+type repoTx struct {
+	*sql.Tx
+	r *Repository
+}
+
+func (r *Repository) begin() (*repoTx, error) {
+	tx, err := r.conn.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &repoTx{Tx: tx, r: r}, nil
+}
+
+func (r *Repository) cached(query string) (*sql.Stmt, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	s, ok := r.stmts[query]
+	return s, ok
+}
+
+func (t *repoTx) Exec(query string, args ...interface{}) (sql.Result, error) {
+	if s, ok := t.r.cached(query); ok {
+		return t.Stmt(s).Exec(args...)
+	}
+	return t.Tx.Exec(query, args...)
+}
+
+func (t *repoTx) QueryRow(query string, args ...interface{}) *sql.Row {
+	if s, ok := t.r.cached(query); ok {
+		return t.Stmt(s).QueryRow(args...)
+	}
+	return t.Tx.QueryRow(query, args...)
+}
@@ -0,0 +1,45 @@
+package db
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpen_ConfiguresConnections(t *testing.T) {
+	repo, err := Open(filepath.Join(t.TempDir(), "hvac.db"))
+	require.NoError(t, err)
+	defer repo.Close()
+
+	var journalMode string
+	var busyTimeout, foreignKeys int
+	require.NoError(t, repo.queryRow(`PRAGMA journal_mode`).Scan(&journalMode))
+	require.NoError(t, repo.queryRow(`PRAGMA busy_timeout`).Scan(&busyTimeout))
+	require.NoError(t, repo.queryRow(`PRAGMA foreign_keys`).Scan(&foreignKeys))
+	assert.Equal(t, "wal", journalMode)
+	assert.Equal(t, busyTimeoutMillis, busyTimeout)
+	assert.Equal(t, 1, foreignKeys)
+}
+
+func TestRepository_ReusesPreparedStatements(t *testing.T) {
+	c := reconcileTestConfig(filepath.Join(t.TempDir(), "hvac.db"))
+	seedReconcileTestDB(t, c)
+	repo, err := Open(c.DBPath)
+	require.NoError(t, err)
+	defer repo.Close()
+
+	for i := 0; i < 2; i++ {
+		zones, err := repo.GetAllZones()
+		require.NoError(t, err)
+		assert.Len(t, zones, 2)
+	}
+	assert.Len(t, repo.stmts, 2) // zones and zone sensors, each prepared once
+
+	// Writes inside a transaction see the same data as the pool
+	require.NoError(t, repo.UpdateZoneSetpoint("garage", 50, Audit{Actor: ActorCLI}))
+	zone, err := repo.GetZoneByID("garage")
+	require.NoError(t, err)
+	assert.Equal(t, 50.0, zone.Setpoint)
+}
@@ -3,14 +3,16 @@ package db
 import (
 	"database/sql"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/thatsimonsguy/hvac-controller/internal/model"
 )
 
 // StartTransaction starts a new database transaction.
-func StartTransaction(db *sql.DB) (*sql.Tx, error) {
-	tx, err := db.Begin()
+func (r *Repository) StartTransaction() (*sql.Tx, error) {
+	tx, err := r.conn.Begin()
 	if err != nil {
 		return nil, fmt.Errorf("failed to start transaction: %w", err)
 	}
@@ -30,47 +32,332 @@ func RollbackTransaction(tx *sql.Tx) {
 	tx.Rollback()
 }
 
-func UpdateSystemMode(db *sql.DB, mode model.SystemMode) error {
-	tx, err := db.Begin()
+// UpdateSystemMode sets the system mode. With rejectConflicts set, a mode opposite to any zone's
+// fails the change with a *ModeConflictError.
+func (r *Repository) UpdateSystemMode(mode model.SystemMode, rejectConflicts bool, audit Audit) error {
+	tx, err := r.begin()
 	if err != nil {
 		return fmt.Errorf("start transaction: %w", err)
 	}
+	var oldMode string
+	err = tx.QueryRow(`SELECT system_mode FROM system WHERE id = 1`).Scan(&oldMode)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("get system mode: %w", err)
+	}
+	if rejectConflicts {
+		zoneModes, err := currentZoneModes(tx)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := checkModeConflicts(mode, zoneModes); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
 	_, err = tx.Exec(`UPDATE system SET system_mode = ? WHERE id = 1`, string(mode))
 	if err != nil {
 		tx.Rollback()
 		return fmt.Errorf("update system mode: %w", err)
 	}
+	if err := recordAudit(tx, audit, AuditSystemMode, "", oldMode, string(mode)); err != nil {
+		tx.Rollback()
+		return err
+	}
 	return tx.Commit()
 }
 
-func UpdateZoneSetpoint(db *sql.DB, id string, setpoint float64) error {
-	tx, err := db.Begin()
+func (r *Repository) UpdateZoneSetpoint(id string, setpoint float64, audit Audit) error {
+	return r.UpdateZoneSetpoints([]string{id}, setpoint, audit)
+}
+
+// UpdateZoneSetpoints sets every listed zone to setpoint in one transaction, so either all of them
+// change or none do.
+func (r *Repository) UpdateZoneSetpoints(ids []string, setpoint float64, audit Audit) error {
+	tx, err := r.begin()
 	if err != nil {
 		return fmt.Errorf("start transaction: %w", err)
 	}
-	_, err = tx.Exec(`UPDATE zones SET setpoint = ? WHERE id = ?`, setpoint, id)
+	for _, id := range ids {
+		if err := setZoneSetpoint(tx, id, setpoint, audit); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (r *Repository) UpdateZoneMode(id string, mode model.SystemMode, rejectConflicts bool, audit Audit) error {
+	return r.UpdateZoneModes(map[string]model.SystemMode{id: mode}, rejectConflicts, audit)
+}
+
+// UpdateZoneModes sets each zone to its mode in one transaction, so either all of them change or
+// none do. A zone whose capabilities don't allow its mode fails the change with an
+// *UnsupportedModeError, and with rejectConflicts set so does one whose mode is opposite to the
+// system's, with a *ModeConflictError.
+func (r *Repository) UpdateZoneModes(modes map[string]model.SystemMode, rejectConflicts bool, audit Audit) error {
+	tx, err := r.begin()
+	if err != nil {
+		return fmt.Errorf("start transaction: %w", err)
+	}
+	if rejectConflicts {
+		if err := checkZoneModeConflicts(tx, modes); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	for _, id := range sortedKeys(modes) {
+		if err := checkZoneMode(tx, id, modes[id]); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := setZoneMode(tx, id, modes[id], audit); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// UnsupportedModeError lists the zones that can't be put in a mode and why.
+type UnsupportedModeError struct {
+	Mode    model.SystemMode
+	Zones   []string
+	Reasons []string // one per zone
+}
+
+func (e *UnsupportedModeError) Error() string {
+	return fmt.Sprintf("mode %s is not supported: %s", e.Mode, strings.Join(e.Reasons, "; "))
+}
+
+// ModeConflictError lists the zones whose mode would be opposite to the system mode, see
+// model.ModesConflict.
+type ModeConflictError struct {
+	SystemMode model.SystemMode
+	Zones      []string
+	ZoneModes  []model.SystemMode // one per zone
+}
+
+func (e *ModeConflictError) Error() string {
+	return fmt.Sprintf("system mode %s conflicts with zones: %s", e.SystemMode, e.ZoneList())
+}
+
+// ZoneList formats the conflicting zones as "id (mode)", comma separated.
+func (e *ModeConflictError) ZoneList() string {
+	zones := make([]string, len(e.Zones))
+	for i, id := range e.Zones {
+		zones[i] = fmt.Sprintf("%s (%s)", id, e.ZoneModes[i])
+	}
+	return strings.Join(zones, ", ")
+}
+
+// checkModeConflicts returns a *ModeConflictError if any zone's mode in zoneModes conflicts with
+// sysMode.
+func checkModeConflicts(sysMode model.SystemMode, zoneModes map[string]model.SystemMode) error {
+	conflict := &ModeConflictError{SystemMode: sysMode}
+	for _, id := range sortedKeys(zoneModes) {
+		if model.ModesConflict(zoneModes[id], sysMode) {
+			conflict.Zones = append(conflict.Zones, id)
+			conflict.ZoneModes = append(conflict.ZoneModes, zoneModes[id])
+		}
+	}
+	if len(conflict.Zones) > 0 {
+		return conflict
+	}
+	return nil
+}
+
+// checkZoneModeConflicts checks new zone modes against the system mode read within tx, so the
+// system mode can't change before they are written.
+func checkZoneModeConflicts(tx *repoTx, zoneModes map[string]model.SystemMode) error {
+	var sysMode string
+	if err := tx.QueryRow(`SELECT system_mode FROM system WHERE id = 1`).Scan(&sysMode); err != nil {
+		return fmt.Errorf("get system mode: %w", err)
+	}
+	return checkModeConflicts(model.SystemMode(sysMode), zoneModes)
+}
+
+// currentZoneModes reads every zone's mode within tx.
+func currentZoneModes(tx *repoTx) (map[string]model.SystemMode, error) {
+	rows, err := tx.Query(`SELECT id, mode FROM zones`)
+	if err != nil {
+		return nil, fmt.Errorf("get zone modes: %w", err)
+	}
+	defer rows.Close()
+	modes := make(map[string]model.SystemMode)
+	for rows.Next() {
+		var id, mode string
+		if err := rows.Scan(&id, &mode); err != nil {
+			return nil, fmt.Errorf("scan zone mode: %w", err)
+		}
+		modes[id] = model.SystemMode(mode)
+	}
+	return modes, rows.Err()
+}
+
+// checkZoneMode returns an *UnsupportedModeError if the zone's derived capabilities don't allow
+// mode, see GetZoneCapabilities.
+func checkZoneMode(tx *repoTx, zoneID string, mode model.SystemMode) error {
+	report, err := capabilityReport(tx, zoneID)
+	if err != nil {
+		return err
+	}
+	if err := report.CheckMode(mode); err != nil {
+		return &UnsupportedModeError{Mode: mode, Zones: []string{zoneID}, Reasons: []string{err.Error()}}
+	}
+	return nil
+}
+
+// capabilityReport reads a zone's capability report within tx, so it can't change before the
+// mode it allows is written.
+func capabilityReport(tx *repoTx, zoneID string) (*model.CapabilityReport, error) {
+	zone, err := scanZone(tx.QueryRow(`SELECT `+zoneColumns+` FROM zones WHERE id = ?`, zoneID).Scan)
 	if err != nil {
+		return nil, fmt.Errorf("failed to get zone %s: %w", zoneID, err)
+	}
+	return zoneCapabilityReport(tx.Query, zone)
+}
+
+// UpdateZoneGroupMode sets every zone in the group to mode in one transaction and returns the mode
+// each zone was set to. Zones whose capabilities don't allow the mode fail the whole change with an
+// *UnsupportedModeError, or are turned off instead when offIfUnsupported is set. With
+// rejectConflicts set, a mode opposite to the system's fails the change with a *ModeConflictError.
+func (r *Repository) UpdateZoneGroupMode(groupID string, mode model.SystemMode, offIfUnsupported, rejectConflicts bool, audit Audit) (map[string]model.SystemMode, error) {
+	group, err := r.GetZoneGroup(groupID)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := r.begin()
+	if err != nil {
+		return nil, fmt.Errorf("start transaction: %w", err)
+	}
+	modes := make(map[string]model.SystemMode)
+	unsupported := &UnsupportedModeError{Mode: mode}
+	for _, id := range group.Zones {
+		report, err := capabilityReport(tx, id)
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		modes[id] = mode
+		if err := report.CheckMode(mode); err != nil {
+			modes[id] = model.ModeOff
+			unsupported.Zones = append(unsupported.Zones, id)
+			unsupported.Reasons = append(unsupported.Reasons, err.Error())
+		}
+	}
+	if rejectConflicts {
+		if err := checkZoneModeConflicts(tx, modes); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+	if len(unsupported.Zones) > 0 && !offIfUnsupported {
 		tx.Rollback()
+		return nil, unsupported
+	}
+	for _, id := range sortedKeys(modes) {
+		if err := setZoneMode(tx, id, modes[id], audit); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return modes, nil
+}
+
+// UpdateZoneGroupSetpoint sets every zone in the group to setpoint in one transaction.
+func (r *Repository) UpdateZoneGroupSetpoint(groupID string, setpoint float64, audit Audit) error {
+	group, err := r.GetZoneGroup(groupID)
+	if err != nil {
+		return err
+	}
+	return r.UpdateZoneSetpoints(group.Zones, setpoint, audit)
+}
+
+func setZoneSetpoint(tx *repoTx, id string, setpoint float64, audit Audit) error {
+	var oldSetpoint float64
+	err := tx.QueryRow(`SELECT setpoint FROM zones WHERE id = ?`, id).Scan(&oldSetpoint)
+	if err != nil {
+		return fmt.Errorf("get zone setpoint: %w", err)
+	}
+	_, err = tx.Exec(`UPDATE zones SET setpoint = ? WHERE id = ?`, setpoint, id)
+	if err != nil {
 		return fmt.Errorf("update zone setpoint: %w", err)
 	}
+	return recordAudit(tx, audit, AuditZoneSetpoint, id, formatSetpoint(oldSetpoint), formatSetpoint(setpoint))
+}
+
+func setZoneMode(tx *repoTx, id string, mode model.SystemMode, audit Audit) error {
+	var oldMode string
+	err := tx.QueryRow(`SELECT mode FROM zones WHERE id = ?`, id).Scan(&oldMode)
+	if err != nil {
+		return fmt.Errorf("get zone mode: %w", err)
+	}
+	_, err = tx.Exec(`UPDATE zones SET mode = ? WHERE id = ?`, string(mode), id)
+	if err != nil {
+		return fmt.Errorf("update zone mode: %w", err)
+	}
+	return recordAudit(tx, audit, AuditZoneMode, id, oldMode, string(mode))
+}
+
+// UpdateZoneFailsafe replaces a zone's failsafe settings. Nil limits fall back to the system-wide
+// override limits.
+func (r *Repository) UpdateZoneFailsafe(id string, enabled bool, minTemp, maxTemp *float64, audit Audit) error {
+	tx, err := r.begin()
+	if err != nil {
+		return fmt.Errorf("start transaction: %w", err)
+	}
+	var oldEnabled bool
+	var oldMin, oldMax sql.NullFloat64
+	err = tx.QueryRow(`SELECT failsafe_enabled, failsafe_min_temp, failsafe_max_temp FROM zones WHERE id = ?`, id).Scan(&oldEnabled, &oldMin, &oldMax)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("get zone failsafe: %w", err)
+	}
+	_, err = tx.Exec(`UPDATE zones SET failsafe_enabled = ?, failsafe_min_temp = ?, failsafe_max_temp = ? WHERE id = ?`, enabled, minTemp, maxTemp, id)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("update zone failsafe: %w", err)
+	}
+	oldValue := formatFailsafe(oldEnabled, nullFloatPtr(oldMin), nullFloatPtr(oldMax))
+	if err := recordAudit(tx, audit, AuditZoneFailsafe, id, oldValue, formatFailsafe(enabled, minTemp, maxTemp)); err != nil {
+		tx.Rollback()
+		return err
+	}
 	return tx.Commit()
 }
 
-func UpdateZoneMode(db *sql.DB, id string, mode model.SystemMode) error {
-	tx, err := db.Begin()
+// UpdateZoneCirculate replaces a zone's blower circulation duty.
+func (r *Repository) UpdateZoneCirculate(id string, circulate model.Circulation, audit Audit) error {
+	tx, err := r.begin()
 	if err != nil {
 		return fmt.Errorf("start transaction: %w", err)
 	}
-	_, err = tx.Exec(`UPDATE zones SET mode = ? WHERE id = ?`, string(mode), id)
+	var old model.Circulation
+	err = tx.QueryRow(`SELECT circulate_minutes_per_hour, circulate_between_calls FROM zones WHERE id = ?`, id).Scan(&old.MinutesPerHour, &old.BetweenCalls)
 	if err != nil {
 		tx.Rollback()
-		return fmt.Errorf("update zone mode: %w", err)
+		return fmt.Errorf("get zone circulation: %w", err)
+	}
+	_, err = tx.Exec(`UPDATE zones SET circulate_minutes_per_hour = ?, circulate_between_calls = ? WHERE id = ?`, circulate.MinutesPerHour, circulate.BetweenCalls, id)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("update zone circulation: %w", err)
+	}
+	if err := recordAudit(tx, audit, AuditZoneCirculate, id, formatCirculation(old), formatCirculation(circulate)); err != nil {
+		tx.Rollback()
+		return err
 	}
 	return tx.Commit()
 }
 
-func UpdateDeviceLastChanged(db *sql.DB, deviceName string, timestamp time.Time) error {
-	tx, err := db.Begin()
+func (r *Repository) UpdateDeviceLastChanged(deviceName string, timestamp time.Time) error {
+	tx, err := r.begin()
 	if err != nil {
 		return fmt.Errorf("start transaction: %w", err)
 	}
@@ -82,46 +369,214 @@ func UpdateDeviceLastChanged(db *sql.DB, deviceName string, timestamp time.Time)
 	return tx.Commit()
 }
 
-func SetRecirculationActive(db *sql.DB, active bool, startedAt time.Time) error {
-	tx, err := db.Begin()
+// SetRecirculationRun records an air handler's recirculation run, or clears it when run is zero.
+func (r *Repository) SetRecirculationRun(deviceName string, run model.RecirculationRun) error {
+	tx, err := r.begin()
 	if err != nil {
 		return fmt.Errorf("start transaction: %w", err)
 	}
-	
-	var startedAtStr *string
+	if err := setRecirculationRun(tx, deviceName, run); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// StartRecirculation starts a manual recirculation run on an air handler that lasts until endsAt,
+// replacing any run in progress. The recirculation controller turns the blower on.
+func (r *Repository) StartRecirculation(deviceName string, endsAt time.Time, audit Audit) error {
+	tx, err := r.begin()
+	if err != nil {
+		return fmt.Errorf("start transaction: %w", err)
+	}
+	old, err := recirculationRun(tx, deviceName)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	run := model.RecirculationRun{StartedAt: time.Now(), EndsAt: endsAt, Manual: true}
+	if old.Active() {
+		run.StartedAt = old.StartedAt
+	}
+	if err := setRecirculationRun(tx, deviceName, run); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := recordAudit(tx, audit, AuditRecirculationStart, deviceName, formatRecirculationRun(old), formatRecirculationRun(run)); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// CancelRecirculation ends an air handler's recirculation run now. The run stays recorded until
+// the recirculation controller has turned the blower off. It returns false if no run was active or
+// the run has already ended.
+func (r *Repository) CancelRecirculation(deviceName string, audit Audit) (bool, error) {
+	tx, err := r.begin()
+	if err != nil {
+		return false, fmt.Errorf("start transaction: %w", err)
+	}
+	old, err := recirculationRun(tx, deviceName)
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	now := time.Now()
+	if !old.Active() || !now.Before(old.EndsAt) {
+		tx.Rollback()
+		return false, nil
+	}
+	run := old
+	run.EndsAt = now
+	if err := setRecirculationRun(tx, deviceName, run); err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	if err := recordAudit(tx, audit, AuditRecirculationCancel, deviceName, formatRecirculationRun(old), "off"); err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+func recirculationRun(tx *repoTx, deviceName string) (model.RecirculationRun, error) {
+	var run model.RecirculationRun
+	var startedAt, endsAt sql.NullString
+	err := tx.QueryRow(`SELECT recirculation_started_at, recirculation_ends_at, recirculation_manual FROM devices WHERE name = ? AND device_type = 'air_handler'`, deviceName).
+		Scan(&startedAt, &endsAt, &run.Manual)
+	if err != nil {
+		return run, fmt.Errorf("get recirculation run: %w", err)
+	}
+	if startedAt.Valid {
+		run.StartedAt, _ = time.Parse(time.RFC3339, startedAt.String)
+	}
+	if endsAt.Valid {
+		run.EndsAt, _ = time.Parse(time.RFC3339, endsAt.String)
+	}
+	return run, nil
+}
+
+func setRecirculationRun(tx *repoTx, deviceName string, run model.RecirculationRun) error {
+	var startedAt, endsAt *string
+	if run.Active() {
+		s, e := run.StartedAt.Format(time.RFC3339), run.EndsAt.Format(time.RFC3339)
+		startedAt, endsAt = &s, &e
+	}
+	_, err := tx.Exec(`UPDATE devices SET recirculation_started_at = ?, recirculation_ends_at = ?, recirculation_manual = ? WHERE name = ?`,
+		startedAt, endsAt, run.Active() && run.Manual, deviceName)
+	if err != nil {
+		return fmt.Errorf("update recirculation run: %w", err)
+	}
+	return nil
+}
+
+// formatRecirculationRun renders a run for the audit log, e.g. "until 2025-01-02T15:04:05Z".
+func formatRecirculationRun(run model.RecirculationRun) string {
+	if !run.Active() {
+		return "off"
+	}
+	return "until " + run.EndsAt.Format(time.RFC3339)
+}
+
+// SetZoneSensorDegraded marks a zone's sensor as degraded since its last valid reading, or healthy
+// again when since is nil.
+func (r *Repository) SetZoneSensorDegraded(id string, since *time.Time) error {
+	tx, err := r.begin()
+	if err != nil {
+		return fmt.Errorf("start transaction: %w", err)
+	}
+
+	var sinceStr *string
+	if since != nil {
+		s := since.Format(time.RFC3339)
+		sinceStr = &s
+	}
+
+	_, err = tx.Exec(`UPDATE zones SET sensor_degraded_since = ? WHERE id = ?`, sinceStr, id)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("update zone sensor degraded: %w", err)
+	}
+	return tx.Commit()
+}
+
+// SetZoneShed marks a zone as shed for lack of heating capacity since since, or no longer shed
+// when since is nil.
+func (r *Repository) SetZoneShed(id string, since *time.Time) error {
+	tx, err := r.begin()
+	if err != nil {
+		return fmt.Errorf("start transaction: %w", err)
+	}
+
+	var sinceStr *string
+	if since != nil {
+		s := since.Format(time.RFC3339)
+		sinceStr = &s
+	}
+
+	_, err = tx.Exec(`UPDATE zones SET shed_since = ? WHERE id = ?`, sinceStr, id)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("update zone shed: %w", err)
+	}
+	return tx.Commit()
+}
+
+// SetPumpExerciseActive marks a device's pump as being exercised since at, or as having finished
+// its exercise run at at.
+func (r *Repository) SetPumpExerciseActive(deviceName string, active bool, at time.Time) error {
+	tx, err := r.begin()
+	if err != nil {
+		return fmt.Errorf("start transaction: %w", err)
+	}
+
+	ts := at.Format(time.RFC3339)
 	if active {
-		s := startedAt.Format(time.RFC3339)
-		startedAtStr = &s
+		_, err = tx.Exec(`UPDATE devices SET pump_exercise_started_at = ? WHERE name = ?`, ts, deviceName)
+	} else {
+		_, err = tx.Exec(`UPDATE devices SET pump_exercise_started_at = NULL, pump_last_run_at = ? WHERE name = ?`, ts, deviceName)
 	}
-	
-	_, err = tx.Exec(`UPDATE system SET recirculation_active = ?, recirculation_started_at = ? WHERE id = 1`, active, startedAtStr)
 	if err != nil {
 		tx.Rollback()
-		return fmt.Errorf("update recirculation active: %w", err)
+		return fmt.Errorf("update pump exercise: %w", err)
 	}
 	return tx.Commit()
 }
 
-func GetRecirculationStatus(db *sql.DB) (active bool, startedAt time.Time, err error) {
-	var startedAtStr sql.NullString
-	err = db.QueryRow(`SELECT recirculation_active, recirculation_started_at FROM system WHERE id = 1`).Scan(&active, &startedAtStr)
+// RecordPumpRun records that a device's pump was running for demand at at.
+func (r *Repository) RecordPumpRun(deviceName string, at time.Time) error {
+	if _, err := r.exec(`UPDATE devices SET pump_last_run_at = ? WHERE name = ?`, at.Format(time.RFC3339), deviceName); err != nil {
+		return fmt.Errorf("update pump last run: %w", err)
+	}
+	return nil
+}
+
+// GetPumpExerciseStatus returns whether a device's pump is being exercised, since when, and when
+// the pump last ran. Times are zero when unset.
+func (r *Repository) GetPumpExerciseStatus(deviceName string) (active bool, startedAt, lastRun time.Time, err error) {
+	var startedAtStr, lastRunStr sql.NullString
+	err = r.queryRow(`SELECT pump_exercise_started_at, pump_last_run_at FROM devices WHERE name = ?`, deviceName).Scan(&startedAtStr, &lastRunStr)
 	if err != nil {
-		return false, time.Time{}, fmt.Errorf("query recirculation status: %w", err)
+		return false, time.Time{}, time.Time{}, fmt.Errorf("query pump exercise status: %w", err)
 	}
-	
+
 	if startedAtStr.Valid {
+		active = true
 		startedAt, _ = time.Parse(time.RFC3339, startedAtStr.String)
 	}
-	
-	return active, startedAt, nil
+	if lastRunStr.Valid {
+		lastRun, _ = time.Parse(time.RFC3339, lastRunStr.String)
+	}
+	return active, startedAt, lastRun, nil
 }
 
 func ResetAirHandlerTimestampsCLI(dbPath string) error {
-	db, err := sql.Open("sqlite3", dbPath)
+	r, err := Open(dbPath)
 	if err != nil {
-		return fmt.Errorf("failed to open database: %w", err)
+		return err
 	}
-	defer db.Close()
+	defer r.Close()
 
 	// Set timestamps to 13 hours ago to trigger recirculation
 	thirteenHoursAgo := time.Now().Add(-13 * time.Hour)
@@ -131,7 +586,7 @@ func ResetAirHandlerTimestampsCLI(dbPath string) error {
 	zones := []string{"basement", "main_floor"}
 	
 	for _, zoneID := range zones {
-		result, err := db.Exec(`UPDATE devices 
+		result, err := r.exec(`UPDATE devices 
 			SET last_changed = ? 
 			WHERE device_type = 'air_handler' AND zone_id = ?`, 
 			timestampStr, zoneID)
@@ -150,38 +605,72 @@ func ResetAirHandlerTimestampsCLI(dbPath string) error {
 	return nil
 }
 
-func UpdateDeviceOnlineStatus(db *sql.DB, id int, online bool) error {
-	tx, err := db.Begin()
+func (r *Repository) UpdateDeviceOnlineStatus(id int, online bool, audit Audit) error {
+	tx, err := r.begin()
 	if err != nil {
 		return fmt.Errorf("start transaction: %w", err)
 	}
-	_, err = tx.Exec(`UPDATE devices SET online = ? WHERE id = ?`, online, id)
+	var name string
+	var wasOnline bool
+	err = tx.QueryRow(`SELECT name, online FROM devices WHERE id = ?`, id).Scan(&name, &wasOnline)
 	if err != nil {
 		tx.Rollback()
-		return fmt.Errorf("update device online status: %w", err)
+		return fmt.Errorf("get device online status: %w", err)
+	}
+	if err := updateDeviceOnline(tx, name, wasOnline, online, audit); err != nil {
+		tx.Rollback()
+		return err
 	}
 	return tx.Commit()
 }
 
-func SwapPrimaryHeatPump(db *sql.DB) error {
-	tx, err := db.Begin()
+func (r *Repository) UpdateDeviceOnlineStatusByName(deviceName string, online bool, audit Audit) error {
+	tx, err := r.begin()
+	if err != nil {
+		return fmt.Errorf("start transaction: %w", err)
+	}
+	var wasOnline bool
+	err = tx.QueryRow(`SELECT online FROM devices WHERE name = ?`, deviceName).Scan(&wasOnline)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("get device online status: %w", err)
+	}
+	if err := updateDeviceOnline(tx, deviceName, wasOnline, online, audit); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func updateDeviceOnline(tx *repoTx, deviceName string, wasOnline, online bool, audit Audit) error {
+	_, err := tx.Exec(`UPDATE devices SET online = ? WHERE name = ?`, online, deviceName)
+	if err != nil {
+		return fmt.Errorf("update device online status: %w", err)
+	}
+	return recordAudit(tx, audit, AuditDeviceOnline, deviceName, strconv.FormatBool(wasOnline), strconv.FormatBool(online))
+}
+
+func (r *Repository) SwapPrimaryHeatPump(audit Audit) error {
+	tx, err := r.begin()
 	if err != nil {
 		return fmt.Errorf("start transaction: %w", err)
 	}
 
 	// 1. Get current primary heat pump
-	row := tx.QueryRow(`SELECT id FROM devices WHERE device_type = 'heat_pump' AND is_primary = true`)
+	row := tx.QueryRow(`SELECT id, name FROM devices WHERE device_type = 'heat_pump' AND is_primary = true`)
 	var currentID int
-	err = row.Scan(&currentID)
+	var currentName string
+	err = row.Scan(&currentID, &currentName)
 	if err != nil {
 		tx.Rollback()
 		return fmt.Errorf("failed to find current primary: %w", err)
 	}
 
 	// 2. Get next (non-primary) heat pump
-	row = tx.QueryRow(`SELECT id FROM devices WHERE device_type = 'heat_pump' AND id != ? ORDER BY last_rotated ASC LIMIT 1`, currentID)
+	row = tx.QueryRow(`SELECT id, name FROM devices WHERE device_type = 'heat_pump' AND id != ? ORDER BY last_rotated ASC LIMIT 1`, currentID)
 	var newID int
-	err = row.Scan(&newID)
+	var newName string
+	err = row.Scan(&newID, &newName)
 	if err != nil {
 		tx.Rollback()
 		return fmt.Errorf("failed to find new primary: %w", err)
@@ -201,43 +690,61 @@ func SwapPrimaryHeatPump(db *sql.DB) error {
 		return fmt.Errorf("set new primary: %w", err)
 	}
 
+	if err := recordAudit(tx, audit, AuditPrimaryHeatPump, "", currentName, newName); err != nil {
+		tx.Rollback()
+		return err
+	}
+
 	return tx.Commit()
 }
 
-func SetSystemOverride(db *sql.DB, newMode model.SystemMode) error {
-	tx, err := db.Begin()
+// SetSystemOverride puts the system in newMode for the failsafe. An override that is already active
+// switches to newMode and keeps the mode to restore when it clears.
+func (r *Repository) SetSystemOverride(newMode model.SystemMode, audit Audit) error {
+	tx, err := r.begin()
 	if err != nil {
 		return fmt.Errorf("start transaction: %w", err)
 	}
 	
-	// Get current mode to store as prior
+	// Get current mode to store as prior, unless an override already stored it
 	var currentMode string
-	err = tx.QueryRow(`SELECT system_mode FROM system WHERE id = 1`).Scan(&currentMode)
+	var active bool
+	var priorMode sql.NullString
+	err = tx.QueryRow(`SELECT system_mode, override_active, prior_system_mode FROM system WHERE id = 1`).Scan(&currentMode, &active, &priorMode)
 	if err != nil {
 		tx.Rollback()
 		return fmt.Errorf("failed to get current system mode: %w", err)
 	}
+	if !active {
+		priorMode = sql.NullString{String: currentMode, Valid: true}
+	}
 	
 	// Set override active, store prior mode, and set new mode
 	_, err = tx.Exec(`UPDATE system SET override_active = TRUE, prior_system_mode = ?, system_mode = ? WHERE id = 1`, 
-		currentMode, string(newMode))
+		priorMode, string(newMode))
 	if err != nil {
 		tx.Rollback()
 		return fmt.Errorf("failed to set system override: %w", err)
 	}
+
+	if err := recordAudit(tx, audit, AuditOverrideSet, "", currentMode, string(newMode)); err != nil {
+		tx.Rollback()
+		return err
+	}
 	
 	return tx.Commit()
 }
 
-func ClearSystemOverride(db *sql.DB) error {
-	tx, err := db.Begin()
+func (r *Repository) ClearSystemOverride(audit Audit) error {
+	tx, err := r.begin()
 	if err != nil {
 		return fmt.Errorf("start transaction: %w", err)
 	}
 	
 	// Get prior mode to restore
+	var currentMode string
 	var priorMode sql.NullString
-	err = tx.QueryRow(`SELECT prior_system_mode FROM system WHERE id = 1`).Scan(&priorMode)
+	err = tx.QueryRow(`SELECT system_mode, prior_system_mode FROM system WHERE id = 1`).Scan(&currentMode, &priorMode)
 	if err != nil {
 		tx.Rollback()
 		return fmt.Errorf("failed to get prior system mode: %w", err)
@@ -256,6 +763,42 @@ func ClearSystemOverride(db *sql.DB) error {
 		tx.Rollback()
 		return fmt.Errorf("failed to clear system override: %w", err)
 	}
+
+	if err := recordAudit(tx, audit, AuditOverrideClear, "", currentMode, string(restoreMode)); err != nil {
+		tx.Rollback()
+		return err
+	}
 	
 	return tx.Commit()
 }
+
+func formatSetpoint(setpoint float64) string {
+	return strconv.FormatFloat(setpoint, 'f', -1, 64)
+}
+
+// formatFailsafe renders failsafe settings for the audit log, e.g. "enabled=true min=40 max=default".
+func formatFailsafe(enabled bool, minTemp, maxTemp *float64) string {
+	limit := func(t *float64) string {
+		if t == nil {
+			return "default"
+		}
+		return formatSetpoint(*t)
+	}
+	return fmt.Sprintf("enabled=%t min=%s max=%s", enabled, limit(minTemp), limit(maxTemp))
+}
+
+// formatCirculation renders a circulation duty for the audit log, e.g. "20 min/h between_calls=true".
+func formatCirculation(c model.Circulation) string {
+	duty := "continuous"
+	if !c.Continuous() {
+		duty = fmt.Sprintf("%d min/h", c.MinutesPerHour)
+	}
+	return fmt.Sprintf("%s between_calls=%t", duty, c.BetweenCalls)
+}
+
+func nullFloatPtr(n sql.NullFloat64) *float64 {
+	if !n.Valid {
+		return nil
+	}
+	return &n.Float64
+}
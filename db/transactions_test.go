@@ -2,129 +2,235 @@ package db
 
 import (
 	"database/sql"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/thatsimonsguy/hvac-controller/internal/config"
+	"github.com/thatsimonsguy/hvac-controller/internal/model"
 )
 
-func TestRecirculationMigration(t *testing.T) {
-	// Create in-memory test database without recirculation columns (simulates old DB)
+func TestRecirculationRun(t *testing.T) {
 	db, err := sql.Open("sqlite3", ":memory:")
 	require.NoError(t, err)
 	defer db.Close()
+	db.SetMaxOpenConns(1)
+	_, err = Migrate(db)
+	require.NoError(t, err)
 
-	// Create old system table without recirculation columns
-	_, err = db.Exec(`CREATE TABLE system (
-		id INTEGER PRIMARY KEY CHECK(id=1),
-		system_mode TEXT NOT NULL,
-		override_active BOOLEAN DEFAULT FALSE,
-		prior_system_mode TEXT DEFAULT NULL
-	)`)
-	require.NoError(t, err)
-
-	// Insert system record
-	_, err = db.Exec(`INSERT INTO system (id, system_mode) VALUES (1, 'off')`)
-	require.NoError(t, err)
-
-	// Verify columns don't exist initially
-	rows, err := db.Query("PRAGMA table_info(system)")
-	require.NoError(t, err)
-	defer rows.Close()
-	
-	hasRecircActive := false
-	hasRecircStarted := false
-	for rows.Next() {
-		var cid int
-		var name, dataType string
-		var notNull bool
-		var defaultValue *string
-		var pk int
-		
-		err = rows.Scan(&cid, &name, &dataType, &notNull, &defaultValue, &pk)
+	for _, name := range []string{"main_ah", "basement_ah"} {
+		_, err = db.Exec(`INSERT INTO devices (name, pin_number, pin_active_high, min_on, min_off, online, last_changed, active_modes, device_type, role, zone_id, circ_pump_pin_number, circ_pump_pin_active_high, recirculation_policy)
+			VALUES (?, 1, 1, 1, 1, 1, ?, '["heating"]', 'air_handler', 'distributor', ?, 2, 1, '{"interval_minutes":240,"start_hour":8,"end_hour":22}')`,
+			name, time.Now().Format(time.RFC3339), name+"_zone")
 		require.NoError(t, err)
-		
-		if name == "recirculation_active" {
-			hasRecircActive = true
-		}
-		if name == "recirculation_started_at" {
-			hasRecircStarted = true
+	}
+
+	repo := New(db)
+	runs := func() map[string]model.RecirculationRun {
+		handlers, err := repo.GetAirHandlers()
+		require.NoError(t, err)
+		runs := make(map[string]model.RecirculationRun)
+		for _, ah := range handlers {
+			assert.Equal(t, model.RecirculationPolicy{IntervalMinutes: 240, StartHour: 8, EndHour: 22}, ah.Recirculation)
+			runs[ah.Name] = ah.RecirculationRun
 		}
+		return runs
 	}
-	
-	assert.False(t, hasRecircActive, "recirculation_active should not exist before migration")
-	assert.False(t, hasRecircStarted, "recirculation_started_at should not exist before migration")
+	assert.False(t, runs()["main_ah"].Active())
+
+	// Runs are per handler
+	start := time.Now()
+	require.NoError(t, repo.SetRecirculationRun("main_ah", model.RecirculationRun{StartedAt: start, EndsAt: start.Add(15 * time.Minute)}))
+	run := runs()["main_ah"]
+	assert.True(t, run.Active())
+	assert.False(t, run.Manual)
+	assert.WithinDuration(t, start.Add(15*time.Minute), run.EndsAt, time.Second)
+	assert.False(t, runs()["basement_ah"].Active())
+
+	require.NoError(t, repo.SetRecirculationRun("main_ah", model.RecirculationRun{}))
+	assert.False(t, runs()["main_ah"].Active())
+
+	// Manual runs are audited, and cancelling ends them now rather than clearing them
+	audit := Audit{Actor: "test", Reason: "stale air"}
+	require.NoError(t, repo.StartRecirculation("basement_ah", start.Add(time.Hour), audit))
+	run = runs()["basement_ah"]
+	assert.True(t, run.Manual)
+	assert.WithinDuration(t, start.Add(time.Hour), run.EndsAt, time.Second)
+
+	cancelled, err := repo.CancelRecirculation("basement_ah", audit)
+	require.NoError(t, err)
+	assert.True(t, cancelled)
+	run = runs()["basement_ah"]
+	assert.True(t, run.Active())
+	assert.WithinDuration(t, time.Now(), run.EndsAt, time.Second)
 
-	// Simulate applying migrations by manually adding columns (since we can't call ApplyMigrations with in-memory DB)
-	_, err = db.Exec("ALTER TABLE system ADD COLUMN recirculation_active BOOLEAN DEFAULT FALSE")
+	// A run that has already ended isn't cancelled again
+	cancelled, err = repo.CancelRecirculation("basement_ah", audit)
 	require.NoError(t, err)
-	_, err = db.Exec("ALTER TABLE system ADD COLUMN recirculation_started_at TEXT")
+	assert.False(t, cancelled)
+
+	cancelled, err = repo.CancelRecirculation("main_ah", audit)
+	require.NoError(t, err)
+	assert.False(t, cancelled)
+
+	entries, total, err := repo.GetAuditLog(AuditFilter{Target: "basement_ah"}, 10, 0)
 	require.NoError(t, err)
+	assert.Equal(t, 2, total)
+	assert.Equal(t, AuditRecirculationCancel, entries[0].Action)
+	assert.Equal(t, "off", entries[0].NewValue)
+	assert.Equal(t, AuditRecirculationStart, entries[1].Action)
+	assert.Equal(t, "off", entries[1].OldValue)
+
+	err = repo.StartRecirculation("missing", start.Add(time.Hour), audit)
+	assert.Error(t, err)
+}
 
-	// Now test that the functions work with the migrated schema
-	active, startedAt, err := GetRecirculationStatus(db)
+func TestPumpExerciseStatus(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	_, err = Migrate(db)
+	require.NoError(t, err)
+
+	_, err = db.Exec(`INSERT INTO devices (name, pin_number, pin_active_high, min_on, min_off, online, last_changed, active_modes, device_type, role)
+		VALUES ('rf_loop', 1, 1, 1, 1, 1, ?, '["heating"]', 'radiant_floor', 'distributor')`, time.Now().Format(time.RFC3339))
+	require.NoError(t, err)
+
+	repo := New(db)
+
+	active, startedAt, lastRun, err := repo.GetPumpExerciseStatus("rf_loop")
 	require.NoError(t, err)
 	assert.False(t, active)
 	assert.True(t, startedAt.IsZero())
+	assert.True(t, lastRun.IsZero())
 
-	// Test setting active
-	now := time.Now()
-	err = SetRecirculationActive(db, true, now)
+	start := time.Now()
+	require.NoError(t, repo.SetPumpExerciseActive("rf_loop", true, start))
+	active, startedAt, lastRun, err = repo.GetPumpExerciseStatus("rf_loop")
 	require.NoError(t, err)
+	assert.True(t, active)
+	assert.WithinDuration(t, start, startedAt, time.Second)
+	assert.True(t, lastRun.IsZero())
 
-	active, startedAt, err = GetRecirculationStatus(db)
+	finish := start.Add(time.Minute)
+	require.NoError(t, repo.SetPumpExerciseActive("rf_loop", false, finish))
+	active, _, lastRun, err = repo.GetPumpExerciseStatus("rf_loop")
 	require.NoError(t, err)
-	assert.True(t, active)
-	assert.WithinDuration(t, now, startedAt, time.Second)
+	assert.False(t, active)
+	assert.WithinDuration(t, finish, lastRun, time.Second)
+
+	// Runs for demand count too
+	ran := finish.Add(time.Hour)
+	require.NoError(t, repo.RecordPumpRun("rf_loop", ran))
+	_, _, lastRun, err = repo.GetPumpExerciseStatus("rf_loop")
+	require.NoError(t, err)
+	assert.WithinDuration(t, ran, lastRun, time.Second)
+
+	_, _, _, err = repo.GetPumpExerciseStatus("missing")
+	assert.Error(t, err)
 }
 
-func TestRecirculationOverrideFunctions(t *testing.T) {
-	// Create in-memory test database
-	db, err := sql.Open("sqlite3", ":memory:")
+func TestUpdateZoneModes(t *testing.T) {
+	c := reconcileTestConfig(filepath.Join(t.TempDir(), "hvac.db"))
+	repo := New(seedReconcileTestDB(t, c))
+	audit := Audit{Actor: ActorCLI, Reason: "group change"}
+
+	// One unknown zone rolls back the whole change
+	err := repo.UpdateZoneModes(map[string]model.SystemMode{"main_floor": model.ModeHeating, "attic": model.ModeHeating}, false, audit)
+	require.Error(t, err)
+	zone, err := repo.GetZoneByID("main_floor")
 	require.NoError(t, err)
-	defer db.Close()
+	assert.Equal(t, model.ModeOff, zone.Mode)
 
-	// Create system table
-	_, err = db.Exec(`CREATE TABLE system (
-		id INTEGER PRIMARY KEY CHECK(id=1),
-		system_mode TEXT NOT NULL,
-		override_active BOOLEAN DEFAULT FALSE,
-		recirculation_active BOOLEAN DEFAULT FALSE,
-		recirculation_started_at TEXT
-	)`)
+	require.NoError(t, repo.UpdateZoneModes(map[string]model.SystemMode{"main_floor": model.ModeHeating, "garage": model.ModeOff}, false, audit))
+	require.NoError(t, repo.UpdateZoneSetpoints([]string{"main_floor", "garage"}, 66, audit))
+
+	zones, err := repo.GetAllZones()
 	require.NoError(t, err)
+	for _, z := range zones {
+		assert.Equal(t, 66.0, z.Setpoint, z.ID)
+	}
 
-	// Insert system record
-	_, err = db.Exec(`INSERT INTO system (id, system_mode) VALUES (1, 'off')`)
+	entries, _, err := repo.GetAuditLog(AuditFilter{Action: AuditZoneMode}, 10, 0)
 	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "group change", entries[0].Reason)
+}
 
-	t.Run("initially inactive", func(t *testing.T) {
-		active, startedAt, err := GetRecirculationStatus(db)
-		require.NoError(t, err)
-		assert.False(t, active)
-		assert.True(t, startedAt.IsZero())
-	})
+func TestModeConflicts(t *testing.T) {
+	c := reconcileTestConfig(filepath.Join(t.TempDir(), "hvac.db"))
+	repo := New(seedReconcileTestDB(t, c))
+	audit := Audit{Actor: ActorCLI}
 
-	t.Run("set active", func(t *testing.T) {
-		now := time.Now()
-		err := SetRecirculationActive(db, true, now)
-		require.NoError(t, err)
+	require.NoError(t, repo.UpdateSystemMode(model.ModeHeating, true, audit))
+	require.NoError(t, repo.UpdateZoneMode("main_floor", model.ModeHeating, true, audit))
 
-		active, startedAt, err := GetRecirculationStatus(db)
-		require.NoError(t, err)
-		assert.True(t, active)
-		assert.WithinDuration(t, now, startedAt, time.Second)
-	})
+	// The zones are read in the same transaction as the system mode is written
+	var conflict *ModeConflictError
+	require.ErrorAs(t, repo.UpdateSystemMode(model.ModeCooling, true, audit), &conflict)
+	assert.Equal(t, []string{"main_floor"}, conflict.Zones)
+	assert.Equal(t, "main_floor (heating)", conflict.ZoneList())
+	mode, err := repo.GetSystemMode()
+	require.NoError(t, err)
+	assert.Equal(t, model.ModeHeating, mode)
 
-	t.Run("clear active", func(t *testing.T) {
-		err := SetRecirculationActive(db, false, time.Time{})
-		require.NoError(t, err)
+	require.ErrorAs(t, repo.UpdateZoneMode("garage", model.ModeCooling, true, audit), &conflict)
+	assert.Equal(t, model.ModeHeating, conflict.SystemMode)
 
-		active, startedAt, err := GetRecirculationStatus(db)
-		require.NoError(t, err)
-		assert.False(t, active)
-		assert.True(t, startedAt.IsZero())
-	})
-}
\ No newline at end of file
+	require.NoError(t, repo.UpdateZoneMode("main_floor", model.ModeOff, true, audit))
+	require.NoError(t, repo.UpdateSystemMode(model.ModeCooling, true, audit))
+	_, err = repo.UpdateZoneGroupMode(model.AllZonesGroup, model.ModeHeating, false, true, audit)
+	require.ErrorAs(t, err, &conflict)
+	assert.Equal(t, []string{"garage", "main_floor"}, conflict.Zones)
+
+	// Without rejectConflicts the change goes through
+	_, err = repo.UpdateZoneGroupMode(model.AllZonesGroup, model.ModeHeating, false, false, audit)
+	require.NoError(t, err)
+}
+
+func TestZoneCapabilities(t *testing.T) {
+	c := reconcileTestConfig(filepath.Join(t.TempDir(), "hvac.db"))
+	c.Zones[0].Capabilities = []string{"heating", "cooling", "dehumidify"}
+	c.Zones[1].Capabilities = []string{"heating", "cooling"}
+	c.DeviceConfig.AirHandlers = config.AirHandlerGroup{
+		DeviceProfile: config.DeviceProfile{MinTimeOn: 3, MinTimeOff: 1, ActiveModes: []string{"heating", "cooling", "fan"}},
+		Devices:       []config.AirHandlerConfig{{Name: "main_floor_air_handler", Pin: 5, CircPumpPin: 6, Zone: "main_floor"}},
+	}
+	repo := New(seedReconcileTestDB(t, c))
+
+	report, err := repo.GetZoneCapabilities("main_floor")
+	require.NoError(t, err)
+	assert.Equal(t, []model.ZoneCapability{
+		{Capability: "heating", Listed: true, Devices: []string{"main_floor_air_handler", "main_radiant_loop"}},
+		{Capability: "cooling", Listed: true, Devices: []string{"main_floor_air_handler"}},
+		{Capability: "fan", Listed: false, Devices: []string{"main_floor_air_handler"}},
+		{Capability: "dehumidify", Listed: true, Devices: []string{"main_floor_air_handler"}},
+	}, report.Capabilities)
+	assert.Equal(t, []model.SystemMode{model.ModeOff, model.ModeHeating, model.ModeCooling}, report.SupportedModes())
+
+	_, err = repo.GetZoneCapabilities("attic")
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	// A zone's mode is only written if its capabilities allow it
+	require.NoError(t, repo.UpdateZoneMode("garage", model.ModeHeating, false, Audit{Actor: ActorCLI}))
+	var unsupported *UnsupportedModeError
+	require.ErrorAs(t, repo.UpdateZoneMode("garage", model.ModeCooling, false, Audit{Actor: ActorCLI}), &unsupported)
+	assert.Equal(t, []string{`zone garage lists "cooling" but no attached device provides it`}, unsupported.Reasons)
+	garage, err := repo.GetZoneByID("garage")
+	require.NoError(t, err)
+	assert.Equal(t, model.ModeHeating, garage.Mode)
+
+	// A group change fails as a whole unless unsupported zones may be turned off
+	_, err = repo.UpdateZoneGroupMode(model.AllZonesGroup, model.ModeCooling, false, false, Audit{Actor: ActorCLI})
+	require.ErrorAs(t, err, &unsupported)
+	assert.Equal(t, []string{"garage"}, unsupported.Zones)
+
+	modes, err := repo.UpdateZoneGroupMode(model.AllZonesGroup, model.ModeCooling, true, false, Audit{Actor: ActorCLI})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]model.SystemMode{"main_floor": model.ModeCooling, "garage": model.ModeOff}, modes)
+}
@@ -5,59 +5,243 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/rs/zerolog/log"
 
 	"github.com/thatsimonsguy/hvac-controller/db"
 	"github.com/thatsimonsguy/hvac-controller/internal/config"
 	"github.com/thatsimonsguy/hvac-controller/internal/model"
+	"github.com/thatsimonsguy/hvac-controller/internal/reload"
 	"github.com/thatsimonsguy/hvac-controller/internal/temperature"
 )
 
+const (
+	defaultAuditLimit = 50
+	maxAuditLimit     = 500
+
+	// maxRecirculationMinutes caps a manual recirculation run at a day
+	maxRecirculationMinutes = 24 * 60
+)
+
 type Server struct {
-	db          *sql.DB
+	store       Store
 	tempService *temperature.Service
-	config      *config.Config
+	config      atomic.Pointer[config.Config]
+	reloader    ConfigReloader
+}
+
+// Store is the system and zone state the API reads and updates
+type Store interface {
+	GetSystemMode() (model.SystemMode, error)
+	UpdateSystemMode(mode model.SystemMode, rejectConflicts bool, audit db.Audit) error
+	GetAllZones() ([]model.Zone, error)
+	GetZoneByID(id string) (*model.Zone, error)
+	UpdateZoneMode(id string, mode model.SystemMode, rejectConflicts bool, audit db.Audit) error
+	GetZoneCapabilities(id string) (*model.CapabilityReport, error)
+	UpdateZoneSetpoint(id string, setpoint float64, audit db.Audit) error
+	UpdateZoneFailsafe(id string, enabled bool, minTemp, maxTemp *float64, audit db.Audit) error
+	UpdateZoneCirculate(id string, circulate model.Circulation, audit db.Audit) error
+	GetZoneGroups() ([]model.ZoneGroup, error)
+	GetZoneGroup(id string) (*model.ZoneGroup, error)
+	UpdateZoneGroupMode(groupID string, mode model.SystemMode, offIfUnsupported, rejectConflicts bool, audit db.Audit) (map[string]model.SystemMode, error)
+	UpdateZoneGroupSetpoint(groupID string, setpoint float64, audit db.Audit) error
+	GetAuditLog(filter db.AuditFilter, limit, offset int) ([]db.AuditEntry, int, error)
+	GetAllSensors() ([]model.Sensor, error)
+	GetSensorByID(id string) (*model.Sensor, error)
+	GetAirHandlers() ([]model.AirHandler, error)
+	StartRecirculation(deviceName string, endsAt time.Time, audit db.Audit) error
+	CancelRecirculation(deviceName string, audit db.Audit) (bool, error)
+}
+
+// ConfigReloader re-reads the controller config at runtime
+type ConfigReloader interface {
+	Reload() error
 }
 
 type SystemModeResponse struct {
-	Mode       string  `json:"mode"`
-	BufferTemp float64 `json:"buffer_temp"`
+	Mode                    string  `json:"mode"`
+	BufferTemp              float64 `json:"buffer_temp"`
+	BufferReadingAgeSeconds float64 `json:"buffer_reading_age_seconds"`
+	BufferReadingQuality    string  `json:"buffer_reading_quality"`
 }
 
 type SystemModeRequest struct {
-	Mode string `json:"mode"`
+	Mode   string `json:"mode"`
+	Reason string `json:"reason,omitempty"`
 }
 
 type ZoneResponse struct {
-	ID           string   `json:"id"`
-	Label        string   `json:"label"`
-	Setpoint     float64  `json:"setpoint"`
-	Mode         string   `json:"mode"`
-	CurrentTemp  float64  `json:"current_temp"`
-	Capabilities []string `json:"capabilities"`
+	ID           string           `json:"id"`
+	Label        string           `json:"label"`
+	Setpoint     float64          `json:"setpoint"`
+	Mode         string           `json:"mode"`
+	CurrentTemp  float64          `json:"current_temp"`
+	Capabilities []string         `json:"capabilities"`
+	Failsafe     FailsafeResponse `json:"failsafe"`
+
+	// Blower circulation duty, see ZoneCirculateRequest
+	Circulate model.Circulation `json:"circulate"`
+
+	// Set while the zone's mode is opposite to the system mode, so the zone is kept idle
+	Conflict bool `json:"conflict"`
+
+	// Weighted degrees below setpoint while heating, and whether the zone is shed for limited
+	// heating capacity, see buffercontroller.DemandStager
+	Priority  float64    `json:"priority"`
+	Demand    float64    `json:"demand"`
+	Shed      bool       `json:"shed"`
+	ShedSince *time.Time `json:"shed_since,omitempty"`
+
+	// How old current_temp is and whether it can be trusted, see SensorResponse
+	ReadingAgeSeconds float64 `json:"reading_age_seconds"`
+	ReadingQuality    string  `json:"reading_quality"`
+
+	// How current_temp is combined from the zone's sensors, and which of them it came from
+	Aggregation    string   `json:"aggregation"`
+	ReadingSources []string `json:"reading_sources"`
+
+	// Relative humidity from the zone's humidity sensor, omitted for zones without one
+	Humidity        *float64 `json:"humidity,omitempty"`
+	HumidityTarget  float64  `json:"humidity_target,omitempty"`
+	HumidityQuality string   `json:"humidity_quality,omitempty"`
+
+	// Set while the zone's sensor has gone without a valid reading and the failsafe is degraded
+	SensorDegraded      bool       `json:"sensor_degraded"`
+	SensorDegradedSince *time.Time `json:"sensor_degraded_since,omitempty"`
+}
+
+// FailsafeResponse shows the limits the failsafe controller applies to a zone, after falling back
+// to the system-wide override limits.
+type FailsafeResponse struct {
+	Enabled bool    `json:"enabled"`
+	MinTemp float64 `json:"min_temp"`
+	MaxTemp float64 `json:"max_temp"`
+}
+
+// SensorResponse is a sensor's current value. Quality is fresh, stale, substituted (the latest poll
+// was rejected and the last good value stands in), disabled or missing.
+type SensorResponse struct {
+	ID            string     `json:"id"`
+	Type          string     `json:"type"`
+	Temperature   float64    `json:"temperature"`
+	Timestamp     *time.Time `json:"timestamp,omitempty"`
+	AgeSeconds    float64    `json:"age_seconds"`
+	Quality       string     `json:"quality"`
+	AnomalyCount  int        `json:"anomaly_count"`
+	RecoveryCount int        `json:"recovery_count"`
+	ReadFailures  int        `json:"read_failures"` // consecutive reads that errored or timed out
+	Humidity      *float64   `json:"humidity,omitempty"` // zone humidity sensors only
 }
 
 type ZoneSetpointRequest struct {
 	Setpoint float64 `json:"setpoint"`
+	Reason   string  `json:"reason,omitempty"`
 }
 
 type ZoneModeRequest struct {
-	Mode string `json:"mode"`
+	Mode   string `json:"mode"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// ZoneCapabilitiesResponse shows how each of a zone's capabilities derives from its config and
+// attached devices, and the modes the zone can be put in.
+type ZoneCapabilitiesResponse struct {
+	model.CapabilityReport
+	SupportedModes []model.SystemMode `json:"supported_modes"`
+}
+
+// ZoneGroupModeRequest sets every zone in a group to Mode in one change. A zone without the
+// capability for Mode fails the whole request, unless OffIfUnsupported turns it off instead.
+type ZoneGroupModeRequest struct {
+	Mode             string `json:"mode"`
+	OffIfUnsupported bool   `json:"off_if_unsupported,omitempty"`
+	Reason           string `json:"reason,omitempty"`
+}
+
+// ZoneGroupModeResponse is the mode each zone in the group was set to.
+type ZoneGroupModeResponse struct {
+	Modes map[string]model.SystemMode `json:"modes"`
+}
+
+// ZoneFailsafeRequest replaces a zone's failsafe settings. Omitted limits fall back to the
+// system-wide override limits.
+type ZoneFailsafeRequest struct {
+	Enabled *bool    `json:"enabled"`
+	MinTemp *float64 `json:"min_temp,omitempty"`
+	MaxTemp *float64 `json:"max_temp,omitempty"`
+	Reason  string   `json:"reason,omitempty"`
+}
+
+// AirHandlerResponse is an air handler's recirculation policy and the run in progress, if any.
+type AirHandlerResponse struct {
+	Name          string                    `json:"name"`
+	Zone          string                    `json:"zone"`
+	Recirculation model.RecirculationPolicy `json:"recirculation"`
+	Run           *model.RecirculationRun   `json:"recirculation_run,omitempty"`
+}
+
+// RecirculationRequest starts a manual recirculation run. A zero duration uses the handler's
+// policy duration.
+type RecirculationRequest struct {
+	DurationMinutes int    `json:"duration_minutes,omitempty"`
+	Reason          string `json:"reason,omitempty"`
+}
+
+// ZoneCirculateRequest replaces a zone's blower circulation duty: minutes per hour the blower runs
+// in circulate mode (0 or 60 for continuous), and whether it also circulates at that duty between
+// heating and cooling calls.
+type ZoneCirculateRequest struct {
+	MinutesPerHour int    `json:"minutes_per_hour"`
+	BetweenCalls   bool   `json:"between_calls"`
+	Reason         string `json:"reason,omitempty"`
+}
+
+type AuditEntryResponse struct {
+	ID       int64     `json:"id"`
+	Time     time.Time `json:"time"`
+	Actor    string    `json:"actor"`
+	Action   string    `json:"action"`
+	Target   string    `json:"target,omitempty"`
+	OldValue string    `json:"old_value"`
+	NewValue string    `json:"new_value"`
+	Reason   string    `json:"reason,omitempty"`
+}
+
+type AuditLogResponse struct {
+	Entries []AuditEntryResponse `json:"entries"`
+	Total   int                  `json:"total"`
+	Limit   int                  `json:"limit"`
+	Offset  int                  `json:"offset"`
 }
 
 type ErrorResponse struct {
 	Error string `json:"error"`
 }
 
-func NewServer(database *sql.DB, tempService *temperature.Service, cfg *config.Config) *Server {
-	return &Server{
-		db:          database,
+func NewServer(store Store, tempService *temperature.Service, cfg *config.Config) *Server {
+	s := &Server{
+		store:       store,
 		tempService: tempService,
-		config:      cfg,
 	}
+	s.config.Store(cfg)
+	return s
+}
+
+// SetReloader enables the config reload endpoint
+func (s *Server) SetReloader(r ConfigReloader) {
+	s.reloader = r
+}
+
+// UpdateConfig swaps in a reloaded config
+func (s *Server) UpdateConfig(cfg *config.Config) {
+	s.config.Store(cfg)
 }
 
 func (s *Server) Start(port int) error {
@@ -85,6 +269,24 @@ func (s *Server) Start(port int) error {
 	// Zone endpoints
 	mux.HandleFunc("/api/zones", s.handleZones)
 	mux.HandleFunc("/api/zones/", s.handleZoneOperations)
+
+	// Zone groups, including the "all" group of every zone
+	mux.HandleFunc("/api/zone-groups", s.handleZoneGroups)
+	mux.HandleFunc("/api/zone-groups/", s.handleZoneGroups)
+
+	// Config endpoints
+	mux.HandleFunc("/api/config/reload", s.handleConfigReload)
+
+	// Audit log
+	mux.HandleFunc("/api/audit", s.handleAudit)
+
+	// Sensor readings
+	mux.HandleFunc("/api/sensors", s.handleSensors)
+	mux.HandleFunc("/api/sensors/", s.handleSensors)
+
+	// Air handlers and recirculation runs
+	mux.HandleFunc("/api/air-handlers", s.handleAirHandlers)
+	mux.HandleFunc("/api/air-handlers/", s.handleAirHandlers)
 	
 	addr := fmt.Sprintf("0.0.0.0:%d", port)
 	log.Info().Str("address", addr).Msg("Starting REST API server")
@@ -129,8 +331,15 @@ func (s *Server) handleZoneOperations(w http.ResponseWriter, r *http.Request) {
 		} else {
 			s.writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
 		}
+	} else if len(parts) == 2 && parts[1] == "capabilities" {
+		// /api/zones/{id}/capabilities
+		if r.Method == http.MethodGet {
+			s.getZoneCapabilities(w, r, zoneID)
+		} else {
+			s.writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		}
 	} else if len(parts) == 2 {
-		// /api/zones/{id}/mode or /api/zones/{id}/setpoint
+		// /api/zones/{id}/mode, /api/zones/{id}/setpoint, /api/zones/{id}/failsafe or /api/zones/{id}/circulate
 		operation := parts[1]
 		if r.Method == http.MethodPut {
 			switch operation {
@@ -138,6 +347,10 @@ func (s *Server) handleZoneOperations(w http.ResponseWriter, r *http.Request) {
 				s.setZoneMode(w, r, zoneID)
 			case "setpoint":
 				s.setZoneSetpoint(w, r, zoneID)
+			case "failsafe":
+				s.setZoneFailsafe(w, r, zoneID)
+			case "circulate":
+				s.setZoneCirculate(w, r, zoneID)
 			default:
 				s.writeError(w, http.StatusNotFound, "Unknown operation")
 			}
@@ -149,8 +362,41 @@ func (s *Server) handleZoneOperations(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func (s *Server) handleZoneGroups(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/zone-groups"), "/")
+	if path == "" {
+		if r.Method != http.MethodGet {
+			s.writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		s.getZoneGroups(w)
+		return
+	}
+
+	parts := strings.Split(path, "/")
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		// /api/zone-groups/{id}
+		if group := s.zoneGroup(w, parts[0]); group != nil {
+			s.writeJSON(w, http.StatusOK, group)
+		}
+	case len(parts) == 1:
+		s.writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	case len(parts) == 2 && r.Method != http.MethodPut:
+		s.writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	case len(parts) == 2 && parts[1] == "mode":
+		s.setZoneGroupMode(w, r, parts[0])
+	case len(parts) == 2 && parts[1] == "setpoint":
+		s.setZoneGroupSetpoint(w, r, parts[0])
+	case len(parts) == 2:
+		s.writeError(w, http.StatusNotFound, "Unknown operation")
+	default:
+		s.writeError(w, http.StatusNotFound, "Invalid path")
+	}
+}
+
 func (s *Server) getSystemMode(w http.ResponseWriter, r *http.Request) {
-	mode, err := db.GetSystemMode(s.db)
+	mode, err := s.store.GetSystemMode()
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to get system mode")
 		s.writeError(w, http.StatusInternalServerError, err.Error())
@@ -158,11 +404,13 @@ func (s *Server) getSystemMode(w http.ResponseWriter, r *http.Request) {
 	}
 	
 	// Get buffer tank temperature
-	bufferTemp, _ := s.tempService.GetTemperature("buffer_tank")
-	
+	buffer, _ := s.tempService.GetReading("buffer_tank")
+
 	response := SystemModeResponse{
-		Mode:       string(mode),
-		BufferTemp: bufferTemp,
+		Mode:                    string(mode),
+		BufferTemp:              buffer.Temperature,
+		BufferReadingAgeSeconds: buffer.Age.Seconds(),
+		BufferReadingQuality:    string(buffer.Quality),
 	}
 	s.writeJSON(w, http.StatusOK, response)
 }
@@ -181,7 +429,12 @@ func (s *Server) setSystemMode(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	
-	if err := db.UpdateSystemMode(s.db, systemMode); err != nil {
+	if err := s.store.UpdateSystemMode(systemMode, s.config.Load().RejectsModeConflicts(), auditFor(r, req.Reason)); err != nil {
+		var conflict *db.ModeConflictError
+		if errors.As(err, &conflict) {
+			s.writeError(w, http.StatusConflict, fmt.Sprintf("System mode %s conflicts with zones: %s", systemMode, conflict.ZoneList()))
+			return
+		}
 		log.Error().Err(err).Str("mode", req.Mode).Msg("Failed to update system mode")
 		s.writeError(w, http.StatusInternalServerError, err.Error())
 		return
@@ -192,31 +445,29 @@ func (s *Server) setSystemMode(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) getZones(w http.ResponseWriter, r *http.Request) {
-	zones, err := db.GetAllZones(s.db)
+	zones, err := s.store.GetAllZones()
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to get zones")
 		s.writeError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
+	sysMode, err := s.store.GetSystemMode()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get system mode")
+		s.writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
 	
 	var response []ZoneResponse
 	for _, zone := range zones {
-		temp, _ := s.tempService.GetTemperature(zone.Sensor.ID)
-		response = append(response, ZoneResponse{
-			ID:           zone.ID,
-			Label:        zone.Label,
-			Setpoint:     zone.Setpoint,
-			Mode:         string(zone.Mode),
-			CurrentTemp:  temp,
-			Capabilities: zone.Capabilities,
-		})
+		response = append(response, s.zoneResponse(zone, sysMode))
 	}
 	
 	s.writeJSON(w, http.StatusOK, response)
 }
 
 func (s *Server) getZone(w http.ResponseWriter, r *http.Request, zoneID string) {
-	zone, err := db.GetZoneByID(s.db, zoneID)
+	zone, err := s.store.GetZoneByID(zoneID)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) || strings.Contains(err.Error(), "no rows in result set") {
 			s.writeError(w, http.StatusNotFound, "Zone not found")
@@ -227,17 +478,76 @@ func (s *Server) getZone(w http.ResponseWriter, r *http.Request, zoneID string)
 		return
 	}
 	
-	temp, _ := s.tempService.GetTemperature(zone.Sensor.ID)
+	sysMode, err := s.store.GetSystemMode()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get system mode")
+		s.writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, s.zoneResponse(*zone, sysMode))
+}
+
+// zoneResponse describes a zone with its latest reading, demand and humidity.
+func (s *Server) zoneResponse(zone model.Zone, sysMode model.SystemMode) ZoneResponse {
+	reading, _ := s.tempService.GetZoneReading(zone)
 	response := ZoneResponse{
 		ID:           zone.ID,
 		Label:        zone.Label,
 		Setpoint:     zone.Setpoint,
 		Mode:         string(zone.Mode),
-		CurrentTemp:  temp,
+		CurrentTemp:  reading.Temperature,
 		Capabilities: zone.Capabilities,
+		Failsafe:     s.failsafeResponse(zone),
+		Circulate:    zone.CirculateSettings(),
+		Conflict:     model.ModesConflict(zone.Mode, sysMode),
+		Priority:     zone.PriorityWeight(),
+		Demand:       s.zoneDemand(zone, reading),
+		Shed:         zone.ShedSince != nil,
+		ShedSince:    zone.ShedSince,
+
+		ReadingAgeSeconds: reading.Age.Seconds(),
+		ReadingQuality:    string(reading.Quality),
+		Aggregation:       zone.AggregationMethod(),
+		ReadingSources:    readingSources(reading),
+
+		SensorDegraded:      zone.SensorDegradedSince != nil,
+		SensorDegradedSince: zone.SensorDegradedSince,
 	}
-	
-	s.writeJSON(w, http.StatusOK, response)
+	s.addHumidity(&response, zone)
+	return response
+}
+
+// zoneDemand is the zone's weighted heating demand, or 0 without a reading fresh enough to act on.
+func (s *Server) zoneDemand(zone model.Zone, reading temperature.SensorReading) float64 {
+	if !reading.FreshEnough(s.config.Load().MaxReadingAge()) {
+		return 0
+	}
+	return zone.Demand(reading.Temperature)
+}
+
+// addHumidity fills in the humidity of zones that have a humidity sensor.
+func (s *Server) addHumidity(response *ZoneResponse, zone model.Zone) {
+	if zone.HumiditySensor == nil {
+		return
+	}
+	humidity, ok := s.tempService.GetHumidity(zone.HumiditySensor.ID)
+	if ok {
+		response.Humidity = &humidity.Humidity
+	}
+	response.HumidityTarget = zone.HumidityTarget
+	response.HumidityQuality = string(humidity.Quality)
+}
+
+// readingSources lists the sensors a zone reading came from, or none if there is no reading.
+func readingSources(reading temperature.SensorReading) []string {
+	if len(reading.Sources) > 0 {
+		return reading.Sources
+	}
+	if reading.Quality == temperature.QualityMissing {
+		return []string{}
+	}
+	return []string{reading.SensorID}
 }
 
 func (s *Server) setZoneMode(w http.ResponseWriter, r *http.Request, zoneID string) {
@@ -255,7 +565,7 @@ func (s *Server) setZoneMode(w http.ResponseWriter, r *http.Request, zoneID stri
 	}
 	
 	// Check if zone exists
-	if _, err := db.GetZoneByID(s.db, zoneID); err != nil {
+	if _, err := s.store.GetZoneByID(zoneID); err != nil {
 		if errors.Is(err, sql.ErrNoRows) || strings.Contains(err.Error(), "no rows in result set") {
 			s.writeError(w, http.StatusNotFound, "Zone not found")
 		} else {
@@ -264,7 +574,18 @@ func (s *Server) setZoneMode(w http.ResponseWriter, r *http.Request, zoneID stri
 		return
 	}
 	
-	if err := db.UpdateZoneMode(s.db, zoneID, zoneMode); err != nil {
+	// The zone's capabilities and attached devices must support the mode
+	if err := s.store.UpdateZoneMode(zoneID, zoneMode, s.config.Load().RejectsModeConflicts(), auditFor(r, req.Reason)); err != nil {
+		var unsupported *db.UnsupportedModeError
+		if errors.As(err, &unsupported) {
+			s.writeError(w, http.StatusBadRequest, "Unsupported zone mode: "+strings.Join(unsupported.Reasons, "; "))
+			return
+		}
+		var conflict *db.ModeConflictError
+		if errors.As(err, &conflict) {
+			s.writeError(w, http.StatusConflict, fmt.Sprintf("Zone mode %s conflicts with system mode %s", zoneMode, conflict.SystemMode))
+			return
+		}
 		log.Error().Err(err).Str("zone_id", zoneID).Str("mode", req.Mode).Msg("Failed to update zone mode")
 		s.writeError(w, http.StatusInternalServerError, err.Error())
 		return
@@ -282,13 +603,14 @@ func (s *Server) setZoneSetpoint(w http.ResponseWriter, r *http.Request, zoneID
 	}
 	
 	// Validate setpoint range using config values
-	if req.Setpoint < s.config.ZoneMinTemp || req.Setpoint > s.config.ZoneMaxTemp {
-		s.writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid setpoint. Must be between %.1f°F and %.1f°F", s.config.ZoneMinTemp, s.config.ZoneMaxTemp))
+	cfg := s.config.Load()
+	if req.Setpoint < cfg.ZoneMinTemp || req.Setpoint > cfg.ZoneMaxTemp {
+		s.writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid setpoint. Must be between %.1f°F and %.1f°F", cfg.ZoneMinTemp, cfg.ZoneMaxTemp))
 		return
 	}
 	
 	// Check if zone exists
-	if _, err := db.GetZoneByID(s.db, zoneID); err != nil {
+	if _, err := s.store.GetZoneByID(zoneID); err != nil {
 		if errors.Is(err, sql.ErrNoRows) || strings.Contains(err.Error(), "no rows in result set") {
 			s.writeError(w, http.StatusNotFound, "Zone not found")
 		} else {
@@ -297,7 +619,7 @@ func (s *Server) setZoneSetpoint(w http.ResponseWriter, r *http.Request, zoneID
 		return
 	}
 	
-	if err := db.UpdateZoneSetpoint(s.db, zoneID, req.Setpoint); err != nil {
+	if err := s.store.UpdateZoneSetpoint(zoneID, req.Setpoint, auditFor(r, req.Reason)); err != nil {
 		log.Error().Err(err).Str("zone_id", zoneID).Float64("setpoint", req.Setpoint).Msg("Failed to update zone setpoint")
 		s.writeError(w, http.StatusInternalServerError, err.Error())
 		return
@@ -307,6 +629,472 @@ func (s *Server) setZoneSetpoint(w http.ResponseWriter, r *http.Request, zoneID
 	w.WriteHeader(http.StatusOK)
 }
 
+func (s *Server) getZoneCapabilities(w http.ResponseWriter, r *http.Request, zoneID string) {
+	report, err := s.store.GetZoneCapabilities(zoneID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			s.writeError(w, http.StatusNotFound, "Zone not found")
+		} else {
+			log.Error().Err(err).Str("zone_id", zoneID).Msg("Failed to get zone capabilities")
+			s.writeError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+	s.writeJSON(w, http.StatusOK, ZoneCapabilitiesResponse{CapabilityReport: *report, SupportedModes: report.SupportedModes()})
+}
+
+func (s *Server) getZoneGroups(w http.ResponseWriter) {
+	all := s.zoneGroup(w, model.AllZonesGroup)
+	if all == nil {
+		return
+	}
+	groups, err := s.store.GetZoneGroups()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get zone groups")
+		s.writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	s.writeJSON(w, http.StatusOK, append([]model.ZoneGroup{*all}, groups...))
+}
+
+// zoneGroup looks up a zone group by ID, writing a 404 or 500 and returning nil if it can't.
+func (s *Server) zoneGroup(w http.ResponseWriter, id string) *model.ZoneGroup {
+	group, err := s.store.GetZoneGroup(id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			s.writeError(w, http.StatusNotFound, "Zone group not found")
+		} else {
+			log.Error().Err(err).Str("group_id", id).Msg("Failed to get zone group")
+			s.writeError(w, http.StatusInternalServerError, err.Error())
+		}
+		return nil
+	}
+	if group.Zones == nil {
+		group.Zones = []string{}
+	}
+	return group
+}
+
+func (s *Server) setZoneGroupMode(w http.ResponseWriter, r *http.Request, groupID string) {
+	var req ZoneGroupModeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.writeError(w, http.StatusBadRequest, "Invalid JSON payload")
+		return
+	}
+
+	mode := model.SystemMode(req.Mode)
+	if !isValidSystemMode(mode) {
+		s.writeError(w, http.StatusBadRequest, "Invalid zone mode. Valid modes: off, heating, cooling, circulate")
+		return
+	}
+
+	modes, err := s.store.UpdateZoneGroupMode(groupID, mode, req.OffIfUnsupported, s.config.Load().RejectsModeConflicts(), auditFor(r, req.Reason))
+	var unsupported *db.UnsupportedModeError
+	var conflict *db.ModeConflictError
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		s.writeError(w, http.StatusNotFound, "Zone group not found")
+		return
+	case errors.As(err, &conflict):
+		s.writeError(w, http.StatusConflict, fmt.Sprintf("Zone mode %s conflicts with system mode %s", mode, conflict.SystemMode))
+		return
+	case errors.As(err, &unsupported):
+		s.writeError(w, http.StatusBadRequest, fmt.Sprintf("Unsupported zone mode: %s (set off_if_unsupported to turn these zones off instead)",
+			strings.Join(unsupported.Reasons, "; ")))
+		return
+	case err != nil:
+		log.Error().Err(err).Str("group_id", groupID).Str("mode", req.Mode).Msg("Failed to update zone group mode")
+		s.writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	log.Info().Str("group_id", groupID).Str("mode", req.Mode).Int("zones", len(modes)).Msg("Zone group mode updated via API")
+	s.writeJSON(w, http.StatusOK, ZoneGroupModeResponse{Modes: modes})
+}
+
+func (s *Server) setZoneGroupSetpoint(w http.ResponseWriter, r *http.Request, groupID string) {
+	var req ZoneSetpointRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.writeError(w, http.StatusBadRequest, "Invalid JSON payload")
+		return
+	}
+
+	cfg := s.config.Load()
+	if req.Setpoint < cfg.ZoneMinTemp || req.Setpoint > cfg.ZoneMaxTemp {
+		s.writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid setpoint. Must be between %.1f°F and %.1f°F", cfg.ZoneMinTemp, cfg.ZoneMaxTemp))
+		return
+	}
+
+	err := s.store.UpdateZoneGroupSetpoint(groupID, req.Setpoint, auditFor(r, req.Reason))
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		s.writeError(w, http.StatusNotFound, "Zone group not found")
+		return
+	case err != nil:
+		log.Error().Err(err).Str("group_id", groupID).Float64("setpoint", req.Setpoint).Msg("Failed to update zone group setpoint")
+		s.writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	log.Info().Str("group_id", groupID).Float64("setpoint", req.Setpoint).Msg("Zone group setpoint updated via API")
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) setZoneFailsafe(w http.ResponseWriter, r *http.Request, zoneID string) {
+	var req ZoneFailsafeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.writeError(w, http.StatusBadRequest, "Invalid JSON payload")
+		return
+	}
+	if req.Enabled == nil {
+		s.writeError(w, http.StatusBadRequest, "enabled is required")
+		return
+	}
+
+	// Validate the limits that will actually apply, including the system-wide fallbacks
+	cfg := s.config.Load()
+	minTemp, maxTemp := model.Zone{FailsafeMinTemp: req.MinTemp, FailsafeMaxTemp: req.MaxTemp}.FailsafeLimits(cfg.SystemOverrideMinTemp, cfg.SystemOverrideMaxTemp)
+	if minTemp >= maxTemp {
+		s.writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid failsafe limits. min_temp (%.1f°F) must be below max_temp (%.1f°F)", minTemp, maxTemp))
+		return
+	}
+
+	// Check if zone exists
+	if _, err := s.store.GetZoneByID(zoneID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) || strings.Contains(err.Error(), "no rows in result set") {
+			s.writeError(w, http.StatusNotFound, "Zone not found")
+		} else {
+			s.writeError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	if err := s.store.UpdateZoneFailsafe(zoneID, *req.Enabled, req.MinTemp, req.MaxTemp, auditFor(r, req.Reason)); err != nil {
+		log.Error().Err(err).Str("zone_id", zoneID).Msg("Failed to update zone failsafe")
+		s.writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	log.Info().Str("zone_id", zoneID).Bool("enabled", *req.Enabled).Float64("min_temp", minTemp).Float64("max_temp", maxTemp).Msg("Zone failsafe updated via API")
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) setZoneCirculate(w http.ResponseWriter, r *http.Request, zoneID string) {
+	var req ZoneCirculateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.writeError(w, http.StatusBadRequest, "Invalid JSON payload")
+		return
+	}
+
+	zone, err := s.store.GetZoneByID(zoneID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) || strings.Contains(err.Error(), "no rows in result set") {
+			s.writeError(w, http.StatusNotFound, "Zone not found")
+		} else {
+			s.writeError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	circulate := model.Circulation{MinutesPerHour: req.MinutesPerHour, BetweenCalls: req.BetweenCalls}
+	if errs := s.config.Load().CheckCirculation(*zone, circulate); len(errs) > 0 {
+		s.writeError(w, http.StatusBadRequest, "Invalid circulation: "+strings.Join(errs.Messages(), "; "))
+		return
+	}
+
+	if err := s.store.UpdateZoneCirculate(zoneID, circulate, auditFor(r, req.Reason)); err != nil {
+		log.Error().Err(err).Str("zone_id", zoneID).Msg("Failed to update zone circulation")
+		s.writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	log.Info().Str("zone_id", zoneID).Int("minutes_per_hour", req.MinutesPerHour).Bool("between_calls", req.BetweenCalls).Msg("Zone circulation updated via API")
+	w.WriteHeader(http.StatusOK)
+}
+
+// failsafeResponse resolves a zone's failsafe settings against the current config.
+func (s *Server) failsafeResponse(zone model.Zone) FailsafeResponse {
+	cfg := s.config.Load()
+	minTemp, maxTemp := zone.FailsafeLimits(cfg.SystemOverrideMinTemp, cfg.SystemOverrideMaxTemp)
+	return FailsafeResponse{Enabled: zone.FailsafeActive(), MinTemp: minTemp, MaxTemp: maxTemp}
+}
+
+func (s *Server) handleConfigReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if s.reloader == nil {
+		s.writeError(w, http.StatusServiceUnavailable, "Config reload not available")
+		return
+	}
+
+	if err := s.reloader.Reload(); err != nil {
+		var invalid *reload.InvalidConfigError
+		var restart *reload.RestartRequiredError
+		switch {
+		case errors.As(err, &invalid):
+			s.writeError(w, http.StatusUnprocessableEntity, err.Error())
+		case errors.As(err, &restart):
+			s.writeError(w, http.StatusConflict, err.Error())
+		default:
+			log.Error().Err(err).Msg("Failed to reload config")
+			s.writeError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	log.Info().Msg("Config reloaded via API")
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleSensors serves GET /api/sensors and GET /api/sensors/{id}. Configured sensors without a
+// reading are listed with quality missing.
+func (s *Server) handleSensors(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	sensorID := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/sensors"), "/")
+	if sensorID == "" {
+		sensors, err := s.store.GetAllSensors()
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to get sensors")
+			s.writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		response := []SensorResponse{}
+		for _, sensor := range sensors {
+			response = append(response, s.sensorResponse(sensor))
+		}
+		s.writeJSON(w, http.StatusOK, response)
+		return
+	}
+	if strings.Contains(sensorID, "/") {
+		s.writeError(w, http.StatusNotFound, "Invalid path")
+		return
+	}
+
+	sensor, err := s.store.GetSensorByID(sensorID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) || strings.Contains(err.Error(), "no rows in result set") {
+			s.writeError(w, http.StatusNotFound, "Sensor not found")
+		} else {
+			log.Error().Err(err).Str("sensor_id", sensorID).Msg("Failed to get sensor")
+			s.writeError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+	s.writeJSON(w, http.StatusOK, s.sensorResponse(*sensor))
+}
+
+// handleAirHandlers serves GET /api/air-handlers, and POST (start) and DELETE (cancel) on
+// /api/air-handlers/{name}/recirculation.
+func (s *Server) handleAirHandlers(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/air-handlers"), "/")
+	if path == "" {
+		if r.Method != http.MethodGet {
+			s.writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		s.getAirHandlers(w)
+		return
+	}
+
+	parts := strings.Split(path, "/")
+	if len(parts) != 2 || parts[1] != "recirculation" {
+		s.writeError(w, http.StatusNotFound, "Invalid path")
+		return
+	}
+	switch r.Method {
+	case http.MethodPost:
+		s.startRecirculation(w, r, parts[0])
+	case http.MethodDelete:
+		s.cancelRecirculation(w, r, parts[0])
+	default:
+		s.writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+func (s *Server) getAirHandlers(w http.ResponseWriter) {
+	handlers, err := s.store.GetAirHandlers()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get air handlers")
+		s.writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	response := []AirHandlerResponse{}
+	for _, ah := range handlers {
+		resp := AirHandlerResponse{Name: ah.Name, Zone: ah.Zone.ID, Recirculation: ah.Recirculation}
+		if ah.RecirculationRun.Active() {
+			run := ah.RecirculationRun
+			resp.Run = &run
+		}
+		response = append(response, resp)
+	}
+	s.writeJSON(w, http.StatusOK, response)
+}
+
+// airHandler looks up an air handler by name, writing a 404 or 500 and returning nil if it can't.
+func (s *Server) airHandler(w http.ResponseWriter, name string) *model.AirHandler {
+	handlers, err := s.store.GetAirHandlers()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get air handlers")
+		s.writeError(w, http.StatusInternalServerError, err.Error())
+		return nil
+	}
+	for i := range handlers {
+		if handlers[i].Name == name {
+			return &handlers[i]
+		}
+	}
+	s.writeError(w, http.StatusNotFound, "Air handler not found")
+	return nil
+}
+
+func (s *Server) startRecirculation(w http.ResponseWriter, r *http.Request, name string) {
+	var req RecirculationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		s.writeError(w, http.StatusBadRequest, "Invalid JSON payload")
+		return
+	}
+	if req.DurationMinutes < 0 || req.DurationMinutes > maxRecirculationMinutes {
+		s.writeError(w, http.StatusBadRequest, fmt.Sprintf("duration_minutes must be between 0 and %d", maxRecirculationMinutes))
+		return
+	}
+
+	handler := s.airHandler(w, name)
+	if handler == nil {
+		return
+	}
+	if !handler.Online {
+		s.writeError(w, http.StatusConflict, fmt.Sprintf("Air handler %s is offline", name))
+		return
+	}
+	duration := handler.Recirculation.Duration()
+	if req.DurationMinutes > 0 {
+		duration = time.Duration(req.DurationMinutes) * time.Minute
+	}
+
+	if err := s.store.StartRecirculation(name, time.Now().Add(duration), auditFor(r, req.Reason)); err != nil {
+		log.Error().Err(err).Str("device", name).Msg("Failed to start recirculation")
+		s.writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	log.Info().Str("device", name).Dur("duration", duration).Msg("Recirculation started via API")
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) cancelRecirculation(w http.ResponseWriter, r *http.Request, name string) {
+	var req RecirculationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		s.writeError(w, http.StatusBadRequest, "Invalid JSON payload")
+		return
+	}
+	if s.airHandler(w, name) == nil {
+		return
+	}
+
+	cancelled, err := s.store.CancelRecirculation(name, auditFor(r, req.Reason))
+	if err != nil {
+		log.Error().Err(err).Str("device", name).Msg("Failed to cancel recirculation")
+		s.writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !cancelled {
+		s.writeError(w, http.StatusConflict, "No recirculation run in progress")
+		return
+	}
+
+	log.Info().Str("device", name).Msg("Recirculation cancelled via API")
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) sensorResponse(sensor model.Sensor) SensorResponse {
+	reading, ok := s.tempService.GetReading(sensor.ID)
+	response := SensorResponse{
+		ID:            sensor.ID,
+		Type:          sensor.Driver(),
+		Temperature:   reading.Temperature,
+		AgeSeconds:    reading.Age.Seconds(),
+		Quality:       string(reading.Quality),
+		AnomalyCount:  reading.AnomalyCount,
+		RecoveryCount: reading.RecoveryCount,
+		ReadFailures:  reading.ReadFailures,
+	}
+	if ok {
+		response.Timestamp = &reading.Timestamp
+	}
+	if humidity, ok := s.tempService.GetHumidity(sensor.ID); ok {
+		response.Humidity = &humidity.Humidity
+	}
+	return response
+}
+
+func (s *Server) handleAudit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	query := r.URL.Query()
+	limit, err := queryInt(query.Get("limit"), defaultAuditLimit)
+	if err != nil || limit < 1 || limit > maxAuditLimit {
+		s.writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid limit. Must be between 1 and %d", maxAuditLimit))
+		return
+	}
+	offset, err := queryInt(query.Get("offset"), 0)
+	if err != nil || offset < 0 {
+		s.writeError(w, http.StatusBadRequest, "Invalid offset. Must be 0 or more")
+		return
+	}
+	filter := db.AuditFilter{
+		Actor:  query.Get("actor"),
+		Action: query.Get("action"),
+		Target: query.Get("target"),
+	}
+
+	entries, total, err := s.store.GetAuditLog(filter, limit, offset)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get audit log")
+		s.writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response := AuditLogResponse{Entries: []AuditEntryResponse{}, Total: total, Limit: limit, Offset: offset}
+	for _, e := range entries {
+		response.Entries = append(response.Entries, AuditEntryResponse{
+			ID:       e.ID,
+			Time:     e.CreatedAt,
+			Actor:    e.Actor,
+			Action:   e.Action,
+			Target:   e.Target,
+			OldValue: e.OldValue,
+			NewValue: e.NewValue,
+			Reason:   e.Reason,
+		})
+	}
+	s.writeJSON(w, http.StatusOK, response)
+}
+
+// auditFor attributes a change to the API client that requested it.
+func auditFor(r *http.Request, reason string) db.Audit {
+	client := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		client = host
+	}
+	return db.Audit{Actor: "api:" + client, Reason: reason}
+}
+
+func queryInt(value string, fallback int) (int, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	return strconv.Atoi(value)
+}
+
 func (s *Server) writeJSON(w http.ResponseWriter, statusCode int, data interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(statusCode)
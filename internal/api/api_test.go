@@ -8,13 +8,16 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
 	"github.com/thatsimonsguy/hvac-controller/db"
 	"github.com/thatsimonsguy/hvac-controller/internal/config"
+	"github.com/thatsimonsguy/hvac-controller/internal/env"
 	"github.com/thatsimonsguy/hvac-controller/internal/model"
+	"github.com/thatsimonsguy/hvac-controller/internal/reload"
 	"github.com/thatsimonsguy/hvac-controller/internal/temperature"
 )
 
@@ -22,55 +25,10 @@ func setupTestDB(t *testing.T) *sql.DB {
 	// Create in-memory SQLite database for testing
 	database, err := sql.Open("sqlite3", ":memory:")
 	require.NoError(t, err)
+	database.SetMaxOpenConns(1)
 
 	// Apply schema
-	schemaSQL := `
-		CREATE TABLE system (
-			id INTEGER PRIMARY KEY,
-			system_mode TEXT NOT NULL,
-			main_power_pin_number INTEGER NOT NULL,
-			main_power_pin_active_high BOOLEAN NOT NULL,
-			temp_sensor_bus_pin INTEGER,
-			override_active BOOLEAN DEFAULT FALSE,
-			prior_system_mode TEXT DEFAULT NULL
-		);
-
-		CREATE TABLE zones (
-			id TEXT PRIMARY KEY,
-			label TEXT NOT NULL,
-			setpoint REAL NOT NULL,
-			mode TEXT NOT NULL,
-			capabilities TEXT NOT NULL,
-			sensor_id TEXT NOT NULL
-		);
-
-		CREATE TABLE sensors (
-			id TEXT PRIMARY KEY,
-			bus TEXT NOT NULL
-		);
-
-		CREATE TABLE devices (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			name TEXT NOT NULL,
-			pin_number INTEGER NOT NULL,
-			pin_active_high BOOLEAN NOT NULL,
-			min_on INTEGER NOT NULL,
-			min_off INTEGER NOT NULL,
-			online BOOLEAN NOT NULL,
-			last_changed TEXT,
-			active_modes TEXT NOT NULL,
-			device_type TEXT NOT NULL,
-			role TEXT,
-			zone_id TEXT,
-			mode_pin_number INTEGER,
-			mode_pin_active_high BOOLEAN,
-			is_primary BOOLEAN DEFAULT FALSE,
-			last_rotated TEXT,
-			circ_pump_pin_number INTEGER,
-			circ_pump_pin_active_high BOOLEAN
-		);
-	`
-	_, err = database.Exec(schemaSQL)
+	_, err = db.Migrate(database)
 	require.NoError(t, err)
 
 	// Seed test data
@@ -92,6 +50,13 @@ func setupTestDB(t *testing.T) *sql.DB {
 		VALUES ('zone2', 'Bedroom', 68.0, 'heating', '["heating"]', 'test_sensor_2')`)
 	require.NoError(t, err)
 
+	// The distribution devices that give each zone its capabilities
+	_, err = database.Exec(`INSERT INTO devices (name, pin_number, pin_active_high, min_on, min_off, online, last_changed, active_modes, device_type, role, zone_id)
+		VALUES ('zone1_loop', 16, 1, 300, 180, 1, ?, '["heating","cooling"]', 'radiant_floor', 'distributor', 'zone1'),
+		       ('zone2_loop', 17, 1, 300, 180, 1, ?, '["heating"]', 'radiant_floor', 'distributor', 'zone2')`,
+		time.Now().Format(time.RFC3339), time.Now().Format(time.RFC3339))
+	require.NoError(t, err)
+
 	return database
 }
 
@@ -103,10 +68,14 @@ func setupTestServer(t *testing.T) (*Server, *sql.DB) {
 		PollIntervalSeconds: 30,
 		ZoneMinTemp:         50.0,
 		ZoneMaxTemp:         95.0,
+		SystemOverrideMinTemp: 45.0,
+		SystemOverrideMaxTemp: 90.0,
 	}
-	tempService := temperature.NewService(database, cfg.PollIntervalSeconds)
+	env.SetCfg(cfg)
+	repo := db.New(database)
+	tempService := temperature.NewService(repo, cfg.PollIntervalSeconds)
 	
-	server := NewServer(database, tempService, cfg)
+	server := NewServer(repo, tempService, cfg)
 	return server, database
 }
 
@@ -161,7 +130,7 @@ func TestSetSystemMode(t *testing.T) {
 
 			if tt.expectedStatus == http.StatusOK {
 				// Verify the mode was actually updated in the database
-				actualMode, err := db.GetSystemMode(database)
+				actualMode, err := db.New(database).GetSystemMode()
 				require.NoError(t, err)
 				assert.Equal(t, model.SystemMode(tt.expectedMode), actualMode)
 			}
@@ -265,7 +234,9 @@ func TestSetZoneMode(t *testing.T) {
 		expectedStatus int
 	}{
 		{"valid mode for existing zone", "zone1", "heating", http.StatusOK},
+		{"off is always allowed", "zone2", "off", http.StatusOK},
 		{"invalid mode", "zone1", "invalid", http.StatusBadRequest},
+		{"capability not listed", "zone2", "cooling", http.StatusBadRequest},
 		{"nonexistent zone", "nonexistent", "heating", http.StatusNotFound},
 	}
 
@@ -284,7 +255,7 @@ func TestSetZoneMode(t *testing.T) {
 
 			if tt.expectedStatus == http.StatusOK {
 				// Verify the mode was actually updated in the database
-				zone, err := db.GetZoneByID(database, tt.zoneID)
+				zone, err := db.New(database).GetZoneByID(tt.zoneID)
 				require.NoError(t, err)
 				assert.Equal(t, model.SystemMode(tt.mode), zone.Mode)
 			}
@@ -292,6 +263,47 @@ func TestSetZoneMode(t *testing.T) {
 	}
 }
 
+func TestZoneCapabilities(t *testing.T) {
+	server, database := setupTestServer(t)
+	defer database.Close()
+
+	// zone2 lists cooling, but its only device is a heating-only loop
+	_, err := database.Exec(`UPDATE zones SET capabilities = '["heating","cooling"]' WHERE id = 'zone2'`)
+	require.NoError(t, err)
+
+	send := func(method, path string, body interface{}) *httptest.ResponseRecorder {
+		reqJSON, _ := json.Marshal(body)
+		req := httptest.NewRequest(method, path, bytes.NewBuffer(reqJSON))
+		w := httptest.NewRecorder()
+		server.handleZoneOperations(w, req)
+		return w
+	}
+
+	w := send(http.MethodPut, "/api/zones/zone2/mode", ZoneModeRequest{Mode: "cooling"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), `zone zone2 lists \"cooling\" but no attached device provides it`)
+
+	w = send(http.MethodPut, "/api/zones/zone1/mode", ZoneModeRequest{Mode: "circulate"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), `zone zone1 doesn't list the \"fan\" capability and no attached device provides it`)
+
+	assert.Equal(t, http.StatusNotFound, send(http.MethodGet, "/api/zones/nonexistent/capabilities", nil).Code)
+	assert.Equal(t, http.StatusMethodNotAllowed, send(http.MethodPut, "/api/zones/zone2/capabilities", nil).Code)
+
+	w = send(http.MethodGet, "/api/zones/zone2/capabilities", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	var response ZoneCapabilitiesResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "zone2", response.Zone)
+	assert.Equal(t, []model.ZoneCapability{
+		{Capability: "heating", Listed: true, Devices: []string{"zone2_loop"}},
+		{Capability: "cooling", Listed: true, Devices: []string{}},
+		{Capability: "fan", Listed: false, Devices: []string{}},
+		{Capability: "dehumidify", Listed: false, Devices: []string{}},
+	}, response.Capabilities)
+	assert.Equal(t, []model.SystemMode{model.ModeOff, model.ModeHeating}, response.SupportedModes)
+}
+
 func TestSetZoneSetpoint(t *testing.T) {
 	server, database := setupTestServer(t)
 	defer database.Close()
@@ -323,7 +335,7 @@ func TestSetZoneSetpoint(t *testing.T) {
 
 			if tt.expectedStatus == http.StatusOK {
 				// Verify the setpoint was actually updated in the database
-				zone, err := db.GetZoneByID(database, tt.zoneID)
+				zone, err := db.New(database).GetZoneByID(tt.zoneID)
 				require.NoError(t, err)
 				assert.Equal(t, tt.setpoint, zone.Setpoint)
 			}
@@ -410,4 +422,505 @@ func TestIsValidSystemMode(t *testing.T) {
 			assert.Equal(t, tt.valid, isValidSystemMode(tt.mode))
 		})
 	}
-}
\ No newline at end of file
+}
+
+type mockReloader struct {
+	err   error
+	calls int
+}
+
+func (m *mockReloader) Reload() error {
+	m.calls++
+	return m.err
+}
+
+func TestConfigReload(t *testing.T) {
+	tests := []struct {
+		name           string
+		method         string
+		reloader       *mockReloader
+		expectedStatus int
+	}{
+		{"successful reload", http.MethodPost, &mockReloader{}, http.StatusOK},
+		{"invalid config", http.MethodPost, &mockReloader{err: &reload.InvalidConfigError{Err: fmt.Errorf("bad")}}, http.StatusUnprocessableEntity},
+		{"restart required", http.MethodPost, &mockReloader{err: &reload.RestartRequiredError{Reasons: []string{"hp_a.pin moved (23 -> 24)"}}}, http.StatusConflict},
+		{"unexpected failure", http.MethodPost, &mockReloader{err: fmt.Errorf("disk on fire")}, http.StatusInternalServerError},
+		{"no reloader configured", http.MethodPost, nil, http.StatusServiceUnavailable},
+		{"wrong method", http.MethodGet, &mockReloader{}, http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, database := setupTestServer(t)
+			defer database.Close()
+			if tt.reloader != nil {
+				server.SetReloader(tt.reloader)
+			}
+
+			req := httptest.NewRequest(tt.method, "/api/config/reload", nil)
+			w := httptest.NewRecorder()
+
+			server.handleConfigReload(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+		})
+	}
+}
+
+func TestUpdateConfigChangesSetpointLimits(t *testing.T) {
+	server, database := setupTestServer(t)
+	defer database.Close()
+
+	server.UpdateConfig(&config.Config{ZoneMinTemp: 50.0, ZoneMaxTemp: 72.0})
+
+	reqJSON, _ := json.Marshal(ZoneSetpointRequest{Setpoint: 74.5})
+	req := httptest.NewRequest(http.MethodPut, "/api/zones/zone1/setpoint", bytes.NewBuffer(reqJSON))
+	w := httptest.NewRecorder()
+
+	server.handleZoneOperations(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestModeConflicts(t *testing.T) {
+	server, database := setupTestServer(t)
+	defer database.Close()
+
+	put := func(handler http.HandlerFunc, path string, body interface{}) *httptest.ResponseRecorder {
+		reqJSON, _ := json.Marshal(body)
+		req := httptest.NewRequest(http.MethodPut, path, bytes.NewBuffer(reqJSON))
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+	conflict := func(zoneID string) bool {
+		req := httptest.NewRequest(http.MethodGet, "/api/zones/"+zoneID, nil)
+		w := httptest.NewRecorder()
+		server.handleZoneOperations(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+		var zone ZoneResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &zone))
+		return zone.Conflict
+	}
+
+	// By default conflicting changes go through and the heating zone is reported idle
+	assert.Equal(t, http.StatusOK, put(server.handleSystemMode, "/api/system/mode", SystemModeRequest{Mode: "cooling"}).Code)
+	assert.True(t, conflict("zone2"))
+	assert.False(t, conflict("zone1"))
+
+	server.UpdateConfig(&config.Config{ZoneMinTemp: 50.0, ZoneMaxTemp: 95.0, ModeConflictPolicy: config.ModeConflictReject})
+
+	w := put(server.handleSystemMode, "/api/system/mode", SystemModeRequest{Mode: "heating"})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.False(t, conflict("zone2"))
+
+	w = put(server.handleZoneOperations, "/api/zones/zone1/mode", ZoneModeRequest{Mode: "cooling"})
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Contains(t, w.Body.String(), "Zone mode cooling conflicts with system mode heating")
+
+	w = put(server.handleZoneGroups, "/api/zone-groups/all/mode", ZoneGroupModeRequest{Mode: "cooling", OffIfUnsupported: true})
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	w = put(server.handleSystemMode, "/api/system/mode", SystemModeRequest{Mode: "cooling"})
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Contains(t, w.Body.String(), "System mode cooling conflicts with zones: zone2 (heating)")
+
+	mode, err := db.New(database).GetSystemMode()
+	require.NoError(t, err)
+	assert.Equal(t, model.ModeHeating, mode)
+}
+
+func TestSetZoneFailsafe(t *testing.T) {
+	server, database := setupTestServer(t)
+	defer database.Close()
+
+	enabled, disabled := true, false
+	freeze, tooHigh := 35.0, 95.0
+	tests := []struct {
+		name           string
+		zoneID         string
+		req            ZoneFailsafeRequest
+		expectedStatus int
+	}{
+		{"disable", "zone2", ZoneFailsafeRequest{Enabled: &disabled}, http.StatusOK},
+		{"own freeze limit", "zone1", ZoneFailsafeRequest{Enabled: &enabled, MinTemp: &freeze}, http.StatusOK},
+		{"enabled missing", "zone1", ZoneFailsafeRequest{MinTemp: &freeze}, http.StatusBadRequest},
+		{"min above system max", "zone1", ZoneFailsafeRequest{Enabled: &enabled, MinTemp: &tooHigh}, http.StatusBadRequest},
+		{"nonexistent zone", "nonexistent", ZoneFailsafeRequest{Enabled: &enabled}, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reqJSON, _ := json.Marshal(tt.req)
+			req := httptest.NewRequest(http.MethodPut, fmt.Sprintf("/api/zones/%s/failsafe", tt.zoneID), bytes.NewBuffer(reqJSON))
+			w := httptest.NewRecorder()
+
+			server.handleZoneOperations(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+		})
+	}
+
+	// Responses show the effective limits, falling back to the system-wide ones
+	req := httptest.NewRequest(http.MethodGet, "/api/zones", nil)
+	w := httptest.NewRecorder()
+	server.handleZones(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var zones []ZoneResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &zones))
+	require.Len(t, zones, 2)
+	assert.Equal(t, FailsafeResponse{Enabled: true, MinTemp: 35.0, MaxTemp: 90.0}, zones[0].Failsafe)
+	assert.Equal(t, FailsafeResponse{Enabled: false, MinTemp: 45.0, MaxTemp: 90.0}, zones[1].Failsafe)
+
+	entries, _, err := db.New(database).GetAuditLog(db.AuditFilter{Action: db.AuditZoneFailsafe, Target: "zone1"}, 10, 0)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "enabled=true min=default max=default", entries[0].OldValue)
+	assert.Equal(t, "enabled=true min=35 max=default", entries[0].NewValue)
+}
+
+func TestSetZoneCirculate(t *testing.T) {
+	server, database := setupTestServer(t)
+	defer database.Close()
+
+	_, err := database.Exec(`UPDATE zones SET capabilities = '["heating","cooling","fan"]' WHERE id = 'zone1'`)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name           string
+		zoneID         string
+		req            ZoneCirculateRequest
+		expectedStatus int
+	}{
+		{"no fan capability", "zone2", ZoneCirculateRequest{MinutesPerHour: 20}, http.StatusBadRequest},
+		{"over an hour", "zone1", ZoneCirculateRequest{MinutesPerHour: 90}, http.StatusBadRequest},
+		{"duty between calls", "zone1", ZoneCirculateRequest{MinutesPerHour: 20, BetweenCalls: true}, http.StatusOK},
+		{"nonexistent zone", "nonexistent", ZoneCirculateRequest{MinutesPerHour: 20}, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reqJSON, _ := json.Marshal(tt.req)
+			req := httptest.NewRequest(http.MethodPut, fmt.Sprintf("/api/zones/%s/circulate", tt.zoneID), bytes.NewBuffer(reqJSON))
+			w := httptest.NewRecorder()
+
+			server.handleZoneOperations(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+		})
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/zones/zone1", nil)
+	w := httptest.NewRecorder()
+	server.handleZoneOperations(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var zone ZoneResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &zone))
+	assert.Equal(t, model.Circulation{MinutesPerHour: 20, BetweenCalls: true}, zone.Circulate)
+
+	entries, _, err := db.New(database).GetAuditLog(db.AuditFilter{Action: db.AuditZoneCirculate, Target: "zone1"}, 10, 0)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "continuous between_calls=false", entries[0].OldValue)
+	assert.Equal(t, "20 min/h between_calls=true", entries[0].NewValue)
+}
+
+func TestZoneGroups(t *testing.T) {
+	server, database := setupTestServer(t)
+	defer database.Close()
+
+	_, err := database.Exec(`INSERT INTO zone_groups (id, label) VALUES ('bedrooms', 'Bedrooms')`)
+	require.NoError(t, err)
+	_, err = database.Exec(`INSERT INTO zone_group_members (group_id, zone_id) VALUES ('bedrooms', 'zone2')`)
+	require.NoError(t, err)
+
+	send := func(method, path string, body interface{}) *httptest.ResponseRecorder {
+		reqJSON, _ := json.Marshal(body)
+		req := httptest.NewRequest(method, path, bytes.NewBuffer(reqJSON))
+		w := httptest.NewRecorder()
+		server.handleZoneGroups(w, req)
+		return w
+	}
+
+	w := send(http.MethodGet, "/api/zone-groups", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	var groups []model.ZoneGroup
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &groups))
+	assert.Equal(t, []model.ZoneGroup{
+		{ID: "all", Label: "All zones", Zones: []string{"zone1", "zone2"}},
+		{ID: "bedrooms", Label: "Bedrooms", Zones: []string{"zone2"}},
+	}, groups)
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		body           interface{}
+		expectedStatus int
+	}{
+		{"unknown group", http.MethodGet, "/api/zone-groups/attic", nil, http.StatusNotFound},
+		{"invalid mode", http.MethodPut, "/api/zone-groups/all/mode", ZoneGroupModeRequest{Mode: "invalid"}, http.StatusBadRequest},
+		{"zone without cooling", http.MethodPut, "/api/zone-groups/all/mode", ZoneGroupModeRequest{Mode: "cooling"}, http.StatusBadRequest},
+		{"setpoint out of range", http.MethodPut, "/api/zone-groups/all/setpoint", ZoneSetpointRequest{Setpoint: 100}, http.StatusBadRequest},
+		{"setpoint unknown group", http.MethodPut, "/api/zone-groups/attic/setpoint", ZoneSetpointRequest{Setpoint: 65}, http.StatusNotFound},
+		{"group setpoint", http.MethodPut, "/api/zone-groups/bedrooms/setpoint", ZoneSetpointRequest{Setpoint: 65}, http.StatusOK},
+		{"unknown operation", http.MethodPut, "/api/zone-groups/all/failsafe", nil, http.StatusNotFound},
+		{"method not allowed", http.MethodPost, "/api/zone-groups/all/mode", nil, http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expectedStatus, send(tt.method, tt.path, tt.body).Code)
+		})
+	}
+
+	// The garage-style zone without cooling is turned off instead of failing the change
+	w = send(http.MethodPut, "/api/zone-groups/all/mode", ZoneGroupModeRequest{Mode: "cooling", OffIfUnsupported: true, Reason: "summer"})
+	require.Equal(t, http.StatusOK, w.Code)
+	var response ZoneGroupModeResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, map[string]model.SystemMode{"zone1": model.ModeCooling, "zone2": model.ModeOff}, response.Modes)
+
+	repo := db.New(database)
+	zones, err := repo.GetAllZones()
+	require.NoError(t, err)
+	assert.Equal(t, model.ModeCooling, zones[0].Mode)
+	assert.Equal(t, model.ModeOff, zones[1].Mode)
+	assert.Equal(t, 72.0, zones[0].Setpoint)
+	assert.Equal(t, 65.0, zones[1].Setpoint)
+
+	entries, _, err := repo.GetAuditLog(db.AuditFilter{Action: db.AuditZoneMode}, 10, 0)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "summer", entries[0].Reason)
+}
+
+func TestGetZoneSensorDegraded(t *testing.T) {
+	server, database := setupTestServer(t)
+	defer database.Close()
+
+	since := time.Date(2025, 1, 10, 6, 0, 0, 0, time.UTC)
+	require.NoError(t, db.New(database).SetZoneSensorDegraded("zone2", &since))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/zones/zone2", nil)
+	w := httptest.NewRecorder()
+	server.handleZoneOperations(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var zone ZoneResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &zone))
+	assert.True(t, zone.SensorDegraded)
+	require.NotNil(t, zone.SensorDegradedSince)
+	assert.True(t, since.Equal(*zone.SensorDegradedSince))
+
+	require.NoError(t, db.New(database).SetZoneSensorDegraded("zone2", nil))
+	w = httptest.NewRecorder()
+	server.handleZoneOperations(w, req)
+	var healthy ZoneResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &healthy))
+	assert.False(t, healthy.SensorDegraded)
+	assert.NotContains(t, w.Body.String(), "sensor_degraded_since")
+}
+
+func TestGetZoneShed(t *testing.T) {
+	server, database := setupTestServer(t)
+	defer database.Close()
+
+	since := time.Date(2025, 1, 10, 6, 0, 0, 0, time.UTC)
+	require.NoError(t, db.New(database).SetZoneShed("zone2", &since))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/zones/zone2", nil)
+	w := httptest.NewRecorder()
+	server.handleZoneOperations(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var zone ZoneResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &zone))
+	assert.Equal(t, 1.0, zone.Priority)
+	assert.True(t, zone.Shed)
+	require.NotNil(t, zone.ShedSince)
+	assert.True(t, since.Equal(*zone.ShedSince))
+
+	require.NoError(t, db.New(database).SetZoneShed("zone2", nil))
+	w = httptest.NewRecorder()
+	server.handleZoneOperations(w, req)
+	var restored ZoneResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &restored))
+	assert.False(t, restored.Shed)
+	assert.NotContains(t, w.Body.String(), "shed_since")
+}
+
+func TestGetSensors(t *testing.T) {
+	server, database := setupTestServer(t)
+	defer database.Close()
+
+	req := httptest.NewRequest(http.MethodGet, "/api/sensors", nil)
+	w := httptest.NewRecorder()
+	server.handleSensors(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	// Nothing has been read yet, so every configured sensor is missing
+	var sensors []SensorResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &sensors))
+	require.Len(t, sensors, 2)
+	for _, sensor := range sensors {
+		assert.Equal(t, "missing", sensor.Quality)
+		assert.Nil(t, sensor.Timestamp)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/sensors/test_sensor_1", nil)
+	w = httptest.NewRecorder()
+	server.handleSensors(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	var sensor SensorResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &sensor))
+	assert.Equal(t, "test_sensor_1", sensor.ID)
+	assert.Equal(t, "w1", sensor.Type)
+
+	req = httptest.NewRequest(http.MethodGet, "/api/sensors/nope", nil)
+	w = httptest.NewRecorder()
+	server.handleSensors(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	req = httptest.NewRequest(http.MethodPut, "/api/sensors", nil)
+	w = httptest.NewRecorder()
+	server.handleSensors(w, req)
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+
+	// Zones report the quality of their sensor's reading
+	req = httptest.NewRequest(http.MethodGet, "/api/zones/zone1", nil)
+	w = httptest.NewRecorder()
+	server.handleZoneOperations(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	var zone ZoneResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &zone))
+	assert.Equal(t, "missing", zone.ReadingQuality)
+}
+
+func TestAuditLog(t *testing.T) {
+	server, database := setupTestServer(t)
+	defer database.Close()
+
+	reqJSON, _ := json.Marshal(ZoneModeRequest{Mode: "heating", Reason: "cold snap"})
+	req := httptest.NewRequest(http.MethodPut, "/api/zones/zone1/mode", bytes.NewBuffer(reqJSON))
+	w := httptest.NewRecorder()
+	server.handleZoneOperations(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	reqJSON, _ = json.Marshal(ZoneSetpointRequest{Setpoint: 70})
+	req = httptest.NewRequest(http.MethodPut, "/api/zones/zone1/setpoint", bytes.NewBuffer(reqJSON))
+	w = httptest.NewRecorder()
+	server.handleZoneOperations(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	// Newest first, one per page
+	req = httptest.NewRequest(http.MethodGet, "/api/audit?limit=1", nil)
+	w = httptest.NewRecorder()
+	server.handleAudit(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var page AuditLogResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+	assert.Equal(t, 2, page.Total)
+	require.Len(t, page.Entries, 1)
+	assert.Equal(t, "zone_setpoint", page.Entries[0].Action)
+	assert.Equal(t, "72", page.Entries[0].OldValue)
+	assert.Equal(t, "70", page.Entries[0].NewValue)
+
+	req = httptest.NewRequest(http.MethodGet, "/api/audit?limit=1&offset=1", nil)
+	w = httptest.NewRecorder()
+	server.handleAudit(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+	require.Len(t, page.Entries, 1)
+	entry := page.Entries[0]
+	assert.Equal(t, "zone_mode", entry.Action)
+	assert.Equal(t, "zone1", entry.Target)
+	assert.Equal(t, "off", entry.OldValue)
+	assert.Equal(t, "heating", entry.NewValue)
+	assert.Equal(t, "cold snap", entry.Reason)
+	assert.Equal(t, "api:192.0.2.1", entry.Actor) // httptest's default client address
+
+	for _, query := range []string{"limit=0", "limit=abc", "limit=501", "offset=-1"} {
+		req = httptest.NewRequest(http.MethodGet, "/api/audit?"+query, nil)
+		w = httptest.NewRecorder()
+		server.handleAudit(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code, query)
+	}
+}
+
+func TestRecirculation(t *testing.T) {
+	server, database := setupTestServer(t)
+	defer database.Close()
+
+	_, err := database.Exec(`INSERT INTO devices (name, pin_number, pin_active_high, min_on, min_off, online, last_changed, active_modes, device_type, role, zone_id, circ_pump_pin_number, circ_pump_pin_active_high, recirculation_policy)
+		VALUES ('zone1_ah', 5, 1, 180, 60, 1, ?, '["heating"]', 'air_handler', 'distributor', 'zone1', 6, 1, '{"duration_minutes":20}')`, time.Now().Format(time.RFC3339))
+	require.NoError(t, err)
+
+	send := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		w := httptest.NewRecorder()
+		server.handleAirHandlers(w, req)
+		return w
+	}
+	handlers := func() []AirHandlerResponse {
+		w := send(http.MethodGet, "/api/air-handlers", "")
+		require.Equal(t, http.StatusOK, w.Code)
+		var response []AirHandlerResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		return response
+	}
+
+	response := handlers()
+	require.Len(t, response, 1)
+	assert.Equal(t, "zone1", response[0].Zone)
+	assert.Equal(t, 20, response[0].Recirculation.DurationMinutes)
+	assert.Nil(t, response[0].Run)
+
+	assert.Equal(t, http.StatusConflict, send(http.MethodDelete, "/api/air-handlers/zone1_ah/recirculation", "").Code)
+
+	// Without a duration the run lasts the policy's duration
+	assert.Equal(t, http.StatusOK, send(http.MethodPost, "/api/air-handlers/zone1_ah/recirculation", "").Code)
+	run := handlers()[0].Run
+	require.NotNil(t, run)
+	assert.True(t, run.Manual)
+	assert.WithinDuration(t, time.Now().Add(20*time.Minute), run.EndsAt, 2*time.Second)
+
+	assert.Equal(t, http.StatusOK, send(http.MethodPost, "/api/air-handlers/zone1_ah/recirculation", `{"duration_minutes": 90, "reason": "painting"}`).Code)
+	assert.WithinDuration(t, time.Now().Add(90*time.Minute), handlers()[0].Run.EndsAt, 2*time.Second)
+
+	assert.Equal(t, http.StatusOK, send(http.MethodDelete, "/api/air-handlers/zone1_ah/recirculation", `{"reason": "done"}`).Code)
+	assert.WithinDuration(t, time.Now(), handlers()[0].Run.EndsAt, 2*time.Second)
+
+	// Cancelling again is a conflict and isn't audited
+	assert.Equal(t, http.StatusConflict, send(http.MethodDelete, "/api/air-handlers/zone1_ah/recirculation", "").Code)
+
+	for _, tc := range []struct {
+		method, path, body string
+		status             int
+	}{
+		{http.MethodPost, "/api/air-handlers/zone1_ah/recirculation", `{"duration_minutes": -5}`, http.StatusBadRequest},
+		{http.MethodPost, "/api/air-handlers/zone1_ah/recirculation", `{"duration_minutes": 2000}`, http.StatusBadRequest},
+		{http.MethodPost, "/api/air-handlers/zone1_ah/recirculation", `not json`, http.StatusBadRequest},
+		{http.MethodPost, "/api/air-handlers/missing/recirculation", "", http.StatusNotFound},
+		{http.MethodPost, "/api/air-handlers/zone1_ah", "", http.StatusNotFound},
+		{http.MethodPut, "/api/air-handlers/zone1_ah/recirculation", "", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/api/air-handlers", "", http.StatusMethodNotAllowed},
+	} {
+		assert.Equal(t, tc.status, send(tc.method, tc.path, tc.body).Code, "%s %s %s", tc.method, tc.path, tc.body)
+	}
+
+	entries, total, err := db.New(database).GetAuditLog(db.AuditFilter{Target: "zone1_ah"}, 10, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 3, total)
+	assert.Equal(t, db.AuditRecirculationCancel, entries[0].Action)
+	assert.Equal(t, "done", entries[0].Reason)
+	assert.Equal(t, "painting", entries[1].Reason)
+
+	// An offline handler can't be started
+	_, err = database.Exec(`UPDATE devices SET online = 0 WHERE name = 'zone1_ah'`)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusConflict, send(http.MethodPost, "/api/air-handlers/zone1_ah/recirculation", "").Code)
+}
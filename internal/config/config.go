@@ -5,6 +5,7 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/rs/zerolog"
 	"github.com/thatsimonsguy/hvac-controller/internal/model"
@@ -42,6 +43,7 @@ type Config struct {
 	RelayBoardActiveHigh bool `json:"relay_board_active_high"`
 
 	Zones         []model.Zone            `json:"zones"`
+	ZoneGroups    []model.ZoneGroup       `json:"zone_groups,omitempty"`
 	DeviceConfig  DeviceConfig            `json:"devices"`
 	SystemSensors map[string]model.Sensor `json:"system_sensors"`
 
@@ -52,10 +54,54 @@ type Config struct {
 
 	NtfyTopic string `json:"ntfy_topic"`
 
+	MQTT MQTTConfig `json:"mqtt"` // broker for sensors of type mqtt
+
 	TempAnomalyMaxDelta    float64 `json:"temp_anomaly_max_delta"`
 	TempAnomalyGarageDelta float64 `json:"temp_anomaly_garage_delta"`
 	TempMaxAnomalies       int     `json:"temp_max_anomalies"`
 	TempHistorySize        int     `json:"temp_history_size"`
+	MaxReadingAgeSeconds   int     `json:"max_reading_age_seconds"` // controllers ignore older readings, 0 uses two poll intervals
+
+	SensorReadTimeoutSeconds int `json:"sensor_read_timeout_seconds"` // give up on a sensor read after this long, 0 uses 10 seconds
+	SensorReadConcurrency    int `json:"sensor_read_concurrency"`     // sensors read at once, 0 uses 4
+
+	FlowVerifyWindowSeconds int     `json:"flow_verify_window_seconds"` // how long a distribution device has to show a supply/return delta after activation
+	FlowVerifyMinDelta      float64 `json:"flow_verify_min_delta"`
+	FlowVerifyTakeOffline   bool    `json:"flow_verify_take_offline"` // mark the device offline when verification fails
+
+	BackupDir           string `json:"backup_dir"`
+	BackupIntervalHours int    `json:"backup_interval_hours"` // 0 disables periodic backups
+	BackupRetention     int    `json:"backup_retention"`      // number of backups kept in backup_dir
+
+	FailsafeStaleMinutes         int     `json:"failsafe_stale_minutes"`          // minutes without a valid zone reading before the zone is degraded, 0 disables
+	FailsafeFallbackOutdoorTemp  float64 `json:"failsafe_fallback_outdoor_temp"`  // degraded zones get fallback heat while outdoors is below this
+	FailsafeFallbackDutyCycle    float64 `json:"failsafe_fallback_duty_cycle"`    // fraction of each fallback cycle spent heating, 0 disables fallback heat
+	FailsafeFallbackCycleMinutes int     `json:"failsafe_fallback_cycle_minutes"` // length of one fallback heat on/off cycle
+
+	DehumidifyMaxOvercool float64 `json:"dehumidify_max_overcool"` // degrees below setpoint a zone may be cooled to bring humidity down
+	DewPointMargin        float64 `json:"dew_point_margin"`        // degrees the chilled buffer is kept above the indoor dew point, 0 disables dew point protection
+
+	PumpExerciseIntervalHours int `json:"pump_exercise_interval_hours"` // run circulator and radiant loop pumps idle this long, 0 disables pump exercise
+	PumpExerciseSeconds       int `json:"pump_exercise_seconds"`        // length of one exercise run
+
+	ModeConflictPolicy string `json:"mode_conflict_policy"` // idle (default) or reject zone and system modes that conflict, see ModeConflict*
+
+	DemandPrestageThreshold float64 `json:"demand_prestage_threshold"` // total weighted zone demand that starts the secondary heat pump ahead of the buffer, 0 disables
+	DemandShedPriority      float64 `json:"demand_shed_priority"`      // zones weighted below this are shed while heating capacity is limited, 0 disables shedding
+
+	DemandResetMaxRaise   float64 `json:"demand_reset_max_raise"`   // degrees the buffer heating target rises with zone demand, 0 disables
+	DemandResetFullDemand float64 `json:"demand_reset_full_demand"` // total weighted zone demand that raises the target the full demand_reset_max_raise
+	DemandResetMaxLower   float64 `json:"demand_reset_max_lower"`   // degrees the heating target falls while no zone is calling, 0 disables
+	DemandResetCoastHours int     `json:"demand_reset_coast_hours"` // hours without a call before the target is fully lowered and the tank coasts
+}
+
+// MQTTConfig is the broker remote sensors publish their readings to.
+type MQTTConfig struct {
+	Broker        string `json:"broker"`    // host:port or a URL such as ssl://host:8883, required when any sensor is type mqtt
+	ClientID      string `json:"client_id"` // empty uses hvac-controller
+	Username      string `json:"username"`
+	Password      string `json:"password"`
+	MaxAgeSeconds int    `json:"max_age_seconds"` // a sensor's last message counts as a failed read once older than this, 0 uses 300
 }
 
 // DeviceConfig and related structs
@@ -100,10 +146,14 @@ type HeatPumpConfig struct {
 }
 
 type AirHandlerConfig struct {
-	Name        string `json:"name"`
-	Pin         int    `json:"pin"`
-	CircPumpPin int    `json:"circ_pump_pin"`
-	Zone        string `json:"zone"`
+	Name         string        `json:"name"`
+	Pin          int           `json:"pin"`
+	CircPumpPin  int           `json:"circ_pump_pin"`
+	Zone         string        `json:"zone"`
+	SupplySensor *model.Sensor `json:"supply_sensor,omitempty"` // optional, used for flow verification
+	ReturnSensor *model.Sensor `json:"return_sensor,omitempty"`
+
+	Recirculation model.RecirculationPolicy `json:"recirculation,omitempty"`
 }
 
 type BoilerConfig struct {
@@ -112,13 +162,15 @@ type BoilerConfig struct {
 }
 
 type RadiantLoopConfig struct {
-	Name string `json:"name"`
-	Pin  int    `json:"pin"`
-	Zone string `json:"zone"`
+	Name         string        `json:"name"`
+	Pin          int           `json:"pin"`
+	Zone         string        `json:"zone"`
+	SupplySensor *model.Sensor `json:"supply_sensor,omitempty"` // optional, used for flow verification
+	ReturnSensor *model.Sensor `json:"return_sensor,omitempty"`
 }
 
-// Load parses config file and CLI flags
-func Load() *Config {
+// Load parses config file and CLI flags, and validates the result
+func Load() (*Config, error) {
 	var path string
 	var logLevel string
 	var safeMode bool
@@ -128,23 +180,69 @@ func Load() *Config {
 	flag.BoolVar(&safeMode, "safe-mode", false, "Run in dry mode without energizing GPIO")
 	flag.Parse()
 
+	cfg, err := LoadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.LogLevel = parseLogLevel(logLevel)
+	cfg.SafeMode = safeMode
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// LoadFile parses the config file at path without touching CLI flags or validating it.
+func LoadFile(path string) (*Config, error) {
 	f, err := os.Open(path)
 	if err != nil {
-		panic(fmt.Sprintf("Failed to open config file: %s", err))
+		return nil, fmt.Errorf("Failed to open config file: %s", err)
 	}
 	defer f.Close()
 
 	var cfg Config
 	if err := json.NewDecoder(f).Decode(&cfg); err != nil {
-		panic(fmt.Sprintf("Failed to parse config file: %s", err))
+		return nil, fmt.Errorf("Failed to parse config file: %s", err)
+	}
+
+	// A zone that only lists sensors takes the first as its primary
+	for i, z := range cfg.Zones {
+		if z.Sensor.ID == "" && len(z.Sensors) > 0 {
+			cfg.Zones[i].Sensor = z.Sensors[0].Sensor
+		}
 	}
 
 	cfg.ConfigFile = path
-	cfg.LogLevel = parseLogLevel(logLevel)
-	cfg.SafeMode = safeMode
+	return &cfg, nil
+}
+
+// MaxReadingAge is how old a temperature reading may be before controllers stop acting on it.
+func (cfg *Config) MaxReadingAge() time.Duration {
+	if cfg.MaxReadingAgeSeconds > 0 {
+		return time.Duration(cfg.MaxReadingAgeSeconds) * time.Second
+	}
+	return 2 * time.Duration(cfg.PollIntervalSeconds) * time.Second
+}
 
-	cfg.validate()
-	return &cfg
+// RejectsModeConflicts reports whether the API refuses zone and system mode changes that would leave
+// a zone in a mode opposite to the system's, rather than idling the zone.
+func (cfg *Config) RejectsModeConflicts() bool {
+	return cfg.ModeConflictPolicy == ModeConflictReject
+}
+
+// Sheddable reports whether zone z is weighted low enough to be shed while heating capacity is limited.
+func (cfg *Config) Sheddable(z model.Zone) bool {
+	return cfg.DemandShedPriority > 0 && z.PriorityWeight() < cfg.DemandShedPriority
+}
+
+// SensorReadTimeout is how long one sensor read, including retries, may take.
+func (cfg *Config) SensorReadTimeout() time.Duration {
+	if cfg.SensorReadTimeoutSeconds > 0 {
+		return time.Duration(cfg.SensorReadTimeoutSeconds) * time.Second
+	}
+	return 10 * time.Second
 }
 
 func parseLogLevel(level string) zerolog.Level {
@@ -160,53 +258,29 @@ func parseLogLevel(level string) zerolog.Level {
 	}
 }
 
-func (cfg *Config) validate() {
-	// Validate unique zone IDs
-	zoneIDs := make(map[string]bool)
-	for _, z := range cfg.Zones {
-		if zoneIDs[z.ID] {
-			panic(fmt.Sprintf("Duplicate zone ID found: %s", z.ID))
-		}
-		zoneIDs[z.ID] = true
-	}
-
-	// Validate device zone references
-	for _, ah := range cfg.DeviceConfig.AirHandlers.Devices {
-		if !zoneIDs[ah.Zone] {
-			panic(fmt.Sprintf("Air handler %s references unknown zone ID: %s", ah.Name, ah.Zone))
-		}
-	}
-	for _, rf := range cfg.DeviceConfig.RadiantFloorLoops.Devices {
-		if !zoneIDs[rf.Zone] {
-			panic(fmt.Sprintf("Radiant loop %s references unknown zone ID: %s", rf.Name, rf.Zone))
-		}
-	}
-
-	// Validate GPIO pin uniqueness
-	usedPins := make(map[int]string)
+type pinAssignment struct {
+	Label string
+	Pin   int
+}
 
-	check := func(pin int, label string) {
-		if existing, exists := usedPins[pin]; exists {
-			panic(fmt.Sprintf("GPIO pin conflict: %s and %s both use pin %d", existing, label, pin))
-		}
-		usedPins[pin] = label
+// pinAssignments lists every GPIO pin claimed by the config, in a stable order.
+func (cfg *Config) pinAssignments() []pinAssignment {
+	pins := []pinAssignment{
+		{"temp_sensor_bus_gpio", cfg.TempSensorBusGPIO},
+		{"main_power_gpio", cfg.MainPowerGPIO},
 	}
 
-	check(cfg.TempSensorBusGPIO, "temp_sensor_bus_gpio")
-	check(cfg.MainPowerGPIO, "main_power_gpio")
-
 	for _, hp := range cfg.DeviceConfig.HeatPumps.Devices {
-		check(hp.Pin, hp.Name+".pin")
-		check(hp.ModePin, hp.Name+".mode_pin")
+		pins = append(pins, pinAssignment{hp.Name + ".pin", hp.Pin}, pinAssignment{hp.Name + ".mode_pin", hp.ModePin})
 	}
 	for _, ah := range cfg.DeviceConfig.AirHandlers.Devices {
-		check(ah.Pin, ah.Name+".pin")
-		check(ah.CircPumpPin, ah.Name+".circ_pump_pin")
+		pins = append(pins, pinAssignment{ah.Name + ".pin", ah.Pin}, pinAssignment{ah.Name + ".circ_pump_pin", ah.CircPumpPin})
 	}
 	for _, b := range cfg.DeviceConfig.Boilers.Devices {
-		check(b.Pin, b.Name+".pin")
+		pins = append(pins, pinAssignment{b.Name + ".pin", b.Pin})
 	}
 	for _, rf := range cfg.DeviceConfig.RadiantFloorLoops.Devices {
-		check(rf.Pin, rf.Name+".pin")
+		pins = append(pins, pinAssignment{rf.Name + ".pin", rf.Pin})
 	}
+	return pins
 }
@@ -1,10 +1,12 @@
 package config
 
 import (
+	"errors"
 	"testing"
 
 	"github.com/rs/zerolog"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/thatsimonsguy/hvac-controller/internal/model"
 )
 
@@ -37,10 +39,7 @@ func TestConfigValidate_UniqueZones(t *testing.T) {
 		},
 	}
 
-	assert.PanicsWithValue(t,
-		"Duplicate zone ID found: zone1",
-		func() { cfg.validate() },
-	)
+	assert.Contains(t, cfg.validate().Messages(), "Duplicate zone ID found: zone1")
 }
 
 func TestConfigValidate_UnknownZoneReference(t *testing.T) {
@@ -57,10 +56,7 @@ func TestConfigValidate_UnknownZoneReference(t *testing.T) {
 		},
 	}
 
-	assert.PanicsWithValue(t,
-		"Air handler ah1 references unknown zone ID: zoneX",
-		func() { cfg.validate() },
-	)
+	assert.Contains(t, cfg.validate().Messages(), "Air handler ah1 references unknown zone ID: zoneX")
 }
 
 func TestConfigValidate_GPIOConflict(t *testing.T) {
@@ -70,8 +66,306 @@ func TestConfigValidate_GPIOConflict(t *testing.T) {
 		Zones:             []model.Zone{{ID: "zone1"}},
 	}
 
-	assert.PanicsWithValue(t,
-		"GPIO pin conflict: temp_sensor_bus_gpio and main_power_gpio both use pin 4",
-		func() { cfg.validate() },
-	)
+	assert.Contains(t, cfg.validate().Messages(), "GPIO pin conflict: temp_sensor_bus_gpio and main_power_gpio both use pin 4")
+}
+
+func TestConfigValidate_UnpairedFlowSensor(t *testing.T) {
+	cfg := &Config{
+		Zones: []model.Zone{{ID: "zone1"}},
+		DeviceConfig: DeviceConfig{
+			RadiantFloorLoops: RadiantLoopGroup{
+				Devices: []RadiantLoopConfig{
+					{Name: "rf1", Zone: "zone1", SupplySensor: &model.Sensor{ID: "rf1_supply", Bus: "28-000000000001"}},
+				},
+			},
+		},
+	}
+
+	assert.Contains(t, cfg.validate().Messages(), "Radiant loop rf1 must define both supply_sensor and return_sensor or neither")
+}
+
+// validConfig mirrors the shape of config.json and passes validation.
+func validConfig() *Config {
+	return &Config{
+		HeatingThreshold:       105,
+		CoolingThreshold:       40,
+		ZoneMaxTemp:            85,
+		ZoneMinTemp:            60,
+		SystemOverrideMaxTemp:  90,
+		SystemOverrideMinTemp:  50,
+		Spread:                 5,
+		SecondaryMargin:        10,
+		TertiaryMargin:         30,
+		RoleRotationMinutes:    1440,
+		PollIntervalSeconds:    30,
+		TempSensorBusGPIO:      4,
+		MainPowerGPIO:          25,
+		TempAnomalyMaxDelta:    5,
+		TempAnomalyGarageDelta: 25,
+		TempMaxAnomalies:       6,
+		TempHistorySize:        20,
+		Zones: []model.Zone{
+			{ID: "main_floor", Capabilities: []string{"heating", "cooling", "fan"}, Sensor: model.Sensor{ID: "main_floor_sensor", Bus: "28-000000523cb7"}},
+			{ID: "garage", Capabilities: []string{"heating"}, Sensor: model.Sensor{ID: "garage_sensor", Bus: "28-0000005084fd"}},
+		},
+		DeviceConfig: DeviceConfig{
+			HeatPumps: HeatPumpGroup{
+				DeviceProfile: DeviceProfile{MinTimeOn: 10, MinTimeOff: 5, ActiveModes: []string{"heating", "cooling"}},
+				Devices:       []HeatPumpConfig{{Name: "heat_pump_A", Pin: 23, ModePin: 18}},
+			},
+			AirHandlers: AirHandlerGroup{
+				DeviceProfile: DeviceProfile{MinTimeOn: 3, MinTimeOff: 1, ActiveModes: []string{"heating", "cooling", "fan"}},
+				Devices:       []AirHandlerConfig{{Name: "main_floor_air_handler", Pin: 5, CircPumpPin: 6, Zone: "main_floor"}},
+			},
+			Boilers: BoilerGroup{
+				DeviceProfile: DeviceProfile{MinTimeOn: 2, MinTimeOff: 5, ActiveModes: []string{"heating"}},
+				Devices:       []BoilerConfig{{Name: "boiler", Pin: 22}},
+			},
+			RadiantFloorLoops: RadiantLoopGroup{
+				DeviceProfile: DeviceProfile{MinTimeOn: 5, MinTimeOff: 3, ActiveModes: []string{"heating"}},
+				Devices:       []RadiantLoopConfig{{Name: "garage_radiant_loop", Pin: 17, Zone: "garage"}},
+			},
+		},
+		SystemSensors: map[string]model.Sensor{
+			"buffer_tank": {ID: "buffer_tank", Bus: "28-0000005050cc"},
+		},
+	}
+}
+
+func TestConfigValidate_Valid(t *testing.T) {
+	assert.NoError(t, validConfig().Validate())
+}
+
+func TestConfigValidate_ReportsAllErrors(t *testing.T) {
+	cfg := validConfig()
+	cfg.PollIntervalSeconds = 0
+	cfg.CoolingThreshold = 110
+	cfg.TertiaryMargin = 5
+	cfg.Zones[1].Capabilities = []string{"heating", "cooling"}
+	cfg.Zones[1].Sensor.Bus = "garage"
+	cfg.DeviceConfig.Boilers.DeviceProfile.ActiveModes = []string{"heating", "cooling"}
+	delete(cfg.SystemSensors, "buffer_tank")
+
+	err := cfg.Validate()
+	require.Error(t, err)
+
+	var errs ValidationErrors
+	require.True(t, errors.As(err, &errs))
+	assert.ElementsMatch(t, []string{
+		"must be greater than 0 (got 0)",
+		"must be above cooling_threshold (105 <= 110)",
+		"must be at least secondary_margin (5 < 10)",
+		`mode "cooling" is not supported (allowed: [heating])`,
+		`zone garage lists "cooling" but no attached device provides it`,
+		`required sensor "buffer_tank" is missing`,
+		`sensor bus "garage" does not look like a 1-Wire address (e.g. 28-000000523cb7)`,
+	}, errs.Messages())
+	assert.Contains(t, err.Error(), "config has 7 problem(s):")
+	assert.Contains(t, err.Error(), "poll_interval_seconds: must be greater than 0 (got 0)")
+}
+
+func TestConfigValidate_ActiveModes(t *testing.T) {
+	cfg := validConfig()
+	cfg.DeviceConfig.AirHandlers.DeviceProfile.ActiveModes = []string{"heating", "heating", "cooling", "fan", "dehumidify"}
+	cfg.DeviceConfig.HeatPumps.DeviceProfile.ActiveModes = nil
+
+	msgs := cfg.validate().Messages()
+	assert.Contains(t, msgs, `duplicate mode "heating"`)
+	assert.Contains(t, msgs, `mode "dehumidify" is not supported (allowed: [heating cooling fan])`)
+	assert.Contains(t, msgs, "must list at least one mode")
+}
+
+func TestConfigValidate_UnknownCapability(t *testing.T) {
+	cfg := validConfig()
+	cfg.Zones[0].Capabilities = append(cfg.Zones[0].Capabilities, "circulate")
+
+	assert.Equal(t, []string{`unknown capability "circulate"`}, cfg.validate().Messages())
+}
+
+func TestConfigValidate_ZoneFailsafeLimits(t *testing.T) {
+	low, high := 40.0, 95.0
+	cfg := validConfig()
+	cfg.Zones[0].FailsafeMinTemp = &low
+	cfg.Zones[0].FailsafeMaxTemp = &high
+	assert.NoError(t, cfg.Validate())
+
+	// A zone limit is checked against the system-wide limit it doesn't override
+	tooHigh := 92.0
+	cfg.Zones[1].FailsafeMinTemp = &tooHigh
+	assert.Equal(t, []string{"must be below failsafe_max_temp (92 >= 90)"}, cfg.validate().Messages())
+}
+
+func TestConfigValidate_FailsafeFallback(t *testing.T) {
+	cfg := validConfig()
+	cfg.FailsafeStaleMinutes = 15
+	cfg.FailsafeFallbackDutyCycle = 0.25
+	cfg.FailsafeFallbackCycleMinutes = 60
+	assert.NoError(t, cfg.Validate())
+
+	cfg.FailsafeFallbackDutyCycle = 1.5
+	cfg.FailsafeFallbackCycleMinutes = 0
+	assert.ElementsMatch(t, []string{
+		"must be at most 1 (got 1.5)",
+		"must be greater than 0 (got 0)",
+	}, cfg.validate().Messages())
+}
+
+func TestConfigValidate_SensorTypes(t *testing.T) {
+	cfg := validConfig()
+	cfg.Zones[0].Sensor = model.Sensor{ID: "main_floor_sensor", Type: model.SensorMQTT, Bus: "home/main_floor/temperature"}
+	cfg.Zones[1].Sensor = model.Sensor{ID: "garage_sensor", Type: model.SensorHTTP, Bus: "http://garage.local/temperature"}
+	cfg.SystemSensors["outdoor"] = model.Sensor{ID: "outdoor", Type: model.SensorIIO, Bus: "iio:device0"}
+	cfg.MQTT.Broker = "localhost:1883"
+	// Flow verification reads its probes through the same drivers
+	cfg.DeviceConfig.RadiantFloorLoops.Devices[0].SupplySensor = &model.Sensor{ID: "garage_supply", Type: model.SensorW1Temperature, Bus: "28-000000000001"}
+	cfg.DeviceConfig.RadiantFloorLoops.Devices[0].ReturnSensor = &model.Sensor{ID: "garage_return", Type: model.SensorHwmon, Bus: "hwmon2/temp1_input"}
+	assert.NoError(t, cfg.Validate())
+
+	cfg.MQTT.Broker = ""
+	cfg.Zones[0].Sensor.Bus = "home/+/temperature"
+	cfg.Zones[1].Sensor.Bus = "garage.local"
+	cfg.SystemSensors["outdoor"] = model.Sensor{ID: "outdoor", Type: model.SensorHwmon, Bus: "iio:device0"}
+	cfg.SystemSensors["buffer_tank"] = model.Sensor{ID: "buffer_tank", Type: "thermocouple", Bus: "28-0000005050cc"}
+	assert.ElementsMatch(t, []string{
+		`sensor bus "home/+/temperature" must be a single MQTT topic without wildcards`,
+		"mqtt sensors need mqtt.broker to be set",
+		`sensor bus "garage.local" is not an http or https URL`,
+		`sensor bus "iio:device0" does not look like a hwmon input (e.g. hwmon2/temp1_input)`,
+		`unknown sensor type "thermocouple" (valid types: w1, w1_temperature, iio, hwmon, mqtt, http)`,
+	}, cfg.validate().Messages())
+}
+
+func TestConfigValidate_ZoneSensors(t *testing.T) {
+	cfg := validConfig()
+	primary := cfg.Zones[0].Sensor
+	sunroom := model.Sensor{ID: "sunroom_sensor", Bus: "28-000000000011"}
+	cfg.Zones[0].Sensors = []model.ZoneSensor{{Sensor: primary}, {Sensor: sunroom, Weight: 0.5}}
+	cfg.Zones[0].Aggregation = model.AggregateWeightedMean
+	assert.NoError(t, cfg.Validate())
+
+	cfg.Zones[0].Aggregation = "mode"
+	cfg.Zones[0].Sensors = []model.ZoneSensor{{Sensor: sunroom, Weight: -1}, {Sensor: sunroom}}
+	assert.ElementsMatch(t, []string{
+		`unknown aggregation "mode" (valid: mean, weighted_mean, min, max, median)`,
+		"must not be negative (got -1)",
+		"sensor sunroom_sensor is listed more than once",
+		"primary sensor main_floor_sensor must be one of the zone's sensors",
+	}, cfg.validate().Messages())
+}
+
+func TestConfigValidate_Dehumidify(t *testing.T) {
+	cfg := validConfig()
+	cfg.DehumidifyMaxOvercool = 2
+	cfg.Zones[0].Capabilities = append(cfg.Zones[0].Capabilities, CapabilityDehumidify)
+	cfg.Zones[0].HumiditySensor = &model.Sensor{ID: "main_floor_humidity", Type: model.SensorIIO, Bus: "iio:device0"}
+	cfg.Zones[0].HumidityTarget = 50
+	assert.NoError(t, cfg.Validate())
+
+	// Radiant loops can't dehumidify, and the zone needs a humidity sensor and target
+	cfg.DehumidifyMaxOvercool = 0
+	cfg.Zones[0].HumiditySensor = &model.Sensor{ID: "main_floor_humidity", Bus: "28-000000000001"}
+	cfg.Zones[1].Capabilities = append(cfg.Zones[1].Capabilities, CapabilityDehumidify)
+	cfg.Zones[1].HumidityTarget = 120
+	assert.ElementsMatch(t, []string{
+		"must be greater than 0 (got 0)",
+		`zone garage lists "dehumidify" but no attached device provides it`,
+		"humidity sensors must be one of iio, mqtt, http (got w1)",
+		"must be a relative humidity between 0 and 100 (got 120)",
+		`zone garage lists "dehumidify" but has no humidity_sensor`,
+	}, cfg.validate().Messages())
+}
+
+func TestConfigValidate_Recirculation(t *testing.T) {
+	cfg := validConfig()
+	cfg.DeviceConfig.AirHandlers.Devices[0].Recirculation = model.RecirculationPolicy{IntervalMinutes: 240, StartHour: 22, EndHour: 6}
+	assert.NoError(t, cfg.Validate())
+
+	cfg.DeviceConfig.AirHandlers.Devices[0].Recirculation = model.RecirculationPolicy{IntervalMinutes: 10, EndHour: 24}
+	assert.ElementsMatch(t, []string{
+		"duration (15m0s) must be shorter than the interval (10m0s)",
+		"start_hour and end_hour must be between 0 and 23",
+	}, cfg.validate().Messages())
+}
+
+func TestConfigValidate_Circulation(t *testing.T) {
+	cfg := validConfig()
+	cfg.Zones[0].Circulate = &model.Circulation{MinutesPerHour: 20, BetweenCalls: true}
+	cfg.Zones[1].Circulate = &model.Circulation{}
+	assert.NoError(t, cfg.Validate())
+
+	cfg.Zones[0].Circulate = &model.Circulation{MinutesPerHour: 2}
+	cfg.Zones[1].Circulate = &model.Circulation{MinutesPerHour: 75}
+	assert.ElementsMatch(t, []string{
+		"must be at least the air handler min_time_on (2 < 3)",
+		`zone garage circulates but doesn't list the "fan" capability`,
+		"must be between 0 and 60 (got 75)",
+	}, cfg.validate().Messages())
+}
+
+func TestConfigValidate_ZoneGroups(t *testing.T) {
+	cfg := validConfig()
+	cfg.ZoneGroups = []model.ZoneGroup{{ID: "house", Label: "House", Zones: []string{"main_floor", "garage"}}}
+	assert.NoError(t, cfg.Validate())
+
+	cfg.ZoneGroups = []model.ZoneGroup{
+		{ID: "all", Zones: []string{"main_floor"}},
+		{ID: "upstairs", Zones: []string{"main_floor", "attic", "main_floor"}},
+		{ID: "upstairs"},
+	}
+	assert.ElementsMatch(t, []string{
+		`group id "all" is reserved for every zone`,
+		"Zone group upstairs references unknown zone ID: attic",
+		"zone main_floor is listed more than once",
+		"Duplicate zone group ID found: upstairs",
+		"group must list at least one zone",
+	}, cfg.validate().Messages())
+}
+
+func TestConfigValidate_ModeConflictPolicy(t *testing.T) {
+	cfg := validConfig()
+	assert.False(t, cfg.RejectsModeConflicts())
+
+	cfg.ModeConflictPolicy = ModeConflictReject
+	assert.NoError(t, cfg.Validate())
+	assert.True(t, cfg.RejectsModeConflicts())
+
+	cfg.ModeConflictPolicy = "shutdown"
+	assert.Equal(t, []string{`must be "idle" or "reject" (got "shutdown")`}, cfg.validate().Messages())
+}
+
+func TestConfigValidate_Demand(t *testing.T) {
+	cfg := validConfig()
+	cfg.DemandShedPriority = 1
+	cfg.Zones[0].Priority = 0.5
+	assert.NoError(t, cfg.Validate())
+	assert.True(t, cfg.Sheddable(cfg.Zones[0]))
+
+	// Unset priorities weigh 1, and only zones weighted below demand_shed_priority are shed
+	cfg.Zones[0].Priority = 0
+	assert.False(t, cfg.Sheddable(cfg.Zones[0]))
+	cfg.DemandShedPriority = 0
+	cfg.Zones[0].Priority = 0.5
+	assert.False(t, cfg.Sheddable(cfg.Zones[0]))
+
+	cfg.Zones[0].Priority = -1
+	cfg.DemandPrestageThreshold = -2
+	assert.Equal(t, []string{"must not be negative (got -1)", "must not be negative (got -2)"}, cfg.validate().Messages())
+}
+
+func TestConfigValidate_DemandReset(t *testing.T) {
+	cfg := validConfig()
+	cfg.DemandResetMaxRaise = 5
+	cfg.DemandResetFullDemand = 10
+	cfg.DemandResetMaxLower = 15
+	cfg.DemandResetCoastHours = 4
+	assert.NoError(t, cfg.Validate())
+
+	cfg.DemandResetFullDemand = 0
+	cfg.DemandResetMaxLower = cfg.HeatingThreshold - cfg.CoolingThreshold
+	cfg.DemandResetCoastHours = -1
+	errs := cfg.validate()
+	assert.Len(t, errs, 3)
+	assert.Equal(t, "demand_reset_full_demand", errs[0].Field)
+	assert.Equal(t, "demand_reset_coast_hours", errs[1].Field)
+	assert.Equal(t, "demand_reset_max_lower", errs[2].Field)
 }
@@ -0,0 +1,124 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// RestartRequired compares a freshly loaded config against the running one and lists the changes
+// that cannot be applied without restarting the controller. An empty result means the new config
+// can be swapped in live.
+func RestartRequired(old, new *Config) []string {
+	var reasons []string
+
+	changed := func(field string, a, b interface{}) {
+		if !reflect.DeepEqual(a, b) {
+			reasons = append(reasons, fmt.Sprintf("%s changed (%v -> %v)", field, a, b))
+		}
+	}
+	// Credentials end up in logs and API responses, so only say that they changed
+	changedSecret := func(field string, a, b string) {
+		if a != b {
+			reasons = append(reasons, fmt.Sprintf("%s changed", field))
+		}
+	}
+
+	changed("dbPath", old.DBPath, new.DBPath)
+	changed("boot_script_file_path", old.BootScriptFilePath, new.BootScriptFilePath)
+	changed("os_service_path", old.OSServicePath, new.OSServicePath)
+	changed("main_service_path", old.MainServicePath, new.MainServicePath)
+	changed("main_power_active_high", old.MainPowerActiveHigh, new.MainPowerActiveHigh)
+	changed("relay_board_active_high", old.RelayBoardActiveHigh, new.RelayBoardActiveHigh)
+	changed("enable_datadog", old.EnableDatadog, new.EnableDatadog)
+	changed("dd_agent_addr", old.DDAgentAddr, new.DDAgentAddr)
+	changed("dd_namespace", old.DDNamespace, new.DDNamespace)
+	changed("dd_tags", old.DDTags, new.DDTags)
+	changed("ntfy_topic", old.NtfyTopic, new.NtfyTopic)
+	changed("mqtt.broker", old.MQTT.Broker, new.MQTT.Broker)
+	changed("mqtt.client_id", old.MQTT.ClientID, new.MQTT.ClientID)
+	changedSecret("mqtt.username", old.MQTT.Username, new.MQTT.Username)
+	changedSecret("mqtt.password", old.MQTT.Password, new.MQTT.Password)
+	changed("mqtt.max_age_seconds", old.MQTT.MaxAgeSeconds, new.MQTT.MaxAgeSeconds)
+
+	// Pins are applied by the boot script and validated at startup, so any move needs a restart
+	oldPins := make(map[string]int)
+	for _, p := range old.pinAssignments() {
+		oldPins[p.Label] = p.Pin
+	}
+	newPins := make(map[string]int)
+	for _, p := range new.pinAssignments() {
+		newPins[p.Label] = p.Pin
+	}
+	for _, label := range sortedKeys(oldPins) {
+		pin, ok := newPins[label]
+		if !ok {
+			reasons = append(reasons, fmt.Sprintf("%s removed", label))
+		} else if pin != oldPins[label] {
+			reasons = append(reasons, fmt.Sprintf("%s moved (%d -> %d)", label, oldPins[label], pin))
+		}
+	}
+	for _, label := range sortedKeys(newPins) {
+		if _, ok := oldPins[label]; !ok {
+			reasons = append(reasons, fmt.Sprintf("%s added", label))
+		}
+	}
+
+	// Each zone has its own controller goroutine started at boot
+	oldZones := make(map[string]bool)
+	for _, z := range old.Zones {
+		oldZones[z.ID] = true
+	}
+	newZones := make(map[string]bool)
+	for _, z := range new.Zones {
+		newZones[z.ID] = true
+		if !oldZones[z.ID] {
+			reasons = append(reasons, fmt.Sprintf("zone %s added", z.ID))
+		}
+	}
+	for _, z := range old.Zones {
+		if !newZones[z.ID] {
+			reasons = append(reasons, fmt.Sprintf("zone %s removed", z.ID))
+		}
+	}
+
+	// Distribution devices are looked up by zone, so moving one between zones needs a restart too
+	oldDeviceZones := deviceZones(old)
+	newDeviceZones := deviceZones(new)
+	for _, name := range sortedKeys(oldDeviceZones) {
+		if zone, ok := newDeviceZones[name]; ok && zone != oldDeviceZones[name] {
+			reasons = append(reasons, fmt.Sprintf("%s zone changed (%s -> %s)", name, oldDeviceZones[name], zone))
+		}
+	}
+
+	return reasons
+}
+
+// InheritRuntime copies the fields that come from CLI flags rather than the config file.
+func (cfg *Config) InheritRuntime(running *Config) {
+	cfg.LogLevel = running.LogLevel
+	cfg.SafeMode = running.SafeMode
+	if cfg.ConfigFile == "" {
+		cfg.ConfigFile = running.ConfigFile
+	}
+}
+
+func deviceZones(cfg *Config) map[string]string {
+	zones := make(map[string]string)
+	for _, ah := range cfg.DeviceConfig.AirHandlers.Devices {
+		zones[ah.Name] = ah.Zone
+	}
+	for _, rf := range cfg.DeviceConfig.RadiantFloorLoops.Devices {
+		zones[rf.Name] = rf.Zone
+	}
+	return zones
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
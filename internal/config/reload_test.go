@@ -0,0 +1,85 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/thatsimonsguy/hvac-controller/internal/model"
+)
+
+func reloadTestConfig() *Config {
+	return &Config{
+		DBPath:            "data/hvac.db",
+		TempSensorBusGPIO: 4,
+		MainPowerGPIO:     25,
+		HeatingThreshold:  105,
+		Zones:             []model.Zone{{ID: "main_floor"}, {ID: "garage"}},
+		DeviceConfig: DeviceConfig{
+			HeatPumps: HeatPumpGroup{
+				Devices: []HeatPumpConfig{{Name: "hp_a", Pin: 23, ModePin: 18}},
+			},
+			AirHandlers: AirHandlerGroup{
+				Devices: []AirHandlerConfig{{Name: "ah", Pin: 5, CircPumpPin: 6, Zone: "main_floor"}},
+			},
+		},
+	}
+}
+
+func TestRestartRequired_LiveChangesAllowed(t *testing.T) {
+	old := reloadTestConfig()
+	next := reloadTestConfig()
+	next.HeatingThreshold = 110
+	next.PollIntervalSeconds = 60
+	next.DeviceConfig.HeatPumps.DeviceProfile.MinTimeOn = 15
+
+	assert.Empty(t, RestartRequired(old, next))
+}
+
+func TestRestartRequired_PinMove(t *testing.T) {
+	old := reloadTestConfig()
+	next := reloadTestConfig()
+	next.DeviceConfig.HeatPumps.Devices[0].ModePin = 19
+
+	assert.Equal(t, []string{"hp_a.mode_pin moved (18 -> 19)"}, RestartRequired(old, next))
+}
+
+func TestRestartRequired_DeviceAndZoneChanges(t *testing.T) {
+	old := reloadTestConfig()
+	next := reloadTestConfig()
+	next.Zones = append(next.Zones, model.Zone{ID: "basement"})
+	next.DeviceConfig.AirHandlers.Devices[0].Zone = "garage"
+	next.DeviceConfig.Boilers.Devices = []BoilerConfig{{Name: "boiler", Pin: 22}}
+
+	assert.ElementsMatch(t, []string{
+		"boiler.pin added",
+		"zone basement added",
+		"ah zone changed (main_floor -> garage)",
+	}, RestartRequired(old, next))
+}
+
+func TestRestartRequired_MQTTCredentialsNotShown(t *testing.T) {
+	old := reloadTestConfig()
+	old.MQTT = MQTTConfig{Broker: "localhost:1883", Username: "hvac", Password: "secret"}
+	next := reloadTestConfig()
+	next.MQTT = MQTTConfig{Broker: "broker.local:1883", Username: "hvac2", Password: "hunter2"}
+
+	reasons := RestartRequired(old, next)
+	assert.Equal(t, []string{
+		"mqtt.broker changed (localhost:1883 -> broker.local:1883)",
+		"mqtt.username changed",
+		"mqtt.password changed",
+	}, reasons)
+	for _, reason := range reasons {
+		assert.NotContains(t, reason, "secret")
+		assert.NotContains(t, reason, "hunter2")
+	}
+}
+
+func TestInheritRuntime(t *testing.T) {
+	running := &Config{ConfigFile: "/etc/hvac.json", SafeMode: true}
+	next := &Config{}
+	next.InheritRuntime(running)
+
+	assert.Equal(t, "/etc/hvac.json", next.ConfigFile)
+	assert.True(t, next.SafeMode)
+}
@@ -0,0 +1,579 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/thatsimonsguy/hvac-controller/internal/model"
+)
+
+// 1-Wire slave IDs are a family code and a 48-bit serial, e.g. 28-000000523cb7
+var oneWireAddress = regexp.MustCompile(`^[0-9a-f]{2}-[0-9a-f]{12}$`)
+
+var (
+	iioDevice   = regexp.MustCompile(`^iio:device[0-9]+$`)
+	hwmonInput  = regexp.MustCompile(`^hwmon[0-9]+/temp[0-9]+_input$`)
+	sensorTypes = []string{model.SensorW1, model.SensorW1Temperature, model.SensorIIO, model.SensorHwmon, model.SensorMQTT, model.SensorHTTP}
+
+	// Sensor types whose drivers can read relative humidity
+	humiditySensorTypes = []string{model.SensorIIO, model.SensorMQTT, model.SensorHTTP}
+)
+
+const BufferTankSensor = "buffer_tank"
+
+// OutdoorSensor is optional; without it fallback heat for degraded zones runs regardless of the weather.
+const OutdoorSensor = "outdoor"
+
+// Zone capabilities and device active modes share these names. Fan maps to circulate mode.
+const (
+	CapabilityHeating = "heating"
+	CapabilityCooling = "cooling"
+	CapabilityFan     = "fan"
+
+	// CapabilityDehumidify is a zone capability only: the zone's air handler keeps cooling to dry the air
+	CapabilityDehumidify = "dehumidify"
+)
+
+// Mode conflict policies: what happens when a zone is heating while the system is cooling, or the
+// reverse. Either way the zone controller idles a conflicting zone; reject also refuses the change.
+const (
+	ModeConflictIdle   = "idle"
+	ModeConflictReject = "reject"
+)
+
+// ValidationError is a single problem found in the config, tied to the field that caused it.
+type ValidationError struct {
+	Field   string
+	Message string
+}
+
+func (e ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Field, e.Message)
+}
+
+// ValidationErrors collects every problem found in a config so they can be fixed in one pass.
+type ValidationErrors []ValidationError
+
+func (errs ValidationErrors) Error() string {
+	lines := make([]string, 0, len(errs)+1)
+	lines = append(lines, fmt.Sprintf("config has %d problem(s):", len(errs)))
+	for _, e := range errs {
+		lines = append(lines, "  - "+e.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Messages returns just the messages, in the order they were found.
+func (errs ValidationErrors) Messages() []string {
+	msgs := make([]string, len(errs))
+	for i, e := range errs {
+		msgs[i] = e.Message
+	}
+	return msgs
+}
+
+// Validate checks the whole config and returns a ValidationErrors listing every problem, or nil.
+func (cfg *Config) Validate() error {
+	if errs := cfg.validate(); len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func (cfg *Config) validate() ValidationErrors {
+	var errs ValidationErrors
+	add := func(field, format string, args ...interface{}) {
+		errs = append(errs, ValidationError{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	// Validate unique zone IDs
+	zoneIDs := make(map[string]bool)
+	for _, z := range cfg.Zones {
+		if zoneIDs[z.ID] {
+			add("zones", "Duplicate zone ID found: %s", z.ID)
+		}
+		zoneIDs[z.ID] = true
+	}
+
+	// Validate zone groups
+	groupIDs := make(map[string]bool)
+	for _, g := range cfg.ZoneGroups {
+		field := fmt.Sprintf("zone_groups.%s", g.ID)
+		switch {
+		case g.ID == "":
+			add("zone_groups", "group id must not be empty")
+		case g.ID == model.AllZonesGroup:
+			add(field, "group id %q is reserved for every zone", g.ID)
+		case groupIDs[g.ID]:
+			add("zone_groups", "Duplicate zone group ID found: %s", g.ID)
+		}
+		groupIDs[g.ID] = true
+		if len(g.Zones) == 0 {
+			add(field, "group must list at least one zone")
+		}
+		members := make(map[string]bool)
+		for _, id := range g.Zones {
+			if !zoneIDs[id] {
+				add(field, "Zone group %s references unknown zone ID: %s", g.ID, id)
+			}
+			if members[id] {
+				add(field, "zone %s is listed more than once", id)
+			}
+			members[id] = true
+		}
+	}
+
+	// Validate device zone references
+	for _, ah := range cfg.DeviceConfig.AirHandlers.Devices {
+		if !zoneIDs[ah.Zone] {
+			add("devices.air_handlers", "Air handler %s references unknown zone ID: %s", ah.Name, ah.Zone)
+		}
+	}
+	for _, rf := range cfg.DeviceConfig.RadiantFloorLoops.Devices {
+		if !zoneIDs[rf.Zone] {
+			add("devices.radiant_floor_loops", "Radiant loop %s references unknown zone ID: %s", rf.Name, rf.Zone)
+		}
+	}
+
+	// Validate flow verification sensors come in supply/return pairs
+	for _, ah := range cfg.DeviceConfig.AirHandlers.Devices {
+		if (ah.SupplySensor == nil) != (ah.ReturnSensor == nil) {
+			add("devices.air_handlers", "Air handler %s must define both supply_sensor and return_sensor or neither", ah.Name)
+		}
+	}
+	for _, rf := range cfg.DeviceConfig.RadiantFloorLoops.Devices {
+		if (rf.SupplySensor == nil) != (rf.ReturnSensor == nil) {
+			add("devices.radiant_floor_loops", "Radiant loop %s must define both supply_sensor and return_sensor or neither", rf.Name)
+		}
+	}
+
+	// Validate recirculation policies
+	for _, ah := range cfg.DeviceConfig.AirHandlers.Devices {
+		field := fmt.Sprintf("devices.air_handlers.%s.recirculation", ah.Name)
+		p := ah.Recirculation
+		if p.IntervalMinutes < 0 || p.DurationMinutes < 0 {
+			add(field, "interval_minutes and duration_minutes must not be negative")
+		} else if p.Duration() >= p.Interval() {
+			add(field, "duration (%v) must be shorter than the interval (%v)", p.Duration(), p.Interval())
+		}
+		if p.StartHour < 0 || p.StartHour > 23 || p.EndHour < 0 || p.EndHour > 23 {
+			add(field, "start_hour and end_hour must be between 0 and 23")
+		}
+	}
+
+	// Validate GPIO pin uniqueness
+	usedPins := make(map[int]string)
+	for _, p := range cfg.pinAssignments() {
+		if existing, exists := usedPins[p.Pin]; exists {
+			add("gpio", "GPIO pin conflict: %s and %s both use pin %d", existing, p.Label, p.Pin)
+			continue
+		}
+		usedPins[p.Pin] = p.Label
+	}
+
+	cfg.validateRanges(add)
+	cfg.validateActiveModes(add)
+	cfg.validateCapabilities(add)
+	cfg.validateSensors(add)
+	cfg.validateCirculation(add)
+
+	return errs
+}
+
+type addFunc func(field, format string, args ...interface{})
+
+func (cfg *Config) validateRanges(add addFunc) {
+	positive := func(field string, v float64) {
+		if v <= 0 {
+			add(field, "must be greater than 0 (got %v)", v)
+		}
+	}
+	nonNegative := func(field string, v float64) {
+		if v < 0 {
+			add(field, "must not be negative (got %v)", v)
+		}
+	}
+
+	positive("heating_threshold", cfg.HeatingThreshold)
+	positive("cooling_threshold", cfg.CoolingThreshold)
+	if cfg.HeatingThreshold <= cfg.CoolingThreshold {
+		add("heating_threshold", "must be above cooling_threshold (%v <= %v)", cfg.HeatingThreshold, cfg.CoolingThreshold)
+	}
+
+	positive("spread", cfg.Spread)
+	nonNegative("secondary_margin", cfg.SecondaryMargin)
+	nonNegative("tertiary_margin", cfg.TertiaryMargin)
+	if cfg.TertiaryMargin < cfg.SecondaryMargin {
+		add("tertiary_margin", "must be at least secondary_margin (%v < %v)", cfg.TertiaryMargin, cfg.SecondaryMargin)
+	}
+
+	if cfg.ZoneMinTemp >= cfg.ZoneMaxTemp {
+		add("zone_min_temp", "must be below zone_max_temp (%v >= %v)", cfg.ZoneMinTemp, cfg.ZoneMaxTemp)
+	}
+	if cfg.SystemOverrideMinTemp >= cfg.SystemOverrideMaxTemp {
+		add("system_override_min_temp", "must be below system_override_max_temp (%v >= %v)", cfg.SystemOverrideMinTemp, cfg.SystemOverrideMaxTemp)
+	}
+
+	for _, z := range cfg.Zones {
+		minTemp, maxTemp := z.FailsafeLimits(cfg.SystemOverrideMinTemp, cfg.SystemOverrideMaxTemp)
+		if (z.FailsafeMinTemp != nil || z.FailsafeMaxTemp != nil) && minTemp >= maxTemp {
+			add(fmt.Sprintf("zones.%s.failsafe_min_temp", z.ID), "must be below failsafe_max_temp (%v >= %v)", minTemp, maxTemp)
+		}
+		nonNegative(fmt.Sprintf("zones.%s.priority", z.ID), z.Priority)
+	}
+
+	switch cfg.ModeConflictPolicy {
+	case "", ModeConflictIdle, ModeConflictReject:
+	default:
+		add("mode_conflict_policy", "must be %q or %q (got %q)", ModeConflictIdle, ModeConflictReject, cfg.ModeConflictPolicy)
+	}
+
+	positive("poll_interval_seconds", float64(cfg.PollIntervalSeconds))
+	positive("role_rotation_minutes", float64(cfg.RoleRotationMinutes))
+
+	positive("temp_anomaly_max_delta", cfg.TempAnomalyMaxDelta)
+	positive("temp_anomaly_garage_delta", cfg.TempAnomalyGarageDelta)
+	positive("temp_max_anomalies", float64(cfg.TempMaxAnomalies))
+	positive("temp_history_size", float64(cfg.TempHistorySize))
+	nonNegative("max_reading_age_seconds", float64(cfg.MaxReadingAgeSeconds))
+	nonNegative("sensor_read_timeout_seconds", float64(cfg.SensorReadTimeoutSeconds))
+	nonNegative("sensor_read_concurrency", float64(cfg.SensorReadConcurrency))
+
+	nonNegative("flow_verify_window_seconds", float64(cfg.FlowVerifyWindowSeconds))
+	if cfg.FlowVerifyWindowSeconds > 0 {
+		positive("flow_verify_min_delta", cfg.FlowVerifyMinDelta)
+	}
+
+	nonNegative("backup_interval_hours", float64(cfg.BackupIntervalHours))
+	if cfg.BackupIntervalHours > 0 {
+		if cfg.BackupDir == "" {
+			add("backup_dir", "must be set when backup_interval_hours is enabled")
+		}
+		positive("backup_retention", float64(cfg.BackupRetention))
+	}
+
+	nonNegative("failsafe_stale_minutes", float64(cfg.FailsafeStaleMinutes))
+	if cfg.FailsafeStaleMinutes > 0 {
+		nonNegative("failsafe_fallback_duty_cycle", cfg.FailsafeFallbackDutyCycle)
+		if cfg.FailsafeFallbackDutyCycle > 1 {
+			add("failsafe_fallback_duty_cycle", "must be at most 1 (got %v)", cfg.FailsafeFallbackDutyCycle)
+		}
+		if cfg.FailsafeFallbackDutyCycle > 0 {
+			positive("failsafe_fallback_cycle_minutes", float64(cfg.FailsafeFallbackCycleMinutes))
+		}
+	}
+
+	dehumidifies := false
+	for _, z := range cfg.Zones {
+		dehumidifies = dehumidifies || z.HasCapability(CapabilityDehumidify)
+	}
+	if dehumidifies {
+		positive("dehumidify_max_overcool", cfg.DehumidifyMaxOvercool)
+	} else {
+		nonNegative("dehumidify_max_overcool", cfg.DehumidifyMaxOvercool)
+	}
+	nonNegative("dew_point_margin", cfg.DewPointMargin)
+
+	nonNegative("demand_prestage_threshold", cfg.DemandPrestageThreshold)
+	nonNegative("demand_shed_priority", cfg.DemandShedPriority)
+	nonNegative("demand_reset_max_raise", cfg.DemandResetMaxRaise)
+	if cfg.DemandResetMaxRaise > 0 {
+		positive("demand_reset_full_demand", cfg.DemandResetFullDemand)
+	}
+	nonNegative("demand_reset_max_lower", cfg.DemandResetMaxLower)
+	nonNegative("demand_reset_coast_hours", float64(cfg.DemandResetCoastHours))
+	if cfg.DemandResetMaxLower > 0 && cfg.HeatingThreshold-cfg.DemandResetMaxLower <= cfg.CoolingThreshold {
+		add("demand_reset_max_lower", "must keep the heating target above cooling_threshold (%v - %v <= %v)", cfg.HeatingThreshold, cfg.DemandResetMaxLower, cfg.CoolingThreshold)
+	}
+
+	nonNegative("pump_exercise_interval_hours", float64(cfg.PumpExerciseIntervalHours))
+	if cfg.PumpExerciseIntervalHours > 0 {
+		positive("pump_exercise_seconds", float64(cfg.PumpExerciseSeconds))
+	}
+
+	for _, g := range cfg.deviceGroups() {
+		nonNegative(g.field+".device_profile.min_time_on", float64(g.profile.MinTimeOn))
+		nonNegative(g.field+".device_profile.min_time_off", float64(g.profile.MinTimeOff))
+	}
+}
+
+type deviceGroup struct {
+	field   string
+	profile DeviceProfile
+	count   int
+	allowed []string // modes the hardware can actually run in
+}
+
+func (cfg *Config) deviceGroups() []deviceGroup {
+	dc := cfg.DeviceConfig
+	return []deviceGroup{
+		{"devices.heat_pumps", dc.HeatPumps.DeviceProfile, len(dc.HeatPumps.Devices), []string{CapabilityHeating, CapabilityCooling}},
+		{"devices.air_handlers", dc.AirHandlers.DeviceProfile, len(dc.AirHandlers.Devices), []string{CapabilityHeating, CapabilityCooling, CapabilityFan}},
+		{"devices.boilers", dc.Boilers.DeviceProfile, len(dc.Boilers.Devices), []string{CapabilityHeating}},
+		{"devices.radiant_floor_loops", dc.RadiantFloorLoops.DeviceProfile, len(dc.RadiantFloorLoops.Devices), []string{CapabilityHeating, CapabilityCooling}},
+	}
+}
+
+func (cfg *Config) validateActiveModes(add addFunc) {
+	for _, g := range cfg.deviceGroups() {
+		field := g.field + ".device_profile.active_modes"
+		if g.count > 0 && len(g.profile.ActiveModes) == 0 {
+			add(field, "must list at least one mode")
+		}
+
+		seen := make(map[string]bool)
+		for _, m := range g.profile.ActiveModes {
+			if seen[m] {
+				add(field, "duplicate mode %q", m)
+				continue
+			}
+			seen[m] = true
+
+			if !containsMode(g.allowed, m) {
+				add(field, "mode %q is not supported (allowed: %v)", m, g.allowed)
+			}
+		}
+	}
+}
+
+func (cfg *Config) validateCapabilities(add addFunc) {
+	ahModes := cfg.DeviceConfig.AirHandlers.DeviceProfile.ActiveModes
+	rfModes := cfg.DeviceConfig.RadiantFloorLoops.DeviceProfile.ActiveModes
+
+	// What each zone can actually do, given the distribution devices attached to it
+	provided := make(map[string]map[string]bool)
+	provide := func(zone string, modes []string) {
+		if provided[zone] == nil {
+			provided[zone] = make(map[string]bool)
+		}
+		for _, m := range modes {
+			provided[zone][m] = true
+		}
+	}
+	for _, ah := range cfg.DeviceConfig.AirHandlers.Devices {
+		provide(ah.Zone, ahModes)
+		// Only an air handler's coil dries the air, radiant cooling can't remove moisture
+		if containsMode(ahModes, CapabilityCooling) {
+			provide(ah.Zone, []string{CapabilityDehumidify})
+		}
+	}
+	for _, rf := range cfg.DeviceConfig.RadiantFloorLoops.Devices {
+		provide(rf.Zone, rfModes)
+	}
+
+	for _, z := range cfg.Zones {
+		field := fmt.Sprintf("zones.%s.capabilities", z.ID)
+		for _, c := range z.Capabilities {
+			switch c {
+			case CapabilityHeating, CapabilityCooling, CapabilityFan, CapabilityDehumidify:
+			default:
+				add(field, "unknown capability %q", c)
+				continue
+			}
+			if !provided[z.ID][c] {
+				add(field, "zone %s lists %q but no attached device provides it", z.ID, c)
+			}
+		}
+	}
+}
+
+func (cfg *Config) validateCirculation(add addFunc) {
+	for _, z := range cfg.Zones {
+		if z.Circulate == nil {
+			continue
+		}
+		for _, e := range cfg.CheckCirculation(z, *z.Circulate) {
+			add(e.Field, "%s", e.Message)
+		}
+	}
+}
+
+// CheckCirculation checks a circulation duty for zone z fits an hour around the air handlers'
+// minimum on and off times, which the zone controller can't cut short.
+func (cfg *Config) CheckCirculation(z model.Zone, c model.Circulation) ValidationErrors {
+	var errs ValidationErrors
+	add := func(field, format string, args ...interface{}) {
+		errs = append(errs, ValidationError{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+	if c == (model.Circulation{}) {
+		return nil
+	}
+
+	field := fmt.Sprintf("zones.%s.circulate", z.ID)
+	profile := cfg.DeviceConfig.AirHandlers.DeviceProfile
+	if !z.HasCapability(CapabilityFan) {
+		add(field, "zone %s circulates but doesn't list the %q capability", z.ID, CapabilityFan)
+	}
+	switch {
+	case c.MinutesPerHour < 0 || c.MinutesPerHour > 60:
+		add(field+".minutes_per_hour", "must be between 0 and 60 (got %d)", c.MinutesPerHour)
+	case c.Continuous():
+	case c.MinutesPerHour < profile.MinTimeOn:
+		add(field+".minutes_per_hour", "must be at least the air handler min_time_on (%d < %d)", c.MinutesPerHour, profile.MinTimeOn)
+	case 60-c.MinutesPerHour < profile.MinTimeOff:
+		add(field+".minutes_per_hour", "must leave the air handler min_time_off each hour (%d > %d)", c.MinutesPerHour, 60-profile.MinTimeOff)
+	}
+	return errs
+}
+
+func (cfg *Config) validateSensors(add addFunc) {
+	if _, ok := cfg.SystemSensors[BufferTankSensor]; !ok {
+		add("system_sensors", "required sensor %q is missing", BufferTankSensor)
+	}
+
+	buses := make(map[string]string) // sensor ID -> bus, a shared ID must point at the same bus
+	types := make(map[string]string)
+	check := func(field string, s model.Sensor) {
+		if s.ID == "" {
+			add(field, "sensor id must not be empty")
+		}
+		cfg.validateSensorBus(add, field, s)
+		if bus, ok := buses[s.ID]; ok && bus != s.Bus {
+			add(field, "sensor %s is defined with different buses (%s, %s)", s.ID, bus, s.Bus)
+		}
+		if t, ok := types[s.ID]; ok && t != s.Driver() {
+			add(field, "sensor %s is defined with different types (%s, %s)", s.ID, t, s.Driver())
+		}
+		buses[s.ID] = s.Bus
+		types[s.ID] = s.Driver()
+		if s.PollIntervalSeconds < 0 {
+			add(field+".poll_interval_seconds", "must not be negative (got %v)", s.PollIntervalSeconds)
+		}
+	}
+	// Controllers ignore readings older than max_reading_age, so the sensors they act on must be read more often
+	controlled := func(field string, s model.Sensor) {
+		if interval := time.Duration(s.PollIntervalSeconds) * time.Second; interval > cfg.MaxReadingAge() {
+			add(field+".poll_interval_seconds", "must not exceed the max reading age (%v > %v)", interval, cfg.MaxReadingAge())
+		}
+	}
+
+	for _, name := range sortedKeys(cfg.SystemSensors) {
+		check("system_sensors."+name, cfg.SystemSensors[name])
+	}
+	if s, ok := cfg.SystemSensors[BufferTankSensor]; ok {
+		controlled("system_sensors."+BufferTankSensor, s)
+	}
+	for _, z := range cfg.Zones {
+		check(fmt.Sprintf("zones.%s.sensor", z.ID), z.Sensor)
+		controlled(fmt.Sprintf("zones.%s.sensor", z.ID), z.Sensor)
+		cfg.validateZoneSensors(add, z, check, controlled)
+	}
+	for _, ah := range cfg.DeviceConfig.AirHandlers.Devices {
+		if ah.SupplySensor != nil && ah.ReturnSensor != nil {
+			check(ah.Name+".supply_sensor", *ah.SupplySensor)
+			check(ah.Name+".return_sensor", *ah.ReturnSensor)
+		}
+	}
+	for _, rf := range cfg.DeviceConfig.RadiantFloorLoops.Devices {
+		if rf.SupplySensor != nil && rf.ReturnSensor != nil {
+			check(rf.Name+".supply_sensor", *rf.SupplySensor)
+			check(rf.Name+".return_sensor", *rf.ReturnSensor)
+		}
+	}
+
+	for _, z := range cfg.Zones {
+		cfg.validateHumidity(add, z, check)
+	}
+
+	if cfg.MQTT.MaxAgeSeconds < 0 {
+		add("mqtt.max_age_seconds", "must not be negative (got %v)", cfg.MQTT.MaxAgeSeconds)
+	}
+}
+
+// validateZoneSensors checks a multi-sensor zone's sensor list and aggregation.
+func (cfg *Config) validateZoneSensors(add addFunc, z model.Zone, check, controlled func(field string, s model.Sensor)) {
+	switch z.AggregationMethod() {
+	case model.AggregateMean, model.AggregateWeightedMean, model.AggregateMin, model.AggregateMax, model.AggregateMedian:
+	default:
+		add(fmt.Sprintf("zones.%s.aggregation", z.ID), "unknown aggregation %q (valid: %s)", z.Aggregation,
+			strings.Join([]string{model.AggregateMean, model.AggregateWeightedMean, model.AggregateMin, model.AggregateMax, model.AggregateMedian}, ", "))
+	}
+	if len(z.Sensors) == 0 {
+		return
+	}
+
+	listed := make(map[string]bool)
+	for i, s := range z.Sensors {
+		field := fmt.Sprintf("zones.%s.sensors[%d]", z.ID, i)
+		if listed[s.ID] {
+			add(field, "sensor %s is listed more than once", s.ID)
+		}
+		listed[s.ID] = true
+		check(field, s.Sensor)
+		controlled(field, s.Sensor)
+		if s.Weight < 0 {
+			add(field+".weight", "must not be negative (got %v)", s.Weight)
+		}
+	}
+	if !listed[z.Sensor.ID] {
+		add(fmt.Sprintf("zones.%s.sensor", z.ID), "primary sensor %s must be one of the zone's sensors", z.Sensor.ID)
+	}
+}
+
+// validateHumidity checks a zone's humidity sensor and target, which dehumidifying requires.
+func (cfg *Config) validateHumidity(add addFunc, z model.Zone, check func(field string, s model.Sensor)) {
+	if z.HumiditySensor != nil {
+		field := fmt.Sprintf("zones.%s.humidity_sensor", z.ID)
+		check(field, *z.HumiditySensor)
+		if !containsMode(humiditySensorTypes, z.HumiditySensor.Driver()) {
+			add(field, "humidity sensors must be one of %s (got %s)", strings.Join(humiditySensorTypes, ", "), z.HumiditySensor.Driver())
+		}
+	}
+	if z.HumidityTarget < 0 || z.HumidityTarget >= 100 {
+		add(fmt.Sprintf("zones.%s.humidity_target", z.ID), "must be a relative humidity between 0 and 100 (got %v)", z.HumidityTarget)
+	}
+
+	if !z.HasCapability(CapabilityDehumidify) {
+		return
+	}
+	field := fmt.Sprintf("zones.%s.capabilities", z.ID)
+	if z.HumiditySensor == nil {
+		add(field, "zone %s lists %q but has no humidity_sensor", z.ID, CapabilityDehumidify)
+	}
+	if z.HumidityTarget == 0 {
+		add(field, "zone %s lists %q but has no humidity_target", z.ID, CapabilityDehumidify)
+	}
+}
+
+// validateSensorBus checks a sensor's bus against the form its type expects.
+func (cfg *Config) validateSensorBus(add addFunc, field string, s model.Sensor) {
+	switch s.Driver() {
+	case model.SensorW1, model.SensorW1Temperature:
+		if !oneWireAddress.MatchString(s.Bus) {
+			add(field, "sensor bus %q does not look like a 1-Wire address (e.g. 28-000000523cb7)", s.Bus)
+		}
+	case model.SensorIIO:
+		if !iioDevice.MatchString(s.Bus) {
+			add(field, "sensor bus %q does not look like an IIO device (e.g. iio:device0)", s.Bus)
+		}
+	case model.SensorHwmon:
+		if !hwmonInput.MatchString(s.Bus) {
+			add(field, "sensor bus %q does not look like a hwmon input (e.g. hwmon2/temp1_input)", s.Bus)
+		}
+	case model.SensorMQTT:
+		if s.Bus == "" || strings.ContainsAny(s.Bus, "+#") {
+			add(field, "sensor bus %q must be a single MQTT topic without wildcards", s.Bus)
+		}
+		if cfg.MQTT.Broker == "" {
+			add(field, "mqtt sensors need mqtt.broker to be set")
+		}
+	case model.SensorHTTP:
+		if u, err := url.Parse(s.Bus); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			add(field, "sensor bus %q is not an http or https URL", s.Bus)
+		}
+	default:
+		add(field, "unknown sensor type %q (valid types: %s)", s.Type, strings.Join(sensorTypes, ", "))
+	}
+}
+
+func containsMode(modes []string, m string) bool {
+	for _, allowed := range modes {
+		if allowed == m {
+			return true
+		}
+	}
+	return false
+}
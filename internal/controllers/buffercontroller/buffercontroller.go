@@ -1,7 +1,6 @@
 package buffercontroller
 
 import (
-	"database/sql"
 	"fmt"
 	"time"
 
@@ -13,7 +12,9 @@ import (
 	"github.com/thatsimonsguy/hvac-controller/internal/env"
 	"github.com/thatsimonsguy/hvac-controller/internal/gpio"
 	"github.com/thatsimonsguy/hvac-controller/internal/model"
+	"github.com/thatsimonsguy/hvac-controller/internal/temperature"
 	"github.com/thatsimonsguy/hvac-controller/system/shutdown"
+	"github.com/thatsimonsguy/hvac-controller/system/startup"
 )
 
 type HeatSources struct {
@@ -22,8 +23,19 @@ type HeatSources struct {
 	Tertiary  *model.Boiler
 }
 
+// Store is the heat source and system state the buffer controller reads.
+// Mode changes also rewrite the startup script, so it includes the startup device listing.
+type Store interface {
+	device.Store
+	startup.DeviceStore
+	GetSensorByID(id string) (*model.Sensor, error)
+	GetAllZones() ([]model.Zone, error)
+	SetZoneShed(id string, since *time.Time) error
+	SwapPrimaryHeatPump(audit db.Audit) error
+}
+
 type HeatSourcesProvider interface {
-	GetHeatSources(dbConn *sql.DB) HeatSources
+	GetHeatSources(store Store) HeatSources
 }
 
 type SourceRefresher struct {
@@ -32,19 +44,21 @@ type SourceRefresher struct {
 
 type RealProvider struct{}
 
-func (RealProvider) GetHeatSources(dbConn *sql.DB) HeatSources {
-	return GetHeatSources(dbConn)
+func (RealProvider) GetHeatSources(store Store) HeatSources {
+	return GetHeatSources(store)
 }
 
 type TemperatureService interface {
-	GetTemperature(sensorID string) (float64, bool)
+	GetReading(sensorID string) (temperature.SensorReading, bool)
+	GetZoneReading(zone model.Zone) (temperature.SensorReading, bool)
+	GetHumidity(sensorID string) (temperature.HumidityReading, bool)
 }
 
-func RunBufferController(dbConn *sql.DB, tempService TemperatureService) {
+func RunBufferController(store Store, tempService TemperatureService) {
 	go func() {
 		log.Info().Msg("Starting buffer tank controller")
 
-		sensor, err := db.GetSensorByID(dbConn, "buffer_tank")
+		sensor, err := store.GetSensorByID("buffer_tank")
 		if err != nil {
 			log.Error().Err(err).Str("sensor id", "buffer_tank").Msg("Could not retrieve sensor")
 		}
@@ -53,36 +67,54 @@ func RunBufferController(dbConn *sql.DB, tempService TemperatureService) {
 		refresher := SourceRefresher{Provider: RealProvider{}}
 
 		// Sleep once at startup to honor min-off duration
-		sleepDuration := time.Duration(env.Cfg.DeviceConfig.HeatPumps.DeviceProfile.MinTimeOff) * time.Minute
+		sleepDuration := time.Duration(env.Cfg().DeviceConfig.HeatPumps.DeviceProfile.MinTimeOff) * time.Minute
 		log.Info().Dur("sleep", sleepDuration).Msg("Initial delay to avoid startup flapping")
 		time.Sleep(sleepDuration)
 
+		var guard dewPointGuard
+		var stager DemandStager
 		for {
 			// refresh current source list to handle rotations and maintenance drops
-			sources := refresher.RefreshSources(dbConn)
-
-			// get buffer tank temp
-			bufferTemp, valid := tempService.GetTemperature(sensor.ID)
-			if !valid {
-				log.Warn().Msg("No valid temperature reading available for buffer tank")
+			sources := refresher.RefreshSources(store)
+
+			// get buffer tank temp, refusing to act on old readings
+			reading, ok := tempService.GetReading(sensor.ID)
+			if maxAge := env.Cfg().MaxReadingAge(); !ok || !reading.FreshEnough(maxAge) {
+				log.Warn().
+					Str("quality", string(reading.Quality)).
+					Dur("age", reading.Age).
+					Dur("max_age", maxAge).
+					Msg("No recent temperature reading available for buffer tank")
+				time.Sleep(time.Duration(env.Cfg().PollIntervalSeconds) * time.Second)
 				continue
 			}
+			bufferTemp := reading.Temperature
 
 			datadog.Gauge("buffer_tank.temperature", bufferTemp, "component:sensor")
 
 			// get system mode
-			mode, err := db.GetSystemMode(dbConn)
+			mode, err := store.GetSystemMode()
 			if err != nil {
 				log.Error().Err(err).Msg("Could nor retrieve system mode from db")
 			}
 
-			if err := SetSystemMode(dbConn, mode); err != nil {
+			if err := SetSystemMode(store, mode); err != nil {
 				log.Error().Err(err).Msg("failed to set system mode pins correctly")
 			}
 
+			// keep chilled water above the indoor dew point so pipes and coils don't sweat
+			coolingRaise := guard.update(store, tempService, mode, bufferTemp)
+
+			// follow zone demand with the heating target, stage the secondary early on high demand,
+			// and shed low-priority zones when short of capacity
+			heatingReset, prestage := stager.Update(store, tempService, mode, sources)
+
 			log.Info().
 				Str("mode", string(mode)).
 				Float64("buffer_temp", bufferTemp).
+				Float64("cooling_raise", coolingRaise).
+				Float64("heating_reset", heatingReset).
+				Float64("prestage", prestage).
 				Msg("Evaluating buffer tank and heat sources")
 
 			// activate or deactivate heat sources if they should be and we can
@@ -93,8 +125,9 @@ func RunBufferController(dbConn *sql.DB, tempService TemperatureService) {
 					gpio.CurrentlyActive(sources.Primary.Pin),
 					bufferTemp,
 					mode,
-					func() { device.ActivateHeatPump(sources.Primary, dbConn) },
-					func() { device.DeactivateHeatPump(sources.Primary, dbConn) },
+					coolingRaise+heatingReset,
+					func() { device.ActivateHeatPump(sources.Primary, store) },
+					func() { device.DeactivateHeatPump(sources.Primary, store) },
 				)
 			}
 
@@ -105,8 +138,9 @@ func RunBufferController(dbConn *sql.DB, tempService TemperatureService) {
 					gpio.CurrentlyActive(sources.Secondary.Pin),
 					bufferTemp,
 					mode,
-					func() { device.ActivateHeatPump(sources.Secondary, dbConn) },
-					func() { device.DeactivateHeatPump(sources.Secondary, dbConn) },
+					coolingRaise+heatingReset+prestage,
+					func() { device.ActivateHeatPump(sources.Secondary, store) },
+					func() { device.DeactivateHeatPump(sources.Secondary, store) },
 				)
 			}
 
@@ -117,12 +151,13 @@ func RunBufferController(dbConn *sql.DB, tempService TemperatureService) {
 					gpio.CurrentlyActive(sources.Tertiary.Pin),
 					bufferTemp,
 					mode,
-					func() { device.ActivateBoiler(sources.Tertiary, dbConn) },
-					func() { device.DeactivateBoiler(sources.Tertiary, dbConn) },
+					coolingRaise+heatingReset,
+					func() { device.ActivateBoiler(sources.Tertiary, store) },
+					func() { device.DeactivateBoiler(sources.Tertiary, store) },
 				)
 			}
 
-			time.Sleep(time.Duration(env.Cfg.PollIntervalSeconds) * time.Second)
+			time.Sleep(time.Duration(env.Cfg().PollIntervalSeconds) * time.Second)
 		}
 	}()
 }
@@ -133,10 +168,11 @@ func EvaluateAndToggle(
 	active bool,
 	bufferTemp float64,
 	mode model.SystemMode,
+	raise float64,
 	activate func(),
 	deactivate func(),
 ) {
-	shouldToggle := EvaluateToggleSource(role, bufferTemp, active, &source, mode)
+	shouldToggle := EvaluateToggleSource(role, bufferTemp, active, &source, mode, raise)
 
 	if shouldToggle && active {
 		log.Info().Str("device", source.Name).Msgf("Deactivating %s", role)
@@ -159,8 +195,10 @@ func ShouldBeOn(bt float64, threshold float64, mode model.SystemMode) bool {
 	}
 }
 
-var EvaluateToggleSource = func(role string, bt float64, active bool, d *model.Device, mode model.SystemMode) bool {
-	threshold := GetThreshold(role, mode, active)
+// EvaluateToggleSource reports whether a source should be switched and can be. The source's
+// thresholds are raised by raise, which may be negative, see DewPointRaise and DemandStager.
+var EvaluateToggleSource = func(role string, bt float64, active bool, d *model.Device, mode model.SystemMode, raise float64) bool {
+	threshold := GetThreshold(role, mode, active) + raise
 	should := ShouldBeOn(bt, threshold, mode)
 
 	log.Debug().
@@ -190,25 +228,27 @@ func GetThreshold(role string, mode model.SystemMode, active bool) float64 {
 		shutdown.ShutdownWithError(fmt.Errorf("invalid role definition: %s", role), "error setting temperature thresholds")
 	}
 
+	cfg := env.Cfg()
+
 	var (
 		// activation and deactivation thresholds are overlapped by spread to prevent flapping
-		baseHeat = env.Cfg.HeatingThreshold
-		baseCool = env.Cfg.CoolingThreshold
+		baseHeat = cfg.HeatingThreshold
+		baseCool = cfg.CoolingThreshold
 
 		primaryHeatOn  = baseHeat
-		primaryHeatOff = baseHeat + env.Cfg.Spread
+		primaryHeatOff = baseHeat + cfg.Spread
 
-		secondaryHeatOn  = baseHeat - env.Cfg.SecondaryMargin
-		secondaryHeatOff = secondaryHeatOn + env.Cfg.Spread
+		secondaryHeatOn  = baseHeat - cfg.SecondaryMargin
+		secondaryHeatOff = secondaryHeatOn + cfg.Spread
 
-		tertiaryHeatOn  = baseHeat - env.Cfg.TertiaryMargin
-		tertiaryHeatOff = tertiaryHeatOn + env.Cfg.Spread
+		tertiaryHeatOn  = baseHeat - cfg.TertiaryMargin
+		tertiaryHeatOff = tertiaryHeatOn + cfg.Spread
 
 		primaryCoolOn  = baseCool
-		primaryCoolOff = baseCool - env.Cfg.Spread
+		primaryCoolOff = baseCool - cfg.Spread
 
-		secondaryCoolOn  = baseCool + env.Cfg.SecondaryMargin
-		secondaryCoolOff = secondaryCoolOn - env.Cfg.Spread
+		secondaryCoolOn  = baseCool + cfg.SecondaryMargin
+		secondaryCoolOff = secondaryCoolOn - cfg.Spread
 	)
 
 	switch mode {
@@ -254,18 +294,18 @@ func GetThreshold(role string, mode model.SystemMode, active bool) float64 {
 	return 0.0
 }
 
-func (r *SourceRefresher) RefreshSources(dbConn *sql.DB) HeatSources {
+func (r *SourceRefresher) RefreshSources(store Store) HeatSources {
 	var newPrimary *model.HeatPump
 	var newSecondary *model.HeatPump
 	var newTertiary *model.Boiler
 
-	mode, err := db.GetSystemMode(dbConn)
+	mode, err := store.GetSystemMode()
 	if err != nil {
 		shutdown.ShutdownWithError(err, "Could not get system mode")
 	}
 
 	now := time.Now()
-	sources := r.Provider.GetHeatSources(dbConn)
+	sources := r.Provider.GetHeatSources(store)
 
 	offlineCool := !sources.Primary.Online && !sources.Secondary.Online && mode == model.ModeCooling
 	offlineHeat := !sources.Primary.Online && !sources.Secondary.Online && !sources.Tertiary.Online
@@ -274,12 +314,15 @@ func (r *SourceRefresher) RefreshSources(dbConn *sql.DB) HeatSources {
 	}
 
 	if sources.Primary.Online && sources.Secondary.Online {
-		if now.Sub(sources.Primary.LastRotated) > time.Duration(env.Cfg.RoleRotationMinutes)*time.Minute {
+		if now.Sub(sources.Primary.LastRotated) > time.Duration(env.Cfg().RoleRotationMinutes)*time.Minute {
 			log.Info().Msgf("Rotating heat pump primary from %s to %s", sources.Primary.Name, sources.Secondary.Name)
 			newPrimary = sources.Secondary
 			newSecondary = sources.Primary
 
-			err = db.SwapPrimaryHeatPump(dbConn)
+			err = store.SwapPrimaryHeatPump(db.Audit{
+				Actor:  "buffercontroller",
+				Reason: fmt.Sprintf("role rotation after %d minutes", env.Cfg().RoleRotationMinutes),
+			})
 			if err != nil {
 				log.Error().Err(err).Msg("Could not swap primary and secondary heatpumps")
 			}
@@ -316,13 +359,13 @@ func (r *SourceRefresher) RefreshSources(dbConn *sql.DB) HeatSources {
 	}
 }
 
-var GetHeatSources = func(dbConn *sql.DB) HeatSources {
+var GetHeatSources = func(store Store) HeatSources {
 	var primary *model.HeatPump
 	var secondary *model.HeatPump
 	var tertiary *model.Boiler
 	var foundPrimary bool
 
-	hps, err := db.GetHeatPumps(dbConn)
+	hps, err := store.GetHeatPumps()
 	if err != nil {
 		shutdown.ShutdownWithError(err, "Could not retrieve heat pumps from db")
 	}
@@ -344,7 +387,7 @@ var GetHeatSources = func(dbConn *sql.DB) HeatSources {
 		shutdown.ShutdownWithError(fmt.Errorf("no heat pumps marked as primary"), "state validation error")
 	}
 
-	boilers, err := db.GetBoilers(dbConn)
+	boilers, err := store.GetBoilers()
 	if err != nil {
 		shutdown.ShutdownWithError(err, "Could not retrieve boilers from db")
 	}
@@ -10,6 +10,7 @@ import (
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/stretchr/testify/assert"
 
+	"github.com/thatsimonsguy/hvac-controller/db"
 	"github.com/thatsimonsguy/hvac-controller/internal/config"
 	"github.com/thatsimonsguy/hvac-controller/internal/controllers/buffercontroller"
 	"github.com/thatsimonsguy/hvac-controller/internal/device"
@@ -23,12 +24,9 @@ func setupTestDB(t *testing.T) *sql.DB {
 	if err != nil {
 		t.Fatalf("failed to open in-memory DB: %v", err)
 	}
-	schema, err := os.ReadFile("../../../db/schema.sql")
-	if err != nil {
-		t.Fatalf("failed to read schema.sql: %v", err)
-	}
-	_, err = conn.Exec(string(schema))
-	if err != nil {
+	// A single connection keeps every query on the same in-memory database
+	conn.SetMaxOpenConns(1)
+	if _, err := db.Migrate(conn); err != nil {
 		t.Fatalf("failed to apply schema: %v", err)
 	}
 	return conn
@@ -69,14 +67,14 @@ func setTestSystemMode(t *testing.T, db *sql.DB, mode string) {
 
 func OverrideEnvCfg(newCfg *config.Config) (restore func()) {
 	// Save the original config
-	originalCfg := env.Cfg
+	originalCfg := env.Cfg()
 
 	// Override with the new config
-	env.Cfg = newCfg
+	env.SetCfg(newCfg)
 
 	// Return a restore function
 	return func() {
-		env.Cfg = originalCfg
+		env.SetCfg(originalCfg)
 	}
 }
 
@@ -87,7 +85,7 @@ func TestGetHeatSourcesHappyPath(t *testing.T) {
 	insertTestHeatPump(t, dbConn, "hp2", true, false, now, now)
 	insertTestBoiler(t, dbConn, "boiler1", true, now)
 
-	sources := buffercontroller.GetHeatSources(dbConn)
+	sources := buffercontroller.GetHeatSources(db.New(dbConn))
 	assert.NotNil(t, sources.Primary)
 	assert.Equal(t, "hp1", sources.Primary.Name)
 	assert.NotNil(t, sources.Secondary)
@@ -105,7 +103,7 @@ func TestGetHeatSourcesMultiplePrimaries(t *testing.T) {
 			t.Errorf("Expected panic due to multiple primaries, but did not panic")
 		}
 	}()
-	buffercontroller.GetHeatSources(dbConn)
+	buffercontroller.GetHeatSources(db.New(dbConn))
 }
 
 func TestGetHeatSourcesNoPrimary(t *testing.T) {
@@ -118,7 +116,7 @@ func TestGetHeatSourcesNoPrimary(t *testing.T) {
 			t.Errorf("Expected panic due to no primary, but did not panic")
 		}
 	}()
-	buffercontroller.GetHeatSources(dbConn)
+	buffercontroller.GetHeatSources(db.New(dbConn))
 }
 
 func TestGetHeatSourcesQueryError(t *testing.T) {
@@ -129,7 +127,7 @@ func TestGetHeatSourcesQueryError(t *testing.T) {
 			t.Errorf("Expected panic due to query error, but did not panic")
 		}
 	}()
-	buffercontroller.GetHeatSources(dbConn)
+	buffercontroller.GetHeatSources(db.New(dbConn))
 }
 
 // MockHeatSourcesProvider implements HeatSourcesProvider for testing
@@ -137,7 +135,7 @@ type MockHeatSourcesProvider struct {
 	HeatSources buffercontroller.HeatSources
 }
 
-func (m *MockHeatSourcesProvider) GetHeatSources(_ *sql.DB) buffercontroller.HeatSources {
+func (m *MockHeatSourcesProvider) GetHeatSources(_ buffercontroller.Store) buffercontroller.HeatSources {
 	return m.HeatSources
 }
 
@@ -192,7 +190,7 @@ func TestRefreshSourcesHappyPath(t *testing.T) {
 	}
 
 	// Call RefreshSources
-	sources := refresher.RefreshSources(dbConn)
+	sources := refresher.RefreshSources(db.New(dbConn))
 
 	// Assertions
 	assert.NotNil(t, sources.Primary)
@@ -254,7 +252,7 @@ func TestRefreshSourcesRotationHeating(t *testing.T) {
 	}
 
 	// Call RefreshSources
-	sources := refresher.RefreshSources(dbConn)
+	sources := refresher.RefreshSources(db.New(dbConn))
 
 	// Assertions
 	assert.NotNil(t, sources.Primary)
@@ -316,7 +314,7 @@ func TestRefreshSourcesRotationCooling(t *testing.T) {
 	}
 
 	// Call RefreshSources
-	sources := refresher.RefreshSources(dbConn)
+	sources := refresher.RefreshSources(db.New(dbConn))
 
 	// Assertions
 	assert.NotNil(t, sources.Primary)
@@ -377,7 +375,7 @@ func TestRefreshSourcesHeatingNoHeatPumps(t *testing.T) {
 	}
 
 	// Call RefreshSources
-	sources := refresher.RefreshSources(dbConn)
+	sources := refresher.RefreshSources(db.New(dbConn))
 
 	// Assertions
 	assert.Nil(t, sources.Primary)
@@ -437,7 +435,7 @@ func TestRefreshSourcesCoolingNoHeatPumps(t *testing.T) {
 	}
 
 	// Call RefreshSources
-	sources := refresher.RefreshSources(dbConn)
+	sources := refresher.RefreshSources(db.New(dbConn))
 
 	assert.Nil(t, sources.Primary)
 	assert.Nil(t, sources.Secondary)
@@ -495,7 +493,7 @@ func TestRefreshSourcesOnePumpRotationCooling(t *testing.T) {
 	}
 
 	// Call RefreshSources
-	sources := refresher.RefreshSources(dbConn)
+	sources := refresher.RefreshSources(db.New(dbConn))
 
 	// Assertions
 	assert.NotNil(t, sources.Primary)
@@ -555,7 +553,7 @@ func TestRefreshSourcesOnePumpRotationHeating(t *testing.T) {
 	}
 
 	// Call RefreshSources
-	sources := refresher.RefreshSources(dbConn)
+	sources := refresher.RefreshSources(db.New(dbConn))
 
 	// Assertions
 	assert.NotNil(t, sources.Primary)
@@ -594,13 +592,13 @@ func TestShouldBeOn(t *testing.T) {
 
 func TestGetThreshold(t *testing.T) {
 	// Setup fake config
-	env.Cfg = &config.Config{
+	env.SetCfg(&config.Config{
 		HeatingThreshold: 50.0,
 		CoolingThreshold: 70.0,
 		SecondaryMargin:  2.0,
 		TertiaryMargin:   5.0,
 		Spread:           1.0,
-	}
+	})
 
 	tests := []struct {
 		name     string
@@ -610,19 +608,19 @@ func TestGetThreshold(t *testing.T) {
 		expected float64
 	}{
 		{"primary heating inactive", "primary", model.ModeHeating, false, 50.0},
-		{"primary heating active", "primary", model.ModeHeating, true, 50.0 + env.Cfg.Spread},
+		{"primary heating active", "primary", model.ModeHeating, true, 50.0 + env.Cfg().Spread},
 
 		{"secondary heating inactive", "secondary", model.ModeHeating, false, 48.0},
-		{"secondary heating active", "secondary", model.ModeHeating, true, 48.0 + env.Cfg.Spread},
+		{"secondary heating active", "secondary", model.ModeHeating, true, 48.0 + env.Cfg().Spread},
 
 		{"tertiary heating inactive", "tertiary", model.ModeHeating, false, 45.0},
-		{"tertiary heating active", "tertiary", model.ModeHeating, true, 45.0 + env.Cfg.Spread},
+		{"tertiary heating active", "tertiary", model.ModeHeating, true, 45.0 + env.Cfg().Spread},
 
 		{"primary cooling inactive", "primary", model.ModeCooling, false, 70.0},
-		{"primary cooling active", "primary", model.ModeCooling, true, 70.0 - env.Cfg.Spread},
+		{"primary cooling active", "primary", model.ModeCooling, true, 70.0 - env.Cfg().Spread},
 
 		{"secondary cooling inactive", "secondary", model.ModeCooling, false, 72.0},
-		{"secondary cooling active", "secondary", model.ModeCooling, true, 72.0 - env.Cfg.Spread},
+		{"secondary cooling active", "secondary", model.ModeCooling, true, 72.0 - env.Cfg().Spread},
 
 		{"primary active mode off", "primary", model.ModeOff, true, 0.0},
 		{"secondary active mode off", "secondary", model.ModeOff, true, 0.0},
@@ -682,12 +680,12 @@ func TestEvaluateToggleSource(t *testing.T) {
 	defer func() { device.CanToggle = originalCanToggle }()
 
 	// Set config so thresholds are predictable
-	env.Cfg = &config.Config{
+	env.SetCfg(&config.Config{
 		HeatingThreshold: 50.0,
 		CoolingThreshold: 70.0,
 		SecondaryMargin:  2.0,
 		TertiaryMargin:   5.0,
-	}
+	})
 
 	tests := []struct {
 		name       string
@@ -725,7 +723,7 @@ func TestEvaluateToggleSource(t *testing.T) {
 				return tt.canToggle
 			}
 
-			result := buffercontroller.EvaluateToggleSource(tt.role, tt.bt, tt.active, &model.Device{Name: "test"}, tt.mode)
+			result := buffercontroller.EvaluateToggleSource(tt.role, tt.bt, tt.active, &model.Device{Name: "test"}, tt.mode, 0)
 			assert.Equal(t, tt.expectFlip, result)
 		})
 	}
@@ -740,7 +738,7 @@ func TestEvaluateAndToggle(t *testing.T) {
 	// Override evaluateToggleSource for control
 	origEval := buffercontroller.EvaluateToggleSource
 	defer func() { buffercontroller.EvaluateToggleSource = origEval }()
-	buffercontroller.EvaluateToggleSource = func(role string, bt float64, active bool, d *model.Device, mode model.SystemMode) bool {
+	buffercontroller.EvaluateToggleSource = func(role string, bt float64, active bool, d *model.Device, mode model.SystemMode, raise float64) bool {
 		// simulate "should flip"
 		return true
 	}
@@ -748,7 +746,7 @@ func TestEvaluateAndToggle(t *testing.T) {
 	t.Run("should activate when currently off", func(t *testing.T) {
 		activated, deactivated = false, false
 
-		buffercontroller.EvaluateAndToggle("primary", model.Device{Name: "hp1"}, false, 45, model.ModeHeating, mockActivate, mockDeactivate)
+		buffercontroller.EvaluateAndToggle("primary", model.Device{Name: "hp1"}, false, 45, model.ModeHeating, 0, mockActivate, mockDeactivate)
 		assert.True(t, activated)
 		assert.False(t, deactivated)
 	})
@@ -756,7 +754,7 @@ func TestEvaluateAndToggle(t *testing.T) {
 	t.Run("should deactivate when currently on", func(t *testing.T) {
 		activated, deactivated = false, false
 
-		buffercontroller.EvaluateAndToggle("secondary", model.Device{Name: "hp2"}, true, 55, model.ModeHeating, mockActivate, mockDeactivate)
+		buffercontroller.EvaluateAndToggle("secondary", model.Device{Name: "hp2"}, true, 55, model.ModeHeating, 0, mockActivate, mockDeactivate)
 		assert.False(t, activated)
 		assert.True(t, deactivated)
 	})
@@ -765,11 +763,11 @@ func TestEvaluateAndToggle(t *testing.T) {
 		activated, deactivated = false, false
 
 		// simulate "already in correct state"
-		buffercontroller.EvaluateToggleSource = func(role string, bt float64, active bool, d *model.Device, mode model.SystemMode) bool {
+		buffercontroller.EvaluateToggleSource = func(role string, bt float64, active bool, d *model.Device, mode model.SystemMode, raise float64) bool {
 			return false
 		}
 
-		buffercontroller.EvaluateAndToggle("tertiary", model.Device{Name: "boil1"}, false, 60, model.ModeHeating, mockActivate, mockDeactivate)
+		buffercontroller.EvaluateAndToggle("tertiary", model.Device{Name: "boil1"}, false, 60, model.ModeHeating, 0, mockActivate, mockDeactivate)
 		assert.False(t, activated)
 		assert.False(t, deactivated)
 	})
@@ -777,7 +775,7 @@ func TestEvaluateAndToggle(t *testing.T) {
 	t.Run("mode is off, source is off, no toggle should occur", func(t *testing.T) {
 		activated, deactivated = false, false
 
-		buffercontroller.EvaluateAndToggle("primary", model.Device{Name: "offcase"}, false, 45, model.ModeOff, mockActivate, mockDeactivate)
+		buffercontroller.EvaluateAndToggle("primary", model.Device{Name: "offcase"}, false, 45, model.ModeOff, 0, mockActivate, mockDeactivate)
 		assert.False(t, activated)
 		assert.False(t, deactivated)
 	})
@@ -785,7 +783,7 @@ func TestEvaluateAndToggle(t *testing.T) {
 	t.Run("mode is circulate, source is off, no toggle should occur", func(t *testing.T) {
 		activated, deactivated = false, false
 
-		buffercontroller.EvaluateAndToggle("primary", model.Device{Name: "circ"}, false, 45, model.ModeCirculate, mockActivate, mockDeactivate)
+		buffercontroller.EvaluateAndToggle("primary", model.Device{Name: "circ"}, false, 45, model.ModeCirculate, 0, mockActivate, mockDeactivate)
 		assert.False(t, activated)
 		assert.False(t, deactivated)
 	})
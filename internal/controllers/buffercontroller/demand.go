@@ -0,0 +1,190 @@
+package buffercontroller
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/thatsimonsguy/hvac-controller/internal/datadog"
+	"github.com/thatsimonsguy/hvac-controller/internal/env"
+	"github.com/thatsimonsguy/hvac-controller/internal/model"
+)
+
+// ZoneDemand returns each heating zone's weighted demand, see model.Zone.Demand, and their total.
+// Zones without a fresh reading are left out.
+func ZoneDemand(zones []model.Zone, tempService TemperatureService) (total float64, demand map[string]float64) {
+	demand = make(map[string]float64)
+	for _, zone := range zones {
+		if zone.Mode != model.ModeHeating {
+			continue
+		}
+		reading, ok := tempService.GetZoneReading(zone)
+		if !ok || !reading.FreshEnough(env.Cfg().MaxReadingAge()) {
+			continue
+		}
+		demand[zone.ID] = zone.Demand(reading.Temperature)
+		total += demand[zone.ID]
+	}
+	return total, demand
+}
+
+// DemandReset returns how far to move the buffer heating target for total weighted zone demand,
+// idle being how long it has been since a zone last called. Calling zones raise the target, up to
+// demand_reset_max_raise at demand_reset_full_demand. Once no zone calls it falls steadily,
+// reaching demand_reset_max_lower after demand_reset_coast_hours, and the tank coasts there.
+func DemandReset(demand float64, idle time.Duration) float64 {
+	cfg := env.Cfg()
+	if demand > 0 {
+		if cfg.DemandResetMaxRaise <= 0 {
+			return 0
+		}
+		return cfg.DemandResetMaxRaise * math.Min(1, demand/cfg.DemandResetFullDemand)
+	}
+
+	if cfg.DemandResetMaxLower <= 0 {
+		return 0
+	}
+	coast := time.Duration(cfg.DemandResetCoastHours) * time.Hour
+	if idle >= coast {
+		return -cfg.DemandResetMaxLower
+	}
+	return -cfg.DemandResetMaxLower * idle.Seconds() / coast.Seconds()
+}
+
+// DemandStager arbitrates zone heating demand each buffer cycle, remembering whether demand is
+// high so pre-staging doesn't flap around the threshold, and when a zone last called.
+type DemandStager struct {
+	high     bool
+	lastCall time.Time
+	coasting bool
+}
+
+// Update returns how far to move the heating target this cycle, see DemandReset, and how much
+// further to raise the secondary heat pump's thresholds. While total demand is at or above
+// demand_prestage_threshold the secondary starts with the primary instead of waiting for the
+// buffer to fall secondary_margin further, until demand drops to half the threshold. Heating
+// capacity is limited while no heat pump is online, or demand is high with no secondary to stage;
+// low-priority zones are shed for as long as it is.
+func (s *DemandStager) Update(store Store, tempService TemperatureService, mode model.SystemMode, sources HeatSources) (reset, prestage float64) {
+	zones, err := store.GetAllZones()
+	if err != nil {
+		log.Error().Err(err).Msg("Could not retrieve zones for demand arbitration")
+		return 0, 0
+	}
+
+	cfg := env.Cfg()
+	now := time.Now()
+	var total float64
+	if mode == model.ModeHeating {
+		var demand map[string]float64
+		total, demand = ZoneDemand(zones, tempService)
+		calling := 0
+		for id, d := range demand {
+			datadog.Gauge("zone.demand", d, "component:controller", fmt.Sprintf("zone:%s", id))
+			if d > 0 {
+				calling++
+			}
+		}
+		datadog.Gauge("zones.demand", total, "component:controller")
+
+		// Count starting up or switching to heating as a call, so the tank doesn't coast straight away
+		if calling > 0 || s.lastCall.IsZero() {
+			s.lastCall = now
+		}
+		reset = DemandReset(total, now.Sub(s.lastCall))
+		datadog.Gauge("buffer_tank.heating_reset", reset, "component:controller")
+		log.Debug().
+			Int("calling_zones", calling).
+			Dur("since_last_call", now.Sub(s.lastCall)).
+			Float64("heating_reset", reset).
+			Msg("Evaluating demand reset")
+	} else {
+		s.lastCall = time.Time{}
+	}
+	s.setCoasting(cfg.DemandResetMaxLower > 0 && reset <= -cfg.DemandResetMaxLower)
+
+	threshold := cfg.DemandPrestageThreshold
+	switch {
+	case mode != model.ModeHeating || threshold <= 0:
+		s.high = false
+	case s.high:
+		s.high = total > threshold/2
+	default:
+		s.high = total >= threshold
+	}
+
+	limited := mode == model.ModeHeating && (sources.Primary == nil || (s.high && sources.Secondary == nil))
+	shedZones(store, zones, limited)
+
+	log.Debug().
+		Float64("demand", total).
+		Float64("prestage_threshold", threshold).
+		Bool("high_demand", s.high).
+		Bool("capacity_limited", limited).
+		Msg("Evaluating zone demand")
+
+	if s.high && sources.Secondary != nil {
+		prestage = cfg.SecondaryMargin
+	}
+	return reset, prestage
+}
+
+func (s *DemandStager) setCoasting(coasting bool) {
+	if coasting == s.coasting {
+		return
+	}
+	s.coasting = coasting
+
+	if !coasting {
+		log.Info().Msg("Buffer tank no longer coasting")
+		return
+	}
+	log.Info().
+		Dur("since_last_call", time.Since(s.lastCall)).
+		Float64("heating_target", env.Cfg().HeatingThreshold-env.Cfg().DemandResetMaxLower).
+		Msg("No zone has called for heat - buffer tank coasting")
+}
+
+// shedZones sheds sheddable heating zones while capacity is limited and restores them once it
+// isn't, recording the change on each zone for its zone controller.
+func shedZones(store Store, zones []model.Zone, limited bool) {
+	var shed, restored []string
+	now := time.Now()
+	for _, zone := range zones {
+		want := limited && zone.Mode == model.ModeHeating && env.Cfg().Sheddable(zone)
+		if want == (zone.ShedSince != nil) {
+			continue
+		}
+
+		var since *time.Time
+		if want {
+			since = &now
+		}
+		if err := store.SetZoneShed(zone.ID, since); err != nil {
+			log.Error().Err(err).Str("zone", zone.ID).Msg("Failed to record zone shedding")
+			continue
+		}
+		if want {
+			shed = append(shed, zone.Label)
+		} else {
+			restored = append(restored, zone.Label)
+		}
+	}
+
+	if len(restored) > 0 {
+		log.Info().Strs("zones", restored).Msg("Heating capacity restored - zones no longer shed")
+	}
+	if len(shed) == 0 {
+		return
+	}
+
+	log.Warn().Strs("zones", shed).Msg("Heating capacity limited - shedding low-priority zones")
+	message := fmt.Sprintf("Heating capacity is limited, so %s won't be heated until more heat sources are available",
+		strings.Join(shed, ", "))
+	if err := sendAlert("Zones shed", message); err != nil {
+		log.Error().Err(err).Msg("Failed to send zone shedding alert")
+	}
+}
@@ -0,0 +1,172 @@
+package buffercontroller_test
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/thatsimonsguy/hvac-controller/db"
+	"github.com/thatsimonsguy/hvac-controller/internal/config"
+	"github.com/thatsimonsguy/hvac-controller/internal/controllers/buffercontroller"
+	"github.com/thatsimonsguy/hvac-controller/internal/env"
+	"github.com/thatsimonsguy/hvac-controller/internal/model"
+	"github.com/thatsimonsguy/hvac-controller/internal/temperature"
+)
+
+func insertTestZone(t *testing.T, conn *sql.DB, id string, mode model.SystemMode, setpoint, priority float64) {
+	_, err := conn.Exec(`INSERT INTO sensors (id, bus) VALUES (?, '28-000000000001')`, id+"_sensor")
+	require.NoError(t, err)
+	_, err = conn.Exec(`INSERT INTO zones (id, label, setpoint, mode, capabilities, sensor_id, priority) VALUES (?, ?, ?, ?, '["heating"]', ?, ?)`,
+		id, id, setpoint, mode, id+"_sensor", priority)
+	require.NoError(t, err)
+}
+
+func shedZones(t *testing.T, repo *db.Repository) []string {
+	zones, err := repo.GetAllZones()
+	require.NoError(t, err)
+	shed := []string{}
+	for _, z := range zones {
+		if z.ShedSince != nil {
+			shed = append(shed, z.ID)
+		}
+	}
+	return shed
+}
+
+// prestaged drops the heating reset from DemandStager.Update.
+func prestaged(_, prestage float64) float64 {
+	return prestage
+}
+
+func TestZoneDemand(t *testing.T) {
+	defer OverrideEnvCfg(&config.Config{PollIntervalSeconds: 30, MaxReadingAgeSeconds: 90})()
+
+	temps := fakeTemps{zones: map[string]temperature.SensorReading{
+		"main_floor": {Temperature: 66, Quality: temperature.QualityFresh},
+		"garage":     {Temperature: 50, Quality: temperature.QualityFresh},
+		"basement":   {Temperature: 72, Quality: temperature.QualityFresh},
+		"sunroom":    {Temperature: 40, Quality: temperature.QualityStale, Age: time.Hour},
+	}}
+	zones := []model.Zone{
+		{ID: "main_floor", Mode: model.ModeHeating, Setpoint: 70, Priority: 2},
+		{ID: "garage", Mode: model.ModeHeating, Setpoint: 55, Priority: 0.5},
+		{ID: "basement", Mode: model.ModeHeating, Setpoint: 70}, // above setpoint
+		{ID: "sunroom", Mode: model.ModeHeating, Setpoint: 70},  // stale reading is ignored
+		{ID: "office", Mode: model.ModeOff, Setpoint: 70},
+	}
+
+	total, demand := buffercontroller.ZoneDemand(zones, temps)
+	assert.Equal(t, 10.5, total)
+	assert.Equal(t, map[string]float64{"main_floor": 8, "garage": 2.5, "basement": 0}, demand)
+}
+
+func TestDemandStager(t *testing.T) {
+	defer OverrideEnvCfg(&config.Config{
+		PollIntervalSeconds:     30,
+		SecondaryMargin:         10,
+		DemandPrestageThreshold: 6,
+		DemandShedPriority:      1,
+	})()
+
+	conn := setupTestDB(t)
+	defer conn.Close()
+	insertTestZone(t, conn, "main_floor", model.ModeHeating, 70, 1)
+	insertTestZone(t, conn, "garage", model.ModeHeating, 55, 0.5)
+	repo := db.New(conn)
+
+	fresh := func(temp float64) temperature.SensorReading {
+		return temperature.SensorReading{Temperature: temp, Quality: temperature.QualityFresh}
+	}
+	temps := fakeTemps{zones: map[string]temperature.SensorReading{"main_floor": fresh(66), "garage": fresh(54)}}
+	pumps := buffercontroller.HeatSources{Primary: &model.HeatPump{}, Secondary: &model.HeatPump{}}
+	boilerOnly := buffercontroller.HeatSources{Tertiary: &model.Boiler{}}
+
+	var stager buffercontroller.DemandStager
+
+	// Demand of 4.5 is below the threshold, so the secondary waits on the buffer
+	assert.Equal(t, 0.0, prestaged(stager.Update(repo, temps, model.ModeHeating, pumps)))
+	assert.Empty(t, shedZones(t, repo))
+
+	// With only the boiler online the garage is shed
+	assert.Equal(t, 0.0, prestaged(stager.Update(repo, temps, model.ModeHeating, boilerOnly)))
+	assert.Equal(t, []string{"garage"}, shedZones(t, repo))
+
+	// High demand stages the secondary early, and heat pumps coming back restore the garage
+	temps.zones["main_floor"] = fresh(64)
+	assert.Equal(t, 10.0, prestaged(stager.Update(repo, temps, model.ModeHeating, pumps)))
+	assert.Empty(t, shedZones(t, repo))
+
+	// High demand with no secondary to stage sheds instead
+	assert.Equal(t, 0.0, prestaged(stager.Update(repo, temps, model.ModeHeating, buffercontroller.HeatSources{Primary: &model.HeatPump{}})))
+	assert.Equal(t, []string{"garage"}, shedZones(t, repo))
+
+	// Pre-staging holds until demand drops to half the threshold
+	temps.zones["main_floor"] = fresh(67)
+	assert.Equal(t, 10.0, prestaged(stager.Update(repo, temps, model.ModeHeating, pumps)))
+	temps.zones["main_floor"] = fresh(69)
+	assert.Equal(t, 0.0, prestaged(stager.Update(repo, temps, model.ModeHeating, pumps)))
+
+	// Nothing is shed outside heating
+	assert.Equal(t, 0.0, prestaged(stager.Update(repo, temps, model.ModeHeating, boilerOnly)))
+	assert.Equal(t, []string{"garage"}, shedZones(t, repo))
+	assert.Equal(t, 0.0, prestaged(stager.Update(repo, temps, model.ModeCooling, boilerOnly)))
+	assert.Empty(t, shedZones(t, repo))
+}
+
+func TestDemandReset(t *testing.T) {
+	defer OverrideEnvCfg(&config.Config{
+		DemandResetMaxRaise:   5,
+		DemandResetFullDemand: 10,
+		DemandResetMaxLower:   20,
+		DemandResetCoastHours: 4,
+	})()
+
+	// Calling zones raise the target with demand, up to the max
+	assert.Equal(t, 2.0, buffercontroller.DemandReset(4, 0))
+	assert.Equal(t, 5.0, buffercontroller.DemandReset(25, 0))
+
+	// With no call the target falls until the tank coasts
+	assert.Equal(t, 0.0, buffercontroller.DemandReset(0, 0))
+	assert.Equal(t, -5.0, buffercontroller.DemandReset(0, time.Hour))
+	assert.Equal(t, -20.0, buffercontroller.DemandReset(0, 4*time.Hour))
+	assert.Equal(t, -20.0, buffercontroller.DemandReset(0, 12*time.Hour))
+
+	// Disabled by default
+	env.SetCfg(&config.Config{})
+	assert.Equal(t, 0.0, buffercontroller.DemandReset(25, 0))
+	assert.Equal(t, 0.0, buffercontroller.DemandReset(0, 12*time.Hour))
+}
+
+func TestDemandStager_Reset(t *testing.T) {
+	defer OverrideEnvCfg(&config.Config{
+		PollIntervalSeconds:   30,
+		DemandResetMaxRaise:   5,
+		DemandResetFullDemand: 10,
+		DemandResetMaxLower:   20,
+	})()
+
+	conn := setupTestDB(t)
+	defer conn.Close()
+	insertTestZone(t, conn, "main_floor", model.ModeHeating, 70, 1)
+	repo := db.New(conn)
+
+	temps := fakeTemps{zones: map[string]temperature.SensorReading{
+		"main_floor": {Temperature: 66, Quality: temperature.QualityFresh},
+	}}
+	pumps := buffercontroller.HeatSources{Primary: &model.HeatPump{}, Secondary: &model.HeatPump{}}
+
+	var stager buffercontroller.DemandStager
+	reset, _ := stager.Update(repo, temps, model.ModeHeating, pumps)
+	assert.Equal(t, 2.0, reset)
+
+	// Without coast hours the target drops as soon as no zone is calling
+	temps.zones["main_floor"] = temperature.SensorReading{Temperature: 71, Quality: temperature.QualityFresh}
+	reset, _ = stager.Update(repo, temps, model.ModeHeating, pumps)
+	assert.Equal(t, -20.0, reset)
+
+	reset, _ = stager.Update(repo, temps, model.ModeCooling, pumps)
+	assert.Equal(t, 0.0, reset)
+}
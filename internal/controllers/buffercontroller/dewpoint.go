@@ -0,0 +1,155 @@
+package buffercontroller
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/thatsimonsguy/hvac-controller/internal/datadog"
+	"github.com/thatsimonsguy/hvac-controller/internal/device"
+	"github.com/thatsimonsguy/hvac-controller/internal/env"
+	"github.com/thatsimonsguy/hvac-controller/internal/gpio"
+	"github.com/thatsimonsguy/hvac-controller/internal/model"
+	"github.com/thatsimonsguy/hvac-controller/internal/notifications"
+	"github.com/thatsimonsguy/hvac-controller/internal/temperature"
+)
+
+var sendAlert = notifications.Send
+
+// Magnus approximation coefficients, good to within a few tenths of a degree for indoor air
+const (
+	magnusB = 17.62
+	magnusC = 243.12 // °C
+)
+
+// DewPoint returns the dew point in Fahrenheit of air at tempF and relative humidity rh (percent).
+func DewPoint(tempF, rh float64) float64 {
+	if rh <= 0 {
+		return math.Inf(-1)
+	}
+	c := (tempF - 32.0) * 5.0 / 9.0
+	gamma := math.Log(rh/100.0) + magnusB*c/(magnusC+c)
+	return (magnusC*gamma/(magnusB-gamma))*9.0/5.0 + 32.0
+}
+
+// IndoorDewPoint returns the highest dew point among zones with a humidity sensor and fresh
+// temperature and humidity readings, and the zone it was found in.
+func IndoorDewPoint(zones []model.Zone, tempService TemperatureService) (dewPoint float64, zoneID string, ok bool) {
+	for _, zone := range zones {
+		if zone.HumiditySensor == nil {
+			continue
+		}
+		reading, found := tempService.GetZoneReading(zone)
+		if !found || !reading.FreshEnough(env.Cfg().MaxReadingAge()) {
+			continue
+		}
+		humidity, found := tempService.GetHumidity(zone.HumiditySensor.ID)
+		if !found || humidity.Quality != temperature.QualityFresh {
+			continue
+		}
+		dp := DewPoint(reading.Temperature, humidity.Humidity)
+		if !ok || dp > dewPoint {
+			dewPoint, zoneID, ok = dp, zone.ID, true
+		}
+	}
+	return dewPoint, zoneID, ok
+}
+
+// DewPointRaise returns how far the cooling thresholds must rise to keep the buffer tank
+// dew_point_margin above dewPoint. It is 0 when cooling_threshold is already warm enough or
+// dew point protection is disabled.
+func DewPointRaise(dewPoint float64) float64 {
+	cfg := env.Cfg()
+	if cfg.DewPointMargin <= 0 {
+		return 0
+	}
+	return math.Max(0, dewPoint+cfg.DewPointMargin-cfg.CoolingThreshold)
+}
+
+// CondensationRisk reports whether chilled water at bufferTemp would sweat in air at dewPoint.
+func CondensationRisk(bufferTemp, dewPoint float64) bool {
+	return bufferTemp < dewPoint+env.Cfg().DewPointMargin
+}
+
+// dewPointGuard applies dew point protection each buffer cycle, remembering whether it is already
+// limiting cooling so it only alerts when that changes.
+type dewPointGuard struct {
+	limiting bool
+}
+
+// update returns how far to raise the cooling thresholds this cycle and shuts off radiant cooling
+// while the buffer tank is cold enough to cause condensation.
+func (g *dewPointGuard) update(store Store, tempService TemperatureService, mode model.SystemMode, bufferTemp float64) float64 {
+	if mode != model.ModeCooling || env.Cfg().DewPointMargin <= 0 {
+		g.setLimiting(false, 0, "", 0)
+		return 0
+	}
+
+	zones, err := store.GetAllZones()
+	if err != nil {
+		log.Error().Err(err).Msg("Could not retrieve zones for dew point protection")
+		return 0
+	}
+	dewPoint, zoneID, ok := IndoorDewPoint(zones, tempService)
+	if !ok {
+		log.Debug().Msg("No indoor humidity readings - dew point protection inactive")
+		g.setLimiting(false, 0, "", 0)
+		return 0
+	}
+	datadog.Gauge("indoor.dew_point", dewPoint, "component:sensor", fmt.Sprintf("zone:%s", zoneID))
+
+	if CondensationRisk(bufferTemp, dewPoint) {
+		refuseRadiantCooling(store, bufferTemp, dewPoint)
+	}
+
+	raise := DewPointRaise(dewPoint)
+	g.setLimiting(raise > 0, dewPoint, zoneID, raise)
+	return raise
+}
+
+func (g *dewPointGuard) setLimiting(limiting bool, dewPoint float64, zoneID string, raise float64) {
+	if limiting == g.limiting {
+		return
+	}
+	g.limiting = limiting
+
+	if !limiting {
+		log.Info().Msg("Dew point protection no longer limiting cooling")
+		return
+	}
+
+	target := env.Cfg().CoolingThreshold + raise
+	log.Warn().
+		Str("zone", zoneID).
+		Float64("dew_point", dewPoint).
+		Float64("cooling_target", target).
+		Msg("Dew point protection limiting cooling")
+	message := fmt.Sprintf("Indoor dew point is %.1f°F in %s, so the buffer tank is held at %.1f°F instead of %.1f°F",
+		dewPoint, zoneID, target, env.Cfg().CoolingThreshold)
+	if err := sendAlert("Cooling limited by dew point", message); err != nil {
+		log.Error().Err(err).Msg("Failed to send dew point alert")
+	}
+}
+
+// refuseRadiantCooling turns off any radiant loop carrying chilled water that would condense on the
+// floor. Condensation does damage, so the loop's minimum run time is not honored.
+func refuseRadiantCooling(store Store, bufferTemp, dewPoint float64) {
+	loops, err := store.GetRadiantLoops()
+	if err != nil {
+		log.Error().Err(err).Msg("Could not retrieve radiant loops for dew point protection")
+		return
+	}
+	for i := range loops {
+		loop := loops[i]
+		if !gpio.CurrentlyActive(loop.Pin) {
+			continue
+		}
+		log.Warn().
+			Str("device", loop.Name).
+			Float64("buffer_temp", bufferTemp).
+			Float64("dew_point", dewPoint).
+			Msg("Condensation risk - refusing radiant cooling")
+		device.DeactivateRadiantLoop(&loop, store)
+	}
+}
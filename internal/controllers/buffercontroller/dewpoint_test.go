@@ -0,0 +1,98 @@
+package buffercontroller_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/thatsimonsguy/hvac-controller/internal/config"
+	"github.com/thatsimonsguy/hvac-controller/internal/controllers/buffercontroller"
+	"github.com/thatsimonsguy/hvac-controller/internal/device"
+	"github.com/thatsimonsguy/hvac-controller/internal/model"
+	"github.com/thatsimonsguy/hvac-controller/internal/temperature"
+)
+
+type fakeTemps struct {
+	zones    map[string]temperature.SensorReading
+	humidity map[string]temperature.HumidityReading
+}
+
+func (f fakeTemps) GetReading(sensorID string) (temperature.SensorReading, bool) {
+	return temperature.SensorReading{}, false
+}
+
+func (f fakeTemps) GetZoneReading(zone model.Zone) (temperature.SensorReading, bool) {
+	r, ok := f.zones[zone.ID]
+	return r, ok
+}
+
+func (f fakeTemps) GetHumidity(sensorID string) (temperature.HumidityReading, bool) {
+	r, ok := f.humidity[sensorID]
+	return r, ok
+}
+
+func TestDewPoint(t *testing.T) {
+	assert.InDelta(t, 56.9, buffercontroller.DewPoint(77, 50), 0.1)
+	assert.InDelta(t, 68.0, buffercontroller.DewPoint(68, 100), 0.01)
+	assert.InDelta(t, 41.3, buffercontroller.DewPoint(72, 33), 0.1)
+}
+
+func TestIndoorDewPoint(t *testing.T) {
+	defer OverrideEnvCfg(&config.Config{PollIntervalSeconds: 30, MaxReadingAgeSeconds: 90})()
+
+	fresh := func(temp float64) temperature.SensorReading {
+		return temperature.SensorReading{Temperature: temp, Quality: temperature.QualityFresh}
+	}
+	temps := fakeTemps{
+		zones: map[string]temperature.SensorReading{
+			"main_floor": fresh(75),
+			"basement":   fresh(68),
+			"garage":     fresh(80),
+		},
+		humidity: map[string]temperature.HumidityReading{
+			"main_rh":     {Humidity: 50, Quality: temperature.QualityFresh},
+			"basement_rh": {Humidity: 70, Quality: temperature.QualityFresh},
+			"garage_rh":   {Humidity: 90, Quality: temperature.QualityStale, Age: time.Hour},
+		},
+	}
+	zones := []model.Zone{
+		{ID: "main_floor", HumiditySensor: &model.Sensor{ID: "main_rh"}},
+		{ID: "basement", HumiditySensor: &model.Sensor{ID: "basement_rh"}},
+		{ID: "garage", HumiditySensor: &model.Sensor{ID: "garage_rh"}}, // stale humidity is ignored
+		{ID: "sunroom"}, // no humidity sensor
+	}
+
+	dewPoint, zoneID, ok := buffercontroller.IndoorDewPoint(zones, temps)
+	assert.True(t, ok)
+	assert.Equal(t, "basement", zoneID)
+	assert.InDelta(t, buffercontroller.DewPoint(68, 70), dewPoint, 0.001)
+
+	_, _, ok = buffercontroller.IndoorDewPoint(zones[2:], temps)
+	assert.False(t, ok)
+}
+
+func TestDewPointRaise(t *testing.T) {
+	defer OverrideEnvCfg(&config.Config{CoolingThreshold: 40, DewPointMargin: 4})()
+
+	assert.Equal(t, 0.0, buffercontroller.DewPointRaise(30))
+	assert.InDelta(t, 14.0, buffercontroller.DewPointRaise(50), 0.001)
+	assert.True(t, buffercontroller.CondensationRisk(45, 50))
+	assert.True(t, buffercontroller.CondensationRisk(52, 50))
+	assert.False(t, buffercontroller.CondensationRisk(55, 50))
+
+	// A zero margin turns protection off
+	defer OverrideEnvCfg(&config.Config{CoolingThreshold: 40})()
+	assert.Equal(t, 0.0, buffercontroller.DewPointRaise(50))
+}
+
+func TestEvaluateToggleSource_CoolingRaise(t *testing.T) {
+	defer OverrideEnvCfg(&config.Config{CoolingThreshold: 40, Spread: 5})()
+	originalCanToggle := device.CanToggle
+	defer func() { device.CanToggle = originalCanToggle }()
+	device.CanToggle = func(d *model.Device, now time.Time) bool { return true }
+
+	// A buffer at 50°F is warm enough to start cooling, unless the target has been raised above it
+	assert.True(t, buffercontroller.EvaluateToggleSource("primary", 50, false, &model.Device{Name: "hp1"}, model.ModeCooling, 0))
+	assert.False(t, buffercontroller.EvaluateToggleSource("primary", 50, false, &model.Device{Name: "hp1"}, model.ModeCooling, 14))
+}
@@ -1,19 +1,17 @@
 package buffercontroller
 
 import (
-	"database/sql"
 	"time"
 
 	"github.com/rs/zerolog/log"
-	"github.com/thatsimonsguy/hvac-controller/db"
 	"github.com/thatsimonsguy/hvac-controller/internal/device"
 	"github.com/thatsimonsguy/hvac-controller/internal/gpio"
 	"github.com/thatsimonsguy/hvac-controller/internal/model"
 	"github.com/thatsimonsguy/hvac-controller/system/startup"
 )
 
-func SetSystemMode(dbConn *sql.DB, mode model.SystemMode) error {
-	hps, err := db.GetHeatPumps(dbConn)
+func SetSystemMode(store Store, mode model.SystemMode) error {
+	hps, err := store.GetHeatPumps()
 	if err != nil {
 		return err
 	}
@@ -32,7 +30,7 @@ func SetSystemMode(dbConn *sql.DB, mode model.SystemMode) error {
 			canToggle,
 			online,
 			hp.MinOn,
-			func() { device.DeactivateHeatPump(&hp, dbConn) },
+			func() { device.DeactivateHeatPump(&hp, store) },
 			time.Sleep)
 
 		if should && modeActive {
@@ -49,7 +47,7 @@ func SetSystemMode(dbConn *sql.DB, mode model.SystemMode) error {
 
 	// rewrite the startup script to reflect the current state of the DB so validation will pass on reboot if there's a crash or power failure
 	if swapped {
-		if err := startup.WriteStartupScript(dbConn); err != nil {
+		if err := startup.WriteStartupScript(store); err != nil {
 			log.Error().Err(err).Msg("failed to rewrite pinsetter script")
 			return err
 		}
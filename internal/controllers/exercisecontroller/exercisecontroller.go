@@ -0,0 +1,149 @@
+package exercisecontroller
+
+import (
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/thatsimonsguy/hvac-controller/internal/device"
+	"github.com/thatsimonsguy/hvac-controller/internal/env"
+	"github.com/thatsimonsguy/hvac-controller/internal/gpio"
+	"github.com/thatsimonsguy/hvac-controller/internal/model"
+)
+
+// lastRunResolution is how often a pump running for demand has its last run recorded. Exercise
+// intervals are in hours, so an hour is precise enough and spares the database a write every poll.
+const lastRunResolution = time.Hour
+
+var activatePin = gpio.Activate
+var deactivatePin = gpio.Deactivate
+var currentlyActive = gpio.CurrentlyActive
+var canToggle = device.CanToggle
+
+// Store is the device and pump exercise state the exercise controller reads and sets.
+type Store interface {
+	GetAirHandlers() ([]model.AirHandler, error)
+	GetRadiantLoops() ([]model.RadiantFloorLoop, error)
+	GetPumpExerciseStatus(deviceName string) (active bool, startedAt, lastRun time.Time, err error)
+	SetPumpExerciseActive(deviceName string, active bool, at time.Time) error
+	RecordPumpRun(deviceName string, at time.Time) error
+}
+
+// pump is a pump that can seize when left idle: an air handler's circulator or a radiant loop's
+// zone pump. Only the pump's own pin is switched, an air handler's blower is left alone.
+type pump struct {
+	device *model.Device
+	pin    model.GPIOPin
+	busy   bool // the device is running without its pump, e.g. an air handler recirculating
+}
+
+// RunExerciseController briefly runs every circulator and radiant loop pump that has been idle for
+// pump_exercise_interval_hours. Zone controllers treat a pump being exercised as idle, so real
+// demand takes it over and the exercise run ends early.
+func RunExerciseController(store Store) {
+	go func() {
+		log.Info().Msg("Starting pump exercise controller")
+
+		time.Sleep(5 * time.Minute)
+
+		for {
+			time.Sleep(time.Duration(env.Cfg().PollIntervalSeconds) * time.Second)
+
+			cfg := env.Cfg()
+			if cfg.PumpExerciseIntervalHours <= 0 {
+				continue
+			}
+			interval := time.Duration(cfg.PumpExerciseIntervalHours) * time.Hour
+			duration := time.Duration(cfg.PumpExerciseSeconds) * time.Second
+
+			pumps, err := gatherPumps(store)
+			if err != nil {
+				log.Error().Err(err).Msg("Could not retrieve pumps for exercise")
+				continue
+			}
+			for _, p := range pumps {
+				evaluateExercise(p, store, time.Now(), interval, duration)
+			}
+		}
+	}()
+}
+
+func gatherPumps(store Store) ([]pump, error) {
+	handlers, err := store.GetAirHandlers()
+	if err != nil {
+		return nil, err
+	}
+	loops, err := store.GetRadiantLoops()
+	if err != nil {
+		return nil, err
+	}
+
+	var pumps []pump
+	for i := range handlers {
+		ah := &handlers[i]
+		pumps = append(pumps, pump{device: &ah.Device, pin: ah.CircPumpPin, busy: currentlyActive(ah.Pin)})
+	}
+	for i := range loops {
+		rl := &loops[i]
+		pumps = append(pumps, pump{device: &rl.Device, pin: rl.Pin})
+	}
+	return pumps, nil
+}
+
+// evaluateExercise starts an exercise run for a pump idle longer than interval, and ends one that
+// has lasted duration. A run whose device has been switched since it started was taken over by
+// demand (or shut off by another controller) and is left as it is.
+func evaluateExercise(p pump, store Store, now time.Time, interval, duration time.Duration) {
+	name := p.device.Name
+	exercising, startedAt, lastRun, err := store.GetPumpExerciseStatus(name)
+	if err != nil {
+		log.Error().Err(err).Str("device", name).Msg("Failed to check pump exercise status")
+		return
+	}
+	running := currentlyActive(p.pin)
+
+	if exercising {
+		switch {
+		case p.device.LastChanged.After(startedAt) || !running:
+			log.Info().Str("device", name).Msg("Pump exercise taken over by another controller")
+		case now.Sub(startedAt) >= duration:
+			log.Info().Str("device", name).Dur("duration", now.Sub(startedAt)).Msg("Pump exercise complete")
+			deactivatePin(p.pin)
+		default:
+			return
+		}
+		if err := store.SetPumpExerciseActive(name, false, now); err != nil {
+			log.Error().Err(err).Str("device", name).Msg("Failed to clear pump exercise")
+		}
+		return
+	}
+
+	if running {
+		if now.Sub(lastRun) >= lastRunResolution {
+			if err := store.RecordPumpRun(name, now); err != nil {
+				log.Error().Err(err).Str("device", name).Msg("Failed to record pump run")
+			}
+		}
+		return
+	}
+
+	idle := now.Sub(lastRun)
+	if idle < interval || !p.device.Online || p.busy {
+		return
+	}
+	if !canToggle(p.device, now) {
+		log.Debug().Str("device", name).Msg("Pump due for exercise but device can't toggle yet")
+		return
+	}
+
+	log.Info().
+		Str("device", name).
+		Dur("idle", idle).
+		Dur("duration", duration).
+		Msg("Exercising idle pump")
+	if err := store.SetPumpExerciseActive(name, true, now); err != nil {
+		log.Error().Err(err).Str("device", name).Msg("Failed to mark pump exercise active")
+		return
+	}
+	activatePin(p.pin)
+}
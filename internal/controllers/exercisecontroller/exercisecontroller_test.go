@@ -0,0 +1,144 @@
+package exercisecontroller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/thatsimonsguy/hvac-controller/internal/model"
+)
+
+type fakeStore struct {
+	active    bool
+	startedAt time.Time
+	lastRun   time.Time
+	recorded  bool
+}
+
+func (f *fakeStore) GetAirHandlers() ([]model.AirHandler, error)        { return nil, nil }
+func (f *fakeStore) GetRadiantLoops() ([]model.RadiantFloorLoop, error) { return nil, nil }
+
+func (f *fakeStore) GetPumpExerciseStatus(string) (bool, time.Time, time.Time, error) {
+	return f.active, f.startedAt, f.lastRun, nil
+}
+
+func (f *fakeStore) SetPumpExerciseActive(_ string, active bool, at time.Time) error {
+	f.active = active
+	if active {
+		f.startedAt = at
+	} else {
+		f.startedAt = time.Time{}
+		f.lastRun = at
+	}
+	return nil
+}
+
+func (f *fakeStore) RecordPumpRun(_ string, at time.Time) error {
+	f.lastRun = at
+	f.recorded = true
+	return nil
+}
+
+// fakePins stands in for the GPIO and toggle checks and counts pump switching.
+func fakePins(t *testing.T, running *bool, toggle bool) (activated, deactivated *int) {
+	origActivate, origDeactivate, origActive, origToggle := activatePin, deactivatePin, currentlyActive, canToggle
+	t.Cleanup(func() {
+		activatePin, deactivatePin, currentlyActive, canToggle = origActivate, origDeactivate, origActive, origToggle
+	})
+
+	activated, deactivated = new(int), new(int)
+	activatePin = func(model.GPIOPin) { *activated++; *running = true }
+	deactivatePin = func(model.GPIOPin) { *deactivated++; *running = false }
+	currentlyActive = func(model.GPIOPin) bool { return *running }
+	canToggle = func(*model.Device, time.Time) bool { return toggle }
+	return activated, deactivated
+}
+
+func testPump(lastChanged time.Time) pump {
+	return pump{
+		device: &model.Device{Name: "rf_loop", Online: true, LastChanged: lastChanged},
+		pin:    model.GPIOPin{Number: 5},
+	}
+}
+
+func TestEvaluateExercise_RunsIdlePump(t *testing.T) {
+	running := false
+	activated, deactivated := fakePins(t, &running, true)
+	now := time.Now()
+	store := &fakeStore{lastRun: now.Add(-8 * 24 * time.Hour)}
+	p := testPump(now.Add(-8 * 24 * time.Hour))
+
+	evaluateExercise(p, store, now, 7*24*time.Hour, time.Minute)
+	assert.Equal(t, 1, *activated)
+	assert.True(t, store.active)
+
+	// Still within the run
+	evaluateExercise(p, store, now.Add(30*time.Second), 7*24*time.Hour, time.Minute)
+	assert.Equal(t, 0, *deactivated)
+
+	evaluateExercise(p, store, now.Add(time.Minute), 7*24*time.Hour, time.Minute)
+	assert.Equal(t, 1, *deactivated)
+	assert.False(t, store.active)
+	assert.Equal(t, now.Add(time.Minute), store.lastRun)
+}
+
+func TestEvaluateExercise_SkipsPumpsNotDue(t *testing.T) {
+	now := time.Now()
+	interval := 7 * 24 * time.Hour
+
+	t.Run("recently run", func(t *testing.T) {
+		running := false
+		activated, _ := fakePins(t, &running, true)
+		evaluateExercise(testPump(now), &fakeStore{lastRun: now.Add(-time.Hour)}, now, interval, time.Minute)
+		assert.Equal(t, 0, *activated)
+	})
+
+	t.Run("can't toggle", func(t *testing.T) {
+		running := false
+		activated, _ := fakePins(t, &running, false)
+		evaluateExercise(testPump(now), &fakeStore{}, now, interval, time.Minute)
+		assert.Equal(t, 0, *activated)
+	})
+
+	t.Run("offline", func(t *testing.T) {
+		running := false
+		activated, _ := fakePins(t, &running, true)
+		p := testPump(now)
+		p.device.Online = false
+		evaluateExercise(p, &fakeStore{}, now, interval, time.Minute)
+		assert.Equal(t, 0, *activated)
+	})
+
+	t.Run("air handler recirculating", func(t *testing.T) {
+		running := false
+		activated, _ := fakePins(t, &running, true)
+		p := testPump(now)
+		p.busy = true
+		evaluateExercise(p, &fakeStore{}, now, interval, time.Minute)
+		assert.Equal(t, 0, *activated)
+	})
+
+	t.Run("running for demand is recorded", func(t *testing.T) {
+		running := true
+		activated, _ := fakePins(t, &running, true)
+		store := &fakeStore{lastRun: now.Add(-2 * time.Hour)}
+		evaluateExercise(testPump(now), store, now, interval, time.Minute)
+		assert.Equal(t, 0, *activated)
+		assert.True(t, store.recorded)
+		assert.Equal(t, now, store.lastRun)
+	})
+}
+
+func TestEvaluateExercise_DemandTakesOver(t *testing.T) {
+	running := true
+	_, deactivated := fakePins(t, &running, true)
+	now := time.Now()
+	store := &fakeStore{active: true, startedAt: now.Add(-2 * time.Minute)}
+
+	// The zone controller switched the loop on for real demand after the run started
+	evaluateExercise(testPump(now.Add(-time.Minute)), store, now, 7*24*time.Hour, time.Minute)
+	assert.Equal(t, 0, *deactivated)
+	assert.True(t, running)
+	assert.False(t, store.active)
+}
@@ -1,29 +1,22 @@
 package failsafecontroller
 
 import (
-	"database/sql"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/rs/zerolog/log"
 
 	"github.com/thatsimonsguy/hvac-controller/db"
+	"github.com/thatsimonsguy/hvac-controller/internal/config"
 	"github.com/thatsimonsguy/hvac-controller/internal/device"
 	"github.com/thatsimonsguy/hvac-controller/internal/env"
 	"github.com/thatsimonsguy/hvac-controller/internal/model"
+	"github.com/thatsimonsguy/hvac-controller/internal/notifications"
+	"github.com/thatsimonsguy/hvac-controller/internal/temperature"
 )
 
-var ignoredZones = []string{
-	"garage",
-}
-
-func isZoneIgnored(zoneID string) bool {
-	for _, ignored := range ignoredZones {
-		if zoneID == ignored {
-			return true
-		}
-	}
-	return false
-}
+var sendAlert = notifications.Send
 
 type ZoneState struct {
 	Zone        model.Zone
@@ -32,74 +25,132 @@ type ZoneState struct {
 	Loop        *model.RadiantFloorLoop
 }
 
+// ZoneViolation is a zone outside its failsafe limits and the mode needed to bring it back.
+type ZoneViolation struct {
+	ZoneID      string
+	Temperature float64
+	Limit       float64
+	Mode        model.SystemMode
+}
+
 type FailsafeAction struct {
 	SetOverride     bool
 	ClearOverride   bool
 	OverrideMode    model.SystemMode
 	TriggerZone     string
 	TriggerTemp     float64
+	Violations      []ZoneViolation             // every zone outside its limits, in zone order
+	Conflicts       []ZoneViolation             // violations that need the opposite of OverrideMode
 	ActivateZones   map[string]model.SystemMode // zoneID -> mode
 	DeactivateZones []string
+	ReleaseZones    []string        // failsafe zones left running for their own zone controller
+	FallbackZones   map[string]bool // zones without sensor data -> fallback heat on this cycle
+}
+
+// OverrideState is the failsafe override carried between evaluation cycles.
+type OverrideState struct {
+	Active   bool
+	Mode     model.SystemMode
+	Zones    map[string]model.SystemMode // zones whose distribution the failsafe turned on
+	Fallback map[string]bool             // zones currently getting fallback heat
+	Alerted  bool                        // a heat/cool conflict has already been reported
+}
+
+func newOverrideState() OverrideState {
+	return OverrideState{Zones: make(map[string]model.SystemMode), Fallback: make(map[string]bool)}
+}
+
+// Store is the zone and override state the failsafe controller reads and sets.
+type Store interface {
+	device.Store
+	GetAllZones() ([]model.Zone, error)
+	GetAirHandlerByID(id string) (*model.AirHandler, error)
+	GetRadiantLoopByID(id string) (*model.RadiantFloorLoop, error)
+	GetSystemOverride() (bool, error)
+	SetZoneSensorDegraded(id string, since *time.Time) error
+	SetSystemOverride(newMode model.SystemMode, audit db.Audit) error
+	ClearSystemOverride(audit db.Audit) error
 }
 
 type TemperatureService interface {
 	GetTemperature(sensorID string) (float64, bool)
+	GetZoneReading(zone model.Zone) (temperature.SensorReading, bool)
 }
 
-func RunFailsafeController(dbConn *sql.DB, tempService TemperatureService) {
+func RunFailsafeController(store Store, tempService TemperatureService) {
 	go func() {
 		log.Info().Msg("Starting failsafe controller")
 
 		time.Sleep(2 * time.Minute)
 
+		state := newOverrideState()
+		watch := newSensorWatch()
 		for {
-			time.Sleep(time.Duration(env.Cfg.PollIntervalSeconds) * time.Second)
+			time.Sleep(time.Duration(env.Cfg().PollIntervalSeconds) * time.Second)
 
 			log.Info().Msg("Failsafe controller running evaluation cycle")
 
 			// Gather all current state
-			zones, err := db.GetAllZones(dbConn)
+			zones, err := store.GetAllZones()
 			if err != nil {
 				log.Error().Err(err).Msg("Could not retrieve zones from db")
 				continue
 			}
 
-			overrideActive, err := db.GetSystemOverride(dbConn)
+			overrideActive, err := store.GetSystemOverride()
 			if err != nil {
 				log.Error().Err(err).Msg("Could not check override status")
 				continue
 			}
+			if !overrideActive {
+				state = newOverrideState()
+			} else {
+				state.Active = true
+				if state.Mode, err = store.GetSystemMode(); err != nil {
+					log.Error().Err(err).Msg("Could not retrieve override mode")
+					continue
+				}
+			}
 
 			// Read all zone temperatures and device states
-			zoneStates := gatherZoneStates(dbConn, zones, tempService)
+			zoneStates := gatherZoneStates(store, zones, tempService)
+
+			// Track zones that have gone too long without a valid reading
+			cfg := env.Cfg()
+			now := time.Now()
+			degraded, recovered := watch.update(zones, zoneStates, now, time.Duration(cfg.FailsafeStaleMinutes)*time.Minute)
+			recordSensorHealth(store, watch, zones, degraded, recovered)
 
 			// Determine what actions need to be taken
-			action := evaluateFailsafeActions(zoneStates, overrideActive, env.Cfg.SystemOverrideMinTemp, env.Cfg.SystemOverrideMaxTemp, env.Cfg.Spread)
+			action := evaluateFailsafeActions(zoneStates, state, cfg.SystemOverrideMinTemp, cfg.SystemOverrideMaxTemp, cfg.Spread)
+
+			outdoorTemp, outdoorValid := tempService.GetTemperature(config.OutdoorSensor)
+			planFallback(&action, state, watch, zones, outdoorTemp, outdoorValid, FallbackSettings{
+				OutdoorThreshold: cfg.FailsafeFallbackOutdoorTemp,
+				DutyCycle:        cfg.FailsafeFallbackDutyCycle,
+				Cycle:            time.Duration(cfg.FailsafeFallbackCycleMinutes) * time.Minute,
+			}, now)
 
 			// Execute the determined actions
-			executeFailsafeActions(dbConn, action)
+			executeFailsafeActions(store, action, &state)
 		}
 	}()
 }
 
-func gatherZoneStates(dbConn *sql.DB, zones []model.Zone, tempService TemperatureService) []ZoneState {
+func gatherZoneStates(store Store, zones []model.Zone, tempService TemperatureService) []ZoneState {
 	var zoneStates []ZoneState
 
 	for _, zone := range zones {
-		sensor, err := db.GetSensorByID(dbConn, zone.Sensor.ID)
-		if err != nil {
-			log.Error().Err(err).Str("zone", zone.ID).Msg("Could not retrieve sensor for zone")
+		// Multi-sensor zones fall back to whichever of their sensors still read
+		reading, ok := tempService.GetZoneReading(zone)
+		if !ok || !reading.Usable() {
+			log.Warn().Str("zone", zone.ID).Str("quality", string(reading.Quality)).Msg("No valid temperature reading available for zone")
 			continue
 		}
+		zoneTemp := reading.Temperature
 
-		zoneTemp, valid := tempService.GetTemperature(sensor.ID)
-		if !valid {
-			log.Warn().Str("zone", zone.ID).Msg("No valid temperature reading available for zone")
-			continue
-		}
-
-		handler, _ := db.GetAirHandlerByID(dbConn, zone.ID)
-		loop, _ := db.GetRadiantLoopByID(dbConn, zone.ID)
+		handler, _ := store.GetAirHandlerByID(zone.ID)
+		loop, _ := store.GetRadiantLoopByID(zone.ID)
 
 		zoneStates = append(zoneStates, ZoneState{
 			Zone:        zone,
@@ -112,28 +163,30 @@ func gatherZoneStates(dbConn *sql.DB, zones []model.Zone, tempService Temperatur
 	return zoneStates
 }
 
-func evaluateFailsafeActions(zoneStates []ZoneState, overrideActive bool, minTemp, maxTemp, spread float64) FailsafeAction {
+// evaluateFailsafeActions decides whether to set or clear the override and which zones need distribution.
+// Each zone is checked against its own limits, falling back to defaultMin/defaultMax; zones with failsafe
+// disabled are skipped. Every violating zone is served. When zones need both heating and cooling, heating
+// wins (freeze protection comes first) and the cooling zones are reported as conflicts. An active
+// override switches mode once no zone needs its current one, and held zones are released as soon as
+// they are back inside their spread.
+func evaluateFailsafeActions(zoneStates []ZoneState, override OverrideState, defaultMin, defaultMax, spread float64) FailsafeAction {
 	action := FailsafeAction{
 		ActivateZones:   make(map[string]model.SystemMode),
 		DeactivateZones: []string{},
 	}
 
-
-	// Check if any zone is outside safe range
-	var needsOverride bool
-	var requiredMode model.SystemMode
-	var triggerZone string
-	var triggerTemp float64
-
+	// Collect every zone outside its safe range
+	var needsHeat bool
 	for _, zoneState := range zoneStates {
-		// Skip ignored zones
-		if isZoneIgnored(zoneState.Zone.ID) {
+		// Skip zones with failsafe disabled
+		if !zoneState.Zone.FailsafeActive() {
 			log.Debug().
 				Str("zone", zoneState.Zone.ID).
-				Msg("Skipping ignored zone for failsafe evaluation")
+				Msg("Skipping zone with failsafe disabled")
 			continue
 		}
 
+		minTemp, maxTemp := zoneState.Zone.FailsafeLimits(defaultMin, defaultMax)
 		deltaFromMin := zoneState.Temperature - minTemp
 		deltaFromMax := zoneState.Temperature - maxTemp
 
@@ -144,149 +197,323 @@ func evaluateFailsafeActions(zoneStates []ZoneState, overrideActive bool, minTem
 			Float64("max_threshold", maxTemp).
 			Float32("delta_from_min", float32(deltaFromMin)).
 			Float32("delta_from_max", float32(deltaFromMax)).
-			Bool("override_active", overrideActive).
+			Bool("override_active", override.Active).
 			Msg("Evaluating zone for failsafe conditions")
 
 		if zoneState.Temperature < minTemp {
-			needsOverride = true
-			requiredMode = model.ModeHeating
-			triggerZone = zoneState.Zone.ID
-			triggerTemp = zoneState.Temperature
+			needsHeat = true
+			action.Violations = append(action.Violations, ZoneViolation{zoneState.Zone.ID, zoneState.Temperature, minTemp, model.ModeHeating})
 			log.Warn().
 				Str("zone", zoneState.Zone.ID).
 				Float64("temp", zoneState.Temperature).
 				Float64("min_threshold", minTemp).
 				Msg("Zone temperature below safety minimum - failsafe heating required")
-			break
 		}
 
 		if zoneState.Temperature > maxTemp {
-			needsOverride = true
-			requiredMode = model.ModeCooling
-			triggerZone = zoneState.Zone.ID
-			triggerTemp = zoneState.Temperature
+			action.Violations = append(action.Violations, ZoneViolation{zoneState.Zone.ID, zoneState.Temperature, maxTemp, model.ModeCooling})
 			log.Warn().
 				Str("zone", zoneState.Zone.ID).
 				Float64("temp", zoneState.Temperature).
 				Float64("max_threshold", maxTemp).
 				Msg("Zone temperature above safety maximum - failsafe cooling required")
-			break
 		}
 	}
 
 	// Determine actions based on current state and needs
-	if needsOverride && !overrideActive {
-		// Need to activate override
-		action.SetOverride = true
-		action.OverrideMode = requiredMode
-		action.TriggerZone = triggerZone
-		action.TriggerTemp = triggerTemp
-		action.ActivateZones[triggerZone] = requiredMode
-
-	} else if overrideActive && !needsOverride {
+	if len(action.Violations) > 0 {
+		mode := override.Mode
+		if !override.Active || !needsMode(action.Violations, override.Mode) {
+			// Need to activate override, or switch it when no zone needs its mode any more
+			mode = model.ModeCooling
+			if needsHeat {
+				mode = model.ModeHeating
+			}
+			action.SetOverride = true
+		}
+		action.OverrideMode = mode
+
+		if override.Active && mode != override.Mode {
+			// Zones held for the old mode are switched off; those that need the new one are
+			// activated once they aren't held
+			log.Warn().
+				Str("from", string(override.Mode)).
+				Str("to", string(mode)).
+				Msg("No zone needs the failsafe mode any more - switching override")
+			action.DeactivateZones = append(action.DeactivateZones, sortedZoneIDs(override.Zones)...)
+		}
+
+		for _, v := range action.Violations {
+			heldMode, held := override.Zones[v.ZoneID]
+			if v.Mode != mode {
+				action.Conflicts = append(action.Conflicts, v)
+				// Stop driving a held zone the wrong way, e.g. one heated past its max
+				if held && heldMode == mode {
+					action.DeactivateZones = append(action.DeactivateZones, v.ZoneID)
+				}
+				continue
+			}
+			if action.TriggerZone == "" {
+				action.TriggerZone = v.ZoneID
+				action.TriggerTemp = v.Temperature
+			}
+			if !held {
+				action.ActivateZones[v.ZoneID] = mode
+			}
+		}
+
+		if len(action.Conflicts) > 0 {
+			log.Warn().
+				Str("override_mode", string(mode)).
+				Int("conflicting_zones", len(action.Conflicts)).
+				Msg("Zones need both failsafe heating and cooling")
+		}
+
+		if !action.SetOverride {
+			releaseRecoveredZones(&action, zoneStates, override, defaultMin, defaultMax, spread)
+		}
+
+	} else if override.Active {
 		// Check if all zones are safely within bounds (with spread)
 		allZonesSafe := true
-		safeMin := minTemp + spread
-		safeMax := maxTemp - spread
 
 		for _, zoneState := range zoneStates {
-			// Skip ignored zones for safety evaluation too
-			if isZoneIgnored(zoneState.Zone.ID) {
+			// Skip zones with failsafe disabled for safety evaluation too
+			if !zoneState.Zone.FailsafeActive() {
 				continue
 			}
-			
-			if zoneState.Temperature < safeMin || zoneState.Temperature > safeMax {
+			if !withinSpread(zoneState, defaultMin, defaultMax, spread) {
 				allZonesSafe = false
 				break
 			}
 		}
 
 		if allZonesSafe {
-			// Clear override and deactivate all zones
+			// Clear override and hand each failsafe zone back to its own mode
 			action.ClearOverride = true
 			for _, zoneState := range zoneStates {
-				action.DeactivateZones = append(action.DeactivateZones, zoneState.Zone.ID)
+				if mode, held := override.Zones[zoneState.Zone.ID]; held {
+					releaseZone(&action, zoneState.Zone, mode)
+				}
 			}
+		} else {
+			releaseRecoveredZones(&action, zoneStates, override, defaultMin, defaultMax, spread)
 		}
 	}
 
 	return action
 }
 
-func executeFailsafeActions(dbConn *sql.DB, action FailsafeAction) {
+// needsMode reports whether any violation needs mode.
+func needsMode(violations []ZoneViolation, mode model.SystemMode) bool {
+	for _, v := range violations {
+		if v.Mode == mode {
+			return true
+		}
+	}
+	return false
+}
+
+// withinSpread reports whether a zone is back inside its failsafe limits by at least spread.
+func withinSpread(zoneState ZoneState, defaultMin, defaultMax, spread float64) bool {
+	minTemp, maxTemp := zoneState.Zone.FailsafeLimits(defaultMin, defaultMax)
+	return zoneState.Temperature >= minTemp+spread && zoneState.Temperature <= maxTemp-spread
+}
+
+// releaseRecoveredZones switches off each held zone that is back inside its spread while the
+// override stays active for other zones. Zone controllers are suspended until it clears, so these
+// zones can't be left running.
+func releaseRecoveredZones(action *FailsafeAction, zoneStates []ZoneState, override OverrideState, defaultMin, defaultMax, spread float64) {
+	for _, zoneState := range zoneStates {
+		_, held := override.Zones[zoneState.Zone.ID]
+		if !held || !withinSpread(zoneState, defaultMin, defaultMax, spread) {
+			continue
+		}
+		log.Info().Str("zone", zoneState.Zone.ID).Msg("Zone back within safe range - releasing from failsafe")
+		action.DeactivateZones = append(action.DeactivateZones, zoneState.Zone.ID)
+	}
+}
+
+// releaseZone hands a held zone back to its own mode. A zone already set to the failsafe mode keeps
+// running under its zone controller; the rest are switched off.
+func releaseZone(action *FailsafeAction, zone model.Zone, mode model.SystemMode) {
+	if zone.Mode == mode {
+		action.ReleaseZones = append(action.ReleaseZones, zone.ID)
+	} else {
+		action.DeactivateZones = append(action.DeactivateZones, zone.ID)
+	}
+}
+
+// executeFailsafeActions carries out action and records the result in state.
+func executeFailsafeActions(store Store, action FailsafeAction, state *OverrideState) {
 	if action.SetOverride {
+		message := "Activating failsafe override"
+		if state.Active {
+			message = "Switching failsafe override"
+		}
 		log.Warn().
 			Str("trigger_zone", action.TriggerZone).
 			Float64("trigger_temp", action.TriggerTemp).
 			Str("required_mode", string(action.OverrideMode)).
-			Msg("Activating failsafe override")
+			Msg(message)
 
-		if err := db.SetSystemOverride(dbConn, action.OverrideMode); err != nil {
+		reason := fmt.Sprintf("zone %s at %.1f°F", action.TriggerZone, action.TriggerTemp)
+		if action.TriggerZone == "" {
+			reason = fallbackReason(action.FallbackZones)
+		}
+		if err := store.SetSystemOverride(action.OverrideMode, db.Audit{
+			Actor:  "failsafecontroller",
+			Reason: reason,
+		}); err != nil {
 			log.Error().Err(err).Msg("Failed to set system override")
 			return
 		}
+		state.Active = true
+		state.Mode = action.OverrideMode
 	}
 
+	// Alert once per conflict, not every cycle
+	if len(action.Conflicts) > 0 && !state.Alerted {
+		reportConflict(action)
+	}
+	state.Alerted = len(action.Conflicts) > 0
+
 	if action.ClearOverride {
 		log.Info().Msg("All zones within safe range - clearing failsafe override")
 
-		if err := db.ClearSystemOverride(dbConn); err != nil {
+		if err := store.ClearSystemOverride(db.Audit{Actor: "failsafecontroller", Reason: "all zones within safe range"}); err != nil {
 			log.Error().Err(err).Msg("Failed to clear system override")
 			return
 		}
 	}
 
+	// Deactivate zones as needed, before anything is switched on for a new override mode
+	for _, zoneID := range action.DeactivateZones {
+		deactivateZoneDistribution(store, zoneID)
+		delete(state.Zones, zoneID)
+	}
+	for _, zoneID := range action.ReleaseZones {
+		log.Info().Str("zone", zoneID).Msg("Returning zone to its own controller after failsafe")
+		delete(state.Zones, zoneID)
+	}
+
 	// Activate zones as needed
 	for zoneID, mode := range action.ActivateZones {
-		activateZoneDistribution(dbConn, zoneID, mode)
+		activateZoneDistribution(store, zoneID, mode)
+		state.Zones[zoneID] = mode
 	}
 
-	// Deactivate zones as needed
-	for _, zoneID := range action.DeactivateZones {
-		deactivateZoneDistribution(dbConn, zoneID)
+	// Cycle fallback heat, switching only when a zone's phase changes
+	for _, zoneID := range sortedZoneIDs(action.FallbackZones) {
+		on := action.FallbackZones[zoneID]
+		if on && !state.Fallback[zoneID] {
+			log.Warn().Str("zone", zoneID).Msg("Fallback heat on for zone without sensor data")
+			activateZoneDistribution(store, zoneID, model.ModeHeating)
+			state.Zones[zoneID] = model.ModeHeating
+		}
+		if !on && state.Fallback[zoneID] {
+			log.Info().Str("zone", zoneID).Msg("Fallback heat off for zone without sensor data")
+			deactivateZoneDistribution(store, zoneID)
+			delete(state.Zones, zoneID)
+		}
+		state.Fallback[zoneID] = on
+	}
+	// Recovered zones stay held until the override clears
+	for zoneID := range state.Fallback {
+		if _, ok := action.FallbackZones[zoneID]; !ok {
+			delete(state.Fallback, zoneID)
+		}
+	}
+
+	if action.ClearOverride {
+		*state = newOverrideState()
+	}
+}
+
+// recordSensorHealth persists zones that became degraded or recovered and alerts on each change.
+func recordSensorHealth(store Store, watch *SensorWatch, zones []model.Zone, degraded, recovered []string) {
+	labels := make(map[string]string)
+	for _, zone := range zones {
+		labels[zone.ID] = zone.Label
+	}
+
+	for _, zoneID := range degraded {
+		since := watch.Degraded[zoneID]
+		log.Error().Str("zone", zoneID).Time("last_valid", since).Msg("Zone has no valid temperature data - marking degraded")
+		if err := store.SetZoneSensorDegraded(zoneID, &since); err != nil {
+			log.Error().Err(err).Str("zone", zoneID).Msg("Failed to mark zone sensor degraded")
+		}
+		message := fmt.Sprintf("%s has had no valid temperature reading since %s", labels[zoneID], since.Format(time.Kitchen))
+		if err := sendAlert("HVAC Zone Degraded", message); err != nil {
+			log.Error().Err(err).Msg("Failed to send degraded zone alert")
+		}
+	}
+
+	for _, zoneID := range recovered {
+		log.Info().Str("zone", zoneID).Msg("Zone temperature data restored")
+		if err := store.SetZoneSensorDegraded(zoneID, nil); err != nil {
+			log.Error().Err(err).Str("zone", zoneID).Msg("Failed to clear zone sensor degraded")
+		}
+		if err := sendAlert("HVAC Zone Recovered", fmt.Sprintf("%s temperature readings are back", labels[zoneID])); err != nil {
+			log.Error().Err(err).Msg("Failed to send zone recovery alert")
+		}
+	}
+}
+
+// reportConflict alerts that some zones can't be served because others need the opposite mode.
+func reportConflict(action FailsafeAction) {
+	var zones []string
+	for _, v := range action.Conflicts {
+		zones = append(zones, fmt.Sprintf("%s at %.1f°F (limit %.1f°F)", v.ZoneID, v.Temperature, v.Limit))
+	}
+	message := fmt.Sprintf("Failsafe is %s but these zones need %s: %s",
+		action.OverrideMode, action.Conflicts[0].Mode, strings.Join(zones, ", "))
+	log.Error().Str("override_mode", string(action.OverrideMode)).Strs("zones", zones).Msg("Failsafe heat/cool conflict")
+	if err := sendAlert("Failsafe conflict", message); err != nil {
+		log.Error().Err(err).Msg("Failed to send failsafe conflict alert")
 	}
 }
 
-func activateZoneDistribution(dbConn *sql.DB, zoneID string, mode model.SystemMode) {
-	handler, err := db.GetAirHandlerByID(dbConn, zoneID)
+func activateZoneDistribution(store Store, zoneID string, mode model.SystemMode) {
+	handler, err := store.GetAirHandlerByID(zoneID)
 	if err == nil && handler != nil {
 		if device.CanToggle(&handler.Device, time.Now()) {
 			if mode == model.ModeCooling {
 				log.Info().Str("zone", zoneID).Msg("Activating air handler for failsafe cooling")
-				device.ActivateBlower(handler, dbConn)
-				device.ActivateAirHandler(handler, dbConn)
+				device.ActivateBlower(handler, store)
+				device.ActivateAirHandler(handler, store)
 			} else if mode == model.ModeHeating {
 				log.Info().Str("zone", zoneID).Msg("Activating air handler for failsafe heating")
-				device.ActivateBlower(handler, dbConn)
-				device.ActivateAirHandler(handler, dbConn)
+				device.ActivateBlower(handler, store)
+				device.ActivateAirHandler(handler, store)
 			}
 		}
 	}
 
-	loop, err := db.GetRadiantLoopByID(dbConn, zoneID)
+	loop, err := store.GetRadiantLoopByID(zoneID)
 	if err == nil && loop != nil && mode == model.ModeHeating {
 		if device.CanToggle(&loop.Device, time.Now()) {
 			log.Info().Str("zone", zoneID).Msg("Activating radiant loop for failsafe heating")
-			device.ActivateRadiantLoop(loop, dbConn)
+			device.ActivateRadiantLoop(loop, store)
 		}
 	}
 }
 
-func deactivateZoneDistribution(dbConn *sql.DB, zoneID string) {
-	handler, err := db.GetAirHandlerByID(dbConn, zoneID)
+func deactivateZoneDistribution(store Store, zoneID string) {
+	handler, err := store.GetAirHandlerByID(zoneID)
 	if err == nil && handler != nil {
 		if device.CanToggle(&handler.Device, time.Now()) {
 			log.Info().Str("zone", zoneID).Msg("Deactivating air handler after failsafe")
-			device.DeactivateAirHandler(handler, dbConn)
-			device.DeactivateBlower(handler, dbConn)
+			device.DeactivateAirHandler(handler, store)
+			device.DeactivateBlower(handler, store)
 		}
 	}
 
-	loop, err := db.GetRadiantLoopByID(dbConn, zoneID)
+	loop, err := store.GetRadiantLoopByID(zoneID)
 	if err == nil && loop != nil {
 		if device.CanToggle(&loop.Device, time.Now()) {
 			log.Info().Str("zone", zoneID).Msg("Deactivating radiant loop after failsafe")
-			device.DeactivateRadiantLoop(loop, dbConn)
+			device.DeactivateRadiantLoop(loop, store)
 		}
 	}
 }
\ No newline at end of file
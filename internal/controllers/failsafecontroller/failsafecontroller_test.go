@@ -2,25 +2,80 @@ package failsafecontroller
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"github.com/thatsimonsguy/hvac-controller/internal/model"
 )
 
-func TestIsZoneIgnored(t *testing.T) {
-	assert.True(t, isZoneIgnored("garage"))
-	assert.False(t, isZoneIgnored("living_room"))
-	assert.False(t, isZoneIgnored("bedroom"))
-	assert.False(t, isZoneIgnored("basement"))
+var disabled = false
+
+// heatingOverride is an active heating override holding the given zones.
+func heatingOverride(zoneIDs ...string) OverrideState {
+	state := newOverrideState()
+	state.Active, state.Mode = true, model.ModeHeating
+	for _, id := range zoneIDs {
+		state.Zones[id] = model.ModeHeating
+	}
+	return state
+}
+
+func TestZoneFailsafeDefaults(t *testing.T) {
+	zone := model.Zone{ID: "living_room"}
+	assert.True(t, zone.FailsafeActive())
+	minTemp, maxTemp := zone.FailsafeLimits(50.0, 85.0)
+	assert.Equal(t, 50.0, minTemp)
+	assert.Equal(t, 85.0, maxTemp)
+
+	garage := model.Zone{ID: "garage", FailsafeEnabled: &disabled}
+	assert.False(t, garage.FailsafeActive())
+}
+
+func TestEvaluateFailsafeActions_PerZoneLimits(t *testing.T) {
+	freezeOnly := 35.0
+	hotAttic := 110.0
+	zoneStates := []ZoneState{
+		{
+			Zone:        model.Zone{ID: "garage", FailsafeMinTemp: &freezeOnly},
+			Temperature: 40.0, // Below the default minimum but above the garage's own limit
+		},
+		{
+			Zone:        model.Zone{ID: "attic", FailsafeMaxTemp: &hotAttic},
+			Temperature: 100.0, // Above the default maximum but below the attic's own limit
+		},
+	}
+
+	action := evaluateFailsafeActions(zoneStates, OverrideState{}, 50.0, 85.0, 2.0)
+	assert.False(t, action.SetOverride)
+
+	zoneStates[0].Temperature = 34.0
+	action = evaluateFailsafeActions(zoneStates, OverrideState{}, 50.0, 85.0, 2.0)
+	assert.True(t, action.SetOverride)
+	assert.Equal(t, model.ModeHeating, action.OverrideMode)
+	assert.Equal(t, "garage", action.TriggerZone)
+}
+
+func TestEvaluateFailsafeActions_ClearUsesPerZoneLimits(t *testing.T) {
+	freezeOnly := 35.0
+	zoneStates := []ZoneState{
+		{
+			Zone:        model.Zone{ID: "garage", FailsafeMinTemp: &freezeOnly},
+			Temperature: 40.0, // Safe for the garage (35 + 2), not for the default (50 + 2)
+		},
+	}
+
+	action := evaluateFailsafeActions(zoneStates, heatingOverride("living_room"), 50.0, 85.0, 2.0)
+	assert.True(t, action.ClearOverride)
 }
 
-func TestEvaluateFailsafeActions_IgnoredZoneTemperatureUnsafe(t *testing.T) {
-	// Create zone states where garage has unsafe temperature but should be ignored
+func TestEvaluateFailsafeActions_DisabledZoneTemperatureUnsafe(t *testing.T) {
+	// Create zone states where garage has unsafe temperature but failsafe disabled
 	zoneStates := []ZoneState{
 		{
-			Zone:        model.Zone{ID: "garage"},
-			Temperature: 10.0, // Way too cold, but should be ignored
+			Zone:        model.Zone{ID: "garage", FailsafeEnabled: &disabled},
+			Temperature: 10.0, // Way too cold, but failsafe disabled
 		},
 		{
 			Zone:        model.Zone{ID: "living_room"},
@@ -28,7 +83,7 @@ func TestEvaluateFailsafeActions_IgnoredZoneTemperatureUnsafe(t *testing.T) {
 		},
 	}
 
-	action := evaluateFailsafeActions(zoneStates, false, 50.0, 85.0, 2.0)
+	action := evaluateFailsafeActions(zoneStates, OverrideState{}, 50.0, 85.0, 2.0)
 
 	// Should not trigger override despite garage being very cold
 	assert.False(t, action.SetOverride)
@@ -37,20 +92,20 @@ func TestEvaluateFailsafeActions_IgnoredZoneTemperatureUnsafe(t *testing.T) {
 	assert.Equal(t, 0, len(action.DeactivateZones))
 }
 
-func TestEvaluateFailsafeActions_IgnoredZoneWithNormalZoneUnsafe(t *testing.T) {
-	// Garage is cold (ignored), but living room is also cold (not ignored)
+func TestEvaluateFailsafeActions_DisabledZoneWithNormalZoneUnsafe(t *testing.T) {
+	// Garage is cold (disabled), but living room is also cold (enabled)
 	zoneStates := []ZoneState{
 		{
-			Zone:        model.Zone{ID: "garage"},
-			Temperature: 10.0, // Too cold but ignored
+			Zone:        model.Zone{ID: "garage", FailsafeEnabled: &disabled},
+			Temperature: 10.0, // Too cold but disabled
 		},
 		{
 			Zone:        model.Zone{ID: "living_room"},
-			Temperature: 45.0, // Too cold and not ignored - should trigger
+			Temperature: 45.0, // Too cold and enabled - should trigger
 		},
 	}
 
-	action := evaluateFailsafeActions(zoneStates, false, 50.0, 85.0, 2.0)
+	action := evaluateFailsafeActions(zoneStates, OverrideState{}, 50.0, 85.0, 2.0)
 
 	// Should trigger override based on living room, not garage
 	assert.True(t, action.SetOverride)
@@ -75,7 +130,7 @@ func TestEvaluateFailsafeActions_NormalTemperatures(t *testing.T) {
 		},
 	}
 
-	action := evaluateFailsafeActions(zoneStates, false, 50.0, 85.0, 2.0)
+	action := evaluateFailsafeActions(zoneStates, OverrideState{}, 50.0, 85.0, 2.0)
 
 	assert.False(t, action.SetOverride)
 	assert.False(t, action.ClearOverride)
@@ -95,7 +150,7 @@ func TestEvaluateFailsafeActions_TooHot(t *testing.T) {
 		},
 	}
 
-	action := evaluateFailsafeActions(zoneStates, false, 50.0, 85.0, 2.0)
+	action := evaluateFailsafeActions(zoneStates, OverrideState{}, 50.0, 85.0, 2.0)
 
 	assert.True(t, action.SetOverride)
 	assert.Equal(t, model.ModeCooling, action.OverrideMode)
@@ -117,7 +172,7 @@ func TestEvaluateFailsafeActions_TooCold(t *testing.T) {
 		},
 	}
 
-	action := evaluateFailsafeActions(zoneStates, false, 50.0, 85.0, 2.0)
+	action := evaluateFailsafeActions(zoneStates, OverrideState{}, 50.0, 85.0, 2.0)
 
 	assert.True(t, action.SetOverride)
 	assert.Equal(t, model.ModeHeating, action.OverrideMode)
@@ -127,25 +182,143 @@ func TestEvaluateFailsafeActions_TooCold(t *testing.T) {
 	assert.Equal(t, model.ModeHeating, action.ActivateZones["basement"])
 }
 
-func TestEvaluateFailsafeActions_FirstUnsafeZoneWins(t *testing.T) {
-	// Multiple zones unsafe - first one found should trigger
+func TestEvaluateFailsafeActions_AllViolatingZonesActivated(t *testing.T) {
 	zoneStates := []ZoneState{
 		{
 			Zone:        model.Zone{ID: "basement"},
-			Temperature: 45.0, // Too cold - should trigger heating
+			Temperature: 45.0, // Too cold
+		},
+		{
+			Zone:        model.Zone{ID: "bedroom"},
+			Temperature: 70.0, // Normal
 		},
+		{
+			Zone:        model.Zone{ID: "living_room"},
+			Temperature: 48.0, // Also too cold
+		},
+	}
+
+	action := evaluateFailsafeActions(zoneStates, OverrideState{}, 50.0, 85.0, 2.0)
+
+	assert.True(t, action.SetOverride)
+	assert.Equal(t, model.ModeHeating, action.OverrideMode)
+	assert.Equal(t, "basement", action.TriggerZone)
+	assert.Len(t, action.Violations, 2)
+	assert.Empty(t, action.Conflicts)
+	assert.Equal(t, map[string]model.SystemMode{"basement": model.ModeHeating, "living_room": model.ModeHeating}, action.ActivateZones)
+}
+
+func TestEvaluateFailsafeActions_HeatAndCoolConflict(t *testing.T) {
+	// Zones need both heating and cooling - freeze protection wins, the hot zone is reported
+	zoneStates := []ZoneState{
 		{
 			Zone:        model.Zone{ID: "attic"},
-			Temperature: 90.0, // Too hot - but second in list
+			Temperature: 90.0, // Too hot - first in list
+		},
+		{
+			Zone:        model.Zone{ID: "basement"},
+			Temperature: 45.0, // Too cold
 		},
 	}
 
-	action := evaluateFailsafeActions(zoneStates, false, 50.0, 85.0, 2.0)
+	action := evaluateFailsafeActions(zoneStates, OverrideState{}, 50.0, 85.0, 2.0)
 
 	assert.True(t, action.SetOverride)
-	assert.Equal(t, model.ModeHeating, action.OverrideMode) // First unsafe zone was too cold
+	assert.Equal(t, model.ModeHeating, action.OverrideMode)
 	assert.Equal(t, "basement", action.TriggerZone)
 	assert.Equal(t, 45.0, action.TriggerTemp)
+	assert.Equal(t, map[string]model.SystemMode{"basement": model.ModeHeating}, action.ActivateZones)
+	assert.Equal(t, []ZoneViolation{{ZoneID: "attic", Temperature: 90.0, Limit: 85.0, Mode: model.ModeCooling}}, action.Conflicts)
+}
+
+func TestEvaluateFailsafeActions_OverrideActive_NewZoneViolates(t *testing.T) {
+	// A second zone freezes after the override is already active
+	zoneStates := []ZoneState{
+		{
+			Zone:        model.Zone{ID: "basement"},
+			Temperature: 47.0, // Still too cold, already held
+		},
+		{
+			Zone:        model.Zone{ID: "living_room"},
+			Temperature: 49.0, // Newly too cold
+		},
+	}
+
+	action := evaluateFailsafeActions(zoneStates, heatingOverride("basement"), 50.0, 85.0, 2.0)
+
+	assert.False(t, action.SetOverride)
+	assert.Equal(t, map[string]model.SystemMode{"living_room": model.ModeHeating}, action.ActivateZones)
+}
+
+func TestEvaluateFailsafeActions_OverrideActive_OppositeNeedSwitchesMode(t *testing.T) {
+	// Only the opposite mode is needed now, so the override switches and stops heating its zones
+	zoneStates := []ZoneState{
+		{
+			Zone:        model.Zone{ID: "attic"},
+			Temperature: 88.0, // Too hot while heating override is active
+		},
+		{
+			Zone:        model.Zone{ID: "basement"},
+			Temperature: 51.0, // Held, no longer below its min but not yet inside the spread
+		},
+	}
+
+	action := evaluateFailsafeActions(zoneStates, heatingOverride("basement"), 50.0, 85.0, 2.0)
+
+	assert.True(t, action.SetOverride)
+	assert.Equal(t, model.ModeCooling, action.OverrideMode)
+	assert.Equal(t, "attic", action.TriggerZone)
+	assert.Equal(t, map[string]model.SystemMode{"attic": model.ModeCooling}, action.ActivateZones)
+	assert.Equal(t, []string{"basement"}, action.DeactivateZones)
+	assert.Empty(t, action.Conflicts)
+	assert.False(t, action.ClearOverride)
+}
+
+func TestEvaluateFailsafeActions_OverrideActive_HeldZoneOvershoots(t *testing.T) {
+	// A held zone heated past its max stops being heated while another zone still needs heat
+	sunroomMax := 75.0
+	zoneStates := []ZoneState{
+		{
+			Zone:        model.Zone{ID: "sunroom", FailsafeMaxTemp: &sunroomMax},
+			Temperature: 77.0,
+		},
+		{
+			Zone:        model.Zone{ID: "basement"},
+			Temperature: 47.0,
+		},
+	}
+
+	action := evaluateFailsafeActions(zoneStates, heatingOverride("sunroom", "basement"), 50.0, 85.0, 2.0)
+
+	assert.False(t, action.SetOverride)
+	assert.Equal(t, model.ModeHeating, action.OverrideMode)
+	assert.Equal(t, []string{"sunroom"}, action.DeactivateZones)
+	require.Len(t, action.Conflicts, 1)
+	assert.Equal(t, "sunroom", action.Conflicts[0].ZoneID)
+	assert.Empty(t, action.ActivateZones)
+}
+
+func TestEvaluateFailsafeActions_ClearRestoresPriorZoneModes(t *testing.T) {
+	zoneStates := []ZoneState{
+		{
+			Zone:        model.Zone{ID: "basement", Mode: model.ModeHeating},
+			Temperature: 60.0, // Safe, and the zone itself wants heat
+		},
+		{
+			Zone:        model.Zone{ID: "living_room", Mode: model.ModeOff},
+			Temperature: 60.0, // Safe, zone was off before the failsafe
+		},
+		{
+			Zone:        model.Zone{ID: "bedroom", Mode: model.ModeCooling},
+			Temperature: 70.0, // Never touched by the failsafe
+		},
+	}
+
+	action := evaluateFailsafeActions(zoneStates, heatingOverride("basement", "living_room"), 50.0, 85.0, 2.0)
+
+	assert.True(t, action.ClearOverride)
+	assert.Equal(t, []string{"basement"}, action.ReleaseZones)
+	assert.Equal(t, []string{"living_room"}, action.DeactivateZones)
 }
 
 func TestEvaluateFailsafeActions_OverrideAlreadyActive_NeedsOverride(t *testing.T) {
@@ -157,7 +330,7 @@ func TestEvaluateFailsafeActions_OverrideAlreadyActive_NeedsOverride(t *testing.
 		},
 	}
 
-	action := evaluateFailsafeActions(zoneStates, true, 50.0, 85.0, 2.0)
+	action := evaluateFailsafeActions(zoneStates, heatingOverride("living_room"), 50.0, 85.0, 2.0)
 
 	// Should not set override again, but also shouldn't clear it
 	assert.False(t, action.SetOverride)
@@ -179,7 +352,7 @@ func TestEvaluateFailsafeActions_OverrideActive_AllZonesSafe(t *testing.T) {
 		},
 	}
 
-	action := evaluateFailsafeActions(zoneStates, true, 50.0, 85.0, 2.0)
+	action := evaluateFailsafeActions(zoneStates, heatingOverride("living_room", "bedroom"), 50.0, 85.0, 2.0)
 
 	assert.False(t, action.SetOverride)
 	assert.True(t, action.ClearOverride)
@@ -192,7 +365,7 @@ func TestEvaluateFailsafeActions_OverrideActive_OneZoneStillUnsafe(t *testing.T)
 	// Override is active, one zone safe, one still too close to boundary
 	zoneStates := []ZoneState{
 		{
-			Zone:        model.Zone{ID: "living_room"},
+			Zone:        model.Zone{ID: "living_room", Mode: model.ModeHeating},
 			Temperature: 72.0, // Safe
 		},
 		{
@@ -201,12 +374,14 @@ func TestEvaluateFailsafeActions_OverrideActive_OneZoneStillUnsafe(t *testing.T)
 		},
 	}
 
-	action := evaluateFailsafeActions(zoneStates, true, 50.0, 85.0, 2.0)
+	action := evaluateFailsafeActions(zoneStates, heatingOverride("living_room"), 50.0, 85.0, 2.0)
 
 	assert.False(t, action.SetOverride)
 	assert.False(t, action.ClearOverride) // Don't clear yet - still unsafe
 	assert.Equal(t, 0, len(action.ActivateZones))
-	assert.Equal(t, 0, len(action.DeactivateZones))
+	// Released as soon as it is safe, and switched off as zone control is still suspended
+	assert.Equal(t, []string{"living_room"}, action.DeactivateZones)
+	assert.Empty(t, action.ReleaseZones)
 }
 
 func TestEvaluateFailsafeActions_BoundaryConditions(t *testing.T) {
@@ -232,7 +407,7 @@ func TestEvaluateFailsafeActions_BoundaryConditions(t *testing.T) {
 				},
 			}
 
-			action := evaluateFailsafeActions(zoneStates, false, 50.0, 85.0, 2.0)
+			action := evaluateFailsafeActions(zoneStates, OverrideState{}, 50.0, 85.0, 2.0)
 
 			assert.Equal(t, tt.expectTrigger, action.SetOverride)
 			if tt.expectTrigger {
@@ -266,7 +441,7 @@ func TestEvaluateFailsafeActions_SpreadBoundaryConditions(t *testing.T) {
 				},
 			}
 
-			action := evaluateFailsafeActions(zoneStates, true, 50.0, 85.0, 2.0) // Override active
+			action := evaluateFailsafeActions(zoneStates, heatingOverride("test_zone"), 50.0, 85.0, 2.0) // Override active
 
 			assert.Equal(t, tt.expectClear, action.ClearOverride)
 		})
@@ -277,7 +452,7 @@ func TestEvaluateFailsafeActions_EmptyZoneStates(t *testing.T) {
 	// Test with no zones
 	zoneStates := []ZoneState{}
 
-	action := evaluateFailsafeActions(zoneStates, false, 50.0, 85.0, 2.0)
+	action := evaluateFailsafeActions(zoneStates, OverrideState{}, 50.0, 85.0, 2.0)
 
 	assert.False(t, action.SetOverride)
 	assert.False(t, action.ClearOverride)
@@ -285,16 +460,16 @@ func TestEvaluateFailsafeActions_EmptyZoneStates(t *testing.T) {
 	assert.Equal(t, 0, len(action.DeactivateZones))
 }
 
-func TestEvaluateFailsafeActions_OnlyIgnoredZones(t *testing.T) {
-	// Test with only ignored zones
+func TestEvaluateFailsafeActions_OnlyDisabledZones(t *testing.T) {
+	// Test with only disabled zones
 	zoneStates := []ZoneState{
 		{
-			Zone:        model.Zone{ID: "garage"},
-			Temperature: 10.0, // Way too cold but ignored
+			Zone:        model.Zone{ID: "garage", FailsafeEnabled: &disabled},
+			Temperature: 10.0, // Way too cold but disabled
 		},
 	}
 
-	action := evaluateFailsafeActions(zoneStates, false, 50.0, 85.0, 2.0)
+	action := evaluateFailsafeActions(zoneStates, OverrideState{}, 50.0, 85.0, 2.0)
 
 	assert.False(t, action.SetOverride)
 	assert.False(t, action.ClearOverride)
@@ -302,41 +477,165 @@ func TestEvaluateFailsafeActions_OnlyIgnoredZones(t *testing.T) {
 	assert.Equal(t, 0, len(action.DeactivateZones))
 }
 
-func TestEvaluateFailsafeActions_OverrideActive_OnlyIgnoredZones(t *testing.T) {
-	// Test clearing override when only ignored zones present
+func TestEvaluateFailsafeActions_OverrideActive_OnlyDisabledZones(t *testing.T) {
+	// Test clearing override when only disabled zones present
 	zoneStates := []ZoneState{
 		{
-			Zone:        model.Zone{ID: "garage"},
-			Temperature: 70.0, // Normal temp but ignored
+			Zone:        model.Zone{ID: "garage", FailsafeEnabled: &disabled},
+			Temperature: 70.0, // Normal temp but disabled
 		},
 	}
 
-	action := evaluateFailsafeActions(zoneStates, true, 50.0, 85.0, 2.0) // Override active
+	action := evaluateFailsafeActions(zoneStates, heatingOverride(), 50.0, 85.0, 2.0) // Override active
 
-	// Should clear override since no non-ignored zones exist
+	// Should clear override since no enabled zones exist, leaving the garage alone
 	assert.False(t, action.SetOverride)
 	assert.True(t, action.ClearOverride)
-	assert.Equal(t, 1, len(action.DeactivateZones))
-	assert.Contains(t, action.DeactivateZones, "garage")
+	assert.Empty(t, action.DeactivateZones)
 }
 
-func TestEvaluateFailsafeActions_IgnoredZoneInDeactivationList(t *testing.T) {
-	// When clearing override, ignored zones should still be added to deactivation list
+func TestEvaluateFailsafeActions_OnlyFailsafeZonesDeactivated(t *testing.T) {
+	// When clearing override, only zones the failsafe turned on are deactivated
 	zoneStates := []ZoneState{
 		{
 			Zone:        model.Zone{ID: "living_room"},
 			Temperature: 70.0, // Safe
 		},
 		{
-			Zone:        model.Zone{ID: "garage"},
-			Temperature: 20.0, // Cold but ignored
+			Zone:        model.Zone{ID: "garage", FailsafeEnabled: &disabled},
+			Temperature: 20.0, // Cold but disabled
 		},
 	}
 
-	action := evaluateFailsafeActions(zoneStates, true, 50.0, 85.0, 2.0) // Override active
+	action := evaluateFailsafeActions(zoneStates, heatingOverride("living_room"), 50.0, 85.0, 2.0) // Override active
 
 	assert.True(t, action.ClearOverride)
-	assert.Equal(t, 2, len(action.DeactivateZones))
-	assert.Contains(t, action.DeactivateZones, "living_room")
-	assert.Contains(t, action.DeactivateZones, "garage") // Even ignored zones get deactivated
-}
\ No newline at end of file
+	assert.Equal(t, []string{"living_room"}, action.DeactivateZones) // The garage is left to its own controller
+}
+func TestExecuteFailsafeActions_AlertsOnceForConflict(t *testing.T) {
+	origSendAlert := sendAlert
+	defer func() { sendAlert = origSendAlert }()
+	alerts := 0
+	sendAlert = func(title, message string) error {
+		alerts++
+		assert.Contains(t, message, "attic at 90.0°F (limit 85.0°F)")
+		return nil
+	}
+
+	action := FailsafeAction{
+		OverrideMode: model.ModeHeating,
+		Conflicts:    []ZoneViolation{{ZoneID: "attic", Temperature: 90.0, Limit: 85.0, Mode: model.ModeCooling}},
+	}
+	state := heatingOverride("basement")
+	executeFailsafeActions(nil, action, &state)
+	executeFailsafeActions(nil, action, &state)
+	assert.Equal(t, 1, alerts)
+
+	// Once the conflict resolves, a new one alerts again
+	executeFailsafeActions(nil, FailsafeAction{}, &state)
+	executeFailsafeActions(nil, action, &state)
+	assert.Equal(t, 2, alerts)
+}
+
+func TestSensorWatch_DegradesAndRecovers(t *testing.T) {
+	start := time.Date(2025, 1, 10, 6, 0, 0, 0, time.UTC)
+	zones := []model.Zone{{ID: "basement"}, {ID: "living_room"}}
+	living := []ZoneState{{Zone: zones[1], Temperature: 68.0}}
+	watch := newSensorWatch()
+
+	degraded, recovered := watch.update(zones, living, start, 15*time.Minute)
+	assert.Empty(t, degraded)
+	assert.Empty(t, recovered)
+
+	degraded, _ = watch.update(zones, living, start.Add(14*time.Minute), 15*time.Minute)
+	assert.Empty(t, degraded)
+
+	degraded, _ = watch.update(zones, living, start.Add(15*time.Minute), 15*time.Minute)
+	assert.Equal(t, []string{"basement"}, degraded)
+	assert.Equal(t, start, watch.Degraded["basement"])
+
+	// Reported once
+	degraded, _ = watch.update(zones, living, start.Add(20*time.Minute), 15*time.Minute)
+	assert.Empty(t, degraded)
+
+	both := append(living, ZoneState{Zone: zones[0], Temperature: 55.0})
+	_, recovered = watch.update(zones, both, start.Add(25*time.Minute), 15*time.Minute)
+	assert.Equal(t, []string{"basement"}, recovered)
+	assert.Empty(t, watch.Degraded)
+}
+
+func TestSensorWatch_RestoresDegradedZonesAfterRestart(t *testing.T) {
+	since := time.Date(2025, 1, 10, 6, 0, 0, 0, time.UTC)
+	zones := []model.Zone{{ID: "basement", SensorDegradedSince: &since}}
+	watch := newSensorWatch()
+
+	degraded, recovered := watch.update(zones, nil, since.Add(time.Hour), 15*time.Minute)
+	assert.Empty(t, degraded, "already recorded before the restart")
+	assert.Empty(t, recovered)
+	assert.Equal(t, since, watch.Degraded["basement"])
+}
+
+func TestFallbackHeatOn(t *testing.T) {
+	since := time.Date(2025, 1, 10, 6, 0, 0, 0, time.UTC)
+	assert.True(t, fallbackHeatOn(since, since, 0.25, time.Hour))
+	assert.True(t, fallbackHeatOn(since, since.Add(14*time.Minute), 0.25, time.Hour))
+	assert.False(t, fallbackHeatOn(since, since.Add(15*time.Minute), 0.25, time.Hour))
+	assert.False(t, fallbackHeatOn(since, since.Add(59*time.Minute), 0.25, time.Hour))
+	assert.True(t, fallbackHeatOn(since, since.Add(61*time.Minute), 0.25, time.Hour))
+	assert.False(t, fallbackHeatOn(since, since, 0, time.Hour))
+}
+
+func TestPlanFallback(t *testing.T) {
+	since := time.Date(2025, 1, 10, 6, 0, 0, 0, time.UTC)
+	zones := []model.Zone{{ID: "basement"}, {ID: "garage", FailsafeEnabled: &disabled}, {ID: "living_room"}}
+	watch := newSensorWatch()
+	watch.Degraded["basement"] = since
+	watch.Degraded["garage"] = since
+	settings := FallbackSettings{OutdoorThreshold: 40.0, DutyCycle: 0.25, Cycle: time.Hour}
+
+	t.Run("sets a heating override for cold weather", func(t *testing.T) {
+		action := evaluateFailsafeActions(nil, OverrideState{}, 50.0, 85.0, 2.0)
+		planFallback(&action, OverrideState{}, watch, zones, 20.0, true, settings, since.Add(5*time.Minute))
+
+		assert.True(t, action.SetOverride)
+		assert.Equal(t, model.ModeHeating, action.OverrideMode)
+		assert.Equal(t, map[string]bool{"basement": true}, action.FallbackZones) // garage has failsafe disabled
+		assert.Equal(t, "fallback heat for zones without sensor data: basement", fallbackReason(action.FallbackZones))
+	})
+
+	t.Run("runs without an outdoor reading", func(t *testing.T) {
+		action := evaluateFailsafeActions(nil, OverrideState{}, 50.0, 85.0, 2.0)
+		planFallback(&action, OverrideState{}, watch, zones, 0, false, settings, since.Add(30*time.Minute))
+
+		assert.True(t, action.SetOverride)
+		assert.Equal(t, map[string]bool{"basement": false}, action.FallbackZones) // off part of the cycle
+	})
+
+	t.Run("skips warm weather", func(t *testing.T) {
+		action := evaluateFailsafeActions(nil, OverrideState{}, 50.0, 85.0, 2.0)
+		planFallback(&action, OverrideState{}, watch, zones, 55.0, true, settings, since)
+
+		assert.False(t, action.SetOverride)
+		assert.Nil(t, action.FallbackZones)
+	})
+
+	t.Run("keeps the override until readings return", func(t *testing.T) {
+		override := heatingOverride("basement")
+		states := []ZoneState{{Zone: zones[2], Temperature: 68.0}}
+		action := evaluateFailsafeActions(states, override, 50.0, 85.0, 2.0)
+		require.True(t, action.ClearOverride)
+
+		planFallback(&action, override, watch, zones, 20.0, true, settings, since)
+		assert.False(t, action.ClearOverride)
+		assert.Empty(t, action.DeactivateZones)
+	})
+
+	t.Run("no fallback heat while cooling", func(t *testing.T) {
+		states := []ZoneState{{Zone: zones[2], Temperature: 90.0}}
+		action := evaluateFailsafeActions(states, OverrideState{}, 50.0, 85.0, 2.0)
+		planFallback(&action, OverrideState{}, watch, zones, 20.0, true, settings, since)
+
+		assert.Equal(t, model.ModeCooling, action.OverrideMode)
+		assert.Nil(t, action.FallbackZones)
+	})
+}
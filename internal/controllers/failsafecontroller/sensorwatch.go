@@ -0,0 +1,145 @@
+package failsafecontroller
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/thatsimonsguy/hvac-controller/internal/model"
+)
+
+// SensorWatch tracks how long each zone has gone without a valid temperature reading.
+type SensorWatch struct {
+	LastValid map[string]time.Time // zoneID -> last valid reading, or when watching began
+	Degraded  map[string]time.Time // degraded zoneID -> last valid reading
+}
+
+func newSensorWatch() *SensorWatch {
+	return &SensorWatch{
+		LastValid: make(map[string]time.Time),
+		Degraded:  make(map[string]time.Time),
+	}
+}
+
+// update records which zones had a valid reading this cycle and returns the zones that just became
+// degraded and those that just recovered. Zones already marked degraded in the database are picked
+// up again after a restart without being reported. A staleAfter of zero turns detection off.
+func (w *SensorWatch) update(zones []model.Zone, readings []ZoneState, now time.Time, staleAfter time.Duration) (degraded, recovered []string) {
+	valid := make(map[string]bool)
+	for _, r := range readings {
+		valid[r.Zone.ID] = true
+	}
+
+	for _, zone := range zones {
+		if valid[zone.ID] || staleAfter <= 0 {
+			w.LastValid[zone.ID] = now
+			if _, ok := w.Degraded[zone.ID]; ok || zone.SensorDegradedSince != nil {
+				delete(w.Degraded, zone.ID)
+				recovered = append(recovered, zone.ID)
+			}
+			continue
+		}
+
+		if _, seen := w.LastValid[zone.ID]; !seen {
+			w.LastValid[zone.ID] = now
+			if zone.SensorDegradedSince != nil {
+				w.LastValid[zone.ID] = *zone.SensorDegradedSince
+				w.Degraded[zone.ID] = *zone.SensorDegradedSince
+			}
+		}
+
+		if _, ok := w.Degraded[zone.ID]; !ok && now.Sub(w.LastValid[zone.ID]) >= staleAfter {
+			w.Degraded[zone.ID] = w.LastValid[zone.ID]
+			degraded = append(degraded, zone.ID)
+		}
+	}
+	return degraded, recovered
+}
+
+// fallbackHeatOn reports whether a degraded zone should be heating at now: the first duty share of
+// each cycle, counted from the zone's last valid reading.
+func fallbackHeatOn(since, now time.Time, duty float64, cycle time.Duration) bool {
+	if duty <= 0 || cycle <= 0 {
+		return false
+	}
+	elapsed := now.Sub(since) % cycle
+	return elapsed < time.Duration(duty*float64(cycle))
+}
+
+// fallbackNeeded reports whether the weather calls for fallback heat. Without an outdoor reading
+// it has to assume it could be freezing.
+func fallbackNeeded(outdoorTemp float64, outdoorValid bool, threshold float64) bool {
+	return !outdoorValid || outdoorTemp < threshold
+}
+
+// FallbackSettings are the config values planFallback needs.
+type FallbackSettings struct {
+	OutdoorThreshold float64
+	DutyCycle        float64
+	Cycle            time.Duration
+}
+
+// planFallback adds fallback heat for degraded zones to action. It holds a heating override while any
+// zone is in fallback so normal zone control stays out of the way, and gives up if the failsafe is
+// already cooling.
+func planFallback(action *FailsafeAction, override OverrideState, watch *SensorWatch, zones []model.Zone,
+	outdoorTemp float64, outdoorValid bool, settings FallbackSettings, now time.Time) {
+	if settings.DutyCycle <= 0 || len(watch.Degraded) == 0 {
+		return
+	}
+	if !fallbackNeeded(outdoorTemp, outdoorValid, settings.OutdoorThreshold) {
+		log.Debug().Float64("outdoor_temp", outdoorTemp).Msg("Outdoor temperature above fallback threshold - no fallback heat")
+		return
+	}
+
+	fallback := make(map[string]bool)
+	for _, zone := range zones {
+		since, degraded := watch.Degraded[zone.ID]
+		if !degraded || !zone.FailsafeActive() {
+			continue
+		}
+		fallback[zone.ID] = fallbackHeatOn(since, now, settings.DutyCycle, settings.Cycle)
+	}
+	if len(fallback) == 0 {
+		return
+	}
+
+	mode := override.Mode
+	if action.SetOverride || !override.Active {
+		mode = action.OverrideMode
+	}
+	if mode == model.ModeCooling {
+		log.Warn().Strs("zones", sortedZoneIDs(fallback)).Msg("Failsafe is cooling - no fallback heat for zones without sensor data")
+		return
+	}
+
+	action.FallbackZones = fallback
+	if !override.Active && !action.SetOverride {
+		action.SetOverride = true
+		action.OverrideMode = model.ModeHeating
+	}
+
+	// Keep the override until every zone has readings again
+	if action.ClearOverride {
+		action.ClearOverride = false
+		action.DeactivateZones = []string{}
+		action.ReleaseZones = nil
+	}
+}
+
+// fallbackReason describes an override set only for fallback heat.
+func fallbackReason(fallback map[string]bool) string {
+	return fmt.Sprintf("fallback heat for zones without sensor data: %s", strings.Join(sortedZoneIDs(fallback), ", "))
+}
+
+func sortedZoneIDs[V any](zones map[string]V) []string {
+	ids := make([]string, 0, len(zones))
+	for id := range zones {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
@@ -1,111 +1,127 @@
 package recirculationcontroller
 
 import (
-	"database/sql"
 	"time"
 
 	"github.com/rs/zerolog/log"
 
-	"github.com/thatsimonsguy/hvac-controller/db"
 	"github.com/thatsimonsguy/hvac-controller/internal/device"
 	"github.com/thatsimonsguy/hvac-controller/internal/env"
 	"github.com/thatsimonsguy/hvac-controller/internal/gpio"
 	"github.com/thatsimonsguy/hvac-controller/internal/model"
 )
 
-const RecirculationInterval = 12 * time.Hour
-const RecirculationDuration = 15 * time.Minute
-
 var activateBlower = device.ActivateBlower
 var deactivateBlower = device.DeactivateBlower
 var currentlyActive = gpio.CurrentlyActive
 var canToggle = device.CanToggle
 
-func RunRecirculationController(dbConn *sql.DB) {
+// Store is the air handler and recirculation state the recirculation controller reads and sets.
+type Store interface {
+	device.Store
+	GetAllZones() ([]model.Zone, error)
+	GetAirHandlers() ([]model.AirHandler, error)
+	SetRecirculationRun(deviceName string, run model.RecirculationRun) error
+}
+
+// RunRecirculationController runs each air handler's blower on its own recirculation policy, and
+// starts and stops the manual runs requested through the API.
+func RunRecirculationController(store Store) {
 	go func() {
 		log.Info().Msg("Starting recirculation controller")
 
 		time.Sleep(5 * time.Minute)
 
 		for {
-			time.Sleep(time.Duration(env.Cfg.PollIntervalSeconds) * time.Second)
+			time.Sleep(time.Duration(env.Cfg().PollIntervalSeconds) * time.Second)
 
 			log.Info().Msg("Recirculation controller running evaluation cycle")
 
-			// Safety check: clear recirculation override if it's been active too long
-			recircActive, startedAt, err := db.GetRecirculationStatus(dbConn)
+			handlers, err := store.GetAirHandlers()
 			if err != nil {
-				log.Error().Err(err).Msg("Failed to check recirculation status")
-			} else if recircActive && time.Since(startedAt) > RecirculationDuration*2 {
-				log.Warn().
-					Dur("duration", time.Since(startedAt)).
-					Msg("Recirculation has been active too long - clearing override")
-				if err := db.SetRecirculationActive(dbConn, false, time.Time{}); err != nil {
-					log.Error().Err(err).Msg("Failed to clear stuck recirculation flag")
-				}
+				log.Error().Err(err).Msg("Could not retrieve air handlers from db")
+				continue
 			}
 
-			zones, err := db.GetAllZones(dbConn)
+			zones, err := store.GetAllZones()
 			if err != nil {
 				log.Error().Err(err).Msg("Could not retrieve zones from db")
 				continue
 			}
 
-			sysMode, err := db.GetSystemMode(dbConn)
+			sysMode, err := store.GetSystemMode()
 			if err != nil {
 				log.Error().Err(err).Msg("Could not retrieve system mode from db")
 				continue
 			}
 
-			for _, zone := range zones {
-				handler, err := db.GetAirHandlerByID(dbConn, zone.ID)
-				if err != nil {
-					log.Debug().Err(err).Str("zone", zone.ID).Msg("Could not retrieve air handler for zone")
-					continue
-				}
-
-				if handler == nil {
-					continue
+			for i := range handlers {
+				for j := range zones {
+					if zones[j].ID == handlers[i].Zone.ID {
+						handlers[i].Zone = &zones[j]
+					}
 				}
-
-				evaluateRecirculation(handler, sysMode, dbConn)
+				evaluateRecirculation(&handlers[i], sysMode, store)
 			}
 		}
 	}()
 }
 
-func evaluateRecirculation(handler *model.AirHandler, sysMode model.SystemMode, dbConn *sql.DB) {
+func evaluateRecirculation(handler *model.AirHandler, sysMode model.SystemMode, store Store) {
 	now := time.Now()
+	policy := handler.Recirculation
+	run := handler.RecirculationRun
 	blowerActive := currentlyActive(handler.Pin)
 	pumpActive := currentlyActive(handler.CircPumpPin)
 	timeSinceLastToggle := now.Sub(handler.LastChanged)
 
-	timeUntilRecirc := RecirculationInterval - timeSinceLastToggle
-	needsRecirculation := timeSinceLastToggle > RecirculationInterval
+	timeUntilRecirc := policy.Interval() - timeSinceLastToggle
+	needsRecirculation := !policy.Disabled && timeSinceLastToggle > policy.Interval()
 
 	log.Info().
 		Str("zone", handler.Zone.ID).
+		Str("device", handler.Name).
 		Bool("blower_active", blowerActive).
 		Bool("pump_active", pumpActive).
 		Dur("time_since_last_toggle", timeSinceLastToggle).
 		Dur("time_until_recirc", timeUntilRecirc).
 		Bool("needs_recirculation", needsRecirculation).
+		Bool("recirculating", run.Active()).
 		Str("system_mode", string(sysMode)).
 		Msg("Evaluating recirculation for air handler")
 
-	if !blowerActive && timeSinceLastToggle > RecirculationInterval {
+	// The zone controller switches off a handler taken offline; don't start it again
+	if !handler.Online {
+		log.Debug().
+			Str("zone", handler.Zone.ID).
+			Str("device", handler.Name).
+			Msg("Air handler offline - skipping recirculation")
+		return
+	}
+
+	if run.Active() {
+		evaluateRun(handler, run, blowerActive, pumpActive, sysMode, store, now)
+		return
+	}
+
+	if !blowerActive && needsRecirculation {
+		if !policy.AllowsHour(now.Hour()) {
+			log.Debug().
+				Str("zone", handler.Zone.ID).
+				Int("start_hour", policy.StartHour).
+				Int("end_hour", policy.EndHour).
+				Msg("Recirculation due but outside allowed hours")
+			return
+		}
 		if canToggle(&handler.Device, now) {
 			log.Info().
 				Str("zone", handler.Zone.ID).
-				Msg("Activating blower for recirculation - 12+ hours since last activity")
-			activateBlower(handler, dbConn)
-			
-			// Set recirculation override to prevent zone controller from immediately turning it off
-			if dbConn != nil {
-				if err := db.SetRecirculationActive(dbConn, true, now); err != nil {
-					log.Error().Err(err).Msg("Failed to set recirculation active flag")
-				}
-			}
+				Dur("interval", policy.Interval()).
+				Msg("Activating blower for recirculation")
+			activateBlower(handler, store)
+
+			// Record the run so the zone controller leaves the blower on
+			setRun(handler, store, model.RecirculationRun{StartedAt: now, EndsAt: now.Add(policy.Duration())})
 		}
 		return
 	}
@@ -125,20 +141,72 @@ func evaluateRecirculation(handler *model.AirHandler, sysMode model.SystemMode,
 			return
 		}
 
-		if !pumpActive && sysMode != model.ModeCirculate && timeSinceLastToggle > RecirculationDuration {
-			if canToggle(&handler.Device, now) {
-				log.Info().
-					Str("zone", handler.Zone.ID).
-					Msg("Deactivating blower after 15min recirculation")
-				deactivateBlower(handler, dbConn)
-				
-				// Clear recirculation override
-				if dbConn != nil {
-					if err := db.SetRecirculationActive(dbConn, false, time.Time{}); err != nil {
-						log.Error().Err(err).Msg("Failed to clear recirculation active flag")
-					}
-				}
-			}
+		if zoneCirculates(handler.Zone) {
+			log.Debug().
+				Str("zone", handler.Zone.ID).
+				Msg("Zone circulating on its own duty - no action needed")
+			return
 		}
+
+		// A blower left running without a run or demand, e.g. after a restart mid-run
+		if timeSinceLastToggle > policy.Duration() && canToggle(&handler.Device, now) {
+			log.Info().
+				Str("zone", handler.Zone.ID).
+				Msg("Deactivating blower left running after recirculation")
+			deactivateBlower(handler, store)
+		}
+	}
+}
+
+// evaluateRun keeps the blower on for a recirculation run and turns it off once the run ends. A
+// run that ends while the zone has demand, or the system or zone is circulating, leaves the blower
+// to the zone controller.
+func evaluateRun(handler *model.AirHandler, run model.RecirculationRun, blowerActive, pumpActive bool, sysMode model.SystemMode, store Store, now time.Time) {
+	// Safety check: clear a run the blower couldn't be switched off for long after it ended
+	if now.Sub(run.EndsAt) > handler.Recirculation.Duration() {
+		log.Warn().
+			Str("zone", handler.Zone.ID).
+			Dur("duration", now.Sub(run.StartedAt)).
+			Msg("Recirculation has been active too long - clearing run")
+		setRun(handler, store, model.RecirculationRun{})
+		return
 	}
-}
\ No newline at end of file
+
+	if now.Before(run.EndsAt) {
+		if !blowerActive && canToggle(&handler.Device, now) {
+			log.Info().
+				Str("zone", handler.Zone.ID).
+				Bool("manual", run.Manual).
+				Time("ends_at", run.EndsAt).
+				Msg("Activating blower for recirculation run")
+			activateBlower(handler, store)
+		}
+		return
+	}
+
+	if blowerActive && !pumpActive && sysMode != model.ModeCirculate && !zoneCirculates(handler.Zone) {
+		if !canToggle(&handler.Device, now) {
+			return
+		}
+		log.Info().
+			Str("zone", handler.Zone.ID).
+			Dur("duration", now.Sub(run.StartedAt)).
+			Msg("Deactivating blower after recirculation")
+		deactivateBlower(handler, store)
+	}
+	setRun(handler, store, model.RecirculationRun{})
+}
+
+// zoneCirculates reports whether the zone controller runs the blower on the zone's circulation duty.
+func zoneCirculates(zone *model.Zone) bool {
+	return zone.Mode == model.ModeCirculate || zone.CirculateSettings().BetweenCalls
+}
+
+func setRun(handler *model.AirHandler, store Store, run model.RecirculationRun) {
+	if store == nil {
+		return
+	}
+	if err := store.SetRecirculationRun(handler.Name, run); err != nil {
+		log.Error().Err(err).Str("device", handler.Name).Msg("Failed to record recirculation run")
+	}
+}
@@ -1,12 +1,12 @@
 package recirculationcontroller
 
 import (
-	"database/sql"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 
+	"github.com/thatsimonsguy/hvac-controller/internal/device"
 	"github.com/thatsimonsguy/hvac-controller/internal/model"
 )
 
@@ -33,9 +33,150 @@ var testHandler = &model.AirHandler{
 	},
 }
 
-func TestRecirculationConstants(t *testing.T) {
-	assert.Equal(t, 12*time.Hour, RecirculationInterval)
-	assert.Equal(t, 15*time.Minute, RecirculationDuration)
+type fakeStore struct {
+	device.Store
+	runs map[string]model.RecirculationRun
+}
+
+func (f *fakeStore) GetAllZones() ([]model.Zone, error)          { return nil, nil }
+func (f *fakeStore) GetAirHandlers() ([]model.AirHandler, error) { return nil, nil }
+
+func (f *fakeStore) SetRecirculationRun(deviceName string, run model.RecirculationRun) error {
+	f.runs[deviceName] = run
+	return nil
+}
+
+// fakeBlower stands in for the blower, pump and toggle checks and counts blower switching.
+func fakeBlower(t *testing.T, blower, pump bool) (activated, deactivated *int) {
+	origActivate, origDeactivate, origActive, origToggle := activateBlower, deactivateBlower, currentlyActive, canToggle
+	t.Cleanup(func() {
+		activateBlower, deactivateBlower, currentlyActive, canToggle = origActivate, origDeactivate, origActive, origToggle
+	})
+
+	activated, deactivated = new(int), new(int)
+	activateBlower = func(*model.AirHandler, device.Store) { *activated++ }
+	deactivateBlower = func(*model.AirHandler, device.Store) { *deactivated++ }
+	currentlyActive = func(pin model.GPIOPin) bool {
+		if pin.Number == 2 {
+			return pump
+		}
+		return blower
+	}
+	canToggle = func(*model.Device, time.Time) bool { return true }
+	return activated, deactivated
+}
+
+func policyHandler(policy model.RecirculationPolicy, idle time.Duration) *model.AirHandler {
+	return &model.AirHandler{
+		Device:        model.Device{Name: "test-handler", Pin: model.GPIOPin{Number: 1}, Online: true, LastChanged: time.Now().Add(-idle)},
+		Zone:          &model.Zone{ID: "test-zone"},
+		CircPumpPin:   model.GPIOPin{Number: 2},
+		Recirculation: policy,
+	}
+}
+
+func TestRecirculationPolicy(t *testing.T) {
+	var defaults model.RecirculationPolicy
+	assert.Equal(t, 12*time.Hour, defaults.Interval())
+	assert.Equal(t, 15*time.Minute, defaults.Duration())
+	assert.True(t, defaults.AllowsHour(3))
+
+	policy := model.RecirculationPolicy{IntervalMinutes: 240, DurationMinutes: 30}
+	assert.Equal(t, 4*time.Hour, policy.Interval())
+	assert.Equal(t, 30*time.Minute, policy.Duration())
+
+	daytime := model.RecirculationPolicy{StartHour: 8, EndHour: 22}
+	assert.True(t, daytime.AllowsHour(8))
+	assert.False(t, daytime.AllowsHour(22))
+	assert.False(t, daytime.AllowsHour(3))
+
+	overnight := model.RecirculationPolicy{StartHour: 22, EndHour: 6}
+	assert.True(t, overnight.AllowsHour(23))
+	assert.True(t, overnight.AllowsHour(2))
+	assert.False(t, overnight.AllowsHour(12))
+}
+
+func TestEvaluateRecirculation_Policy(t *testing.T) {
+	hour := time.Now().Hour()
+
+	t.Run("own interval", func(t *testing.T) {
+		activated, _ := fakeBlower(t, false, false)
+		store := &fakeStore{runs: map[string]model.RecirculationRun{}}
+		evaluateRecirculation(policyHandler(model.RecirculationPolicy{IntervalMinutes: 60, DurationMinutes: 5}, 2*time.Hour), model.ModeHeating, store)
+		assert.Equal(t, 1, *activated)
+		run := store.runs["test-handler"]
+		assert.True(t, run.Active())
+		assert.False(t, run.Manual)
+		assert.Equal(t, 5*time.Minute, run.EndsAt.Sub(run.StartedAt))
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		activated, _ := fakeBlower(t, false, false)
+		evaluateRecirculation(policyHandler(model.RecirculationPolicy{Disabled: true}, 13*time.Hour), model.ModeHeating, nil)
+		assert.Equal(t, 0, *activated)
+	})
+
+	t.Run("outside allowed hours", func(t *testing.T) {
+		activated, _ := fakeBlower(t, false, false)
+		policy := model.RecirculationPolicy{StartHour: (hour + 1) % 24, EndHour: (hour + 2) % 24}
+		evaluateRecirculation(policyHandler(policy, 13*time.Hour), model.ModeHeating, nil)
+		assert.Equal(t, 0, *activated)
+	})
+}
+
+func TestEvaluateRecirculation_Runs(t *testing.T) {
+	now := time.Now()
+
+	t.Run("manual run starts the blower", func(t *testing.T) {
+		activated, _ := fakeBlower(t, false, false)
+		handler := policyHandler(model.RecirculationPolicy{}, time.Hour)
+		handler.RecirculationRun = model.RecirculationRun{StartedAt: now, EndsAt: now.Add(time.Hour), Manual: true}
+		evaluateRecirculation(handler, model.ModeHeating, nil)
+		assert.Equal(t, 1, *activated)
+	})
+
+	t.Run("finished run stops the blower", func(t *testing.T) {
+		_, deactivated := fakeBlower(t, true, false)
+		store := &fakeStore{runs: map[string]model.RecirculationRun{}}
+		handler := policyHandler(model.RecirculationPolicy{}, 5*time.Minute)
+		handler.RecirculationRun = model.RecirculationRun{StartedAt: now.Add(-5 * time.Minute), EndsAt: now.Add(-time.Second)}
+		evaluateRecirculation(handler, model.ModeHeating, store)
+		assert.Equal(t, 1, *deactivated)
+		assert.False(t, store.runs["test-handler"].Active())
+	})
+
+	t.Run("finished run leaves demand alone", func(t *testing.T) {
+		_, deactivated := fakeBlower(t, true, true)
+		store := &fakeStore{runs: map[string]model.RecirculationRun{}}
+		handler := policyHandler(model.RecirculationPolicy{}, 5*time.Minute)
+		handler.RecirculationRun = model.RecirculationRun{StartedAt: now.Add(-5 * time.Minute), EndsAt: now.Add(-time.Second)}
+		evaluateRecirculation(handler, model.ModeHeating, store)
+		assert.Equal(t, 0, *deactivated)
+		assert.False(t, store.runs["test-handler"].Active())
+	})
+
+	t.Run("offline handler is left off", func(t *testing.T) {
+		activated, _ := fakeBlower(t, false, false)
+		handler := policyHandler(model.RecirculationPolicy{}, 13*time.Hour)
+		handler.Online = false
+		handler.RecirculationRun = model.RecirculationRun{StartedAt: now, EndsAt: now.Add(time.Hour), Manual: true}
+		evaluateRecirculation(handler, model.ModeHeating, nil)
+		assert.Equal(t, 0, *activated)
+
+		handler.RecirculationRun = model.RecirculationRun{}
+		evaluateRecirculation(handler, model.ModeHeating, nil)
+		assert.Equal(t, 0, *activated)
+	})
+
+	t.Run("stuck run is cleared", func(t *testing.T) {
+		fakeBlower(t, true, false)
+		canToggle = func(*model.Device, time.Time) bool { return false }
+		store := &fakeStore{runs: map[string]model.RecirculationRun{"test-handler": {StartedAt: now}}}
+		handler := policyHandler(model.RecirculationPolicy{}, time.Hour)
+		handler.RecirculationRun = model.RecirculationRun{StartedAt: now.Add(-time.Hour), EndsAt: now.Add(-45 * time.Minute)}
+		evaluateRecirculation(handler, model.ModeHeating, store)
+		assert.False(t, store.runs["test-handler"].Active())
+	})
 }
 
 func TestEvaluateRecirculation_BlowerOffMoreThan12Hours(t *testing.T) {
@@ -62,8 +203,8 @@ func TestEvaluateRecirculation_BlowerOffMoreThan12Hours(t *testing.T) {
 		canToggle = origCanToggle
 	}()
 
-	activateBlower = func(*model.AirHandler, *sql.DB) { activateCalled = true }
-	deactivateBlower = func(*model.AirHandler, *sql.DB) { deactivateCalled = true }
+	activateBlower = func(*model.AirHandler, device.Store) { activateCalled = true }
+	deactivateBlower = func(*model.AirHandler, device.Store) { deactivateCalled = true }
 	canToggle = func(*model.Device, time.Time) bool { return true }
 
 	origCurrentlyActive := currentlyActive
@@ -80,6 +221,7 @@ func TestEvaluateRecirculation_BlowerOnWithPumpActive(t *testing.T) {
 	handler := &model.AirHandler{
 		Device: model.Device{
 			Name:        "test-handler",
+			Online:      true,
 			LastChanged: time.Now().Add(-1 * time.Hour),
 		},
 		Zone: &model.Zone{ID: "test-zone"},
@@ -97,8 +239,8 @@ func TestEvaluateRecirculation_BlowerOnWithPumpActive(t *testing.T) {
 		canToggle = origCanToggle
 	}()
 
-	activateBlower = func(*model.AirHandler, *sql.DB) { activateCalled = true }
-	deactivateBlower = func(*model.AirHandler, *sql.DB) { deactivateCalled = true }
+	activateBlower = func(*model.AirHandler, device.Store) { activateCalled = true }
+	deactivateBlower = func(*model.AirHandler, device.Store) { deactivateCalled = true }
 	canToggle = func(*model.Device, time.Time) bool { return true }
 
 	origCurrentlyActive := currentlyActive
@@ -123,6 +265,7 @@ func TestEvaluateRecirculation_BlowerOnSystemCirculate(t *testing.T) {
 	handler := &model.AirHandler{
 		Device: model.Device{
 			Name:        "test-handler",
+			Online:      true,
 			LastChanged: time.Now().Add(-1 * time.Hour),
 		},
 		Zone: &model.Zone{ID: "test-zone"},
@@ -140,8 +283,8 @@ func TestEvaluateRecirculation_BlowerOnSystemCirculate(t *testing.T) {
 		canToggle = origCanToggle
 	}()
 
-	activateBlower = func(*model.AirHandler, *sql.DB) { activateCalled = true }
-	deactivateBlower = func(*model.AirHandler, *sql.DB) { deactivateCalled = true }
+	activateBlower = func(*model.AirHandler, device.Store) { activateCalled = true }
+	deactivateBlower = func(*model.AirHandler, device.Store) { deactivateCalled = true }
 	canToggle = func(*model.Device, time.Time) bool { return true }
 
 	origCurrentlyActive := currentlyActive
@@ -186,8 +329,8 @@ func TestEvaluateRecirculation_BlowerOnMoreThan15Minutes(t *testing.T) {
 		canToggle = origCanToggle
 	}()
 
-	activateBlower = func(*model.AirHandler, *sql.DB) { activateCalled = true }
-	deactivateBlower = func(*model.AirHandler, *sql.DB) { deactivateCalled = true }
+	activateBlower = func(*model.AirHandler, device.Store) { activateCalled = true }
+	deactivateBlower = func(*model.AirHandler, device.Store) { deactivateCalled = true }
 	canToggle = func(*model.Device, time.Time) bool { return true }
 
 	origCurrentlyActive := currentlyActive
@@ -208,3 +351,15 @@ func TestEvaluateRecirculation_BlowerOnMoreThan15Minutes(t *testing.T) {
 	assert.True(t, deactivateCalled)
 }
 
+func TestEvaluateRecirculation_LeavesZoneCirculationAlone(t *testing.T) {
+	for _, zone := range []*model.Zone{
+		{ID: "test-zone", Mode: model.ModeCirculate, Circulate: &model.Circulation{MinutesPerHour: 20}},
+		{ID: "test-zone", Mode: model.ModeHeating, Circulate: &model.Circulation{BetweenCalls: true}},
+	} {
+		_, deactivated := fakeBlower(t, true, false)
+		handler := policyHandler(model.RecirculationPolicy{}, time.Hour)
+		handler.Zone = zone
+		evaluateRecirculation(handler, model.ModeHeating, nil)
+		assert.Equal(t, 0, *deactivated, zone.Mode)
+	}
+}
@@ -0,0 +1,79 @@
+package zonecontroller
+
+import (
+	"time"
+
+	"github.com/thatsimonsguy/hvac-controller/internal/model"
+)
+
+// circulateDuty tracks how long a zone's blower has run in the current hour-long window, so
+// circulation can be held to the zone's minutes per hour. Any blower time counts, including
+// heating and cooling calls.
+type circulateDuty struct {
+	windowStart time.Time
+	ran         time.Duration
+	lastCheck   time.Time
+}
+
+// update accrues blower time since the last check and reports whether the blower should be
+// circulating now: until it has run the zone's minutes in this window, then off until the next.
+func (d *circulateDuty) update(now time.Time, blowerActive bool, c model.Circulation) bool {
+	if c.Continuous() {
+		*d = circulateDuty{}
+		return true
+	}
+
+	if blowerActive && !d.lastCheck.IsZero() {
+		d.ran += now.Sub(d.lastCheck)
+	}
+	d.lastCheck = now
+	if d.windowStart.IsZero() || now.Sub(d.windowStart) >= time.Hour {
+		d.windowStart = now
+		d.ran = 0
+	}
+	return d.ran < time.Duration(c.MinutesPerHour)*time.Minute
+}
+
+// circulationActions adjusts the air handler's switching for circulation once demand has been
+// evaluated. In circulate mode the blower follows the duty cycle; with between-calls circulation it
+// also runs at the duty while heating or cooling has no call on the air handler. Blower switching
+// waits on the handler's minimum on and off times like any other. Pump switching is applied first,
+// and deactivating the pump stops the blower with it.
+func circulationActions(switchThings map[string]bool, mode model.SystemMode, c model.Circulation, circulate, blowerActive, pumpActive, canToggleHandler bool) {
+	if !canToggleHandler {
+		return
+	}
+
+	switch mode {
+	case model.ModeCirculate:
+		if circulate {
+			// Stopping the pump stops the blower too, so bring it straight back on
+			if switchThings["deactivate_pump"] {
+				switchThings["activate_blower"] = true
+			}
+			return
+		}
+		switchThings["activate_blower"] = false
+		if blowerActive {
+			switchThings["deactivate_blower"] = true
+		}
+	case model.ModeHeating, model.ModeCooling:
+		if !c.BetweenCalls {
+			return
+		}
+		// Demand starting or continuing owns the blower
+		if switchThings["activate_pump"] || (pumpActive && !switchThings["deactivate_pump"]) {
+			return
+		}
+		if circulate {
+			switchThings["deactivate_blower"] = false
+			if !blowerActive || switchThings["deactivate_pump"] {
+				switchThings["activate_blower"] = true
+			}
+			return
+		}
+		if blowerActive {
+			switchThings["deactivate_blower"] = true
+		}
+	}
+}
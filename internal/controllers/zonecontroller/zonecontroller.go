@@ -1,46 +1,63 @@
 package zonecontroller
 
 import (
-	"database/sql"
 	"fmt"
 	"math/rand"
+	"strings"
 	"time"
 
 	"github.com/rs/zerolog/log"
 
-	"github.com/thatsimonsguy/hvac-controller/db"
+	"github.com/thatsimonsguy/hvac-controller/internal/config"
 	"github.com/thatsimonsguy/hvac-controller/internal/datadog"
 	"github.com/thatsimonsguy/hvac-controller/internal/device"
 	"github.com/thatsimonsguy/hvac-controller/internal/env"
 	"github.com/thatsimonsguy/hvac-controller/internal/gpio"
 	"github.com/thatsimonsguy/hvac-controller/internal/model"
+	"github.com/thatsimonsguy/hvac-controller/internal/notifications"
+	"github.com/thatsimonsguy/hvac-controller/internal/temperature"
 )
 
 const ZoneSpread float64 = 0.5
 const HeatingSecondaryThreshold float64 = 3
 
+// HumiditySpread is how far below its humidity target (in % RH) a zone dries before dehumidifying stops.
+const HumiditySpread float64 = 3
+
+var notify = notifications.Send
+
+// Store is the zone, device and system state the zone controller reads.
+type Store interface {
+	device.Store
+	GetZoneByID(id string) (*model.Zone, error)
+	GetAirHandlerByID(id string) (*model.AirHandler, error)
+	GetRadiantLoopByID(id string) (*model.RadiantFloorLoop, error)
+	GetSystemOverride() (bool, error)
+	GetPumpExerciseStatus(deviceName string) (active bool, startedAt, lastRun time.Time, err error)
+}
+
 type TemperatureService interface {
-	GetTemperature(sensorID string) (float64, bool)
+	GetZoneReading(zone model.Zone) (temperature.SensorReading, bool)
+	GetHumidity(sensorID string) (temperature.HumidityReading, bool)
 }
 
-func RunZoneController(zone *model.Zone, dbConn *sql.DB, tempService TemperatureService) {
+func RunZoneController(zone *model.Zone, store Store, tempService TemperatureService) {
 	go func() {
 		log.Info().Str("zone", zone.ID).Msg("Starting zone controller")
 
-		sensor, err := db.GetSensorByID(dbConn, zone.Sensor.ID)
-		if err != nil {
-			log.Error().Err(err).Str("sensor id", zone.Sensor.ID).Msg("Could not retrieve sensor")
-		}
-
 		// Sleep for 3 mins at first run, relatively safe assumed minOff
 		jitter := time.Duration(rand.Intn(10000)) * time.Millisecond // stagger cycle activation for all async routines
 		time.Sleep(3*time.Minute + jitter)
 
+		dehumidifyActive := false
+		conflicted := false
+		devicesOffline := false
+		var duty circulateDuty
 		for {
-			time.Sleep(time.Duration(env.Cfg.PollIntervalSeconds) * time.Second)
+			time.Sleep(time.Duration(env.Cfg().PollIntervalSeconds) * time.Second)
 
 			// Check if system is in override mode - if so, skip normal zone control
-			overrideActive, err := db.GetSystemOverride(dbConn)
+			overrideActive, err := store.GetSystemOverride()
 			if err != nil {
 				log.Error().Err(err).Str("zone", zone.ID).Msg("Could not check override status")
 				continue
@@ -51,40 +68,95 @@ func RunZoneController(zone *model.Zone, dbConn *sql.DB, tempService Temperature
 			}
 
 			// Refresh zone from db
-			zone, err = db.GetZoneByID(dbConn, zone.ID)
+			zone, err = store.GetZoneByID(zone.ID)
 			if err != nil {
 				log.Error().Err(err).Str("zone", zone.ID).Msg("Could not retrieve zone from db")
 				continue
 			}
 
-			// Get temp
-			zoneTemp, valid := tempService.GetTemperature(sensor.ID)
-			if !valid {
-				log.Warn().Str("zone", zone.ID).Msg("No valid temperature reading available for zone")
+			// Get temp, refusing to act on old readings
+			reading, ok := tempService.GetZoneReading(*zone)
+			if maxAge := env.Cfg().MaxReadingAge(); !ok || !reading.FreshEnough(maxAge) {
+				log.Warn().
+					Str("zone", zone.ID).
+					Str("quality", string(reading.Quality)).
+					Dur("age", reading.Age).
+					Dur("max_age", maxAge).
+					Msg("No recent temperature reading available for zone")
 				continue
 			}
+			zoneTemp := reading.Temperature
 
 			// Log out temp TODO: move this into o11y routine
 			log.Info().Str("zone", zone.ID).Str("mode", string(zone.Mode)).Float64("temp", zoneTemp).Msg("Evaluating zone")
 			datadog.Gauge("zone.temperature", zoneTemp, "component:sensor", fmt.Sprintf("zone:%s", zone.ID))
 
 			// Get system mode
-			sysMode, err := db.GetSystemMode(dbConn)
+			sysMode, err := store.GetSystemMode()
 			if err != nil {
 				log.Error().Err(err).Msg("Could not retrieve system mode from db")
 			}
 
+			// A zone whose mode conflicts with the system mode is idled; say so once rather than every cycle
+			conflict := model.ModesConflict(zone.Mode, sysMode)
+			if conflict != conflicted {
+				reportConflict(zone, sysMode, conflict)
+				conflicted = conflict
+			}
+
+			// A zone shed by the buffer controller while heating capacity is limited is idled too
+			shed := zone.ShedSince != nil && zone.Mode == model.ModeHeating
+			if shed {
+				log.Debug().Str("zone", zone.ID).Time("shed_since", *zone.ShedSince).Msg("Zone shed for limited heating capacity - idling zone")
+			}
+			mode := zone.Mode
+			if conflict || shed {
+				mode = model.ModeOff
+			}
+
 			// Get distribution devices
 
-			handler, err := db.GetAirHandlerByID(dbConn, zone.ID)
+			handler, err := store.GetAirHandlerByID(zone.ID)
 			if err != nil {
 				log.Error().Err(err).Str("zone", zone.ID).Msg("could not retrieve air handler for zone")
 			}
-			loop, err := db.GetRadiantLoopByID(dbConn, zone.ID)
+			loop, err := store.GetRadiantLoopByID(zone.ID)
 			if err != nil {
 				log.Error().Err(err).Str("zone", zone.ID).Msg("could not retrieve radiant loop for zone")
 			}
 
+			// Devices taken offline (e.g. after failed flow verification) are left alone, once
+			// de-energized if they were taken offline while running
+			var offline []string
+			if handler != nil && !handler.Online {
+				if gpio.CurrentlyActive(handler.Pin) || gpio.CurrentlyActive(handler.CircPumpPin) {
+					log.Warn().Str("zone", zone.ID).Str("device", handler.Name).Msg("Air handler is offline but energized - deactivating")
+					device.DeactivateAirHandler(handler, store)
+				}
+				log.Debug().Str("zone", zone.ID).Str("device", handler.Name).Msg("Air handler is offline - skipping")
+				offline = append(offline, handler.Name)
+				handler = nil
+			}
+			if loop != nil && !loop.Online {
+				if gpio.CurrentlyActive(loop.Pin) {
+					log.Warn().Str("zone", zone.ID).Str("device", loop.Name).Msg("Radiant loop is offline but energized - deactivating")
+					device.DeactivateRadiantLoop(loop, store)
+				}
+				log.Debug().Str("zone", zone.ID).Str("device", loop.Name).Msg("Radiant loop is offline - skipping")
+				offline = append(offline, loop.Name)
+				loop = nil
+			}
+
+			// A zone with every device offline has nothing to control; say so once rather than every cycle
+			unserved := handler == nil && loop == nil && len(offline) > 0
+			if unserved != devicesOffline {
+				reportOffline(zone, offline, unserved)
+				devicesOffline = unserved
+			}
+			if unserved {
+				continue
+			}
+
 			// Get toggleable statuses
 			canToggleHandler := false
 			canToggleLoop := false
@@ -109,8 +181,43 @@ func RunZoneController(zone *model.Zone, dbConn *sql.DB, tempService Temperature
 				loopActive = gpio.CurrentlyActive(loop.Pin)
 			}
 
-			threshold := getThreshold(zone, pumpActive, false)
-			secondaryThreshold := getThreshold(zone, pumpActive, true)
+			// A pump being exercised counts as idle, so demand takes it over rather than shutting it off
+			if handler != nil && pumpActive && exercising(store, handler.Name) {
+				pumpActive = false
+			}
+			if loop != nil && loopActive && exercising(store, loop.Name) {
+				loopActive = false
+			}
+
+			// Keep cooling below the setpoint, by at most the overcool limit, while the zone is too humid
+			control := zone
+			if zone.HumiditySensor != nil {
+				humidity, ok := tempService.GetHumidity(zone.HumiditySensor.ID)
+				if ok && humidity.Quality == temperature.QualityFresh {
+					datadog.Gauge("zone.humidity", humidity.Humidity, "component:sensor", fmt.Sprintf("zone:%s", zone.ID))
+					active := dehumidifying(zone, humidity.Humidity, dehumidifyActive)
+					if active != dehumidifyActive {
+						log.Info().
+							Str("zone", zone.ID).
+							Float64("humidity", humidity.Humidity).
+							Float64("target", zone.HumidityTarget).
+							Bool("dehumidifying", active).
+							Msg("Zone dehumidification changed")
+					}
+					dehumidifyActive = active
+				} else {
+					// Don't overcool on a humidity reading we can't trust
+					dehumidifyActive = false
+				}
+			}
+			if dehumidifyActive {
+				lowered := *zone
+				lowered.Setpoint -= env.Cfg().DehumidifyMaxOvercool
+				control = &lowered
+			}
+
+			threshold := getThreshold(control, pumpActive, false)
+			secondaryThreshold := getThreshold(control, pumpActive, true)
 
 			datadog.Gauge("zone.temperature", zoneTemp, "component:sensor", fmt.Sprintf("zone:%s", zone.ID))
 
@@ -120,6 +227,7 @@ func RunZoneController(zone *model.Zone, dbConn *sql.DB, tempService Temperature
 				Float64("temp", zoneTemp).
 				Float64("setpoint", zone.Setpoint).
 				Float64("threshold", threshold).
+				Bool("dehumidifying", dehumidifyActive).
 				Str("mode", string(zone.Mode)).
 				Msg("Zone temperature check")
 
@@ -134,7 +242,7 @@ func RunZoneController(zone *model.Zone, dbConn *sql.DB, tempService Temperature
 				canToggleHandler,
 				canToggleLoop,
 				zoneTemp,
-				zone.Mode,
+				mode,
 				sysMode,
 				threshold,
 				secondaryThreshold,
@@ -158,56 +266,62 @@ func RunZoneController(zone *model.Zone, dbConn *sql.DB, tempService Temperature
 
 				// turn everything off (but respect recirculation override for blower)
 				if handler != nil {
-					device.DeactivateAirHandler(handler, dbConn)
+					device.DeactivateAirHandler(handler, store)
 					
-					// Check if recirculation is active before deactivating blower
-					recircActive, _, err := db.GetRecirculationStatus(dbConn)
-					if err != nil {
-						log.Error().Err(err).Msg("Failed to check recirculation status")
-					}
-					if !recircActive {
-						device.DeactivateBlower(handler, dbConn)
+					// Leave the blower running for the handler's recirculation run
+					if !handler.RecirculationRun.Active() {
+						device.DeactivateBlower(handler, store)
 					} else {
 						log.Debug().Str("zone", zone.ID).Msg("Skipping blower deactivation - recirculation active")
 					}
 				}
 				if loop != nil {
-					device.DeactivateRadiantLoop(loop, dbConn)
+					device.DeactivateRadiantLoop(loop, store)
 				}
 				continue
 			}
 
-			// no errors, so use our switchMap to turn things off and on
+			// Run the blower on the zone's circulation duty, around heating and cooling calls
 			if handler != nil {
+				circulation := zone.CirculateSettings()
+				circulate := duty.update(time.Now(), blowerActive, circulation)
+				circulationActions(switchMap, mode, circulation, circulate, blowerActive, pumpActive, canToggleHandler)
+				log.Debug().
+					Str("zone", zone.ID).
+					Int("minutes_per_hour", circulation.MinutesPerHour).
+					Bool("between_calls", circulation.BetweenCalls).
+					Bool("circulate", circulate).
+					Msg("Zone circulation check")
+			}
+
+			// no errors, so use our switchMap to turn things off and on. Pumps go first, the
+			// air handler's pump can't be stopped without stopping its blower
+			if handler != nil {
+				if switchMap["activate_pump"] {
+					device.ActivateAirHandler(handler, store)
+				}
+				if switchMap["deactivate_pump"] {
+					device.DeactivateAirHandler(handler, store)
+				}
 				if switchMap["activate_blower"] {
-					device.ActivateBlower(handler, dbConn)
+					device.ActivateBlower(handler, store)
 				}
 				if switchMap["deactivate_blower"] {
-					// Check if recirculation is active before deactivating blower
-					recircActive, _, err := db.GetRecirculationStatus(dbConn)
-					if err != nil {
-						log.Error().Err(err).Msg("Failed to check recirculation status")
-					}
-					if !recircActive {
-						device.DeactivateBlower(handler, dbConn)
+					// Leave the blower running for the handler's recirculation run
+					if !handler.RecirculationRun.Active() {
+						device.DeactivateBlower(handler, store)
 					} else {
 						log.Debug().Str("zone", zone.ID).Msg("Skipping blower deactivation - recirculation active")
 					}
 				}
-				if switchMap["activate_pump"] {
-					device.ActivateAirHandler(handler, dbConn)
-				}
-				if switchMap["deactivate_pump"] {
-					device.DeactivateAirHandler(handler, dbConn)
-				}
 			}
 
 			if loop != nil {
 				if switchMap["activate_loop"] {
-					device.ActivateRadiantLoop(loop, dbConn)
+					device.ActivateRadiantLoop(loop, store)
 				}
 				if switchMap["deactivate_loop"] {
-					device.DeactivateRadiantLoop(loop, dbConn)
+					device.DeactivateRadiantLoop(loop, store)
 				}
 			}
 
@@ -239,8 +353,8 @@ func evaluateZoneActions(
 		"deactivate_loop":   false,
 	}
 
-	// turn everythuing off if zone is off
-	if mode == model.ModeOff {
+	// turn everythuing off if zone is off, or idled because its mode conflicts with the system mode
+	if mode == model.ModeOff || model.ModesConflict(mode, sysMode) {
 		if blowerActive && canToggleHandler {
 			switchThings["deactivate_blower"] = true
 		}
@@ -253,11 +367,6 @@ func evaluateZoneActions(
 		return switchThings, nil
 	}
 
-	// return an error and early out if the system and zone modes conflict
-	if isOppositeMode(mode, sysMode) {
-		return switchThings, fmt.Errorf("zone mode is opposite of system mode. zone: %s, zone mode: %s, system mode: %s", zoneID, mode, sysMode)
-	}
-
 	if handler == nil && loop == nil {
 		return switchThings, fmt.Errorf("no distribution device associated with zone: %s", zoneID)
 	}
@@ -362,6 +471,29 @@ func evaluateZoneActions(
 	return switchThings, nil
 }
 
+// exercising reports whether a device's pump is running for the exercise controller.
+func exercising(store Store, deviceName string) bool {
+	active, _, _, err := store.GetPumpExerciseStatus(deviceName)
+	if err != nil {
+		log.Error().Err(err).Str("device", deviceName).Msg("Failed to check pump exercise status")
+		return false
+	}
+	return active
+}
+
+// dehumidifying reports whether a cooling zone with the dehumidify capability should keep cooling
+// to dry the air. It starts once humidity rises above the target and, once active, carries on
+// until humidity falls HumiditySpread below it.
+func dehumidifying(zone *model.Zone, humidity float64, active bool) bool {
+	if zone.Mode != model.ModeCooling || !zone.HasCapability(config.CapabilityDehumidify) || zone.HumidityTarget <= 0 {
+		return false
+	}
+	if active {
+		return humidity > zone.HumidityTarget-HumiditySpread
+	}
+	return humidity > zone.HumidityTarget
+}
+
 func getThreshold(zone *model.Zone, active bool, secondary bool) float64 {
 	log.Debug().
 		Str("zone", zone.Label).
@@ -400,9 +532,35 @@ func getThreshold(zone *model.Zone, active bool, secondary bool) float64 {
 	return coolOn
 }
 
-func isOppositeMode(a, b model.SystemMode) bool {
-	return (a == model.ModeHeating && b == model.ModeCooling) ||
-		(a == model.ModeCooling && b == model.ModeHeating)
+// reportConflict logs and notifies once when a zone starts or stops being idled for a mode conflict.
+func reportConflict(zone *model.Zone, sysMode model.SystemMode, conflict bool) {
+	if !conflict {
+		log.Info().Str("zone", zone.ID).Str("mode", string(zone.Mode)).Str("system_mode", string(sysMode)).Msg("Zone mode conflict resolved")
+		return
+	}
+
+	log.Warn().Str("zone", zone.ID).Str("mode", string(zone.Mode)).Str("system_mode", string(sysMode)).Msg("Zone mode conflicts with system mode - idling zone")
+	title := fmt.Sprintf("Zone %s idled", zone.Label)
+	message := fmt.Sprintf("%s is set to %s but the system is %s. The zone stays idle until one of the modes changes.", zone.Label, zone.Mode, sysMode)
+	if err := notify(title, message); err != nil {
+		log.Warn().Err(err).Str("zone", zone.ID).Msg("Failed to send mode conflict notification")
+	}
+}
+
+// reportOffline logs and notifies once when every distribution device in a zone is offline, and
+// logs when one comes back.
+func reportOffline(zone *model.Zone, devices []string, offline bool) {
+	if !offline {
+		log.Info().Str("zone", zone.ID).Msg("Zone distribution device back online")
+		return
+	}
+
+	log.Warn().Str("zone", zone.ID).Strs("devices", devices).Msg("All distribution devices offline - skipping zone")
+	title := fmt.Sprintf("Zone %s unserved", zone.Label)
+	message := fmt.Sprintf("%s can't be heated or cooled until a distribution device is back online. Offline: %s.", zone.Label, strings.Join(devices, ", "))
+	if err := notify(title, message); err != nil {
+		log.Warn().Err(err).Str("zone", zone.ID).Msg("Failed to send offline zone notification")
+	}
 }
 
 func shouldBeOn(zt float64, threshold float64, mode model.SystemMode) bool {
@@ -570,6 +570,29 @@ func TestEvaluateZoneActions(t *testing.T) {
 				"deactivate_pump":   false,
 			},
 		},
+		{
+			name:               "Mode conflict - heating zone in cooling system is idled",
+			blowerActive:       true,
+			pumpActive:         true,
+			loopActive:         true,
+			handler:            testHandler,
+			loop:               testLoop,
+			canToggleHandler:   true,
+			canToggleLoop:      true,
+			temp:               60,
+			mode:               model.ModeHeating,
+			sysMode:            model.ModeCooling,
+			threshold:          70, // shouldPrimary = true, but the zone can't be heated
+			secondaryThreshold: 68,
+			want: map[string]bool{
+				"activate_loop":     false,
+				"deactivate_loop":   true,
+				"activate_blower":   false,
+				"activate_pump":     false,
+				"deactivate_blower": true,
+				"deactivate_pump":   true,
+			},
+		},
 		{
 			name:               "Both - heating - all already off, no changes",
 			loopActive:         false,
@@ -624,3 +647,184 @@ func TestEvaluateZoneActions(t *testing.T) {
 		})
 	}
 }
+
+func TestDehumidifying(t *testing.T) {
+	zone := &model.Zone{
+		ID:             "test-zone",
+		Mode:           model.ModeCooling,
+		Capabilities:   []string{"cooling", "dehumidify"},
+		HumidityTarget: 50,
+	}
+
+	tests := []struct {
+		name     string
+		humidity float64
+		active   bool
+		want     bool
+	}{
+		{"below target, idle", 49, false, false},
+		{"above target starts", 52, false, true},
+		{"active within spread keeps going", 48, true, true},
+		{"active below spread stops", 47, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := dehumidifying(zone, tt.humidity, tt.active); got != tt.want {
+				t.Errorf("dehumidifying(%v, %v) = %v; want %v", tt.humidity, tt.active, got, tt.want)
+			}
+		})
+	}
+
+	heating := *zone
+	heating.Mode = model.ModeHeating
+	if dehumidifying(&heating, 70, false) {
+		t.Errorf("dehumidifying() in heating mode = true; want false")
+	}
+	incapable := *zone
+	incapable.Capabilities = []string{"cooling"}
+	if dehumidifying(&incapable, 70, false) {
+		t.Errorf("dehumidifying() without the dehumidify capability = true; want false")
+	}
+}
+
+func TestCirculateDuty(t *testing.T) {
+	var duty circulateDuty
+	start := time.Now()
+	c := model.Circulation{MinutesPerHour: 20}
+
+	// Runs until the blower has had its 20 minutes, then rests for the rest of the hour
+	steps := []struct {
+		at      time.Duration
+		blower  bool
+		want    bool
+		comment string
+	}{
+		{0, false, true, "new window"},
+		{time.Minute, true, true, "blower just started"},
+		{15 * time.Minute, true, true, "14 minutes in"},
+		{21 * time.Minute, true, false, "duty reached"},
+		{40 * time.Minute, false, false, "resting"},
+		{61 * time.Minute, false, true, "next window"},
+	}
+	for _, step := range steps {
+		if got := duty.update(start.Add(step.at), step.blower, c); got != step.want {
+			t.Errorf("%s: update() = %v; want %v", step.comment, got, step.want)
+		}
+	}
+
+	if !duty.update(start.Add(70*time.Minute), true, model.Circulation{}) {
+		t.Errorf("continuous circulation should always run")
+	}
+}
+
+func TestCirculationActions(t *testing.T) {
+	between := model.Circulation{MinutesPerHour: 20, BetweenCalls: true}
+	tests := []struct {
+		name         string
+		mode         model.SystemMode
+		circulation  model.Circulation
+		in           map[string]bool
+		circulate    bool
+		blowerActive bool
+		pumpActive   bool
+		canToggle    bool
+		want         map[string]bool
+	}{
+		{
+			name: "circulate mode resting", mode: model.ModeCirculate, circulation: model.Circulation{MinutesPerHour: 20},
+			in: map[string]bool{"activate_blower": true}, circulate: false, canToggle: true,
+			want: map[string]bool{"activate_blower": false, "deactivate_blower": false},
+		},
+		{
+			name: "circulate mode resting turns blower off", mode: model.ModeCirculate, circulation: model.Circulation{MinutesPerHour: 20},
+			in: map[string]bool{}, circulate: false, blowerActive: true, canToggle: true,
+			want: map[string]bool{"deactivate_blower": true},
+		},
+		{
+			name: "circulate mode blower back on after pump stops", mode: model.ModeCirculate,
+			in: map[string]bool{"deactivate_pump": true}, circulate: true, blowerActive: true, pumpActive: true, canToggle: true,
+			want: map[string]bool{"deactivate_pump": true, "activate_blower": true},
+		},
+		{
+			name: "between calls starts blower", mode: model.ModeHeating, circulation: between,
+			in: map[string]bool{}, circulate: true, canToggle: true,
+			want: map[string]bool{"activate_blower": true},
+		},
+		{
+			name: "between calls keeps blower when call ends", mode: model.ModeCooling, circulation: between,
+			in: map[string]bool{"deactivate_blower": true, "deactivate_pump": true}, circulate: true, blowerActive: true, pumpActive: true, canToggle: true,
+			want: map[string]bool{"deactivate_blower": false, "deactivate_pump": true, "activate_blower": true},
+		},
+		{
+			name: "between calls rests blower", mode: model.ModeHeating, circulation: between,
+			in: map[string]bool{}, circulate: false, blowerActive: true, canToggle: true,
+			want: map[string]bool{"deactivate_blower": true},
+		},
+		{
+			name: "demand owns blower", mode: model.ModeHeating, circulation: between,
+			in: map[string]bool{}, circulate: false, blowerActive: true, pumpActive: true, canToggle: true,
+			want: map[string]bool{"deactivate_blower": false},
+		},
+		{
+			name: "without between calls heating is untouched", mode: model.ModeHeating, circulation: model.Circulation{MinutesPerHour: 20},
+			in: map[string]bool{}, circulate: true, canToggle: true,
+			want: map[string]bool{"activate_blower": false},
+		},
+		{
+			name: "can't toggle", mode: model.ModeHeating, circulation: between,
+			in: map[string]bool{}, circulate: true, canToggle: false,
+			want: map[string]bool{"activate_blower": false},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			circulationActions(tt.in, tt.mode, tt.circulation, tt.circulate, tt.blowerActive, tt.pumpActive, tt.canToggle)
+			for key, wantVal := range tt.want {
+				if tt.in[key] != wantVal {
+					t.Errorf("circulationActions[%s] = %v; want %v", key, tt.in[key], wantVal)
+				}
+			}
+		})
+	}
+}
+
+func TestReportConflict(t *testing.T) {
+	origNotify := notify
+	defer func() { notify = origNotify }()
+
+	var sent []string
+	notify = func(title, message string) error {
+		sent = append(sent, title+": "+message)
+		return nil
+	}
+
+	zone := &model.Zone{ID: "garage", Label: "Garage", Mode: model.ModeHeating}
+	reportConflict(zone, model.ModeCooling, true)
+	reportConflict(zone, model.ModeHeating, false)
+
+	want := []string{"Zone Garage idled: Garage is set to heating but the system is cooling. The zone stays idle until one of the modes changes."}
+	if len(sent) != 1 || sent[0] != want[0] {
+		t.Errorf("notifications = %v; want %v", sent, want)
+	}
+}
+
+func TestReportOffline(t *testing.T) {
+	origNotify := notify
+	defer func() { notify = origNotify }()
+
+	var sent []string
+	notify = func(title, message string) error {
+		sent = append(sent, title+": "+message)
+		return nil
+	}
+
+	zone := &model.Zone{ID: "basement", Label: "Basement"}
+	reportOffline(zone, []string{"basement_air_handler", "basement_radiant_loop"}, true)
+	reportOffline(zone, nil, false)
+
+	want := []string{"Zone Basement unserved: Basement can't be heated or cooled until a distribution device is back online. Offline: basement_air_handler, basement_radiant_loop."}
+	if len(sent) != 1 || sent[0] != want[0] {
+		t.Errorf("notifications = %v; want %v", sent, want)
+	}
+}
@@ -1,7 +1,6 @@
 package device
 
 import (
-	"database/sql"
 	"time"
 
 	"github.com/rs/zerolog/log"
@@ -10,106 +9,116 @@ import (
 	"github.com/thatsimonsguy/hvac-controller/internal/model"
 )
 
-var ActivateAirHandler = func(ah *model.AirHandler, dbConn *sql.DB) {
+// Store is the device state the actions read and persist.
+type Store interface {
+	UpdateDeviceLastChanged(deviceName string, timestamp time.Time) error
+	UpdateDeviceOnlineStatusByName(deviceName string, online bool, audit db.Audit) error
+	GetDeviceFlowSensors(deviceName string) (supply *model.Sensor, ret *model.Sensor, err error)
+	GetSystemMode() (model.SystemMode, error)
+}
+
+var ActivateAirHandler = func(ah *model.AirHandler, store Store) {
 	log.Info().Str("device", ah.Name).Msg("Activating air handler")
 	gpio.Activate(ah.CircPumpPin)
 	time.Sleep(5 * time.Second)
 	gpio.Activate(ah.Pin)
 	now := time.Now()
 	ah.LastChanged = now
-	if err := db.UpdateDeviceLastChanged(dbConn, ah.Name, now); err != nil {
+	if err := store.UpdateDeviceLastChanged(ah.Name, now); err != nil {
 		log.Error().Err(err).Str("device", ah.Name).Msg("Failed to update device last_changed in database")
 	}
+	VerifyDelivery(&ah.Device, store)
 }
 
-var ActivateBlower = func(ah *model.AirHandler, dbConn *sql.DB) {
+var ActivateBlower = func(ah *model.AirHandler, store Store) {
 	log.Info().Str("device", ah.Name).Msg("Activating blower")
 	gpio.Activate(ah.Pin)
 	now := time.Now()
 	ah.LastChanged = now
-	if err := db.UpdateDeviceLastChanged(dbConn, ah.Name, now); err != nil {
+	if err := store.UpdateDeviceLastChanged(ah.Name, now); err != nil {
 		log.Error().Err(err).Str("device", ah.Name).Msg("Failed to update device last_changed in database")
 	}
 }
 
-var DeactivateBlower = func(ah *model.AirHandler, dbConn *sql.DB) {
+var DeactivateBlower = func(ah *model.AirHandler, store Store) {
 	log.Info().Str("device", ah.Name).Msg("Deactivating blower")
 	gpio.Deactivate(ah.Pin)
 	now := time.Now()
 	ah.LastChanged = now
-	if err := db.UpdateDeviceLastChanged(dbConn, ah.Name, now); err != nil {
+	if err := store.UpdateDeviceLastChanged(ah.Name, now); err != nil {
 		log.Error().Err(err).Str("device", ah.Name).Msg("Failed to update device last_changed in database")
 	}
 }
 
-var DeactivateAirHandler = func(ah *model.AirHandler, dbConn *sql.DB) {
+var DeactivateAirHandler = func(ah *model.AirHandler, store Store) {
 	log.Info().Str("device", ah.Name).Msg("Deactivating air handler")
 	gpio.Deactivate(ah.Pin)
 	time.Sleep(30 * time.Second)
 	gpio.Deactivate(ah.CircPumpPin)
 	now := time.Now()
 	ah.LastChanged = now
-	if err := db.UpdateDeviceLastChanged(dbConn, ah.Name, now); err != nil {
+	if err := store.UpdateDeviceLastChanged(ah.Name, now); err != nil {
 		log.Error().Err(err).Str("device", ah.Name).Msg("Failed to update device last_changed in database")
 	}
 }
 
-var ActivateRadiantLoop = func(rl *model.RadiantFloorLoop, dbConn *sql.DB) {
+var ActivateRadiantLoop = func(rl *model.RadiantFloorLoop, store Store) {
 	log.Info().Str("device", rl.Name).Msg("Activating radiant loop")
 	gpio.Activate(rl.Pin)
 	now := time.Now()
 	rl.LastChanged = now
-	if err := db.UpdateDeviceLastChanged(dbConn, rl.Name, now); err != nil {
+	if err := store.UpdateDeviceLastChanged(rl.Name, now); err != nil {
 		log.Error().Err(err).Str("device", rl.Name).Msg("Failed to update device last_changed in database")
 	}
+	VerifyDelivery(&rl.Device, store)
 }
 
-var DeactivateRadiantLoop = func(rl *model.RadiantFloorLoop, dbConn *sql.DB) {
+var DeactivateRadiantLoop = func(rl *model.RadiantFloorLoop, store Store) {
 	log.Info().Str("device", rl.Name).Msg("Deactivating radiant loop")
 	gpio.Deactivate(rl.Pin)
 	now := time.Now()
 	rl.LastChanged = now
-	if err := db.UpdateDeviceLastChanged(dbConn, rl.Name, now); err != nil {
+	if err := store.UpdateDeviceLastChanged(rl.Name, now); err != nil {
 		log.Error().Err(err).Str("device", rl.Name).Msg("Failed to update device last_changed in database")
 	}
 }
 
-var ActivateBoiler = func(b *model.Boiler, dbConn *sql.DB) {
+var ActivateBoiler = func(b *model.Boiler, store Store) {
 	log.Info().Str("device", b.Name).Msg("Activating boiler")
 	gpio.Activate(b.Pin)
 	now := time.Now()
 	b.LastChanged = now
-	if err := db.UpdateDeviceLastChanged(dbConn, b.Name, now); err != nil {
+	if err := store.UpdateDeviceLastChanged(b.Name, now); err != nil {
 		log.Error().Err(err).Str("device", b.Name).Msg("Failed to update device last_changed in database")
 	}
 }
 
-var DeactivateBoiler = func(b *model.Boiler, dbConn *sql.DB) {
+var DeactivateBoiler = func(b *model.Boiler, store Store) {
 	log.Info().Str("device", b.Name).Msg("Deactivating boiler")
 	gpio.Deactivate(b.Pin)
 	now := time.Now()
 	b.LastChanged = now
-	if err := db.UpdateDeviceLastChanged(dbConn, b.Name, now); err != nil {
+	if err := store.UpdateDeviceLastChanged(b.Name, now); err != nil {
 		log.Error().Err(err).Str("device", b.Name).Msg("Failed to update device last_changed in database")
 	}
 }
 
-var ActivateHeatPump = func(hp *model.HeatPump, dbConn *sql.DB) {
+var ActivateHeatPump = func(hp *model.HeatPump, store Store) {
 	log.Info().Str("device", hp.Name).Msg("Activating heat pump")
 	gpio.Activate(hp.Pin)
 	now := time.Now()
 	hp.LastChanged = now
-	if err := db.UpdateDeviceLastChanged(dbConn, hp.Name, now); err != nil {
+	if err := store.UpdateDeviceLastChanged(hp.Name, now); err != nil {
 		log.Error().Err(err).Str("device", hp.Name).Msg("Failed to update device last_changed in database")
 	}
 }
 
-var DeactivateHeatPump = func(hp *model.HeatPump, dbConn *sql.DB) {
+var DeactivateHeatPump = func(hp *model.HeatPump, store Store) {
 	log.Info().Str("device", hp.Name).Msg("Deactivating heat pump")
 	gpio.Deactivate(hp.Pin)
 	now := time.Now()
 	hp.LastChanged = now
-	if err := db.UpdateDeviceLastChanged(dbConn, hp.Name, now); err != nil {
+	if err := store.UpdateDeviceLastChanged(hp.Name, now); err != nil {
 		log.Error().Err(err).Str("device", hp.Name).Msg("Failed to update device last_changed in database")
 	}
 }
@@ -0,0 +1,210 @@
+package device
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/thatsimonsguy/hvac-controller/db"
+	"github.com/thatsimonsguy/hvac-controller/internal/env"
+	"github.com/thatsimonsguy/hvac-controller/internal/gpio"
+	"github.com/thatsimonsguy/hvac-controller/internal/model"
+	"github.com/thatsimonsguy/hvac-controller/internal/notifications"
+)
+
+// how often supply/return sensors are sampled while waiting for a delta
+const FlowPollInterval = 30 * time.Second
+
+var currentlyActive = gpio.CurrentlyActive
+var sendAlert = notifications.Send
+
+// SensorReader reads a sensor with the driver for its type, see temperature.Service.Reader.
+type SensorReader interface {
+	ReadTemp(ctx context.Context, sensor model.Sensor) (float64, error)
+}
+
+var flowSensors SensorReader
+
+// SetSensorReader sets how supply and return sensors are read for flow verification, sharing the
+// temperature service's drivers so every sensor type works.
+func SetSensorReader(r SensorReader) {
+	flowSensors = r
+}
+
+// readSensorTemp reads a flow sensor, giving up after the configured sensor read timeout.
+var readSensorTemp = func(sensor model.Sensor) (float64, error) {
+	if flowSensors == nil {
+		return 0, errors.New("no sensor reader configured")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), env.Cfg().SensorReadTimeout())
+	defer cancel()
+	return flowSensors.ReadTemp(ctx, sensor)
+}
+
+// verifications tracks the latest verification started for each device. Activating a device again
+// supersedes its earlier run, so only one verification per device is ever live.
+var verifications = struct {
+	sync.Mutex
+	seq     uint64
+	current map[string]uint64 // device name -> generation of its live verification
+}{current: make(map[string]uint64)}
+
+// startVerification registers a new verification for a device and returns its generation.
+func startVerification(name string) uint64 {
+	verifications.Lock()
+	defer verifications.Unlock()
+	verifications.seq++
+	verifications.current[name] = verifications.seq
+	return verifications.seq
+}
+
+// verificationCurrent reports whether generation is still the device's live verification.
+func verificationCurrent(name string, generation uint64) bool {
+	verifications.Lock()
+	defer verifications.Unlock()
+	return verifications.current[name] == generation
+}
+
+// finishVerification forgets a device's verification unless a newer one has replaced it.
+func finishVerification(name string, generation uint64) {
+	verifications.Lock()
+	defer verifications.Unlock()
+	if verifications.current[name] == generation {
+		delete(verifications.current, name)
+	}
+}
+
+// VerifyDelivery checks in the background that an energized distribution device actually moves heat,
+// by waiting for the expected supply/return delta within the configured window. Devices without both
+// sensors are skipped, and a run stops once the device is switched off or activated again. On failure
+// an alert is sent and, if configured, the device is taken offline; the zone controller then switches
+// it off.
+var VerifyDelivery = func(d *model.Device, store Store) {
+	cfg := env.Cfg()
+	if cfg.FlowVerifyWindowSeconds <= 0 {
+		return
+	}
+
+	supply, ret, err := store.GetDeviceFlowSensors(d.Name)
+	if err != nil {
+		log.Error().Err(err).Str("device", d.Name).Msg("Could not retrieve flow sensors")
+		return
+	}
+	if supply == nil || ret == nil {
+		return
+	}
+
+	mode, err := store.GetSystemMode()
+	if err != nil {
+		log.Error().Err(err).Str("device", d.Name).Msg("Could not retrieve system mode for flow verification")
+		return
+	}
+	if mode != model.ModeHeating && mode != model.ModeCooling {
+		return
+	}
+
+	name := d.Name
+	pin := d.Pin
+	window := time.Duration(cfg.FlowVerifyWindowSeconds) * time.Second
+	minDelta := cfg.FlowVerifyMinDelta
+
+	generation := startVerification(name)
+	go func() {
+		defer finishVerification(name, generation)
+		stillActive := func() bool { return verificationCurrent(name, generation) && currentlyActive(pin) }
+
+		confirmed, lastDelta, aborted := verifyFlow(
+			*supply,
+			*ret,
+			mode,
+			minDelta,
+			window,
+			FlowPollInterval,
+			stillActive,
+			time.Sleep,
+		)
+		if aborted || (!confirmed && !stillActive()) {
+			log.Debug().Str("device", name).Msg("Device deactivated or reactivated before flow verification completed")
+			return
+		}
+		if confirmed {
+			log.Info().Str("device", name).Float64("delta", lastDelta).Msg("Flow verification passed")
+			return
+		}
+
+		log.Error().
+			Str("device", name).
+			Str("mode", string(mode)).
+			Float64("delta", lastDelta).
+			Float64("min_delta", minDelta).
+			Dur("window", window).
+			Msg("Flow verification failed - no supply/return delta after activation")
+
+		message := fmt.Sprintf("[Flow Verification Failed] %s: supply/return delta %.1f°F after %s (expected %.1f°F)",
+			name, lastDelta, window, minDelta)
+		if err := sendAlert("HVAC Flow Failure", message); err != nil {
+			log.Error().Err(err).Msg("Failed to send flow verification notification")
+		}
+
+		if env.Cfg().FlowVerifyTakeOffline {
+			log.Warn().Str("device", name).Msg("Taking device offline after failed flow verification")
+			if err := store.UpdateDeviceOnlineStatusByName(name, false, db.Audit{
+				Actor:  "flow-verification",
+				Reason: fmt.Sprintf("supply/return delta %.1f°F after %s", lastDelta, window),
+			}); err != nil {
+				log.Error().Err(err).Str("device", name).Msg("Failed to take device offline")
+			}
+		}
+	}()
+}
+
+// verifyFlow samples supply and return temperatures until the expected delta is seen, the window
+// elapses, or the device is no longer active.
+func verifyFlow(
+	supply model.Sensor,
+	ret model.Sensor,
+	mode model.SystemMode,
+	minDelta float64,
+	window time.Duration,
+	interval time.Duration,
+	stillActive func() bool,
+	sleepFunc func(time.Duration),
+) (confirmed bool, lastDelta float64, aborted bool) {
+	for elapsed := time.Duration(0); elapsed < window; elapsed += interval {
+		sleepFunc(interval)
+
+		if !stillActive() {
+			return false, lastDelta, true
+		}
+
+		supplyTemp, err := readSensorTemp(supply)
+		if err != nil {
+			log.Warn().Err(err).Str("sensor", supply.ID).Msg("Could not read supply sensor")
+			continue
+		}
+		returnTemp, err := readSensorTemp(ret)
+		if err != nil {
+			log.Warn().Err(err).Str("sensor", ret.ID).Msg("Could not read return sensor")
+			continue
+		}
+
+		lastDelta = flowDelta(mode, supplyTemp, returnTemp)
+		if lastDelta >= minDelta {
+			return true, lastDelta, false
+		}
+	}
+	return false, lastDelta, false
+}
+
+// flowDelta returns the supply/return delta in the direction expected for the mode:
+// supply above return when heating, below return when cooling.
+func flowDelta(mode model.SystemMode, supplyTemp, returnTemp float64) float64 {
+	if mode == model.ModeCooling {
+		return returnTemp - supplyTemp
+	}
+	return supplyTemp - returnTemp
+}
@@ -0,0 +1,122 @@
+package device
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/thatsimonsguy/hvac-controller/internal/model"
+)
+
+func mockSensorTemps(t *testing.T, temps map[string][]float64) {
+	orig := readSensorTemp
+	t.Cleanup(func() { readSensorTemp = orig })
+
+	calls := make(map[string]int)
+	readSensorTemp = func(sensor model.Sensor) (float64, error) {
+		series, ok := temps[sensor.ID]
+		if !ok {
+			return 0, errors.New("no such sensor")
+		}
+		i := calls[sensor.ID]
+		if i >= len(series) {
+			i = len(series) - 1
+		}
+		calls[sensor.ID]++
+		return series[i], nil
+	}
+}
+
+func noSleep(time.Duration) {}
+
+var (
+	supplySensor = model.Sensor{ID: "supply", Bus: "28-000000000001"}
+	returnSensor = model.Sensor{ID: "return", Bus: "28-000000000002"}
+)
+
+func TestFlowDelta(t *testing.T) {
+	assert.Equal(t, 10.0, flowDelta(model.ModeHeating, 110, 100))
+	assert.Equal(t, 8.0, flowDelta(model.ModeCooling, 45, 53))
+	assert.Equal(t, -8.0, flowDelta(model.ModeHeating, 45, 53))
+}
+
+func TestVerifyFlow_DeltaReachedWithinWindow(t *testing.T) {
+	mockSensorTemps(t, map[string][]float64{
+		"supply": {70, 80, 95},
+		"return": {70, 72, 75},
+	})
+
+	confirmed, delta, aborted := verifyFlow(supplySensor, returnSensor, model.ModeHeating, 10, 5*time.Minute, time.Minute,
+		func() bool { return true }, noSleep)
+
+	assert.True(t, confirmed)
+	assert.False(t, aborted)
+	assert.Equal(t, 20.0, delta)
+}
+
+func TestVerifyFlow_NoDeltaFails(t *testing.T) {
+	mockSensorTemps(t, map[string][]float64{
+		"supply": {70},
+		"return": {69},
+	})
+
+	confirmed, delta, aborted := verifyFlow(supplySensor, returnSensor, model.ModeHeating, 5, 3*time.Minute, time.Minute,
+		func() bool { return true }, noSleep)
+
+	assert.False(t, confirmed)
+	assert.False(t, aborted)
+	assert.Equal(t, 1.0, delta)
+}
+
+func TestVerifyFlow_CoolingDelta(t *testing.T) {
+	mockSensorTemps(t, map[string][]float64{
+		"supply": {48},
+		"return": {56},
+	})
+
+	confirmed, _, _ := verifyFlow(supplySensor, returnSensor, model.ModeCooling, 5, time.Minute, time.Minute,
+		func() bool { return true }, noSleep)
+
+	assert.True(t, confirmed)
+}
+
+func TestVerifyFlow_AbortsWhenDeviceDeactivated(t *testing.T) {
+	mockSensorTemps(t, map[string][]float64{
+		"supply": {70},
+		"return": {70},
+	})
+
+	confirmed, _, aborted := verifyFlow(supplySensor, returnSensor, model.ModeHeating, 5, 10*time.Minute, time.Minute,
+		func() bool { return false }, noSleep)
+
+	assert.False(t, confirmed)
+	assert.True(t, aborted)
+}
+
+func TestVerifyFlow_SensorErrorsCountAsNoDelta(t *testing.T) {
+	mockSensorTemps(t, map[string][]float64{
+		"supply": {90},
+	})
+
+	confirmed, _, aborted := verifyFlow(supplySensor, model.Sensor{ID: "missing"}, model.ModeHeating, 5, 2*time.Minute, time.Minute,
+		func() bool { return true }, noSleep)
+
+	assert.False(t, confirmed)
+	assert.False(t, aborted)
+}
+
+func TestVerificationGenerations(t *testing.T) {
+	first := startVerification("main_ah")
+	assert.True(t, verificationCurrent("main_ah", first))
+
+	// Reactivating supersedes the earlier run, which must not clear the new one when it exits
+	second := startVerification("main_ah")
+	assert.False(t, verificationCurrent("main_ah", first))
+	finishVerification("main_ah", first)
+	assert.True(t, verificationCurrent("main_ah", second))
+
+	finishVerification("main_ah", second)
+	assert.False(t, verificationCurrent("main_ah", second))
+}
@@ -1,9 +1,20 @@
 package env
 
 import (
+	"sync/atomic"
+
 	"github.com/thatsimonsguy/hvac-controller/internal/config"
 )
 
-var (
-	Cfg *config.Config
-)
+var cfg atomic.Pointer[config.Config]
+
+// Cfg returns the active configuration. It may be swapped at runtime by a config reload,
+// so callers that read several fields together should hold on to the returned pointer.
+func Cfg() *config.Config {
+	return cfg.Load()
+}
+
+// SetCfg atomically replaces the active configuration.
+func SetCfg(c *config.Config) {
+	cfg.Store(c)
+}
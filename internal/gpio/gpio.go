@@ -1,16 +1,17 @@
 package gpio
 
 import (
-	"database/sql"
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/rs/zerolog/log"
-	"github.com/thatsimonsguy/hvac-controller/db"
 	"github.com/thatsimonsguy/hvac-controller/internal/pinctrl"
 
 	"github.com/thatsimonsguy/hvac-controller/internal/model"
@@ -22,7 +23,17 @@ var safeMode bool
 // readPinLevel is a mockable wrapper for pinctrl.ReadLevel
 var readPinLevel = pinctrl.ReadLevel
 
-func ValidateInitialPinStates(dbConn *sql.DB) error {
+// DeviceStore lists the devices and system settings whose pins are checked at startup.
+type DeviceStore interface {
+	GetHeatPumps() ([]model.HeatPump, error)
+	GetAirHandlers() ([]model.AirHandler, error)
+	GetBoilers() ([]model.Boiler, error)
+	GetRadiantLoops() ([]model.RadiantFloorLoop, error)
+	GetMainPowerPin() (model.GPIOPin, error)
+	GetSystemMode() (model.SystemMode, error)
+}
+
+func ValidateInitialPinStates(store DeviceStore) error {
 	type pinWithMeta struct {
 		Name       string
 		Pin        model.GPIOPin
@@ -31,11 +42,11 @@ func ValidateInitialPinStates(dbConn *sql.DB) error {
 
 	var checks []pinWithMeta
 
-	heatPumps, err := db.GetHeatPumps(dbConn)
+	heatPumps, err := store.GetHeatPumps()
 	if err != nil {
 		return err
 	}
-	systemMode, err := db.GetSystemMode(dbConn)
+	systemMode, err := store.GetSystemMode()
 	if err != nil {
 		return err
 	}
@@ -57,7 +68,7 @@ func ValidateInitialPinStates(dbConn *sql.DB) error {
 		})
 	}
 
-	airHandlers, err := db.GetAirHandlers(dbConn)
+	airHandlers, err := store.GetAirHandlers()
 	if err != nil {
 		return err
 	}
@@ -67,14 +78,14 @@ func ValidateInitialPinStates(dbConn *sql.DB) error {
 			pinWithMeta{ah.Name + ".circ_pump", ah.CircPumpPin, false},
 		)
 	}
-	boilers, err := db.GetBoilers(dbConn)
+	boilers, err := store.GetBoilers()
 	if err != nil {
 		return err
 	}
 	for _, b := range boilers {
 		checks = append(checks, pinWithMeta{b.Name, b.Pin, false})
 	}
-	radiantLoops, err := db.GetRadiantLoops(dbConn)
+	radiantLoops, err := store.GetRadiantLoops()
 	if err != nil {
 		return err
 	}
@@ -82,7 +93,7 @@ func ValidateInitialPinStates(dbConn *sql.DB) error {
 		checks = append(checks, pinWithMeta{rf.Name, rf.Pin, false})
 	}
 
-	mainPower, err := db.GetMainPowerPin(dbConn)
+	mainPower, err := store.GetMainPowerPin()
 	if err != nil {
 		return err
 	}
@@ -188,42 +199,99 @@ var CurrentlyActive = func(pin model.GPIOPin) bool {
 	return pin.ActiveHigh == level
 }
 
-func ReadSensorTempWithRetries(sensorPath string, retries int) float64 {
-	temp, err := ReadSensorTemp(sensorPath)
-	if retries < 0 {
-		shutdown.ShutdownWithError(err, "max sensor retries reached")
+// SensorRetryDelay is how long ReadSensorTempContext waits between attempts.
+var SensorRetryDelay = 2 * time.Second
+
+// ErrSensorCRC is returned when a 1-Wire sensor's w1_slave CRC line does not end in YES.
+var ErrSensorCRC = errors.New("sensor CRC check failed")
+
+// sensorRead is a read of one sensor in progress. Reads of the same sensor are joined so a hung
+// bus never has more than one outstanding read per sensor.
+type sensorRead struct {
+	done chan struct{}
+	temp float64
+	err  error
+}
+
+var (
+	sensorReadsMu sync.Mutex
+	sensorReads   = make(map[string]*sensorRead)
+)
+
+func startSensorRead(sensorPath string) *sensorRead {
+	sensorReadsMu.Lock()
+	defer sensorReadsMu.Unlock()
+
+	if read, ok := sensorReads[sensorPath]; ok {
+		return read
 	}
-	if err != nil && retries > 0 {
-		time.Sleep(2 * time.Second)
-		return ReadSensorTempWithRetries(sensorPath, retries-1)
+	read := &sensorRead{done: make(chan struct{})}
+	sensorReads[sensorPath] = read
+	go func() {
+		read.temp, read.err = ReadSensorTemp(sensorPath)
+		sensorReadsMu.Lock()
+		delete(sensorReads, sensorPath)
+		sensorReadsMu.Unlock()
+		close(read.done)
+	}()
+	return read
+}
+
+// ReadSensorTempContext reads a sensor, retrying up to retries more times on error. It gives up
+// when ctx is done, even if the underlying read is still blocked.
+func ReadSensorTempContext(ctx context.Context, sensorPath string, retries int) (float64, error) {
+	for attempt := 0; ; attempt++ {
+		read := startSensorRead(sensorPath)
+		select {
+		case <-read.done:
+		case <-ctx.Done():
+			return 0.0, fmt.Errorf("read %s: %w", sensorPath, ctx.Err())
+		}
+		if read.err == nil {
+			return read.temp, nil
+		}
+		if attempt >= retries {
+			return 0.0, read.err
+		}
+
+		select {
+		case <-time.After(SensorRetryDelay):
+		case <-ctx.Done():
+			return 0.0, fmt.Errorf("read %s: %w (last error: %v)", sensorPath, ctx.Err(), read.err)
+		}
 	}
-	return temp
 }
 
 var ReadSensorTemp = func(sensorPath string) (float64, error) {
 	file := filepath.Join(sensorPath, "w1_slave")
 	data, err := os.ReadFile(file)
 	if err != nil {
-		log.Error().Err(err).Msg("failed to read sensor data")
-		return 0.0, err
+		return 0.0, fmt.Errorf("failed to read sensor data: %w", err)
 	}
+	return parseW1Slave(string(data))
+}
 
-	lines := strings.Split(string(data), "\n")
+// parseW1Slave parses the two-line w1_slave format, e.g.
+//
+//	72 01 4b 46 7f ff 0e 10 57 : crc=57 YES
+//	72 01 4b 46 7f ff 0e 10 57 t=23125
+func parseW1Slave(data string) (float64, error) {
+	lines := strings.Split(data, "\n")
 	if len(lines) < 2 || !strings.Contains(lines[1], "t=") {
-		log.Error().Err(err).Msg("temperature data missing or malformed")
-		return 0.0, err
+		return 0.0, fmt.Errorf("temperature data missing or malformed: %q", data)
+	}
+	if !strings.HasSuffix(strings.TrimSpace(lines[0]), "YES") {
+		return 0.0, fmt.Errorf("%w: %q", ErrSensorCRC, lines[0])
 	}
 
 	parts := strings.Split(lines[1], "t=")
 	if len(parts) != 2 {
-		log.Error().Err(err).Msg("could not parse temperature line")
-		return 0.0, err
+		return 0.0, fmt.Errorf("could not parse temperature line: %q", lines[1])
 	}
 
-	tempMilliC, err := strconv.Atoi(parts[1])
+	tempMilliC, err := strconv.Atoi(strings.TrimSpace(parts[1]))
 	if err != nil {
-		log.Error().Err(err).Msg("failed to convert temperature to int")
-		return 0.0, err
+		return 0.0, fmt.Errorf("failed to convert temperature to int: %w", err)
 	}
 
 	// Celsius to Fahrenheit: F = C × 9/5 + 32
@@ -1,11 +1,19 @@
 package gpio
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	hvacdb "github.com/thatsimonsguy/hvac-controller/db"
 	"github.com/thatsimonsguy/hvac-controller/internal/model"
 
 	_ "github.com/mattn/go-sqlite3"
@@ -47,7 +55,11 @@ func setupTestDB(t *testing.T) *sql.DB {
 			mode_pin_number INTEGER,
 			mode_pin_active_high BOOLEAN,
 			is_primary BOOLEAN,
-			last_rotated TEXT
+			last_rotated TEXT,
+			recirculation_policy TEXT,
+			recirculation_started_at TEXT,
+			recirculation_ends_at TEXT,
+			recirculation_manual BOOLEAN NOT NULL DEFAULT FALSE
 		);
 
 		-- Heat pump
@@ -113,7 +125,7 @@ func TestValidateInitialPinStates_AllSafe(t *testing.T) {
 	}
 
 	// Run validation
-	err := ValidateInitialPinStates(db)
+	err := ValidateInitialPinStates(hvacdb.New(db))
 
 	// Assert
 	assert.NoError(t, err)
@@ -160,7 +172,7 @@ func TestValidateInitialPinStates_CorrectionNeeded(t *testing.T) {
 	}
 
 	// Run validation
-	err := ValidateInitialPinStates(db)
+	err := ValidateInitialPinStates(hvacdb.New(db))
 
 	// Assert
 	assert.NoError(t, err)
@@ -209,7 +221,7 @@ func TestValidateInitialPinStates_ActiveHighCorrection(t *testing.T) {
 	}
 
 	// Run validation
-	err := ValidateInitialPinStates(db)
+	err := ValidateInitialPinStates(hvacdb.New(db))
 
 	// Assert
 	assert.NoError(t, err)
@@ -233,7 +245,7 @@ func TestValidateInitialPinStates_ReadError(t *testing.T) {
 	}
 
 	// Run validation
-	err := ValidateInitialPinStates(db)
+	err := ValidateInitialPinStates(hvacdb.New(db))
 
 	// Assert
 	assert.Error(t, err)
@@ -274,7 +286,7 @@ func TestValidateInitialPinStates_MultiplePinsNeedCorrection(t *testing.T) {
 	}
 
 	// Run validation
-	err := ValidateInitialPinStates(db)
+	err := ValidateInitialPinStates(hvacdb.New(db))
 
 	// Assert
 	assert.NoError(t, err)
@@ -323,7 +335,7 @@ func TestValidateInitialPinStates_CoolingModeWithModePin(t *testing.T) {
 	}
 
 	// Run validation
-	err = ValidateInitialPinStates(db)
+	err = ValidateInitialPinStates(hvacdb.New(db))
 
 	// Assert
 	assert.NoError(t, err)
@@ -375,7 +387,7 @@ func TestValidateInitialPinStates_HeatingModeNoModePin(t *testing.T) {
 	}
 
 	// Run validation
-	err := ValidateInitialPinStates(db)
+	err := ValidateInitialPinStates(hvacdb.New(db))
 
 	// Assert
 	assert.NoError(t, err)
@@ -396,9 +408,91 @@ func TestValidateInitialPinStates_DatabaseError(t *testing.T) {
 	}
 
 	// Run validation
-	err := ValidateInitialPinStates(db)
+	err := ValidateInitialPinStates(hvacdb.New(db))
 
 	// Assert
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "sql: database is closed")
 }
+
+func TestParseW1Slave(t *testing.T) {
+	temp, err := parseW1Slave("72 01 4b 46 7f ff 0e 10 57 : crc=57 YES\n72 01 4b 46 7f ff 0e 10 57 t=23125\n")
+	require.NoError(t, err)
+	assert.InDelta(t, 73.625, temp, 0.001)
+
+	_, err = parseW1Slave("72 01 4b 46 7f ff 0e 10 57 : crc=57 NO\n72 01 4b 46 7f ff 0e 10 57 t=23125\n")
+	assert.ErrorIs(t, err, ErrSensorCRC)
+
+	_, err = parseW1Slave("72 01 4b 46 7f ff 0e 10 57 : crc=57 YES\n")
+	assert.Error(t, err)
+
+	_, err = parseW1Slave("72 01 4b 46 7f ff 0e 10 57 : crc=57 YES\n72 01 t=abc\n")
+	assert.Error(t, err)
+}
+
+func TestReadSensorTemp_File(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "w1_slave"),
+		[]byte("50 05 4b 46 7f ff 0c 10 1c : crc=1c YES\n50 05 4b 46 7f ff 0c 10 1c t=85000\n"), 0o644))
+
+	temp, err := ReadSensorTemp(dir)
+	require.NoError(t, err)
+	assert.InDelta(t, 185.0, temp, 0.001)
+
+	_, err = ReadSensorTemp(filepath.Join(dir, "missing"))
+	assert.Error(t, err)
+}
+
+func mockSensorRead(t *testing.T, read func(string) (float64, error)) {
+	origRead, origDelay := ReadSensorTemp, SensorRetryDelay
+	t.Cleanup(func() { ReadSensorTemp, SensorRetryDelay = origRead, origDelay })
+	ReadSensorTemp = read
+	SensorRetryDelay = time.Millisecond
+}
+
+func TestReadSensorTempContext_Retries(t *testing.T) {
+	calls := 0
+	mockSensorRead(t, func(string) (float64, error) {
+		calls++
+		if calls < 3 {
+			return 0, ErrSensorCRC
+		}
+		return 70.0, nil
+	})
+
+	temp, err := ReadSensorTempContext(context.Background(), "/sensor", 3)
+	require.NoError(t, err)
+	assert.Equal(t, 70.0, temp)
+	assert.Equal(t, 3, calls)
+
+	// Gives up after the last retry
+	calls = 0
+	ReadSensorTemp = func(string) (float64, error) {
+		calls++
+		return 0, ErrSensorCRC
+	}
+	_, err = ReadSensorTempContext(context.Background(), "/sensor", 1)
+	assert.ErrorIs(t, err, ErrSensorCRC)
+	assert.Equal(t, 2, calls)
+}
+
+func TestReadSensorTempContext_HungReadTimesOut(t *testing.T) {
+	release := make(chan struct{})
+	var started atomic.Int32
+	mockSensorRead(t, func(string) (float64, error) {
+		started.Add(1)
+		<-release
+		return 70.0, nil
+	})
+	defer close(release)
+
+	for i := 0; i < 2; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		_, err := ReadSensorTempContext(ctx, "/hung", 3)
+		cancel()
+		assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	}
+
+	// The second attempt joined the read still blocked from the first
+	assert.Equal(t, int32(1), started.Load())
+}
@@ -1,6 +1,10 @@
 package model
 
-import "time"
+import (
+	"fmt"
+	"math"
+	"time"
+)
 
 type SystemMode string
 
@@ -16,8 +20,202 @@ type Zone struct {
 	Label        string   `json:"label"`
 	Setpoint     float64  `json:"setpoint"`
 	Capabilities []string `json:"capabilities"` // e.g. ["heating", "cooling"]
-	Sensor       Sensor   `json:"sensor"`
+	Sensor       Sensor   `json:"sensor"`       // primary sensor, stands in when none of Sensors has a usable reading
 	Mode         SystemMode
+
+	// Optional sensors whose readings are combined into the zone temperature, see ZoneSensors
+	Sensors     []ZoneSensor `json:"sensors,omitempty"`
+	Aggregation string       `json:"aggregation,omitempty"` // one of the Aggregate* methods, empty means mean
+
+	// Optional relative humidity sensing; zones with the dehumidify capability keep cooling while
+	// humidity is above HumidityTarget (percent)
+	HumiditySensor *Sensor `json:"humidity_sensor,omitempty"`
+	HumidityTarget float64 `json:"humidity_target,omitempty"`
+
+	// Failsafe settings; nil means enabled, with the system-wide override limits
+	FailsafeEnabled *bool    `json:"failsafe_enabled,omitempty"`
+	FailsafeMinTemp *float64 `json:"failsafe_min_temp,omitempty"`
+	FailsafeMaxTemp *float64 `json:"failsafe_max_temp,omitempty"`
+
+	// Blower circulation duty; nil keeps whatever was last set through the API
+	Circulate *Circulation `json:"circulate,omitempty"`
+
+	// Weight of the zone's heating demand, see Demand; 0 weighs like 1
+	Priority float64 `json:"priority,omitempty"`
+
+	// Set by the failsafe controller while the zone's sensor has no valid readings
+	SensorDegradedSince *time.Time `json:"-"`
+
+	// Set by the buffer controller while the zone is shed for lack of heating capacity
+	ShedSince *time.Time `json:"-"`
+}
+
+// AllZonesGroup is the implicit group of every zone; no configured group may use the ID.
+const AllZonesGroup = "all"
+
+// ZoneGroup is a named set of zones whose mode and setpoint can be changed together.
+type ZoneGroup struct {
+	ID    string   `json:"id"`
+	Label string   `json:"label"`
+	Zones []string `json:"zones"` // zone IDs
+}
+
+// ModeCapability is the zone capability a mode needs, or "" for off, which every zone supports.
+func ModeCapability(mode SystemMode) string {
+	switch mode {
+	case ModeHeating:
+		return "heating"
+	case ModeCooling:
+		return "cooling"
+	case ModeCirculate:
+		return "fan"
+	}
+	return ""
+}
+
+// ModesConflict reports whether a zone in zoneMode can't be served while the system is in sysMode:
+// heating and cooling need the buffer tank at opposite temperatures.
+func ModesConflict(zoneMode, sysMode SystemMode) bool {
+	return (zoneMode == ModeHeating && sysMode == ModeCooling) ||
+		(zoneMode == ModeCooling && sysMode == ModeHeating)
+}
+
+// ZoneCapability is how one capability of a zone is derived: the zone config must list it and a
+// distribution device attached to the zone must provide it.
+type ZoneCapability struct {
+	Capability string   `json:"capability"`
+	Listed     bool     `json:"listed"`  // in the zone's capabilities
+	Devices    []string `json:"devices"` // attached devices that provide it
+}
+
+// Available reports whether the zone can use the capability.
+func (c ZoneCapability) Available() bool {
+	return c.Listed && len(c.Devices) > 0
+}
+
+// CapabilityReport is what a zone can do, derived from its config and attached devices.
+type CapabilityReport struct {
+	Zone         string           `json:"zone"`
+	Capabilities []ZoneCapability `json:"capabilities"`
+}
+
+// CheckMode returns nil if the zone can be put in mode, or an error saying which capability is
+// missing and why.
+func (r CapabilityReport) CheckMode(mode SystemMode) error {
+	capability := ModeCapability(mode)
+	if capability == "" {
+		return nil
+	}
+	c := ZoneCapability{Capability: capability}
+	for _, zc := range r.Capabilities {
+		if zc.Capability == capability {
+			c = zc
+		}
+	}
+	switch {
+	case c.Available():
+		return nil
+	case !c.Listed && len(c.Devices) == 0:
+		return fmt.Errorf("zone %s doesn't list the %q capability and no attached device provides it", r.Zone, capability)
+	case !c.Listed:
+		return fmt.Errorf("zone %s doesn't list the %q capability", r.Zone, capability)
+	default:
+		return fmt.Errorf("zone %s lists %q but no attached device provides it", r.Zone, capability)
+	}
+}
+
+// SupportedModes lists the modes the zone can be put in, always including off.
+func (r CapabilityReport) SupportedModes() []SystemMode {
+	modes := []SystemMode{ModeOff}
+	for _, mode := range []SystemMode{ModeHeating, ModeCooling, ModeCirculate} {
+		if r.CheckMode(mode) == nil {
+			modes = append(modes, mode)
+		}
+	}
+	return modes
+}
+
+// Circulation is how much a zone's blower runs just to move air. In circulate mode the blower
+// runs MinutesPerHour each hour; with BetweenCalls it also does so in heating and cooling mode
+// while there is no call for heat or cooling.
+type Circulation struct {
+	MinutesPerHour int  `json:"minutes_per_hour,omitempty"` // 0 or 60 runs the blower continuously
+	BetweenCalls   bool `json:"between_calls,omitempty"`
+}
+
+// Continuous reports whether the blower circulates all the time rather than on a duty cycle.
+func (c Circulation) Continuous() bool {
+	return c.MinutesPerHour <= 0 || c.MinutesPerHour >= 60
+}
+
+// ZoneSensors returns the sensors the zone temperature is computed from: Sensors if set,
+// otherwise just the primary sensor.
+func (z Zone) ZoneSensors() []ZoneSensor {
+	if len(z.Sensors) > 0 {
+		return z.Sensors
+	}
+	return []ZoneSensor{{Sensor: z.Sensor}}
+}
+
+// AggregationMethod is how the zone's sensor readings are combined, defaulting to the mean.
+func (z Zone) AggregationMethod() string {
+	if z.Aggregation == "" {
+		return AggregateMean
+	}
+	return z.Aggregation
+}
+
+// HasCapability reports whether the zone lists the capability, e.g. "cooling".
+func (z Zone) HasCapability(capability string) bool {
+	for _, c := range z.Capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// FailsafeActive reports whether the failsafe controller protects this zone.
+func (z Zone) FailsafeActive() bool {
+	return z.FailsafeEnabled == nil || *z.FailsafeEnabled
+}
+
+// CirculateSettings returns the zone's circulation duty, continuous circulate mode only when unset.
+func (z Zone) CirculateSettings() Circulation {
+	if z.Circulate == nil {
+		return Circulation{}
+	}
+	return *z.Circulate
+}
+
+// PriorityWeight is how heavily the zone's heating demand counts, 1 when no priority is set.
+func (z Zone) PriorityWeight() float64 {
+	if z.Priority <= 0 {
+		return 1
+	}
+	return z.Priority
+}
+
+// Demand is how far a heating zone at temp is below its setpoint, weighted by its priority. Zones
+// that aren't heating have no demand.
+func (z Zone) Demand(temp float64) float64 {
+	if z.Mode != ModeHeating {
+		return 0
+	}
+	return math.Max(0, z.Setpoint-temp) * z.PriorityWeight()
+}
+
+// FailsafeLimits returns the zone's freeze-protection and overheat limits, falling back to the
+// given system-wide limits where the zone doesn't set its own.
+func (z Zone) FailsafeLimits(defaultMin, defaultMax float64) (minTemp, maxTemp float64) {
+	minTemp, maxTemp = defaultMin, defaultMax
+	if z.FailsafeMinTemp != nil {
+		minTemp = *z.FailsafeMinTemp
+	}
+	if z.FailsafeMaxTemp != nil {
+		maxTemp = *z.FailsafeMaxTemp
+	}
+	return minTemp, maxTemp
 }
 
 type Device struct {
@@ -48,8 +246,68 @@ type RadiantFloorLoop struct {
 
 type AirHandler struct {
 	Device
-	Zone        *Zone
-	CircPumpPin GPIOPin
+	Zone             *Zone
+	CircPumpPin      GPIOPin
+	Recirculation    RecirculationPolicy
+	RecirculationRun RecirculationRun
+}
+
+// Recirculation defaults for air handlers whose policy leaves the interval or duration unset.
+const (
+	DefaultRecirculationInterval = 12 * time.Hour
+	DefaultRecirculationDuration = 15 * time.Minute
+)
+
+// RecirculationPolicy is how often an idle air handler runs its blower to keep air moving. Zero
+// minutes fall back to the defaults. Runs start only in hours [StartHour, EndHour), which may wrap
+// midnight; equal hours allow any time.
+type RecirculationPolicy struct {
+	Disabled        bool `json:"disabled,omitempty"`
+	IntervalMinutes int  `json:"interval_minutes,omitempty"`
+	DurationMinutes int  `json:"duration_minutes,omitempty"`
+	StartHour       int  `json:"start_hour,omitempty"`
+	EndHour         int  `json:"end_hour,omitempty"`
+}
+
+// Interval returns how long the blower must be idle before a recirculation run.
+func (p RecirculationPolicy) Interval() time.Duration {
+	if p.IntervalMinutes > 0 {
+		return time.Duration(p.IntervalMinutes) * time.Minute
+	}
+	return DefaultRecirculationInterval
+}
+
+// Duration returns how long a recirculation run lasts.
+func (p RecirculationPolicy) Duration() time.Duration {
+	if p.DurationMinutes > 0 {
+		return time.Duration(p.DurationMinutes) * time.Minute
+	}
+	return DefaultRecirculationDuration
+}
+
+// AllowsHour reports whether a recirculation run may start during hour (0-23).
+func (p RecirculationPolicy) AllowsHour(hour int) bool {
+	switch {
+	case p.StartHour == p.EndHour:
+		return true
+	case p.StartHour < p.EndHour:
+		return hour >= p.StartHour && hour < p.EndHour
+	default:
+		return hour >= p.StartHour || hour < p.EndHour
+	}
+}
+
+// RecirculationRun is an air handler's recirculation run, zero when none is in progress. Manual
+// runs are started through the API rather than by the recirculation controller.
+type RecirculationRun struct {
+	StartedAt time.Time `json:"started_at"`
+	EndsAt    time.Time `json:"ends_at"`
+	Manual    bool      `json:"manual"`
+}
+
+// Active reports whether a run is in progress.
+func (r RecirculationRun) Active() bool {
+	return !r.StartedAt.IsZero()
 }
 
 type GPIOPin struct {
@@ -57,7 +315,52 @@ type GPIOPin struct {
 	ActiveHigh bool `json:"active_high"`
 }
 
+// Sensor types, each read by its own driver. Bus is interpreted per type.
+const (
+	SensorW1            = "w1"             // DS18B20 w1_slave file, Bus is the 1-Wire address
+	SensorW1Temperature = "w1_temperature" // the newer 1-Wire temperature attribute, Bus is the 1-Wire address
+	SensorIIO           = "iio"            // I2C/SPI sensors such as a BME280, Bus is the IIO device, e.g. iio:device0
+	SensorHwmon         = "hwmon"          // Bus is the input under /sys/class/hwmon, e.g. hwmon2/temp1_input
+	SensorMQTT          = "mqtt"           // Bus is the topic the sensor publishes to
+	SensorHTTP          = "http"           // Bus is the URL to poll
+)
+
 type Sensor struct {
-	ID  string `json:"id"`
-	Bus string `json:"bus"`
+	ID   string `json:"id"`
+	Type string `json:"type,omitempty"` // one of the Sensor* types, empty means w1
+	Bus  string `json:"bus"`
+
+	// How often the sensor is read, 0 uses the system poll interval
+	PollIntervalSeconds int `json:"poll_interval_seconds,omitempty"`
+}
+
+// Ways of combining a multi-sensor zone's readings into one temperature.
+const (
+	AggregateMean         = "mean"
+	AggregateWeightedMean = "weighted_mean"
+	AggregateMin          = "min"
+	AggregateMax          = "max"
+	AggregateMedian       = "median"
+)
+
+// ZoneSensor is one of a zone's sensors with its weight in a weighted mean.
+type ZoneSensor struct {
+	Sensor
+	Weight float64 `json:"weight,omitempty"` // 0 counts as 1
+}
+
+// EffectiveWeight is the sensor's weight, defaulting to 1.
+func (s ZoneSensor) EffectiveWeight() float64 {
+	if s.Weight == 0 {
+		return 1
+	}
+	return s.Weight
+}
+
+// Driver is the sensor's type, defaulting to 1-Wire.
+func (s Sensor) Driver() string {
+	if s.Type == "" {
+		return SensorW1
+	}
+	return s.Type
 }
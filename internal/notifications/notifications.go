@@ -17,7 +17,7 @@ var initialized bool
 
 // Init initializes the notification client
 func Init() {
-	if env.Cfg.NtfyTopic == "" {
+	if env.Cfg().NtfyTopic == "" {
 		log.Warn().Msg("Ntfy topic not configured - notifications disabled")
 		return
 	}
@@ -25,7 +25,7 @@ func Init() {
 	client = &http.Client{
 		Timeout: 10 * time.Second,
 	}
-	topic = env.Cfg.NtfyTopic
+	topic = env.Cfg().NtfyTopic
 	initialized = true
 
 	log.Info().
@@ -0,0 +1,100 @@
+package reload
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/thatsimonsguy/hvac-controller/db"
+	"github.com/thatsimonsguy/hvac-controller/internal/config"
+	"github.com/thatsimonsguy/hvac-controller/internal/env"
+)
+
+// InvalidConfigError is returned when the reloaded config fails validation.
+type InvalidConfigError struct {
+	Err error
+}
+
+func (e *InvalidConfigError) Error() string {
+	return fmt.Sprintf("invalid config: %s", e.Err)
+}
+
+func (e *InvalidConfigError) Unwrap() error {
+	return e.Err
+}
+
+// RestartRequiredError is returned when the reloaded config contains changes that can only
+// take effect after a restart. The running config is left in place.
+type RestartRequiredError struct {
+	Reasons []string
+}
+
+func (e *RestartRequiredError) Error() string {
+	return fmt.Sprintf("config changes require a restart: %s", strings.Join(e.Reasons, "; "))
+}
+
+// Store reconciles the config-owned rows.
+type Store interface {
+	ReconcileConfig(c *config.Config, dryRun bool) (db.ConfigDiff, error)
+}
+
+// Reloader re-reads the config file and swaps it in for the running controllers.
+type Reloader struct {
+	store     Store
+	mutex     sync.Mutex
+	listeners []func(*config.Config)
+
+	// loadFile is overridable for tests
+	loadFile func(path string) (*config.Config, error)
+}
+
+func NewReloader(store Store) *Reloader {
+	return &Reloader{
+		store:    store,
+		loadFile: config.LoadFile,
+	}
+}
+
+// OnReload registers a callback for components that cache config values instead of reading env.Cfg() each cycle.
+func (r *Reloader) OnReload(fn func(*config.Config)) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.listeners = append(r.listeners, fn)
+}
+
+// Reload re-reads and validates the config file, reconciles the config-owned DB rows and
+// atomically swaps the config the controllers see. Changes that need a restart, such as pin
+// moves, are rejected and leave the running config untouched.
+func (r *Reloader) Reload() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	running := env.Cfg()
+	next, err := r.loadFile(running.ConfigFile)
+	if err != nil {
+		return err
+	}
+	next.InheritRuntime(running)
+
+	if err := next.Validate(); err != nil {
+		return &InvalidConfigError{Err: err}
+	}
+
+	if reasons := config.RestartRequired(running, next); len(reasons) > 0 {
+		return &RestartRequiredError{Reasons: reasons}
+	}
+
+	if _, err := r.store.ReconcileConfig(next, false); err != nil {
+		return fmt.Errorf("failed to apply config to database: %w", err)
+	}
+
+	env.SetCfg(next)
+	for _, fn := range r.listeners {
+		fn(next)
+	}
+
+	log.Info().Str("path", next.ConfigFile).Msg("Config reloaded")
+	return nil
+}
@@ -0,0 +1,160 @@
+package reload
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/thatsimonsguy/hvac-controller/db"
+	"github.com/thatsimonsguy/hvac-controller/internal/config"
+	"github.com/thatsimonsguy/hvac-controller/internal/env"
+	"github.com/thatsimonsguy/hvac-controller/internal/model"
+)
+
+func setupTestDB(t *testing.T) *sql.DB {
+	dbConn, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	dbConn.SetMaxOpenConns(1)
+
+	_, err = db.Migrate(dbConn)
+	require.NoError(t, err)
+
+	_, err = dbConn.Exec(`
+		INSERT INTO system (id, system_mode, main_power_pin_number, main_power_pin_active_high, temp_sensor_bus_pin) VALUES (1, 'off', 25, 0, 4);
+		INSERT INTO sensors (id, bus) VALUES ('main_sensor', '28-000000000001'), ('buffer_tank', '28-0000005050cc');
+		INSERT INTO zones (id, label, setpoint, mode, capabilities, sensor_id) VALUES ('main_floor', 'Main', 68, 'heating', '["heating"]', 'main_sensor');
+		INSERT INTO devices (name, pin_number, pin_active_high, min_on, min_off, online, active_modes, device_type, role, mode_pin_number, mode_pin_active_high, is_primary)
+			VALUES ('hp_a', 23, 0, 600, 300, 1, '["heating"]', 'heat_pump', 'source', 18, 0, 1);
+		INSERT INTO devices (name, pin_number, pin_active_high, min_on, min_off, online, active_modes, device_type, role, zone_id)
+			VALUES ('main_radiant_loop', 16, 0, 300, 180, 1, '["heating"]', 'radiant_floor', 'distributor', 'main_floor');
+	`)
+	require.NoError(t, err)
+	return dbConn
+}
+
+func baseConfig() *config.Config {
+	return &config.Config{
+		ConfigFile:             "config.json",
+		HeatingThreshold:       105,
+		CoolingThreshold:       40,
+		ZoneMaxTemp:            85,
+		ZoneMinTemp:            60,
+		SystemOverrideMaxTemp:  90,
+		SystemOverrideMinTemp:  50,
+		Spread:                 5,
+		SecondaryMargin:        10,
+		TertiaryMargin:         30,
+		RoleRotationMinutes:    1440,
+		PollIntervalSeconds:    30,
+		TempSensorBusGPIO:      4,
+		MainPowerGPIO:          25,
+		TempAnomalyMaxDelta:    5,
+		TempAnomalyGarageDelta: 25,
+		TempMaxAnomalies:       6,
+		TempHistorySize:        20,
+		SystemSensors: map[string]model.Sensor{
+			"buffer_tank": {ID: "buffer_tank", Bus: "28-0000005050cc"},
+		},
+		Zones: []model.Zone{{
+			ID:           "main_floor",
+			Label:        "Main",
+			Setpoint:     70,
+			Capabilities: []string{"heating"},
+			Sensor:       model.Sensor{ID: "main_sensor", Bus: "28-000000000001"},
+		}},
+		DeviceConfig: config.DeviceConfig{
+			HeatPumps: config.HeatPumpGroup{
+				DeviceProfile: config.DeviceProfile{MinTimeOn: 10, MinTimeOff: 5, ActiveModes: []string{"heating"}},
+				Devices:       []config.HeatPumpConfig{{Name: "hp_a", Pin: 23, ModePin: 18}},
+			},
+			RadiantFloorLoops: config.RadiantLoopGroup{
+				DeviceProfile: config.DeviceProfile{MinTimeOn: 5, MinTimeOff: 3, ActiveModes: []string{"heating"}},
+				Devices:       []config.RadiantLoopConfig{{Name: "main_radiant_loop", Pin: 16, Zone: "main_floor"}},
+			},
+		},
+	}
+}
+
+func newTestReloader(t *testing.T, dbConn *sql.DB, next *config.Config) *Reloader {
+	running := baseConfig()
+	running.SafeMode = true
+	orig := env.Cfg()
+	env.SetCfg(running)
+	t.Cleanup(func() { env.SetCfg(orig) })
+
+	r := NewReloader(db.New(dbConn))
+	r.loadFile = func(string) (*config.Config, error) { return next, nil }
+	return r
+}
+
+func TestReload_AppliesLiveChanges(t *testing.T) {
+	dbConn := setupTestDB(t)
+	defer dbConn.Close()
+
+	next := baseConfig()
+	next.HeatingThreshold = 110
+	next.Zones[0].Label = "Main Floor"
+	next.Zones[0].Sensor.Bus = "28-000000000002"
+	next.DeviceConfig.HeatPumps.DeviceProfile.MinTimeOn = 15
+
+	r := newTestReloader(t, dbConn, next)
+	var notified *config.Config
+	r.OnReload(func(c *config.Config) { notified = c })
+
+	require.NoError(t, r.Reload())
+
+	assert.Same(t, next, env.Cfg())
+	assert.Same(t, next, notified)
+	assert.True(t, env.Cfg().SafeMode, "CLI flags should carry over")
+
+	var label string
+	var setpoint float64
+	require.NoError(t, dbConn.QueryRow(`SELECT label, setpoint FROM zones WHERE id = 'main_floor'`).Scan(&label, &setpoint))
+	assert.Equal(t, "Main Floor", label)
+	assert.Equal(t, 68.0, setpoint, "runtime setpoint must be preserved")
+
+	var bus string
+	require.NoError(t, dbConn.QueryRow(`SELECT bus FROM sensors WHERE id = 'main_sensor'`).Scan(&bus))
+	assert.Equal(t, "28-000000000002", bus)
+
+	var minOn int
+	require.NoError(t, dbConn.QueryRow(`SELECT min_on FROM devices WHERE name = 'hp_a'`).Scan(&minOn))
+	assert.Equal(t, 900, minOn)
+}
+
+func TestReload_RejectsPinMove(t *testing.T) {
+	dbConn := setupTestDB(t)
+	defer dbConn.Close()
+
+	next := baseConfig()
+	next.DeviceConfig.HeatPumps.Devices[0].Pin = 24
+
+	r := newTestReloader(t, dbConn, next)
+	running := env.Cfg()
+
+	err := r.Reload()
+	var restart *RestartRequiredError
+	require.True(t, errors.As(err, &restart))
+	assert.Equal(t, []string{"hp_a.pin moved (23 -> 24)"}, restart.Reasons)
+	assert.Same(t, running, env.Cfg())
+}
+
+func TestReload_RejectsInvalidConfig(t *testing.T) {
+	dbConn := setupTestDB(t)
+	defer dbConn.Close()
+
+	next := baseConfig()
+	next.MainPowerGPIO = 4 // collides with the sensor bus
+
+	r := newTestReloader(t, dbConn, next)
+	running := env.Cfg()
+
+	err := r.Reload()
+	var invalid *InvalidConfigError
+	require.True(t, errors.As(err, &invalid))
+	assert.Same(t, running, env.Cfg())
+}
@@ -0,0 +1,61 @@
+package sensors
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/thatsimonsguy/hvac-controller/internal/model"
+)
+
+// maxPayloadBytes bounds how much of a remote sensor's response is read.
+const maxPayloadBytes = 4096
+
+// httpDriver polls a URL that returns the sensor's current temperature, see parsePayload.
+type httpDriver struct {
+	client *http.Client
+}
+
+func newHTTPDriver() httpDriver {
+	// The read timeout comes from the request context
+	return httpDriver{client: &http.Client{}}
+}
+
+func (d httpDriver) ReadTemp(ctx context.Context, sensor model.Sensor) (float64, error) {
+	body, err := d.fetch(ctx, sensor)
+	if err != nil {
+		return 0, err
+	}
+	return parsePayload(body)
+}
+
+func (d httpDriver) ReadHumidity(ctx context.Context, sensor model.Sensor) (float64, error) {
+	body, err := d.fetch(ctx, sensor)
+	if err != nil {
+		return 0, err
+	}
+	return parseHumidityPayload(body)
+}
+
+func (d httpDriver) fetch(ctx context.Context, sensor model.Sensor) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, sensor.Bus, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build request for sensor %s: %w", sensor.ID, err)
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to poll sensor %s: %w", sensor.ID, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("sensor %s returned %s", sensor.ID, resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxPayloadBytes))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sensor %s response: %w", sensor.ID, err)
+	}
+	return body, nil
+}
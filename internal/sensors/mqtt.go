@@ -0,0 +1,187 @@
+package sensors
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/rs/zerolog/log"
+
+	"github.com/thatsimonsguy/hvac-controller/internal/config"
+	"github.com/thatsimonsguy/hvac-controller/internal/model"
+)
+
+const (
+	mqttDefaultClientID = "hvac-controller"
+	mqttDefaultMaxAge   = 5 * time.Minute
+	mqttKeepAlive       = 60 * time.Second
+	mqttConnectTimeout  = 10 * time.Second
+	mqttReconnectDelay  = 5 * time.Second
+	mqttDisconnectQuiet = 250 // milliseconds to let in-flight work finish on Close
+)
+
+type mqttMessage struct {
+	payload  []byte
+	received time.Time
+}
+
+// mqttDriver subscribes to each mqtt sensor's topic on its first read and serves the latest
+// message published there, see parsePayload. The client reconnects on its own and every topic
+// is resubscribed once it does.
+type mqttDriver struct {
+	cfg    config.MQTTConfig
+	maxAge time.Duration
+	client paho.Client
+
+	mu      sync.Mutex
+	topics  map[string]bool
+	latest  map[string]mqttMessage
+	updated chan struct{} // closed and replaced whenever a message arrives
+	started bool
+}
+
+func newMQTTDriver(cfg config.MQTTConfig) *mqttDriver {
+	maxAge := time.Duration(cfg.MaxAgeSeconds) * time.Second
+	if maxAge == 0 {
+		maxAge = mqttDefaultMaxAge
+	}
+	d := &mqttDriver{
+		cfg:     cfg,
+		maxAge:  maxAge,
+		topics:  make(map[string]bool),
+		latest:  make(map[string]mqttMessage),
+		updated: make(chan struct{}),
+	}
+
+	clientID := cfg.ClientID
+	if clientID == "" {
+		clientID = mqttDefaultClientID
+	}
+	broker := cfg.Broker
+	if !strings.Contains(broker, "://") {
+		broker = "tcp://" + broker
+	}
+	opts := paho.NewClientOptions().
+		AddBroker(broker).
+		SetClientID(clientID).
+		SetUsername(cfg.Username).
+		SetPassword(cfg.Password).
+		SetCleanSession(true).
+		SetKeepAlive(mqttKeepAlive).
+		SetConnectTimeout(mqttConnectTimeout).
+		SetConnectRetry(true).
+		SetConnectRetryInterval(mqttReconnectDelay).
+		SetAutoReconnect(true).
+		SetMaxReconnectInterval(mqttReconnectDelay).
+		SetOnConnectHandler(d.onConnect).
+		SetConnectionLostHandler(func(_ paho.Client, err error) {
+			log.Warn().Err(err).Str("broker", cfg.Broker).Msg("Lost connection to MQTT broker")
+		})
+	d.client = paho.NewClient(opts)
+	return d
+}
+
+// ReadTemp returns the temperature in the latest message on the sensor's topic, see latestPayload.
+func (d *mqttDriver) ReadTemp(ctx context.Context, sensor model.Sensor) (float64, error) {
+	payload, err := d.latestPayload(ctx, sensor.Bus)
+	if err != nil {
+		return 0, err
+	}
+	return parsePayload(payload)
+}
+
+// ReadHumidity returns the relative humidity in the latest message on the sensor's topic.
+func (d *mqttDriver) ReadHumidity(ctx context.Context, sensor model.Sensor) (float64, error) {
+	payload, err := d.latestPayload(ctx, sensor.Bus)
+	if err != nil {
+		return 0, err
+	}
+	return parseHumidityPayload(payload)
+}
+
+// latestPayload returns the latest message on a topic, waiting for the first one until ctx is
+// done. A message older than the configured max age counts as a failed read.
+func (d *mqttDriver) latestPayload(ctx context.Context, topic string) ([]byte, error) {
+	d.subscribe(topic)
+
+	for {
+		d.mu.Lock()
+		msg, ok := d.latest[topic]
+		updated := d.updated
+		d.mu.Unlock()
+
+		if ok {
+			if age := time.Since(msg.received); age > d.maxAge {
+				return nil, fmt.Errorf("last message on topic %s is %v old", topic, age.Round(time.Second))
+			}
+			return msg.payload, nil
+		}
+
+		select {
+		case <-updated:
+		case <-ctx.Done():
+			return nil, fmt.Errorf("no message on topic %s yet: %w", topic, ctx.Err())
+		}
+	}
+}
+
+// Close disconnects from the broker and stops reconnecting.
+func (d *mqttDriver) Close() {
+	d.mu.Lock()
+	started := d.started
+	d.mu.Unlock()
+	if started {
+		d.client.Disconnect(mqttDisconnectQuiet)
+	}
+}
+
+// subscribe adds a topic, connecting on first use.
+func (d *mqttDriver) subscribe(topic string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !d.started {
+		d.started = true
+		d.client.Connect()
+	}
+	if d.topics[topic] {
+		return
+	}
+	d.topics[topic] = true
+	if d.client.IsConnected() {
+		d.subscribeTopic(topic)
+	}
+}
+
+// onConnect subscribes every known topic, as a clean session starts without subscriptions.
+func (d *mqttDriver) onConnect(paho.Client) {
+	log.Info().Str("broker", d.cfg.Broker).Msg("Connected to MQTT broker")
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for topic := range d.topics {
+		d.subscribeTopic(topic)
+	}
+}
+
+// subscribeTopic requests a QoS 0 subscription, logging if the broker doesn't grant it.
+func (d *mqttDriver) subscribeTopic(topic string) {
+	token := d.client.Subscribe(topic, 0, d.receive)
+	go func() {
+		if token.Wait() && token.Error() != nil {
+			log.Warn().Err(token.Error()).Str("topic", topic).Msg("Failed to subscribe to MQTT topic")
+		}
+	}()
+}
+
+// receive records a message as the topic's latest and wakes any reads waiting on it.
+func (d *mqttDriver) receive(_ paho.Client, msg paho.Message) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.latest[msg.Topic()] = mqttMessage{payload: msg.Payload(), received: time.Now()}
+	close(d.updated)
+	d.updated = make(chan struct{})
+}
@@ -0,0 +1,168 @@
+package sensors
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/thatsimonsguy/hvac-controller/internal/config"
+	"github.com/thatsimonsguy/hvac-controller/internal/model"
+)
+
+// SensorDriver reads one type of temperature sensor. Temperatures are in Fahrenheit.
+type SensorDriver interface {
+	ReadTemp(ctx context.Context, sensor model.Sensor) (float64, error)
+}
+
+// HumidityDriver is implemented by drivers whose sensors can also report relative humidity, in percent.
+type HumidityDriver interface {
+	ReadHumidity(ctx context.Context, sensor model.Sensor) (float64, error)
+}
+
+// w1Retries is how many times a failed 1-Wire read is retried within the read timeout.
+const w1Retries = 3
+
+// Registry reads any sensor with the driver registered for its type.
+type Registry struct {
+	drivers map[string]SensorDriver
+	mqtt    *mqttDriver
+}
+
+// NewRegistry registers a driver for every sensor type. The MQTT driver only connects once an
+// mqtt sensor is first read.
+func NewRegistry(cfg *config.Config) *Registry {
+	r := &Registry{
+		drivers: map[string]SensorDriver{
+			model.SensorW1:            w1SlaveDriver{retries: w1Retries},
+			model.SensorW1Temperature: sysfsDriver{path: w1TemperaturePath},
+			model.SensorIIO:           iioDriver{},
+			model.SensorHwmon:         sysfsDriver{path: hwmonPath},
+			model.SensorHTTP:          newHTTPDriver(),
+		},
+	}
+	if cfg.MQTT.Broker != "" {
+		r.mqtt = newMQTTDriver(cfg.MQTT)
+		r.drivers[model.SensorMQTT] = r.mqtt
+	}
+	return r
+}
+
+// ReadTemp reads a sensor with the driver for its type.
+func (r *Registry) ReadTemp(ctx context.Context, sensor model.Sensor) (float64, error) {
+	driver, ok := r.drivers[sensor.Driver()]
+	if !ok {
+		return 0, fmt.Errorf("no driver for sensor %s of type %q", sensor.ID, sensor.Driver())
+	}
+	return driver.ReadTemp(ctx, sensor)
+}
+
+// ReadHumidity reads a sensor's relative humidity, if its driver supports humidity.
+func (r *Registry) ReadHumidity(ctx context.Context, sensor model.Sensor) (float64, error) {
+	driver, ok := r.drivers[sensor.Driver()]
+	if !ok {
+		return 0, fmt.Errorf("no driver for sensor %s of type %q", sensor.ID, sensor.Driver())
+	}
+	humidity, ok := driver.(HumidityDriver)
+	if !ok {
+		return 0, fmt.Errorf("sensor %s of type %q does not report humidity", sensor.ID, sensor.Driver())
+	}
+	return humidity.ReadHumidity(ctx, sensor)
+}
+
+// Close disconnects from the MQTT broker, if connected.
+func (r *Registry) Close() {
+	if r.mqtt != nil {
+		r.mqtt.Close()
+	}
+}
+
+// payload is the JSON form remote sensors may report, e.g. {"temperature": 21.5, "unit": "C", "humidity": 45}.
+type payload struct {
+	Temperature *float64 `json:"temperature"`
+	Temp        *float64 `json:"temp"`
+	Unit        string   `json:"unit"`
+	Humidity    *float64 `json:"humidity"`
+	RH          *float64 `json:"rh"`
+}
+
+// parsePayload reads a remote sensor's report, either a bare number in Celsius or a JSON
+// object with a temperature and optional unit (C or F, default C).
+func parsePayload(data []byte) (float64, error) {
+	text := strings.TrimSpace(string(data))
+	if c, err := strconv.ParseFloat(text, 64); err == nil {
+		return celsiusToFahrenheit(c), nil
+	}
+
+	var p payload
+	if err := json.Unmarshal([]byte(text), &p); err != nil {
+		return 0, fmt.Errorf("unrecognised sensor payload %q", truncate(text, 64))
+	}
+	value := p.Temperature
+	if value == nil {
+		value = p.Temp
+	}
+	if value == nil {
+		return 0, fmt.Errorf("sensor payload has no temperature: %q", truncate(text, 64))
+	}
+
+	switch strings.ToUpper(p.Unit) {
+	case "", "C":
+		return celsiusToFahrenheit(*value), nil
+	case "F":
+		return *value, nil
+	default:
+		return 0, fmt.Errorf("unknown temperature unit %q", p.Unit)
+	}
+}
+
+// parseHumidityPayload reads a remote sensor's relative humidity, either a bare percentage or a
+// JSON object with a humidity (or rh) field.
+func parseHumidityPayload(data []byte) (float64, error) {
+	text := strings.TrimSpace(string(data))
+	if rh, err := strconv.ParseFloat(text, 64); err == nil {
+		return checkHumidity(rh)
+	}
+
+	var p payload
+	if err := json.Unmarshal([]byte(text), &p); err != nil {
+		return 0, fmt.Errorf("unrecognised sensor payload %q", truncate(text, 64))
+	}
+	value := p.Humidity
+	if value == nil {
+		value = p.RH
+	}
+	if value == nil {
+		return 0, fmt.Errorf("sensor payload has no humidity: %q", truncate(text, 64))
+	}
+	return checkHumidity(*value)
+}
+
+func checkHumidity(rh float64) (float64, error) {
+	if rh < 0 || rh > 100 {
+		return 0, fmt.Errorf("relative humidity %v is out of range", rh)
+	}
+	return rh, nil
+}
+
+// parseMilliCelsius reads the integer millidegree Celsius format sysfs sensors use.
+func parseMilliCelsius(data []byte) (float64, error) {
+	milli, err := strconv.ParseFloat(strings.TrimSpace(string(data)), 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse temperature %q: %w", truncate(string(data), 32), err)
+	}
+	return celsiusToFahrenheit(milli / 1000.0), nil
+}
+
+// Celsius to Fahrenheit: F = C × 9/5 + 32
+func celsiusToFahrenheit(c float64) float64 {
+	return c*9.0/5.0 + 32.0
+}
+
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n] + "..."
+	}
+	return s
+}
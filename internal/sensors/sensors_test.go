@@ -0,0 +1,216 @@
+package sensors
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/thatsimonsguy/hvac-controller/internal/config"
+	"github.com/thatsimonsguy/hvac-controller/internal/model"
+)
+
+func TestParsePayload(t *testing.T) {
+	for payload, want := range map[string]float64{
+		"21.5":                                   70.7,
+		` {"temperature": 21.5} `:                70.7,
+		`{"temp": 0, "unit": "c"}`:               32.0,
+		`{"temperature": 68.2, "unit": "F"}`:     68.2,
+		`{"temperature": -40, "humidity": 30.1}`: -40.0,
+	} {
+		got, err := parsePayload([]byte(payload))
+		require.NoError(t, err, payload)
+		assert.InDelta(t, want, got, 0.001, payload)
+	}
+
+	for _, payload := range []string{"", "warm", `{"humidity": 30}`, `{"temperature": 20, "unit": "K"}`} {
+		_, err := parsePayload([]byte(payload))
+		assert.Error(t, err, payload)
+	}
+}
+
+func TestParseHumidityPayload(t *testing.T) {
+	for payload, want := range map[string]float64{
+		"45.5": 45.5,
+		`{"temperature": 21.5, "humidity": 52.1}`: 52.1,
+		`{"rh": 60}`: 60,
+	} {
+		got, err := parseHumidityPayload([]byte(payload))
+		require.NoError(t, err, payload)
+		assert.InDelta(t, want, got, 0.001, payload)
+	}
+
+	for _, payload := range []string{"", `{"temperature": 21.5}`, "101", `{"humidity": -3}`} {
+		_, err := parseHumidityPayload([]byte(payload))
+		assert.Error(t, err, payload)
+	}
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+}
+
+func TestRegistry_SysfsDrivers(t *testing.T) {
+	dir := t.TempDir()
+	origW1, origIIO, origHwmon := w1Root, iioRoot, hwmonRoot
+	defer func() { w1Root, iioRoot, hwmonRoot = origW1, origIIO, origHwmon }()
+	w1Root, iioRoot, hwmonRoot = filepath.Join(dir, "w1"), filepath.Join(dir, "iio"), filepath.Join(dir, "hwmon")
+
+	writeFile(t, filepath.Join(w1Root, "28-000000000001", "w1_slave"),
+		"72 01 4b 46 7f ff 0e 10 57 : crc=57 YES\n72 01 4b 46 7f ff 0e 10 57 t=23125\n")
+	writeFile(t, filepath.Join(w1Root, "28-000000000002", "temperature"), "20000\n")
+	writeFile(t, filepath.Join(iioRoot, "iio:device0", "in_temp_input"), "21500\n")
+	writeFile(t, filepath.Join(iioRoot, "iio:device1", "in_temp_raw"), "430\n")
+	writeFile(t, filepath.Join(iioRoot, "iio:device1", "in_temp_scale"), "50\n")
+	writeFile(t, filepath.Join(hwmonRoot, "hwmon2", "temp1_input"), "-5000\n")
+
+	registry := NewRegistry(&config.Config{})
+	ctx := context.Background()
+	for _, tc := range []struct {
+		sensor model.Sensor
+		want   float64
+	}{
+		{model.Sensor{ID: "legacy", Bus: "28-000000000001"}, 73.625},
+		{model.Sensor{ID: "attr", Type: model.SensorW1Temperature, Bus: "28-000000000002"}, 68.0},
+		{model.Sensor{ID: "bme280", Type: model.SensorIIO, Bus: "iio:device0"}, 70.7},
+		{model.Sensor{ID: "raw", Type: model.SensorIIO, Bus: "iio:device1"}, 70.7},
+		{model.Sensor{ID: "board", Type: model.SensorHwmon, Bus: "hwmon2/temp1_input"}, 23.0},
+	} {
+		got, err := registry.ReadTemp(ctx, tc.sensor)
+		require.NoError(t, err, tc.sensor.ID)
+		assert.InDelta(t, tc.want, got, 0.001, tc.sensor.ID)
+	}
+
+	_, err := registry.ReadTemp(ctx, model.Sensor{ID: "gone", Type: model.SensorIIO, Bus: "iio:device9"})
+	assert.Error(t, err)
+
+	// IIO sensors report humidity too, processed or raw
+	writeFile(t, filepath.Join(iioRoot, "iio:device0", "in_humidityrelative_input"), "48250\n")
+	writeFile(t, filepath.Join(iioRoot, "iio:device1", "in_humidityrelative_raw"), "1000\n")
+	writeFile(t, filepath.Join(iioRoot, "iio:device1", "in_humidityrelative_scale"), "50\n")
+	rh, err := registry.ReadHumidity(ctx, model.Sensor{ID: "bme280", Type: model.SensorIIO, Bus: "iio:device0"})
+	require.NoError(t, err)
+	assert.InDelta(t, 48.25, rh, 0.001)
+	rh, err = registry.ReadHumidity(ctx, model.Sensor{ID: "raw", Type: model.SensorIIO, Bus: "iio:device1"})
+	require.NoError(t, err)
+	assert.InDelta(t, 50.0, rh, 0.001)
+
+	_, err = registry.ReadHumidity(ctx, model.Sensor{ID: "legacy", Bus: "28-000000000001"})
+	assert.ErrorContains(t, err, `sensor legacy of type "w1" does not report humidity`)
+
+	// Without a broker configured there is no MQTT driver
+	_, err = registry.ReadTemp(ctx, model.Sensor{ID: "remote", Type: model.SensorMQTT, Bus: "home/temp"})
+	assert.ErrorContains(t, err, `no driver for sensor remote of type "mqtt"`)
+}
+
+func TestHTTPDriver(t *testing.T) {
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(`{"temperature": 21.5, "unit": "C"}`))
+	}))
+	defer srv.Close()
+
+	sensor := model.Sensor{ID: "office", Type: model.SensorHTTP, Bus: srv.URL}
+	temp, err := newHTTPDriver().ReadTemp(context.Background(), sensor)
+	require.NoError(t, err)
+	assert.InDelta(t, 70.7, temp, 0.001)
+
+	_, err = newHTTPDriver().ReadHumidity(context.Background(), sensor)
+	assert.ErrorContains(t, err, "no humidity")
+
+	status = http.StatusServiceUnavailable
+	_, err = newHTTPDriver().ReadTemp(context.Background(), sensor)
+	assert.ErrorContains(t, err, "503")
+}
+
+// fakeBroker accepts one client, acknowledges its connect and subscribe, and publishes the
+// given payload on whatever topic it subscribed to.
+func fakeBroker(t *testing.T, payload string) (addr string, connect chan *packets.ConnectPacket) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+
+	connect = make(chan *packets.ConnectPacket, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		cp, err := packets.ReadPacket(conn)
+		if err != nil {
+			return
+		}
+		connect <- cp.(*packets.ConnectPacket)
+		_ = packets.NewControlPacket(packets.Connack).Write(conn)
+
+		cp, err = packets.ReadPacket(conn)
+		sub, ok := cp.(*packets.SubscribePacket)
+		if err != nil || !ok {
+			return
+		}
+		ack := packets.NewControlPacket(packets.Suback).(*packets.SubackPacket)
+		ack.MessageID = sub.MessageID
+		ack.ReturnCodes = []byte{0}
+		_ = ack.Write(conn)
+		pub := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+		pub.TopicName = sub.Topics[0]
+		pub.Payload = []byte(payload)
+		_ = pub.Write(conn)
+
+		// Hold the connection open until the client goes away
+		for {
+			if _, err := packets.ReadPacket(conn); err != nil {
+				return
+			}
+		}
+	}()
+	return ln.Addr().String(), connect
+}
+
+func TestMQTTDriver(t *testing.T) {
+	addr, connect := fakeBroker(t, `{"temperature": 21.5}`)
+	driver := newMQTTDriver(config.MQTTConfig{Broker: addr, Username: "hvac", Password: "secret"})
+	defer driver.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	sensor := model.Sensor{ID: "bedroom", Type: model.SensorMQTT, Bus: "home/bedroom/temperature"}
+	temp, err := driver.ReadTemp(ctx, sensor)
+	require.NoError(t, err)
+	assert.InDelta(t, 70.7, temp, 0.001)
+
+	cp := <-connect
+	assert.Equal(t, "MQTT", cp.ProtocolName)
+	assert.Equal(t, mqttDefaultClientID, cp.ClientIdentifier)
+	assert.Equal(t, "hvac", cp.Username)
+	assert.Equal(t, []byte("secret"), cp.Password)
+	assert.True(t, cp.CleanSession)
+
+	// An old message counts as a failed read
+	driver.maxAge = time.Nanosecond
+	_, err = driver.ReadTemp(ctx, sensor)
+	assert.ErrorContains(t, err, "old")
+}
+
+func TestMQTTDriver_NoMessage(t *testing.T) {
+	driver := newMQTTDriver(config.MQTTConfig{Broker: "127.0.0.1:1"})
+	defer driver.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err := driver.ReadTemp(ctx, model.Sensor{ID: "attic", Type: model.SensorMQTT, Bus: "home/attic"})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
@@ -0,0 +1,166 @@
+package sensors
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/thatsimonsguy/hvac-controller/internal/gpio"
+	"github.com/thatsimonsguy/hvac-controller/internal/model"
+)
+
+// Sysfs roots, variables so tests can point them at a temp dir.
+var (
+	w1Root    = "/sys/bus/w1/devices"
+	iioRoot   = "/sys/bus/iio/devices"
+	hwmonRoot = "/sys/class/hwmon"
+)
+
+// w1SlaveDriver reads DS18B20s through w1_slave, which carries the CRC check.
+type w1SlaveDriver struct {
+	retries int
+}
+
+func (d w1SlaveDriver) ReadTemp(ctx context.Context, sensor model.Sensor) (float64, error) {
+	return gpio.ReadSensorTempContext(ctx, filepath.Join(w1Root, sensor.Bus), d.retries)
+}
+
+func w1TemperaturePath(bus string) string { return filepath.Join(w1Root, bus, "temperature") }
+func hwmonPath(bus string) string         { return filepath.Join(hwmonRoot, bus) }
+
+// sysfsDriver reads a single attribute holding millidegrees Celsius. The kernel checks the CRC
+// of 1-Wire temperature attributes itself and fails the read on a mismatch.
+type sysfsDriver struct {
+	path func(bus string) string
+}
+
+func (d sysfsDriver) ReadTemp(ctx context.Context, sensor model.Sensor) (float64, error) {
+	data, err := readFile(ctx, d.path(sensor.Bus))
+	if err != nil {
+		return 0, err
+	}
+	return parseMilliCelsius(data)
+}
+
+// iioDriver reads IIO temperature channels, such as a BME280's. Drivers either provide a
+// processed in_temp_input or a raw value with scale and offset, both in millidegrees Celsius.
+type iioDriver struct{}
+
+func (iioDriver) ReadTemp(ctx context.Context, sensor model.Sensor) (float64, error) {
+	dir := filepath.Join(iioRoot, sensor.Bus)
+
+	data, err := readFile(ctx, filepath.Join(dir, "in_temp_input"))
+	if err == nil {
+		return parseMilliCelsius(data)
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return 0, err
+	}
+
+	raw, err := readFloat(ctx, filepath.Join(dir, "in_temp_raw"), nil)
+	if err != nil {
+		return 0, err
+	}
+	one, zero := 1.0, 0.0
+	scale, err := readFloat(ctx, filepath.Join(dir, "in_temp_scale"), &one)
+	if err != nil {
+		return 0, err
+	}
+	offset, err := readFloat(ctx, filepath.Join(dir, "in_temp_offset"), &zero)
+	if err != nil {
+		return 0, err
+	}
+	return celsiusToFahrenheit((raw + offset) * scale / 1000.0), nil
+}
+
+// ReadHumidity reads an IIO relative humidity channel, such as a BME280's, in milli-percent.
+func (iioDriver) ReadHumidity(ctx context.Context, sensor model.Sensor) (float64, error) {
+	dir := filepath.Join(iioRoot, sensor.Bus)
+
+	var milli float64
+	data, err := readFile(ctx, filepath.Join(dir, "in_humidityrelative_input"))
+	switch {
+	case err == nil:
+		if milli, err = strconv.ParseFloat(strings.TrimSpace(string(data)), 64); err != nil {
+			return 0, fmt.Errorf("failed to parse humidity %q: %w", truncate(string(data), 32), err)
+		}
+	case errors.Is(err, fs.ErrNotExist):
+		raw, err := readFloat(ctx, filepath.Join(dir, "in_humidityrelative_raw"), nil)
+		if err != nil {
+			return 0, err
+		}
+		one, zero := 1.0, 0.0
+		scale, err := readFloat(ctx, filepath.Join(dir, "in_humidityrelative_scale"), &one)
+		if err != nil {
+			return 0, err
+		}
+		offset, err := readFloat(ctx, filepath.Join(dir, "in_humidityrelative_offset"), &zero)
+		if err != nil {
+			return 0, err
+		}
+		milli = (raw + offset) * scale
+	default:
+		return 0, err
+	}
+	return checkHumidity(milli / 1000.0)
+}
+
+// readFloat reads a numeric attribute, returning def when the attribute doesn't exist and def is set.
+func readFloat(ctx context.Context, path string, def *float64) (float64, error) {
+	data, err := readFile(ctx, path)
+	if err != nil {
+		if def != nil && errors.Is(err, fs.ErrNotExist) {
+			return *def, nil
+		}
+		return 0, err
+	}
+	v, err := strconv.ParseFloat(strings.TrimSpace(string(data)), 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return v, nil
+}
+
+// fileRead is a sysfs read in progress. Reads of the same file are joined so a hung bus never
+// has more than one outstanding read per attribute.
+type fileRead struct {
+	done chan struct{}
+	data []byte
+	err  error
+}
+
+var (
+	fileReadsMu sync.Mutex
+	fileReads   = make(map[string]*fileRead)
+)
+
+// readFile reads a file, giving up when ctx is done even if the read itself is still blocked.
+func readFile(ctx context.Context, path string) ([]byte, error) {
+	fileReadsMu.Lock()
+	read, ok := fileReads[path]
+	if !ok {
+		read = &fileRead{done: make(chan struct{})}
+		fileReads[path] = read
+		go func() {
+			read.data, read.err = os.ReadFile(path)
+			fileReadsMu.Lock()
+			delete(fileReads, path)
+			fileReadsMu.Unlock()
+			close(read.done)
+		}()
+	}
+	fileReadsMu.Unlock()
+
+	select {
+	case <-read.done:
+		return read.data, read.err
+	case <-ctx.Done():
+		return nil, fmt.Errorf("read %s: %w", path, ctx.Err())
+	}
+}
@@ -1,30 +1,83 @@
 package temperature
 
 import (
-	"database/sql"
+	"context"
 	"fmt"
 	"math"
-	"path/filepath"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/rs/zerolog/log"
 
-	"github.com/thatsimonsguy/hvac-controller/db"
+	"github.com/thatsimonsguy/hvac-controller/internal/config"
 	"github.com/thatsimonsguy/hvac-controller/internal/datadog"
 	"github.com/thatsimonsguy/hvac-controller/internal/env"
-	"github.com/thatsimonsguy/hvac-controller/internal/gpio"
 	"github.com/thatsimonsguy/hvac-controller/internal/model"
 	"github.com/thatsimonsguy/hvac-controller/internal/notifications"
+	"github.com/thatsimonsguy/hvac-controller/internal/sensors"
 	"github.com/thatsimonsguy/hvac-controller/system/shutdown"
 )
 
+const (
+	defaultReadConcurrency = 4
+	readFailureAlertCount  = 3 // consecutive failed reads before an unreachable sensor is alerted
+	minPollWait            = time.Second
+)
+
 type Reading struct {
 	Temperature float64
 	Timestamp   time.Time
 	Valid       bool
 }
 
+// Quality says how far a sensor's current value can be trusted.
+type Quality string
+
+const (
+	QualityFresh       Quality = "fresh"       // accepted on the latest poll
+	QualityStale       Quality = "stale"       // older than two of the sensor's poll intervals
+	QualitySubstituted Quality = "substituted" // the latest poll was rejected, the last good reading stands in
+	QualityDisabled    Quality = "disabled"    // the sensor is disabled after repeated anomalies
+	QualityMissing     Quality = "missing"     // no reading has been accepted yet
+)
+
+// SensorReading is a sensor's current value with its age, quality and anomaly state.
+type SensorReading struct {
+	SensorID      string // empty for a zone reading combined from several sensors, see Sources
+	Temperature   float64
+	Timestamp     time.Time
+	Age           time.Duration
+	Quality       Quality
+	AnomalyCount  int
+	RecoveryCount int
+	ReadFailures  int // consecutive reads that errored or timed out
+
+	// The sensors a zone reading was computed from, see GetZoneReading
+	Sources []string
+}
+
+// HumidityReading is a sensor's relative humidity with its age and quality. Humidity isn't checked
+// for anomalies, so the quality is only ever fresh, stale or missing.
+type HumidityReading struct {
+	SensorID  string
+	Humidity  float64 // percent
+	Timestamp time.Time
+	Age       time.Duration
+	Quality   Quality
+}
+
+// FreshEnough reports whether the reading is recent enough to act on.
+func (r SensorReading) FreshEnough(maxAge time.Duration) bool {
+	return r.Quality != QualityMissing && r.Age <= maxAge
+}
+
+// Usable reports whether the reading can stand for its sensor: accepted on the latest poll, or
+// the last good reading standing in for a rejected one.
+func (r SensorReading) Usable() bool {
+	return r.Quality == QualityFresh || r.Quality == QualitySubstituted
+}
+
 type ReadingHistory struct {
 	Readings         []Reading
 	MaxSize          int
@@ -33,9 +86,22 @@ type ReadingHistory struct {
 	Disabled         bool
 	DisabledAt       time.Time
 	LastGoodReading  Reading
+	Substituted      bool   // the latest reading was rejected and LastGoodReading stands in
 	SensorZone       string // Zone ID for this sensor
 }
 
+// Store provides the sensors to poll
+type Store interface {
+	GetAllZones() ([]model.Zone, error)
+	GetSensorByID(id string) (*model.Sensor, error)
+}
+
+// SensorReader reads a sensor with the driver for its type, implemented by *sensors.Registry
+type SensorReader interface {
+	ReadTemp(ctx context.Context, sensor model.Sensor) (float64, error)
+	ReadHumidity(ctx context.Context, sensor model.Sensor) (float64, error)
+}
+
 // Notifier interface for sending notifications
 type Notifier interface {
 	Send(title, message string) error
@@ -47,13 +113,23 @@ type Shutdowner interface {
 }
 
 type Service struct {
-	dbConn       *sql.DB
+	store        Store
+	reader       SensorReader
 	readings     map[string]Reading          // Current reading (public API)
 	history      map[string]*ReadingHistory  // Anomaly detection history
 	sensorZones  map[string]string           // sensorID -> zoneID mapping
+	humidity     map[string]HumidityReading  // latest relative humidity of zone humidity sensors
 	mutex        sync.RWMutex
 	pollInterval time.Duration
 
+	// Scheduling, keyed by sensor ID
+	intervals    map[string]time.Duration // per-sensor poll intervals, where configured
+	nextRead     map[string]time.Time
+	readFailures map[string]int
+
+	readTimeout     time.Duration
+	readConcurrency int
+
 	// Configuration
 	maxTempDelta    float64
 	garageTempDelta float64
@@ -65,36 +141,66 @@ type Service struct {
 	shutdowner Shutdowner
 }
 
-func NewService(dbConn *sql.DB, pollIntervalSeconds int) *Service {
+func NewService(store Store, pollIntervalSeconds int) *Service {
 	return &Service{
-		dbConn:          dbConn,
+		store:           store,
+		reader:          sensors.NewRegistry(env.Cfg()),
 		readings:        make(map[string]Reading),
 		history:         make(map[string]*ReadingHistory),
 		sensorZones:     make(map[string]string),
+		humidity:        make(map[string]HumidityReading),
 		pollInterval:    time.Duration(pollIntervalSeconds) * time.Second,
-		maxTempDelta:    env.Cfg.TempAnomalyMaxDelta,
-		garageTempDelta: env.Cfg.TempAnomalyGarageDelta,
-		maxAnomalies:    env.Cfg.TempMaxAnomalies,
-		historySize:     env.Cfg.TempHistorySize,
+		intervals:       make(map[string]time.Duration),
+		nextRead:        make(map[string]time.Time),
+		readFailures:    make(map[string]int),
+		readTimeout:     env.Cfg().SensorReadTimeout(),
+		readConcurrency: env.Cfg().SensorReadConcurrency,
+		maxTempDelta:    env.Cfg().TempAnomalyMaxDelta,
+		garageTempDelta: env.Cfg().TempAnomalyGarageDelta,
+		maxAnomalies:    env.Cfg().TempMaxAnomalies,
+		historySize:     env.Cfg().TempHistorySize,
 		notifier:        &realNotifier{},
 		shutdowner:      &realShutdowner{},
 	}
 }
 
+// ApplyConfig picks up anomaly detection and polling settings after a config reload.
+// Existing reading history is kept.
+func (s *Service) ApplyConfig(cfg *config.Config) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.pollInterval = time.Duration(cfg.PollIntervalSeconds) * time.Second
+	s.readTimeout = cfg.SensorReadTimeout()
+	s.readConcurrency = cfg.SensorReadConcurrency
+	s.maxTempDelta = cfg.TempAnomalyMaxDelta
+	s.garageTempDelta = cfg.TempAnomalyGarageDelta
+	s.maxAnomalies = cfg.TempMaxAnomalies
+	s.historySize = cfg.TempHistorySize
+}
+
 // TestDeps holds test dependencies
 type TestDeps struct {
 	Notifier  Notifier
 	Shutdowner Shutdowner
+	Sensors   SensorReader
 }
 
 // NewServiceForTest creates a service with injectable dependencies for testing
-func NewServiceForTest(dbConn *sql.DB, pollIntervalSeconds int, deps *TestDeps) *Service {
+func NewServiceForTest(store Store, pollIntervalSeconds int, deps *TestDeps) *Service {
 	s := &Service{
-		dbConn:          dbConn,
+		store:           store,
+		reader:          deps.Sensors,
 		readings:        make(map[string]Reading),
 		history:         make(map[string]*ReadingHistory),
 		sensorZones:     make(map[string]string),
+		humidity:        make(map[string]HumidityReading),
 		pollInterval:    time.Duration(pollIntervalSeconds) * time.Second,
+		intervals:       make(map[string]time.Duration),
+		nextRead:        make(map[string]time.Time),
+		readFailures:    make(map[string]int),
+		readTimeout:     10 * time.Second,
+		readConcurrency: defaultReadConcurrency,
 		maxTempDelta:    5.0,
 		garageTempDelta: 25.0,
 		maxAnomalies:    6,
@@ -119,6 +225,12 @@ func (r *realShutdowner) Shutdown() {
 	shutdown.Shutdown()
 }
 
+// Reader returns the drivers the service reads sensors with, for components that read sensors
+// outside the polling loop.
+func (s *Service) Reader() SensorReader {
+	return s.reader
+}
+
 func (s *Service) Start() {
 	go func() {
 		log.Info().Msg("Starting centralized temperature reading service")
@@ -127,105 +239,284 @@ func (s *Service) Start() {
 		time.Sleep(30 * time.Second)
 
 		for {
-			s.readAllSensors()
-			time.Sleep(s.pollInterval)
+			time.Sleep(s.readDueSensors(time.Now()))
 		}
 	}()
 }
 
-func (s *Service) readAllSensors() {
-	log.Info().Msg("Reading all temperature sensors")
+// sensorRead is one scheduled read: a sensor's temperature, or a zone humidity sensor's humidity.
+type sensorRead struct {
+	sensor   model.Sensor
+	humidity bool
+}
 
+// key identifies the read in the schedule; a sensor can be read for both temperature and humidity.
+func (r sensorRead) key() string {
+	if r.humidity {
+		return r.sensor.ID + "/humidity"
+	}
+	return r.sensor.ID
+}
+
+// sensorsToRead returns every zone, buffer tank and outdoor sensor temperature and zone humidity
+// sensor to read, and refreshes the sensor-to-zone mapping and per-sensor poll intervals.
+func (s *Service) sensorsToRead() []sensorRead {
 	// Get all zones and their sensors
-	zones, err := db.GetAllZones(s.dbConn)
+	zones, err := s.store.GetAllZones()
 	if err != nil {
 		log.Error().Err(err).Msg("Could not retrieve zones for temperature reading")
-		return
+		return nil
 	}
 
 	// Get buffer tank sensor
-	bufferSensor, err := db.GetSensorByID(s.dbConn, "buffer_tank")
+	bufferSensor, err := s.store.GetSensorByID("buffer_tank")
 	if err != nil {
 		log.Error().Err(err).Msg("Could not retrieve buffer tank sensor for temperature reading")
+		bufferSensor = nil
 	}
 
-	// Build sensor-to-zone mapping
-	s.mutex.Lock()
-	for _, zone := range zones {
-		s.sensorZones[zone.Sensor.ID] = zone.ID
-	}
-	if bufferSensor != nil {
-		s.sensorZones[bufferSensor.ID] = "buffer_tank"
+	// The outdoor sensor is optional
+	outdoorSensor, err := s.store.GetSensorByID(config.OutdoorSensor)
+	if err != nil {
+		outdoorSensor = nil
 	}
-	s.mutex.Unlock()
-
-	var sensorsToRead []model.Sensor
 
 	// Collect all unique sensors
 	sensorMap := make(map[string]model.Sensor)
+	sensorZones := make(map[string]string)
+	humiditySensors := make(map[string]model.Sensor)
 
 	for _, zone := range zones {
-		sensor, err := db.GetSensorByID(s.dbConn, zone.Sensor.ID)
-		if err != nil {
-			log.Error().Err(err).Str("zone", zone.ID).Msg("Could not retrieve sensor for zone")
-			continue
+		for _, zs := range zone.ZoneSensors() {
+			sensor, err := s.store.GetSensorByID(zs.ID)
+			if err != nil {
+				log.Error().Err(err).Str("zone", zone.ID).Str("sensor_id", zs.ID).Msg("Could not retrieve sensor for zone")
+				continue
+			}
+			sensorMap[sensor.ID] = *sensor
+			sensorZones[sensor.ID] = zone.ID
+		}
+		if zone.HumiditySensor != nil {
+			sensor, err := s.store.GetSensorByID(zone.HumiditySensor.ID)
+			if err != nil {
+				log.Error().Err(err).Str("zone", zone.ID).Str("sensor_id", zone.HumiditySensor.ID).Msg("Could not retrieve humidity sensor for zone")
+				continue
+			}
+			humiditySensors[sensor.ID] = *sensor
+			if _, ok := sensorZones[sensor.ID]; !ok {
+				sensorZones[sensor.ID] = zone.ID
+			}
 		}
-		sensorMap[sensor.ID] = *sensor
 	}
 
 	if bufferSensor != nil {
 		sensorMap[bufferSensor.ID] = *bufferSensor
+		sensorZones[bufferSensor.ID] = "buffer_tank"
+	}
+	if outdoorSensor != nil {
+		sensorMap[outdoorSensor.ID] = *outdoorSensor
+		sensorZones[outdoorSensor.ID] = config.OutdoorSensor
 	}
 
-	// Convert map to slice
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var reads []sensorRead
+	schedule := func(sensor model.Sensor, humidity bool) {
+		s.sensorZones[sensor.ID] = sensorZones[sensor.ID]
+		if sensor.PollIntervalSeconds > 0 {
+			s.intervals[sensor.ID] = time.Duration(sensor.PollIntervalSeconds) * time.Second
+		} else {
+			delete(s.intervals, sensor.ID)
+		}
+		reads = append(reads, sensorRead{sensor: sensor, humidity: humidity})
+	}
 	for _, sensor := range sensorMap {
-		sensorsToRead = append(sensorsToRead, sensor)
+		schedule(sensor, false)
+	}
+	for _, sensor := range humiditySensors {
+		schedule(sensor, true)
 	}
+	return reads
+}
 
-	// Read all sensors sequentially with delays between reads
-	timestamp := time.Now()
+// readDueSensors reads every sensor whose poll interval has elapsed, at most readConcurrency at a
+// time, and returns how long to wait until the next sensor is due. A sensor that hangs or fails
+// only holds up its own slot until the read timeout.
+func (s *Service) readDueSensors(now time.Time) time.Duration {
+	all := s.sensorsToRead()
 
-	for i, sensor := range sensorsToRead {
-		// Add delay between sensor reads to avoid overwhelming the one-wire bus
-		if i > 0 {
-			time.Sleep(500 * time.Millisecond)
+	s.mutex.Lock()
+	var due []sensorRead
+	next := now.Add(s.pollInterval)
+	for _, read := range all {
+		if at, ok := s.nextRead[read.key()]; !ok || !at.After(now) {
+			due = append(due, read)
+			s.nextRead[read.key()] = now.Add(s.intervalFor(read.sensor.ID))
 		}
+		if at := s.nextRead[read.key()]; at.Before(next) {
+			next = at
+		}
+	}
+	concurrency := s.readConcurrency
+	if concurrency <= 0 {
+		concurrency = defaultReadConcurrency
+	}
+	s.mutex.Unlock()
 
-		sensorPath := filepath.Join("/sys/bus/w1/devices", sensor.Bus)
-		temp := gpio.ReadSensorTempWithRetries(sensorPath, 3)
+	if len(due) > 0 {
+		log.Info().Int("sensors", len(due)).Msg("Reading due temperature sensors")
+	}
 
-		// Get zone for this sensor
-		s.mutex.RLock()
-		sensorZone := s.sensorZones[sensor.ID]
-		s.mutex.RUnlock()
+	slots := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for _, read := range due {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(read sensorRead) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			if read.humidity {
+				s.readHumidity(read.sensor)
+			} else {
+				s.readSensor(read.sensor)
+			}
+		}(read)
+	}
+	wg.Wait()
 
-		// Process reading through anomaly detection
-		accepted := s.processReading(sensor.ID, sensorZone, temp, timestamp)
+	if len(due) > 0 {
+		log.Info().
+			Int("sensors_read", len(due)).
+			Msg("Completed temperature reading cycle")
+	}
 
-		if !accepted {
-			log.Warn().
-				Str("sensor_id", sensor.ID).
-				Str("zone", sensorZone).
-				Float64("temp", temp).
-				Msg("Temperature reading rejected as anomalous")
-		} else {
-			log.Debug().
-				Str("sensor_id", sensor.ID).
-				Str("zone", sensorZone).
-				Float64("temp", temp).
-				Msg("Temperature reading accepted")
+	if wait := time.Until(next); wait > minPollWait {
+		return wait
+	}
+	return minPollWait
+}
+
+// readSensor reads one sensor within the read timeout and passes the value through anomaly
+// detection. Read errors leave the previous reading in place to go stale.
+func (s *Service) readSensor(sensor model.Sensor) {
+	s.mutex.RLock()
+	sensorZone := s.sensorZones[sensor.ID]
+	timeout := s.readTimeout
+	s.mutex.RUnlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	temp, err := s.reader.ReadTemp(ctx, sensor)
+	if err != nil {
+		s.recordReadFailure(sensor.ID, sensorZone, err)
+		return
+	}
+	s.recordReadSuccess(sensor.ID, sensorZone)
+
+	// Process reading through anomaly detection
+	accepted := s.processReading(sensor.ID, sensorZone, temp, time.Now())
+
+	if !accepted {
+		log.Warn().
+			Str("sensor_id", sensor.ID).
+			Str("zone", sensorZone).
+			Float64("temp", temp).
+			Msg("Temperature reading rejected as anomalous")
+	} else {
+		log.Debug().
+			Str("sensor_id", sensor.ID).
+			Str("zone", sensorZone).
+			Float64("temp", temp).
+			Msg("Temperature reading accepted")
+	}
+}
+
+// readHumidity reads a zone humidity sensor within the read timeout. Read errors leave the previous
+// value in place to go stale.
+func (s *Service) readHumidity(sensor model.Sensor) {
+	s.mutex.RLock()
+	sensorZone := s.sensorZones[sensor.ID]
+	timeout := s.readTimeout
+	s.mutex.RUnlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	rh, err := s.reader.ReadHumidity(ctx, sensor)
+	if err != nil {
+		log.Warn().
+			Err(err).
+			Str("sensor_id", sensor.ID).
+			Str("zone", sensorZone).
+			Msg("Humidity sensor read failed")
+		return
+	}
+
+	s.mutex.Lock()
+	s.humidity[sensor.ID] = HumidityReading{SensorID: sensor.ID, Humidity: rh, Timestamp: time.Now()}
+	s.mutex.Unlock()
+
+	log.Debug().
+		Str("sensor_id", sensor.ID).
+		Str("zone", sensorZone).
+		Float64("humidity", rh).
+		Msg("Humidity reading accepted")
+}
+
+func (s *Service) recordReadFailure(sensorID, sensorZone string, err error) {
+	s.mutex.Lock()
+	s.readFailures[sensorID]++
+	failures := s.readFailures[sensorID]
+	s.mutex.Unlock()
+
+	log.Warn().
+		Err(err).
+		Str("sensor_id", sensorID).
+		Str("zone", sensorZone).
+		Int("consecutive_failures", failures).
+		Msg("Temperature sensor read failed")
+
+	if failures == readFailureAlertCount {
+		message := fmt.Sprintf("Sensor %s (%s) has failed %d reads in a row: %v. Its readings will go stale until it recovers.",
+			sensorID, sensorZone, failures, err)
+		if err := s.notifier.Send("HVAC Sensor Unreachable", message); err != nil {
+			log.Error().Err(err).Msg("Failed to send sensor unreachable notification")
 		}
 	}
+}
+
+func (s *Service) recordReadSuccess(sensorID, sensorZone string) {
+	s.mutex.Lock()
+	failures := s.readFailures[sensorID]
+	delete(s.readFailures, sensorID)
+	s.mutex.Unlock()
 
-	log.Info().
-		Int("sensors_read", len(sensorsToRead)).
-		Msg("Completed temperature reading cycle")
+	if failures >= readFailureAlertCount {
+		log.Info().
+			Str("sensor_id", sensorID).
+			Str("zone", sensorZone).
+			Int("failed_reads", failures).
+			Msg("Temperature sensor reachable again")
+	}
+}
+
+// intervalFor is the poll interval of a sensor. Callers must hold the mutex.
+func (s *Service) intervalFor(sensorID string) time.Duration {
+	if interval, ok := s.intervals[sensorID]; ok {
+		return interval
+	}
+	return s.pollInterval
 }
 
 // processReading handles anomaly detection and validation
-func (s *Service) processReading(sensorID, sensorZone string, temp float64, timestamp time.Time) bool {
+func (s *Service) processReading(sensorID, sensorZone string, temp float64, timestamp time.Time) (accepted bool) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
+	defer func() {
+		s.history[sensorID].Substituted = !accepted
+	}()
 
 	// Initialize history if needed
 	if s.history[sensorID] == nil {
@@ -599,6 +890,8 @@ func (s *Service) getZoneName(zoneID string) string {
 		return "Garage"
 	case "buffer_tank":
 		return "Buffer Tank"
+	case config.OutdoorSensor:
+		return "Outdoor"
 	default:
 		return zoneID
 	}
@@ -645,7 +938,7 @@ func (s *Service) GetTemperature(sensorID string) (float64, bool) {
 	}
 
 	// Check if reading is stale (older than 2x poll interval)
-	if time.Since(reading.Timestamp) > 2*s.pollInterval {
+	if time.Since(reading.Timestamp) > 2*s.intervalFor(sensorID) {
 		log.Warn().
 			Str("sensor_id", sensorID).
 			Dur("age", time.Since(reading.Timestamp)).
@@ -661,6 +954,165 @@ func (s *Service) GetTemperature(sensorID string) (float64, bool) {
 	return reading.Temperature, true
 }
 
+// GetReading returns a sensor's current value with its age and quality. Unlike GetTemperature it
+// returns substituted, stale and disabled readings too, labelled as such. It reports false if the
+// sensor has no reading at all.
+func (s *Service) GetReading(sensorID string) (SensorReading, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	r := SensorReading{SensorID: sensorID, Quality: QualityMissing, ReadFailures: s.readFailures[sensorID]}
+	if history := s.history[sensorID]; history != nil {
+		r.AnomalyCount = history.AnomalyCount
+		r.RecoveryCount = history.RecoveryCount
+	}
+
+	reading, exists := s.readings[sensorID]
+	if !exists {
+		return r, false
+	}
+
+	r.Temperature = reading.Temperature
+	r.Timestamp = reading.Timestamp
+	r.Age = time.Since(reading.Timestamp)
+
+	history := s.history[sensorID]
+	switch {
+	case history != nil && history.Disabled:
+		r.Quality = QualityDisabled
+	case r.Age > 2*s.intervalFor(sensorID):
+		r.Quality = QualityStale
+	case history != nil && history.Substituted:
+		r.Quality = QualitySubstituted
+	default:
+		r.Quality = QualityFresh
+	}
+	return r, true
+}
+
+// GetHumidity returns a humidity sensor's latest relative humidity with its age and quality. It
+// reports false if the sensor has no reading at all.
+func (s *Service) GetHumidity(sensorID string) (HumidityReading, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	r, exists := s.humidity[sensorID]
+	if !exists {
+		return HumidityReading{SensorID: sensorID, Quality: QualityMissing}, false
+	}
+	r.Age = time.Since(r.Timestamp)
+	r.Quality = QualityFresh
+	if r.Age > 2*s.intervalFor(sensorID) {
+		r.Quality = QualityStale
+	}
+	return r, true
+}
+
+// GetZoneReading combines the readings of a zone's sensors with the zone's aggregation. Sensors
+// that are stale, disabled by anomaly detection or have no reading are left out, so the zone falls
+// back to the rest. If none are usable the primary sensor's reading is returned as is. A combined
+// reading is as old as its oldest source and substituted if any source is.
+func (s *Service) GetZoneReading(zone model.Zone) (SensorReading, bool) {
+	zoneSensors := zone.ZoneSensors()
+	if len(zoneSensors) == 1 {
+		return s.GetReading(zoneSensors[0].ID)
+	}
+
+	var used []SensorReading
+	var weights []float64
+	for _, zs := range zoneSensors {
+		if r, ok := s.GetReading(zs.ID); ok && r.Usable() {
+			used = append(used, r)
+			weights = append(weights, zs.EffectiveWeight())
+		}
+	}
+	if len(used) == 0 {
+		primary := zone.Sensor.ID
+		if primary == "" {
+			primary = zoneSensors[0].ID
+		}
+		return s.GetReading(primary)
+	}
+
+	combined := SensorReading{Quality: QualityFresh, Timestamp: used[0].Timestamp}
+	temps := make([]float64, len(used))
+	for i, r := range used {
+		temps[i] = r.Temperature
+		combined.Sources = append(combined.Sources, r.SensorID)
+		if r.Timestamp.Before(combined.Timestamp) {
+			combined.Timestamp = r.Timestamp
+		}
+		if r.Age > combined.Age {
+			combined.Age = r.Age
+		}
+		if r.Quality == QualitySubstituted {
+			combined.Quality = QualitySubstituted
+		}
+		combined.AnomalyCount += r.AnomalyCount
+		combined.ReadFailures += r.ReadFailures
+	}
+	combined.Temperature = aggregate(zone.AggregationMethod(), temps, weights)
+	return combined, true
+}
+
+// aggregate combines temperatures with one of the model.Aggregate* methods. Weights only apply to
+// the weighted mean; an unknown method falls back to the plain mean.
+func aggregate(method string, temps, weights []float64) float64 {
+	switch method {
+	case model.AggregateWeightedMean:
+		var sum, total float64
+		for i, t := range temps {
+			sum += t * weights[i]
+			total += weights[i]
+		}
+		return sum / total
+	case model.AggregateMin:
+		result := temps[0]
+		for _, t := range temps[1:] {
+			result = math.Min(result, t)
+		}
+		return result
+	case model.AggregateMax:
+		result := temps[0]
+		for _, t := range temps[1:] {
+			result = math.Max(result, t)
+		}
+		return result
+	case model.AggregateMedian:
+		sorted := append([]float64(nil), temps...)
+		sort.Float64s(sorted)
+		mid := len(sorted) / 2
+		if len(sorted)%2 == 0 {
+			return (sorted[mid-1] + sorted[mid]) / 2
+		}
+		return sorted[mid]
+	default:
+		var sum float64
+		for _, t := range temps {
+			sum += t
+		}
+		return sum / float64(len(temps))
+	}
+}
+
+// GetAllSensorReadings returns GetReading for every sensor with a reading, keyed by sensor ID.
+func (s *Service) GetAllSensorReadings() map[string]SensorReading {
+	s.mutex.RLock()
+	ids := make([]string, 0, len(s.readings))
+	for id := range s.readings {
+		ids = append(ids, id)
+	}
+	s.mutex.RUnlock()
+
+	result := make(map[string]SensorReading, len(ids))
+	for _, id := range ids {
+		if r, ok := s.GetReading(id); ok {
+			result[id] = r
+		}
+	}
+	return result
+}
+
 func (s *Service) GetAllReadings() map[string]Reading {
 	s.mutex.RLock()
 	defer s.mutex.RUnlock()
@@ -0,0 +1,207 @@
+package temperature
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/thatsimonsguy/hvac-controller/internal/model"
+)
+
+func newReadingTestService() *Service {
+	return NewServiceForTest(nil, 30, &TestDeps{Notifier: &MockNotifier{}, Shutdowner: &MockShutdown{}})
+}
+
+func TestGetReading_Quality(t *testing.T) {
+	service := newReadingTestService()
+
+	_, ok := service.GetReading("main_floor_sensor")
+	assert.False(t, ok)
+
+	// Bootstrap with recent readings
+	now := time.Now()
+	for i, temp := range []float64{70.0, 71.0, 70.0, 71.0, 70.5, 71.0} {
+		service.processReading("main_floor_sensor", "main_floor", temp, now.Add(time.Duration(i-6)*time.Second))
+	}
+	reading, ok := service.GetReading("main_floor_sensor")
+	require.True(t, ok)
+	assert.Equal(t, QualityFresh, reading.Quality)
+	assert.Equal(t, 71.0, reading.Temperature)
+	assert.True(t, reading.FreshEnough(time.Minute))
+
+	// An anomalous poll keeps the last good value, labelled as substituted
+	service.processReading("main_floor_sensor", "main_floor", 45.0, now)
+	reading, _ = service.GetReading("main_floor_sensor")
+	assert.Equal(t, QualitySubstituted, reading.Quality)
+	assert.Equal(t, 71.0, reading.Temperature)
+	assert.Equal(t, 1, reading.AnomalyCount)
+
+	// Enough anomalies disable the sensor
+	for _, temp := range []float64{40.0, 38.0, 35.0, 32.0, 30.0} {
+		service.processReading("main_floor_sensor", "main_floor", temp, now)
+	}
+	reading, _ = service.GetReading("main_floor_sensor")
+	assert.Equal(t, QualityDisabled, reading.Quality)
+	assert.Equal(t, 6, reading.AnomalyCount)
+}
+
+func TestGetReading_Stale(t *testing.T) {
+	service := newReadingTestService()
+	service.processReading("garage_sensor", "garage", 50.0, time.Now().Add(-5*time.Minute))
+
+	reading, ok := service.GetReading("garage_sensor")
+	require.True(t, ok)
+	assert.Equal(t, QualityStale, reading.Quality)
+	assert.InDelta(t, 5*time.Minute, reading.Age, float64(time.Second))
+	assert.False(t, reading.FreshEnough(90*time.Second))
+
+	_, valid := service.GetTemperature("garage_sensor")
+	assert.False(t, valid)
+}
+
+func TestGetZoneReading(t *testing.T) {
+	service := newReadingTestService()
+	now := time.Now()
+	for id, temp := range map[string]float64{"north": 68, "south": 72, "sunroom": 76} {
+		for i := 0; i < 6; i++ {
+			service.processReading(id, "main_floor", temp, now.Add(time.Duration(i-6)*time.Second))
+		}
+	}
+
+	zone := model.Zone{
+		ID:     "main_floor",
+		Sensor: model.Sensor{ID: "north"},
+		Sensors: []model.ZoneSensor{
+			{Sensor: model.Sensor{ID: "north"}},
+			{Sensor: model.Sensor{ID: "south"}},
+			{Sensor: model.Sensor{ID: "sunroom"}, Weight: 2},
+		},
+	}
+	for aggregation, want := range map[string]float64{
+		"":                          72,
+		model.AggregateMean:         72,
+		model.AggregateWeightedMean: 73,
+		model.AggregateMin:          68,
+		model.AggregateMax:          76,
+		model.AggregateMedian:       72,
+	} {
+		zone.Aggregation = aggregation
+		reading, ok := service.GetZoneReading(zone)
+		require.True(t, ok, aggregation)
+		assert.Equal(t, want, reading.Temperature, aggregation)
+		assert.Equal(t, QualityFresh, reading.Quality)
+		assert.ElementsMatch(t, []string{"north", "south", "sunroom"}, reading.Sources)
+	}
+
+	// A sensor disabled by anomaly detection drops out and the zone uses the rest
+	for _, temp := range []float64{45, 40, 38, 35, 32, 30} {
+		service.processReading("sunroom", "main_floor", temp, now)
+	}
+	service.processReading("south", "main_floor", 72, now.Add(-20*time.Second))
+	zone.Aggregation = model.AggregateMedian
+	reading, ok := service.GetZoneReading(zone)
+	require.True(t, ok)
+	assert.Equal(t, 70.0, reading.Temperature)
+	assert.ElementsMatch(t, []string{"north", "south"}, reading.Sources)
+	assert.InDelta(t, 20*time.Second, reading.Age, float64(time.Second), "as old as the oldest source")
+
+	// With no usable sensor left the primary's reading is returned as is
+	service.processReading("north", "main_floor", 68, now.Add(-5*time.Minute))
+	service.processReading("south", "main_floor", 72, now.Add(-5*time.Minute))
+	reading, ok = service.GetZoneReading(zone)
+	require.True(t, ok)
+	assert.Equal(t, "north", reading.SensorID)
+	assert.Equal(t, QualityStale, reading.Quality)
+	assert.Empty(t, reading.Sources)
+}
+
+type sensorStore struct {
+	zones   []model.Zone
+	sensors map[string]model.Sensor
+}
+
+func (s *sensorStore) GetAllZones() ([]model.Zone, error) { return s.zones, nil }
+
+func (s *sensorStore) GetSensorByID(id string) (*model.Sensor, error) {
+	sensor, ok := s.sensors[id]
+	if !ok {
+		return &model.Sensor{}, fmt.Errorf("failed to get sensor %s: not found", id)
+	}
+	return &sensor, nil
+}
+
+// fakeSensors reads 70 from every sensor except the basement one, which hangs until the read times out.
+type fakeSensors struct {
+	mu    sync.Mutex
+	reads map[string]int
+}
+
+func (f *fakeSensors) ReadTemp(ctx context.Context, sensor model.Sensor) (float64, error) {
+	f.mu.Lock()
+	f.reads[sensor.ID]++
+	f.mu.Unlock()
+	if sensor.ID == "basement_sensor" {
+		<-ctx.Done()
+		return 0, ctx.Err()
+	}
+	return 70.0, nil
+}
+
+func (f *fakeSensors) ReadHumidity(ctx context.Context, sensor model.Sensor) (float64, error) {
+	f.mu.Lock()
+	f.reads[sensor.ID+"/humidity"]++
+	f.mu.Unlock()
+	return 45.0, nil
+}
+
+func TestReadDueSensors(t *testing.T) {
+	store := &sensorStore{
+		zones: []model.Zone{
+			{ID: "main_floor", Sensor: model.Sensor{ID: "main_floor_sensor"}},
+			{ID: "basement", Sensor: model.Sensor{ID: "basement_sensor"}},
+		},
+		sensors: map[string]model.Sensor{
+			"main_floor_sensor": {ID: "main_floor_sensor", Bus: "28-000000000001"},
+			"basement_sensor":   {ID: "basement_sensor", Bus: "28-000000000002"},
+			"buffer_tank":       {ID: "buffer_tank", Bus: "28-000000000003", PollIntervalSeconds: 300},
+		},
+	}
+	notifier := &MockNotifier{}
+	shutdowner := &MockShutdown{}
+	reader := &fakeSensors{reads: make(map[string]int)}
+	service := NewServiceForTest(store, 30, &TestDeps{Notifier: notifier, Shutdowner: shutdowner, Sensors: reader})
+	service.readTimeout = 20 * time.Millisecond
+
+	now := time.Now()
+	wait := service.readDueSensors(now)
+	assert.InDelta(t, 30*time.Second, wait, float64(time.Second))
+
+	main, ok := service.GetReading("main_floor_sensor")
+	require.True(t, ok)
+	assert.Equal(t, QualityFresh, main.Quality)
+	_, ok = service.GetReading("buffer_tank")
+	assert.True(t, ok)
+
+	basement, ok := service.GetReading("basement_sensor")
+	assert.False(t, ok)
+	assert.Equal(t, 1, basement.ReadFailures)
+	assert.False(t, shutdowner.shutdownCalled)
+
+	// Zone sensors are due again after the poll interval, the buffer tank only after its own
+	service.readDueSensors(now.Add(40 * time.Second))
+	service.readDueSensors(now.Add(80 * time.Second))
+	assert.Equal(t, 3, reader.reads["main_floor_sensor"])
+	assert.Equal(t, 1, reader.reads["buffer_tank"])
+
+	// Repeated failures degrade just that sensor and alert once
+	basement, _ = service.GetReading("basement_sensor")
+	assert.Equal(t, 3, basement.ReadFailures)
+	require.Len(t, notifier.calls, 1)
+	assert.Contains(t, notifier.calls[0], "HVAC Sensor Unreachable")
+	assert.False(t, shutdowner.shutdownCalled)
+}
@@ -12,11 +12,11 @@ import (
 var ExitFunc = os.Exit
 
 func Shutdown() {
-	if !env.Cfg.SafeMode {
-		if env.Cfg.MainPowerActiveHigh {
-			pinctrl.SetPin(env.Cfg.MainPowerGPIO, "op", "pn", "dl")
+	if !env.Cfg().SafeMode {
+		if env.Cfg().MainPowerActiveHigh {
+			pinctrl.SetPin(env.Cfg().MainPowerGPIO, "op", "pn", "dl")
 		} else {
-			pinctrl.SetPin(env.Cfg.MainPowerGPIO, "op", "pn", "dh")
+			pinctrl.SetPin(env.Cfg().MainPowerGPIO, "op", "pn", "dh")
 		}
 		log.Info().Msg("Main power relay deactivated")
 		ExitFunc(0)
@@ -1,7 +1,6 @@
 package startup
 
 import (
-	"database/sql"
 	"fmt"
 	"os"
 	"os/exec"
@@ -11,7 +10,6 @@ import (
 
 	"github.com/rs/zerolog/log"
 
-	"github.com/thatsimonsguy/hvac-controller/db"
 	"github.com/thatsimonsguy/hvac-controller/internal/env"
 	"github.com/thatsimonsguy/hvac-controller/internal/model"
 )
@@ -27,7 +25,17 @@ type ServicesStatus struct {
 	HVAC ServiceStatus
 }
 
-func WriteStartupScript(dbConn *sql.DB) error {
+// DeviceStore lists the devices and system settings whose pins the boot script sets.
+type DeviceStore interface {
+	GetHeatPumps() ([]model.HeatPump, error)
+	GetAirHandlers() ([]model.AirHandler, error)
+	GetBoilers() ([]model.Boiler, error)
+	GetRadiantLoops() ([]model.RadiantFloorLoop, error)
+	GetMainPowerPin() (model.GPIOPin, error)
+	GetSystemMode() (model.SystemMode, error)
+}
+
+func WriteStartupScript(store DeviceStore) error {
 	var lines []string
 	lines = append(lines, "#!/bin/bash", "", "# HVAC GPIO pin configuration at boot", "")
 
@@ -41,11 +49,11 @@ func WriteStartupScript(dbConn *sql.DB) error {
 		lines = append(lines, "")
 	}
 
-	heatPumps, err := db.GetHeatPumps(dbConn)
+	heatPumps, err := store.GetHeatPumps()
 	if err != nil {
 		return err
 	}
-	systemMode, err := db.GetSystemMode(dbConn)
+	systemMode, err := store.GetSystemMode()
 	if err != nil {
 		return err
 	}
@@ -56,7 +64,7 @@ func WriteStartupScript(dbConn *sql.DB) error {
 			systemMode == model.ModeCooling && hp.Device.Online
 		write(hp.Name+".mode_pin", hp.ModePin, modeActive)
 	}
-	airHandlers, err := db.GetAirHandlers(dbConn)
+	airHandlers, err := store.GetAirHandlers()
 	if err != nil {
 		return err
 	}
@@ -64,28 +72,28 @@ func WriteStartupScript(dbConn *sql.DB) error {
 		write(ah.Name, ah.Pin, false)
 		write(ah.Name+".circ_pump", ah.CircPumpPin, false)
 	}
-	boilers, err := db.GetBoilers(dbConn)
+	boilers, err := store.GetBoilers()
 	if err != nil {
 		return err
 	}
 	for _, b := range boilers {
 		write(b.Name, b.Pin, false)
 	}
-	radiantLoops, err := db.GetRadiantLoops(dbConn)
+	radiantLoops, err := store.GetRadiantLoops()
 	if err != nil {
 		return err
 	}
 	for _, rf := range radiantLoops {
 		write(rf.Name, rf.Pin, false)
 	}
-	mainPower, err := db.GetMainPowerPin(dbConn)
+	mainPower, err := store.GetMainPowerPin()
 	if err != nil {
 		return err
 	}
 	write("main_power", mainPower, false)
 
 	contents := strings.Join(lines, "\n") + "\n"
-	return os.WriteFile(env.Cfg.BootScriptFilePath, []byte(contents), 0755)
+	return os.WriteFile(env.Cfg().BootScriptFilePath, []byte(contents), 0755)
 }
 
 func InstallStartupService() error {
@@ -101,13 +109,13 @@ RemainAfterExit=true
 
 [Install]
 WantedBy=multi-user.target
-`, env.Cfg.BootScriptFilePath)
+`, env.Cfg().BootScriptFilePath)
 
-	return os.WriteFile(env.Cfg.OSServicePath, []byte(unitContents), 0644)
+	return os.WriteFile(env.Cfg().OSServicePath, []byte(unitContents), 0644)
 }
 
 func RunStartupScript() error {
-	cmd := exec.Command("/bin/bash", env.Cfg.BootScriptFilePath)
+	cmd := exec.Command("/bin/bash", env.Cfg().BootScriptFilePath)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	return cmd.Run()
@@ -177,7 +185,7 @@ func printSudoGuidance() {
 
 // Add to startup.go:
 func InstallHVACService() error {
-	gpioUnitName := filepath.Base(env.Cfg.OSServicePath)
+	gpioUnitName := filepath.Base(env.Cfg().OSServicePath)
 
 	// Consider adding these to your config, too:
 	user := "oebus"
@@ -202,7 +210,7 @@ RestartSec=5s
 WantedBy=multi-user.target
 `, gpioUnitName, gpioUnitName, user, workdir, execCmd)
 
-	return os.WriteFile(env.Cfg.MainServicePath, []byte(unit), 0644)
+	return os.WriteFile(env.Cfg().MainServicePath, []byte(unit), 0644)
 }
 
 // CheckServicesStatus checks the existence and status of both services
@@ -210,14 +218,14 @@ func CheckServicesStatus() (ServicesStatus, error) {
 	status := ServicesStatus{}
 
 	// Check GPIO service
-	gpioStatus, err := checkSingleService(env.Cfg.OSServicePath)
+	gpioStatus, err := checkSingleService(env.Cfg().OSServicePath)
 	if err != nil {
 		return status, fmt.Errorf("error checking GPIO service: %w", err)
 	}
 	status.GPIO = gpioStatus
 
 	// Check HVAC service
-	hvacStatus, err := checkSingleService(env.Cfg.MainServicePath)
+	hvacStatus, err := checkSingleService(env.Cfg().MainServicePath)
 	if err != nil {
 		return status, fmt.Errorf("error checking HVAC service: %w", err)
 	}
@@ -267,8 +275,8 @@ func LogServicesStatus() error {
 		return err
 	}
 
-	gpioServiceName := filepath.Base(env.Cfg.OSServicePath)
-	hvacServiceName := filepath.Base(env.Cfg.MainServicePath)
+	gpioServiceName := filepath.Base(env.Cfg().OSServicePath)
+	hvacServiceName := filepath.Base(env.Cfg().MainServicePath)
 
 	log.Info().
 		Str("service", gpioServiceName).
@@ -288,7 +296,7 @@ func LogServicesStatus() error {
 }
 
 // EnsureServicesReady checks service status and installs/enables services as needed
-func EnsureServicesReady(dbConn *sql.DB) error {
+func EnsureServicesReady(store DeviceStore) error {
 	log.Info().Msg("Checking HVAC services status...")
 
 	status, err := CheckServicesStatus()
@@ -304,7 +312,7 @@ func EnsureServicesReady(dbConn *sql.DB) error {
 		log.Info().Msg("GPIO service not found, installing...")
 
 		// Write the startup script first
-		if err := WriteStartupScript(dbConn); err != nil {
+		if err := WriteStartupScript(store); err != nil {
 			log.Error().Err(err).Msg("Failed to write startup script")
 			return err
 		}
@@ -361,7 +369,7 @@ func EnsureServicesReady(dbConn *sql.DB) error {
 
 	// Enable GPIO service if not enabled
 	if status.GPIO.Exists && !status.GPIO.Enabled {
-		gpioServiceName := filepath.Base(env.Cfg.OSServicePath)
+		gpioServiceName := filepath.Base(env.Cfg().OSServicePath)
 		log.Info().Str("service", gpioServiceName).Msg("Enabling GPIO service...")
 
 		if err := exec.Command("systemctl", "enable", gpioServiceName).Run(); err != nil {
@@ -378,7 +386,7 @@ func EnsureServicesReady(dbConn *sql.DB) error {
 
 	// Enable HVAC service if not enabled
 	if status.HVAC.Exists && !status.HVAC.Enabled {
-		hvacServiceName := filepath.Base(env.Cfg.MainServicePath)
+		hvacServiceName := filepath.Base(env.Cfg().MainServicePath)
 		log.Info().Str("service", hvacServiceName).Msg("Enabling HVAC service...")
 
 		if err := exec.Command("systemctl", "enable", hvacServiceName).Run(); err != nil {